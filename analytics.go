@@ -0,0 +1,479 @@
+// analytics.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	analyticsMaxDays  = 366 // Longest date range the dashboard covers
+	analyticsTopCount = 8   // Purposes shown in the top purposes chart
+	chartBarWidth     = 320 // Width of a full-length bar
+	chartLabelWidth   = 170
+	heatmapCellSize   = 30
+)
+
+var (
+	chartBarColor = color.NRGBA{R: 0, G: 123, B: 255, A: 200} // Primary blue
+	heatmapColor  = color.NRGBA{R: 40, G: 167, B: 69, A: 255} // Success green
+)
+
+var weekdayNames = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// Lead time buckets, from booking to start
+var leadTimeBuckets = []struct {
+	Label string
+	Limit time.Duration // Upper bound; the last bucket has none
+}{
+	{"Under 1 hour", time.Hour},
+	{"1 - 24 hours", 24 * time.Hour},
+	{"1 - 3 days", 3 * 24 * time.Hour},
+	{"3 - 7 days", 7 * 24 * time.Hour},
+	{"1 - 2 weeks", 14 * 24 * time.Hour},
+	{"Over 2 weeks", 0},
+}
+
+// roomUtilization is the booked share of a room's opening hours
+type roomUtilization struct {
+	Name      string
+	Booked    time.Duration
+	Available time.Duration
+}
+
+func (u roomUtilization) percent() float64 {
+	if u.Available <= 0 {
+		return 0
+	}
+	return 100 * float64(u.Booked) / float64(u.Available)
+}
+
+// purposeCount is how often a purpose was booked
+type purposeCount struct {
+	Purpose string
+	Count   int
+}
+
+// analyticsSummary holds the dashboard figures for a date range
+type analyticsSummary struct {
+	From, To  string
+	Rooms     []roomUtilization
+	FirstHour int            // First hour of the day in the heatmap
+	LastHour  int            // Hour after the last one in the heatmap
+	Occupied  [7][24]float64 // Booked room-minutes by weekday (Monday first) and hour
+	Capacity  [7][24]float64 // Open room-minutes by weekday and hour, for the rooms shown
+	LeadTimes []int          // Bookings in each of leadTimeBuckets
+	Leads     []time.Duration
+	Total     int // Bookings in the range, including cancelled but not undone ones
+	Cancelled int
+	Started   int // Active bookings that have started
+	NoShows   int
+	Purposes  []purposeCount
+}
+
+// overlap returns how long [start, end) and [from, to) have in common.
+func overlap(start, end, from, to time.Time) time.Duration {
+	if from.After(start) {
+		start = from
+	}
+	if to.Before(end) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// mondayIndex numbers weekdays from Monday.
+func mondayIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+// computeAnalytics works out the dashboard figures for the days from and to
+// inclusive, in the site time zone, as of now. Cancelled bookings count
+// only towards the cancellation rate; no-shows still count as booked time.
+func computeAnalytics(from, to string, now time.Time) (analyticsSummary, error) {
+	summary := analyticsSummary{From: from, To: to, LeadTimes: make([]int, len(leadTimeBuckets))}
+	first, err := time.ParseInLocation("2006-01-02", from, siteLocation())
+	if err != nil {
+		return summary, fmt.Errorf("invalid date %q, use YYYY-MM-DD", from)
+	}
+	last, err := time.ParseInLocation("2006-01-02", to, siteLocation())
+	if err != nil {
+		return summary, fmt.Errorf("invalid date %q, use YYYY-MM-DD", to)
+	}
+	if last.Before(first) {
+		return summary, errors.New("the start date is after the end date")
+	}
+	if last.Sub(first) > analyticsMaxDays*24*time.Hour {
+		return summary, fmt.Errorf("choose a range of at most %d days", analyticsMaxDays)
+	}
+
+	openAt, closeAt := openingHours()
+	summary.FirstHour = int(openAt / time.Hour)
+	summary.LastHour = int((closeAt + time.Hour - 1) / time.Hour)
+
+	// hourSlots calls fn for each hour of the day's opening hours, clipped
+	// to them, with the weekday and hour of the slot
+	hourSlots := func(date string, fn func(weekday, hour int, start, end time.Time)) {
+		open, closing, err := businessHours(date)
+		if err != nil {
+			return
+		}
+		weekday := mondayIndex(open.Weekday())
+		for hour := summary.FirstHour; hour < summary.LastHour; hour++ {
+			start := time.Date(open.Year(), open.Month(), open.Day(), hour, 0, 0, 0, open.Location())
+			end := time.Date(open.Year(), open.Month(), open.Day(), hour+1, 0, 0, 0, open.Location())
+			if start.Before(open) {
+				start = open
+			}
+			if end.After(closing) {
+				end = closing
+			}
+			if end.After(start) {
+				fn(weekday, hour, start, end)
+			}
+		}
+	}
+
+	// Opening hours of one room on every day in the range
+	available := time.Duration(0)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if open, closing, err := businessHours(date); err == nil {
+			available += closing.Sub(open)
+		}
+		hourSlots(date, func(weekday, hour int, start, end time.Time) {
+			summary.Capacity[weekday][hour] += end.Sub(start).Minutes()
+		})
+	}
+
+	purposes := map[string]int{}
+	for _, room := range rooms {
+		usage := roomUtilization{Name: room.Name, Available: available}
+		room.mu.Lock()
+		reservations := append([]Reservation{}, room.Reservations...)
+		room.mu.Unlock()
+		for _, res := range reservations {
+			if res.Date < from || res.Date > to || res.Undone {
+				continue
+			}
+			summary.Total++
+			if !res.Active {
+				summary.Cancelled++
+				continue
+			}
+			if res.StartTime.Before(now) {
+				summary.Started++
+				if res.NoShow {
+					summary.NoShows++
+				}
+			}
+			if open, closing, err := businessHours(res.Date); err == nil {
+				usage.Booked += overlap(res.StartTime, res.EndTime, open, closing)
+			}
+			hourSlots(res.Date, func(weekday, hour int, start, end time.Time) {
+				summary.Occupied[weekday][hour] += overlap(res.StartTime, res.EndTime, start, end).Minutes()
+			})
+			if !res.BookedAt.IsZero() {
+				lead := res.StartTime.Sub(res.BookedAt)
+				if lead < 0 {
+					lead = 0
+				}
+				summary.Leads = append(summary.Leads, lead)
+				for i, bucket := range leadTimeBuckets {
+					if bucket.Limit == 0 || lead < bucket.Limit {
+						summary.LeadTimes[i]++
+						break
+					}
+				}
+			}
+			purpose := strings.TrimSpace(res.Purpose)
+			if purpose == "" {
+				purpose = "Not given"
+			}
+			purposes[purpose]++
+		}
+		// Archived rooms only count for periods when they were booked
+		if !room.Archived || usage.Booked > 0 {
+			summary.Rooms = append(summary.Rooms, usage)
+		}
+	}
+	for day := range summary.Capacity {
+		for hour := range summary.Capacity[day] {
+			summary.Capacity[day][hour] *= float64(len(summary.Rooms))
+		}
+	}
+
+	sort.SliceStable(summary.Rooms, func(i, j int) bool {
+		return summary.Rooms[i].Booked > summary.Rooms[j].Booked
+	})
+	sort.Slice(summary.Leads, func(i, j int) bool { return summary.Leads[i] < summary.Leads[j] })
+	for purpose, count := range purposes {
+		summary.Purposes = append(summary.Purposes, purposeCount{Purpose: purpose, Count: count})
+	}
+	sort.Slice(summary.Purposes, func(i, j int) bool {
+		if summary.Purposes[i].Count != summary.Purposes[j].Count {
+			return summary.Purposes[i].Count > summary.Purposes[j].Count
+		}
+		return summary.Purposes[i].Purpose < summary.Purposes[j].Purpose
+	})
+	return summary, nil
+}
+
+// medianLead returns the median lead time of bookings that recorded when
+// they were made.
+func (s analyticsSummary) medianLead() time.Duration {
+	n := len(s.Leads)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return s.Leads[n/2]
+	}
+	return (s.Leads[n/2-1] + s.Leads[n/2]) / 2
+}
+
+// percentOf returns part as a percentage of whole, or 0 for an empty whole.
+func percentOf(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// formatLeadTime shows a lead time in its largest sensible unit.
+func formatLeadTime(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%.1f hours", d.Hours())
+	}
+	return fmt.Sprintf("%.1f days", d.Hours()/24)
+}
+
+// chartBar is one bar of a horizontal bar chart
+type chartBar struct {
+	Label string
+	Value float64
+	Text  string // Shown after the bar
+}
+
+// barChart draws bars scaled so that max fills the full width.
+func barChart(bars []chartBar, max float64) fyne.CanvasObject {
+	rows := container.NewVBox()
+	for _, bar := range bars {
+		width := float32(0)
+		if max > 0 {
+			width = float32(math.Min(bar.Value/max, 1)) * chartBarWidth
+		}
+		rect := canvas.NewRectangle(chartBarColor)
+		rect.SetMinSize(fyne.NewSize(width, theme.TextSize()+4))
+		label := widget.NewLabel(bar.Label)
+		label.Truncation = fyne.TextTruncateEllipsis
+		labelBox := container.NewGridWrap(fyne.NewSize(chartLabelWidth, label.MinSize().Height), label)
+		rows.Add(container.NewHBox(labelBox, container.NewCenter(rect), widget.NewLabel(bar.Text)))
+	}
+	if len(bars) == 0 {
+		rows.Add(widget.NewLabel("No bookings in this period."))
+	}
+	return rows
+}
+
+// heatmap draws the share of room time booked for each weekday and hour.
+// Darker cells are busier; each shows its percentage.
+func heatmap(s analyticsSummary) fyne.CanvasObject {
+	cell := fyne.NewSize(heatmapCellSize, heatmapCellSize)
+	grid := container.NewGridWithColumns(s.LastHour - s.FirstHour + 1)
+
+	text := func(value string, bold bool) fyne.CanvasObject {
+		t := canvas.NewText(value, theme.ForegroundColor())
+		t.TextSize = theme.CaptionTextSize()
+		t.TextStyle.Bold = bold
+		t.Alignment = fyne.TextAlignCenter
+		return container.NewCenter(t)
+	}
+
+	grid.Add(layout.NewSpacer())
+	for hour := s.FirstHour; hour < s.LastHour; hour++ {
+		grid.Add(text(time.Date(2000, 1, 1, hour, 0, 0, 0, time.UTC).Format("3pm"), true))
+	}
+	for day, name := range weekdayNames {
+		grid.Add(text(name, true))
+		for hour := s.FirstHour; hour < s.LastHour; hour++ {
+			share := 0.0
+			if s.Capacity[day][hour] > 0 {
+				share = math.Min(s.Occupied[day][hour]/s.Capacity[day][hour], 1)
+			}
+			fill := heatmapColor
+			fill.A = uint8(20 + 235*share)
+			rect := canvas.NewRectangle(fill)
+			rect.SetMinSize(cell)
+			label := ""
+			if s.Capacity[day][hour] > 0 {
+				label = fmt.Sprintf("%.0f", share*100)
+			}
+			grid.Add(container.NewMax(rect, text(label, false)))
+		}
+	}
+	scroll := container.NewHScroll(grid)
+	scroll.SetMinSize(fyne.NewSize(0, grid.MinSize().Height))
+	return scroll
+}
+
+// analyticsView lays out the dashboard for a summary.
+func analyticsView(s analyticsSummary) fyne.CanvasObject {
+	heading := func(text string) fyne.CanvasObject {
+		return widget.NewLabelWithStyle(text, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+
+	utilization := []chartBar{}
+	totalBooked, totalAvailable := time.Duration(0), time.Duration(0)
+	for _, room := range s.Rooms {
+		utilization = append(utilization, chartBar{
+			Label: room.Name,
+			Value: room.percent(),
+			Text:  fmt.Sprintf("%.1f%% (%.1f h)", room.percent(), room.Booked.Hours()),
+		})
+		totalBooked += room.Booked
+		totalAvailable += room.Available
+	}
+	overall := roomUtilization{Booked: totalBooked, Available: totalAvailable}
+
+	leads := []chartBar{}
+	maxLead := 0
+	for _, count := range s.LeadTimes {
+		if count > maxLead {
+			maxLead = count
+		}
+	}
+	for i, bucket := range leadTimeBuckets {
+		leads = append(leads, chartBar{Label: bucket.Label, Value: float64(s.LeadTimes[i]), Text: fmt.Sprint(s.LeadTimes[i])})
+	}
+	leadSummary := "No bookings in this period recorded when they were made."
+	if len(s.Leads) > 0 {
+		leadSummary = fmt.Sprintf("Median %s between booking and start, over %d bookings.", formatLeadTime(s.medianLead()), len(s.Leads))
+	}
+
+	purposes := []chartBar{}
+	active := s.Total - s.Cancelled
+	for i, purpose := range s.Purposes {
+		if i == analyticsTopCount {
+			break
+		}
+		purposes = append(purposes, chartBar{
+			Label: purpose.Purpose,
+			Value: float64(purpose.Count),
+			Text:  fmt.Sprintf("%d (%.0f%%)", purpose.Count, percentOf(purpose.Count, active)),
+		})
+	}
+	maxPurpose := 0.0
+	if len(s.Purposes) > 0 {
+		maxPurpose = float64(s.Purposes[0].Count)
+	}
+
+	rates := widget.NewLabel(fmt.Sprintf(
+		"Bookings: %d\nCancellation rate: %.1f%% (%d of %d bookings)\nNo-show rate: %.1f%% (%d of %d bookings that have started)",
+		s.Total,
+		percentOf(s.Cancelled, s.Total), s.Cancelled, s.Total,
+		percentOf(s.NoShows, s.Started), s.NoShows, s.Started,
+	))
+
+	return container.NewVBox(
+		heading(fmt.Sprintf("Room Utilization, %s to %s", s.From, s.To)),
+		widget.NewLabel(fmt.Sprintf("Share of opening hours booked. Overall %.1f%%.", overall.percent())),
+		barChart(utilization, 100),
+		widget.NewSeparator(),
+		heading("Peak Hours"),
+		widget.NewLabel("Percentage of room time booked by weekday and hour."),
+		heatmap(s),
+		widget.NewSeparator(),
+		heading("Cancellations and No-Shows"),
+		rates,
+		widget.NewSeparator(),
+		heading("Booking Lead Times"),
+		widget.NewLabel(leadSummary),
+		barChart(leads, float64(maxLead)),
+		widget.NewSeparator(),
+		heading("Top Purposes"),
+		barChart(purposes, maxPurpose),
+	)
+}
+
+// showAnalytics opens the utilization dashboard for admins.
+func showAnalytics(w fyne.Window) {
+	periods := []string{}
+	for _, period := range reportPeriods {
+		if period != reportPeriodToday {
+			periods = append(periods, period)
+		}
+	}
+	periodSelect := widget.NewSelect(periods, nil)
+	fromEntry := widget.NewEntry()
+	fromEntry.SetPlaceHolder("YYYY-MM-DD")
+	toEntry := widget.NewEntry()
+	toEntry.SetPlaceHolder("YYYY-MM-DD")
+	body := container.NewMax()
+
+	refresh := func() {
+		filter := ReportFilter{Period: periodSelect.Selected, From: strings.TrimSpace(fromEntry.Text), To: strings.TrimSpace(toEntry.Text)}
+		from, to := filter.dateRange(time.Now())
+		if from == "" || to == "" {
+			body.Objects = []fyne.CanvasObject{widget.NewLabel("Enter both dates.")}
+			body.Refresh()
+			return
+		}
+		summary, err := computeAnalytics(from, to, time.Now())
+		if err != nil {
+			body.Objects = []fyne.CanvasObject{widget.NewLabel(err.Error())}
+		} else {
+			body.Objects = []fyne.CanvasObject{analyticsView(summary)}
+		}
+		body.Refresh()
+	}
+	periodSelect.OnChanged = func(period string) {
+		if period == reportPeriodCustom {
+			from, to := ReportFilter{Period: reportPeriodLast30}.dateRange(time.Now())
+			if fromEntry.Text == "" && toEntry.Text == "" {
+				fromEntry.SetText(from)
+				toEntry.SetText(to)
+			}
+			fromEntry.Enable()
+			toEntry.Enable()
+		} else {
+			fromEntry.Disable()
+			toEntry.Disable()
+		}
+		refresh()
+	}
+	fromEntry.OnSubmitted = func(string) { refresh() }
+	toEntry.OnSubmitted = func(string) { refresh() }
+	periodSelect.SetSelected(reportPeriodLast30)
+
+	refreshButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), refresh)
+	controls := container.NewHBox(
+		widget.NewLabel("Period"), periodSelect,
+		widget.NewLabel("From"), container.NewGridWrap(fyne.NewSize(120, fromEntry.MinSize().Height), fromEntry),
+		widget.NewLabel("To"), container.NewGridWrap(fyne.NewSize(120, toEntry.MinSize().Height), toEntry),
+		refreshButton,
+	)
+	d := dialog.NewCustom("Analytics", "Close", container.NewBorder(controls, nil, nil, nil, container.NewVScroll(body)), w)
+	d.Resize(fyne.NewSize(800, 700))
+	d.Show()
+}
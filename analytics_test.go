@@ -0,0 +1,38 @@
+// analytics_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyticsLeavesOutUndoneBookings(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	var commands []*ReservationCommand
+	for i, purpose := range []string{"Kept", "Cancelled", "Undone"} {
+		c := &ReservationCommand{room: room, reservation: testReservation(start.Add(time.Duration(i)*time.Hour), purpose)}
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		commands = append(commands, c)
+	}
+	if err := commands[2].Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CancelReservationCommand{room: room, index: commands[1].index}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	// A cancellation saved by an earlier version, which has no Undone flag
+	room.Reservations = append(room.Reservations, testReservation(start.Add(3*time.Hour), "Earlier"))
+
+	summary, err := computeAnalytics("2026-03-02", "2026-03-02", start.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Total != 3 || summary.Cancelled != 2 {
+		t.Errorf("%d bookings with %d cancelled, want 3 with 2 cancelled", summary.Total, summary.Cancelled)
+	}
+}
@@ -0,0 +1,625 @@
+// auth.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Where an account's password is checked
+const (
+	authSourceLocal = ""
+	authSourceLDAP  = "ldap"
+	authSourceOIDC  = "oidc" // No password; signs in with SSO
+)
+
+const (
+	defaultLDAPUserFilter     = "(&(objectClass=person)(uid={username}))"
+	defaultLDAPEmailAttribute = "mail"
+	defaultLDAPGroupAttribute = "memberOf"
+)
+
+// errUnknownUser tells authenticateUser to try the next provider
+var errUnknownUser = errors.New("user not found")
+
+// errInvalidCredentials is the only failure shown for a wrong username or
+// password, so sign-in does not reveal which usernames exist.
+var errInvalidCredentials = errors.New("incorrect username or password")
+
+// dummyHash is compared against when no account matches, so unknown
+// usernames take as long to reject as wrong passwords. It must use the same
+// cost as real password hashes.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("roomy-unknown-user"), passwordHashCost)
+
+// AuthProvider checks a username and password against one user store.
+type AuthProvider interface {
+	Name() string
+	// Authenticate returns the signed-in user, or errUnknownUser if the
+	// provider has no such account.
+	Authenticate(username, password string) (*User, error)
+}
+
+// LDAPSettings configures sign-in against an LDAP or Active Directory server.
+// Users are found either by searching BaseDN with UserFilter (using the
+// service account in BindDN if set) or by binding directly as UserDNTemplate.
+type LDAPSettings struct {
+	Enabled        bool
+	URL            string // ldap://host:389 or ldaps://host:636
+	StartTLS       bool
+	SkipVerify     bool // Accept any server certificate; for testing only
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string // {username} is replaced with the escaped username
+	UserDNTemplate string // e.g. uid={username},ou=people,dc=example,dc=com
+	EmailAttribute string
+	GroupAttribute string   // Attribute on the user entry listing its groups
+	GroupFilter    string   // Optional group search; {dn} is replaced with the user's DN
+	AdminGroups    []string // Members get the Admin role
+	UserGroups     []string // If set, only members of these or AdminGroups may sign in
+}
+
+func (c LDAPSettings) userFilter() string {
+	if c.UserFilter == "" {
+		return defaultLDAPUserFilter
+	}
+	return c.UserFilter
+}
+
+func (c LDAPSettings) emailAttribute() string {
+	if c.EmailAttribute == "" {
+		return defaultLDAPEmailAttribute
+	}
+	return c.EmailAttribute
+}
+
+func (c LDAPSettings) groupAttribute() string {
+	if c.GroupAttribute == "" {
+		return defaultLDAPGroupAttribute
+	}
+	return c.GroupAttribute
+}
+
+// authProviders returns the enabled providers in the order they are tried.
+// Local accounts come first so a directory outage never locks out the
+// local admin.
+func authProviders() []AuthProvider {
+	providers := []AuthProvider{localAuthProvider{}}
+	if settings.LDAP.Enabled {
+		providers = append(providers, ldapAuthProvider{config: settings.LDAP})
+	}
+	return providers
+}
+
+func authenticateUser(username, password string) (*User, error) {
+	for _, provider := range authProviders() {
+		user, err := provider.Authenticate(username, password)
+		if err == errUnknownUser {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	return nil, errInvalidCredentials
+}
+
+// finishSignIn runs the steps that follow a correct password: the
+// two-factor code, then any forced password change or two-factor setup.
+// onSuccess is only called once all of them are done.
+func finishSignIn(user *User, w fyne.Window, onSuccess func(*User)) {
+	signIn := func() {
+		currentUser = user
+		onSuccess(user)
+	}
+	enrolStep := func() {
+		if mustEnrolTwoFactor(user) {
+			showTwoFactorSetup(user, true, w, signIn)
+			return
+		}
+		signIn()
+	}
+	passwordStep := func() {
+		if user.MustChangePassword {
+			showChangePassword(user, true, w, enrolStep)
+			return
+		}
+		enrolStep()
+	}
+	if needsTwoFactor(user) {
+		showTwoFactorPrompt(user, w, passwordStep)
+		return
+	}
+	passwordStep()
+}
+
+// localAuthProvider checks the bcrypt hashes in users.json
+type localAuthProvider struct{}
+
+func (localAuthProvider) Name() string { return "Local" }
+
+func (localAuthProvider) Authenticate(username, password string) (*User, error) {
+	user := findUser(username)
+	if user == nil || user.Source != authSourceLocal {
+		return nil, errUnknownUser
+	}
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return nil, errInvalidCredentials
+	}
+	upgradePasswordHash(user, password)
+	return user, nil
+}
+
+// ldapAuthProvider binds to a directory server and provisions a local
+// account for the user on first sign-in.
+type ldapAuthProvider struct {
+	config LDAPSettings
+}
+
+func (ldapAuthProvider) Name() string { return "LDAP" }
+
+// directoryIdentity is what roomy learns about a user from LDAP or an
+// OpenID provider
+type directoryIdentity struct {
+	ID     string // Distinguished name or OpenID subject
+	Issuer string // OpenID provider; empty for LDAP
+	Email  string
+	Groups []string
+	Role   string
+}
+
+func (p ldapAuthProvider) Authenticate(username, password string) (*User, error) {
+	if user := findUser(username); user != nil && user.Source != authSourceLDAP {
+		return nil, errUnknownUser
+	}
+	identity, err := p.lookup(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return provisionDirectoryUser(username, authSourceLDAP, identity)
+}
+
+// lookup verifies the password with a bind as the user and reads their
+// email address and groups.
+func (p ldapAuthProvider) lookup(username, password string) (directoryIdentity, error) {
+	// An empty password would be an unauthenticated bind, which servers accept
+	if username == "" || password == "" {
+		return directoryIdentity{}, errInvalidCredentials
+	}
+	conn, err := dialLDAP(p.config.URL, p.config.StartTLS, p.config.SkipVerify)
+	if err != nil {
+		log.Printf("Error connecting to directory: %v\n", err)
+		return directoryIdentity{}, fmt.Errorf("could not reach the directory server")
+	}
+	defer conn.Close()
+
+	attributes := []string{p.config.emailAttribute(), p.config.groupAttribute()}
+	var entry ldapEntry
+	if p.config.UserDNTemplate != "" {
+		dn := strings.ReplaceAll(p.config.UserDNTemplate, "{username}", escapeLDAPDN(username))
+		if err := p.bindUser(conn, dn, password); err != nil {
+			return directoryIdentity{}, err
+		}
+		entries, err := conn.Search(dn, ldapScopeBase, "(objectClass=*)", attributes)
+		if err != nil || len(entries) != 1 {
+			log.Printf("Error reading directory entry %q: %v\n", dn, err)
+			return directoryIdentity{}, fmt.Errorf("could not read your directory entry")
+		}
+		entry = entries[0]
+	} else {
+		if p.config.BindDN != "" {
+			if err := conn.Bind(p.config.BindDN, p.config.BindPassword); err != nil {
+				log.Printf("Error binding directory service account: %v\n", err)
+				return directoryIdentity{}, fmt.Errorf("could not sign in to the directory server")
+			}
+		}
+		filter := strings.ReplaceAll(p.config.userFilter(), "{username}", escapeLDAPFilterValue(username))
+		entries, err := conn.Search(p.config.BaseDN, ldapScopeSubtree, filter, attributes)
+		if err != nil {
+			log.Printf("Error searching directory: %v\n", err)
+			return directoryIdentity{}, fmt.Errorf("could not search the directory")
+		}
+		if len(entries) == 0 {
+			return directoryIdentity{}, errUnknownUser
+		}
+		if len(entries) > 1 {
+			return directoryIdentity{}, fmt.Errorf("username %q matches more than one directory entry", username)
+		}
+		entry = entries[0]
+		if err := p.bindUser(conn, entry.DN, password); err != nil {
+			return directoryIdentity{}, err
+		}
+	}
+
+	identity := directoryIdentity{
+		ID:     entry.DN,
+		Email:  entry.first(p.config.emailAttribute()),
+		Groups: entry.Attributes[strings.ToLower(p.config.groupAttribute())],
+	}
+	if p.config.GroupFilter != "" {
+		filter := strings.ReplaceAll(p.config.GroupFilter, "{dn}", escapeLDAPFilterValue(entry.DN))
+		groups, err := conn.Search(p.config.BaseDN, ldapScopeSubtree, filter, []string{"cn"})
+		if err != nil {
+			log.Printf("Error searching directory groups: %v\n", err)
+			return directoryIdentity{}, fmt.Errorf("could not read your directory groups")
+		}
+		for _, group := range groups {
+			identity.Groups = append(identity.Groups, group.DN)
+		}
+	}
+
+	role, ok := roleForGroups(identity.Groups, p.config.AdminGroups, p.config.UserGroups)
+	if !ok {
+		return directoryIdentity{}, fmt.Errorf("your directory account is not in a group allowed to use roomy")
+	}
+	identity.Role = role
+	return identity, nil
+}
+
+func (p ldapAuthProvider) bindUser(conn *ldapConn, dn, password string) error {
+	err := conn.Bind(dn, password)
+	var ldapErr *ldapError
+	if errors.As(err, &ldapErr) && ldapErr.Code == ldapInvalidCredentials {
+		return errInvalidCredentials
+	}
+	if err != nil {
+		log.Printf("Error binding to directory as %q: %v\n", dn, err)
+		return fmt.Errorf("could not sign in to the directory server")
+	}
+	return nil
+}
+
+// roleForGroups maps a user's groups to a roomy role. ok is false if
+// userGroups is set and the user is in none of the configured groups.
+func roleForGroups(groups, adminGroups, userGroups []string) (role string, ok bool) {
+	if inAnyGroup(groups, adminGroups) {
+		return "Admin", true
+	}
+	if len(userGroups) == 0 || inAnyGroup(groups, userGroups) {
+		return "User", true
+	}
+	return "", false
+}
+
+// inAnyGroup reports whether any of groups matches a configured group,
+// given either as a full DN or just its common name.
+func inAnyGroup(groups, configured []string) bool {
+	for _, group := range groups {
+		for _, want := range configured {
+			if strings.EqualFold(group, want) || strings.EqualFold(groupName(group), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupName returns the value of the first RDN of a DN, e.g. "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com".
+func groupName(dn string) string {
+	rdn := dn
+	if i := strings.IndexByte(dn, ','); i >= 0 {
+		rdn = dn[:i]
+	}
+	if i := strings.IndexByte(rdn, '='); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return rdn
+}
+
+// provisionDirectoryUser creates the local account for an LDAP or SSO user
+// on first sign-in, and keeps its role in step with their groups afterwards.
+// LDAP accounts are found by username, which the directory checked. SSO
+// accounts are found by issuer and subject, since users can often change
+// their username at the provider; the username claim only names the account.
+func provisionDirectoryUser(username, source string, identity directoryIdentity) (*User, error) {
+	email := identity.Email
+	if validateEmail(email) != nil {
+		email = ""
+	}
+
+	var user *User
+	changed := false
+	if source == authSourceOIDC {
+		if identity.Issuer == "" || identity.ID == "" {
+			return nil, errors.New("the provider did not identify the account")
+		}
+		user = findSSOUser(identity.Issuer, identity.ID)
+		if user == nil {
+			// Accounts created before subjects were recorded are bound to the
+			// first provider account that signs in with their name
+			if legacy := findUser(username); legacy != nil && legacy.Source == authSourceOIDC && legacy.Subject == "" {
+				log.Printf("Binding SSO account %s to subject %s of %s\n", legacy.Username, identity.ID, identity.Issuer)
+				legacy.Issuer, legacy.Subject = identity.Issuer, identity.ID
+				user, changed = legacy, true
+			} else {
+				username = availableUsername(directoryUsername(username))
+			}
+		}
+	} else {
+		user = findUser(username)
+		if user != nil && user.Source != source {
+			return nil, fmt.Errorf("a different roomy account named %q already exists", username)
+		}
+		// The account is found by the name typed at sign-in, so it cannot
+		// be renamed like an SSO account
+		if user == nil {
+			if err := validateUsername(username); err != nil {
+				return nil, fmt.Errorf("cannot create a roomy account for %q: %v", username, err)
+			}
+			username = normalizeUsername(username)
+		}
+	}
+
+	if user == nil {
+		account := User{
+			Username: username,
+			Role:     identity.Role,
+			Email:    email,
+			Source:   source,
+		}
+		if source == authSourceOIDC {
+			account.Issuer, account.Subject = identity.Issuer, identity.ID
+		}
+		users = append(users, account)
+		refreshCurrentUser()
+		saveUsers()
+		emitWebhook(webhookUserCreated, userPayload{Username: username, Role: identity.Role})
+		return findUser(username), nil
+	}
+
+	if user.Role != identity.Role {
+		if user.Role == "Admin" && countAdmins() == 1 {
+			log.Printf("Not demoting %s: they are the last admin account\n", username)
+		} else {
+			user.Role = identity.Role
+			changed = true
+		}
+	}
+	if user.Email == "" && email != "" {
+		user.Email = email
+		changed = true
+	}
+	if changed {
+		saveUsers()
+	}
+	return user, nil
+}
+
+// findSSOUser returns the account bound to subject at issuer.
+func findSSOUser(issuer, subject string) *User {
+	for i := range users {
+		if users[i].Source == authSourceOIDC && users[i].Issuer == issuer && users[i].Subject == subject {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// availableUsername returns name, or name with a number added if another
+// account already has it, ignoring case. The name is shortened to make room
+// for the number.
+func availableUsername(name string) string {
+	candidate := name
+	for i := 2; findUser(candidate) != nil; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		base := []rune(name)
+		if len(base)+len(suffix) > maxUsernameLength {
+			base = base[:maxUsernameLength-len(suffix)]
+		}
+		candidate = string(base) + suffix
+	}
+	return candidate
+}
+
+// validateLDAPSettings checks the settings needed to find and bind users.
+func validateLDAPSettings(c LDAPSettings) error {
+	if !c.Enabled {
+		return nil
+	}
+	parsed, err := url.Parse(c.URL)
+	if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Hostname() == "" {
+		return fmt.Errorf("server URL must look like ldap://host or ldaps://host")
+	}
+	if c.UserDNTemplate != "" {
+		if !strings.Contains(c.UserDNTemplate, "{username}") {
+			return fmt.Errorf("user DN template must contain {username}")
+		}
+	} else {
+		if c.BaseDN == "" {
+			return fmt.Errorf("base DN is required when searching for users")
+		}
+		if !strings.Contains(c.userFilter(), "{username}") {
+			return fmt.Errorf("user filter must contain {username}")
+		}
+		if _, err := encodeLDAPFilter(c.userFilter()); err != nil {
+			return err
+		}
+	}
+	if c.GroupFilter != "" {
+		if c.BaseDN == "" {
+			return fmt.Errorf("base DN is required for the group filter")
+		}
+		if _, err := encodeLDAPFilter(c.GroupFilter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLines returns the non-empty trimmed lines of s.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// ldapSettingsForm returns the LDAP tab of the authentication settings and a
+// function reading the entered values.
+func ldapSettingsForm(w fyne.Window) (fyne.CanvasObject, func() LDAPSettings) {
+	config := settings.LDAP
+	enabledCheck := widget.NewCheck("Allow directory sign-in", nil)
+	enabledCheck.SetChecked(config.Enabled)
+	urlEntry := widget.NewEntry()
+	urlEntry.SetText(config.URL)
+	urlEntry.SetPlaceHolder("ldaps://directory.example.com")
+	startTLSCheck := widget.NewCheck("Use StartTLS", nil)
+	startTLSCheck.SetChecked(config.StartTLS)
+	skipVerifyCheck := widget.NewCheck("Skip certificate verification", nil)
+	skipVerifyCheck.SetChecked(config.SkipVerify)
+	bindDNEntry := widget.NewEntry()
+	bindDNEntry.SetText(config.BindDN)
+	bindPasswordEntry := widget.NewPasswordEntry()
+	bindPasswordEntry.SetText(config.BindPassword)
+	baseDNEntry := widget.NewEntry()
+	baseDNEntry.SetText(config.BaseDN)
+	baseDNEntry.SetPlaceHolder("dc=example,dc=com")
+	userFilterEntry := widget.NewEntry()
+	userFilterEntry.SetText(config.UserFilter)
+	userFilterEntry.SetPlaceHolder(defaultLDAPUserFilter)
+	userDNEntry := widget.NewEntry()
+	userDNEntry.SetText(config.UserDNTemplate)
+	userDNEntry.SetPlaceHolder("uid={username},ou=people,dc=example,dc=com")
+	emailAttributeEntry := widget.NewEntry()
+	emailAttributeEntry.SetText(config.EmailAttribute)
+	emailAttributeEntry.SetPlaceHolder(defaultLDAPEmailAttribute)
+	groupAttributeEntry := widget.NewEntry()
+	groupAttributeEntry.SetText(config.GroupAttribute)
+	groupAttributeEntry.SetPlaceHolder(defaultLDAPGroupAttribute)
+	groupFilterEntry := widget.NewEntry()
+	groupFilterEntry.SetText(config.GroupFilter)
+	groupFilterEntry.SetPlaceHolder("(&(objectClass=groupOfNames)(member={dn}))")
+	adminGroupsEntry := widget.NewMultiLineEntry()
+	adminGroupsEntry.SetText(strings.Join(config.AdminGroups, "\n"))
+	adminGroupsEntry.SetPlaceHolder("One group name or DN per line")
+	userGroupsEntry := widget.NewMultiLineEntry()
+	userGroupsEntry.SetText(strings.Join(config.UserGroups, "\n"))
+	userGroupsEntry.SetPlaceHolder("Empty allows any directory user")
+
+	current := func() LDAPSettings {
+		return LDAPSettings{
+			Enabled:        enabledCheck.Checked,
+			URL:            strings.TrimSpace(urlEntry.Text),
+			StartTLS:       startTLSCheck.Checked,
+			SkipVerify:     skipVerifyCheck.Checked,
+			BindDN:         strings.TrimSpace(bindDNEntry.Text),
+			BindPassword:   bindPasswordEntry.Text,
+			BaseDN:         strings.TrimSpace(baseDNEntry.Text),
+			UserFilter:     strings.TrimSpace(userFilterEntry.Text),
+			UserDNTemplate: strings.TrimSpace(userDNEntry.Text),
+			EmailAttribute: strings.TrimSpace(emailAttributeEntry.Text),
+			GroupAttribute: strings.TrimSpace(groupAttributeEntry.Text),
+			GroupFilter:    strings.TrimSpace(groupFilterEntry.Text),
+			AdminGroups:    splitLines(adminGroupsEntry.Text),
+			UserGroups:     splitLines(userGroupsEntry.Text),
+		}
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("LDAP", enabledCheck),
+		widget.NewFormItem("Server URL", urlEntry),
+		widget.NewFormItem("", container.NewHBox(startTLSCheck, skipVerifyCheck)),
+		&widget.FormItem{Text: "Bind DN", Widget: bindDNEntry, HintText: "Service account used to search; empty searches anonymously"},
+		widget.NewFormItem("Bind Password", bindPasswordEntry),
+		widget.NewFormItem("Base DN", baseDNEntry),
+		&widget.FormItem{Text: "User Filter", Widget: userFilterEntry, HintText: "{username} is replaced with the name typed at sign-in"},
+		&widget.FormItem{Text: "User DN Template", Widget: userDNEntry, HintText: "Binds directly instead of searching; leave empty to search"},
+		widget.NewFormItem("Email Attribute", emailAttributeEntry),
+		widget.NewFormItem("Group Attribute", groupAttributeEntry),
+		&widget.FormItem{Text: "Group Filter", Widget: groupFilterEntry, HintText: "For servers without memberOf; {dn} is the user's DN"},
+		widget.NewFormItem("Admin Groups", adminGroupsEntry),
+		widget.NewFormItem("User Groups", userGroupsEntry),
+	)
+
+	testButton := widget.NewButton("Test Sign-In", func() {
+		config := current()
+		config.Enabled = true
+		if err := validateLDAPSettings(config); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		usernameEntry := widget.NewEntry()
+		passwordEntry := widget.NewPasswordEntry()
+		dialog.ShowForm("Test Sign-In", "Test", "Cancel", []*widget.FormItem{
+			{Text: "Username", Widget: usernameEntry},
+			{Text: "Password", Widget: passwordEntry},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			identity, err := ldapAuthProvider{config: config}.lookup(usernameEntry.Text, passwordEntry.Text)
+			if err == errUnknownUser {
+				err = fmt.Errorf("no directory entry matches %q", usernameEntry.Text)
+			}
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			dialog.ShowInformation("Test Sign-In", fmt.Sprintf("Signed in as %s\nEmail: %s\nGroups: %d\nRole: %s",
+				identity.ID, identity.Email, len(identity.Groups), identity.Role), w)
+		}, w)
+	})
+
+	return container.NewBorder(nil, testButton, nil, nil, container.NewVScroll(form)), current
+}
+
+func showAuthSettings(w fyne.Window) {
+	ldapTab, currentLDAP := ldapSettingsForm(w)
+	oidcTab, currentOIDC := oidcSettingsForm(w)
+	loginTab, currentLogin := loginSettingsForm()
+	passwordTab, currentPolicy := passwordSettingsForm()
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Passwords", passwordTab),
+		container.NewTabItem("Sign-In", loginTab),
+		container.NewTabItem("LDAP", ldapTab),
+		container.NewTabItem("OpenID Connect", oidcTab),
+	)
+
+	d := dialog.NewCustomConfirm("Authentication", "Save", "Cancel", tabs, func(save bool) {
+		if !save {
+			return
+		}
+		ldap, oidc := currentLDAP(), currentOIDC()
+		if err := validateLDAPSettings(ldap); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if err := validateOIDCSettings(oidc); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		login, err := currentLogin()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		policy, err := currentPolicy()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		settings.Passwords = policy
+		settings.LDAP = ldap
+		settings.OIDC = oidc
+		settings.Login = login
+		saveSettings()
+	}, w)
+	d.Resize(fyne.NewSize(600, 550))
+	d.Show()
+}
@@ -0,0 +1,19 @@
+// auth_test.go
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyHashMatchesPasswordCost(t *testing.T) {
+	cost, err := bcrypt.Cost(dummyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != passwordHashCost {
+		t.Errorf("dummy hash cost = %d, want %d so unknown usernames take as long to reject", cost, passwordHashCost)
+	}
+}
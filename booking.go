@@ -0,0 +1,236 @@
+// booking.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bookingBlock is a run of contiguous selected slots in a single room.
+type bookingBlock struct {
+	RoomID   string
+	RoomName string
+	Start    time.Time
+	End      time.Time
+}
+
+// groupSelectedSlots turns selected slot keys into contiguous blocks per room,
+// ordered as the rooms appear in the grid and then by start time.
+func groupSelectedSlots(keys []string, interval time.Duration) []bookingBlock {
+	roomSlotsMap := make(map[string][]time.Time)
+	for _, key := range keys {
+		roomID, slot := parseSlotKey(key)
+		roomSlotsMap[roomID] = append(roomSlotsMap[roomID], slot)
+	}
+
+	blocks := []bookingBlock{}
+	for _, room := range rooms {
+		slots, ok := roomSlotsMap[room.ID]
+		if !ok {
+			continue
+		}
+
+		// Sort slots by time
+		sort.Slice(slots, func(i, j int) bool {
+			return slots[i].Before(slots[j])
+		})
+
+		// Split into contiguous runs
+		runStart := 0
+		for i := 1; i <= len(slots); i++ {
+			if i < len(slots) && slots[i].Sub(slots[i-1]) == interval {
+				continue
+			}
+			blocks = append(blocks, bookingBlock{
+				RoomID:   room.ID,
+				RoomName: room.Name,
+				Start:    slots[runStart],
+				End:      slots[i-1].Add(interval),
+			})
+			runStart = i
+		}
+	}
+	return blocks
+}
+
+// findBookingConflicts lists every grid cell of the requested reservations
+// that is already taken by an existing booking.
+func findBookingConflicts(reservations []Reservation, interval time.Duration) []string {
+	conflicts := []string{}
+	for _, res := range reservations {
+		room := reservationRoom(res)
+		if room == nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s (room not found)", res.RoomName))
+			continue
+		}
+		// Walk the cells the reservation touches, clipped to its own range
+		for cell := alignToSlot(res.StartTime, interval); cell.Before(res.EndTime); cell = cell.Add(interval) {
+			cellStart, cellEnd := cell, cell.Add(interval)
+			if cellStart.Before(res.StartTime) {
+				cellStart = res.StartTime
+			}
+			if cellEnd.After(res.EndTime) {
+				cellEnd = res.EndTime
+			}
+			probe := res
+			probe.StartTime, probe.EndTime = cellStart, cellEnd
+			room.mu.Lock()
+			taken := room.overlaps(probe, -1)
+			room.mu.Unlock()
+			if taken {
+				conflicts = append(conflicts, fmt.Sprintf("%s at %s", res.RoomName, formatClock(cell)))
+			}
+		}
+	}
+	return conflicts
+}
+
+// alignToSlot rounds t down to the start of its grid cell, counted from
+// midnight in the site time zone so zone offsets don't shift the cells.
+func alignToSlot(t time.Time, interval time.Duration) time.Time {
+	local := t.In(siteLocation())
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return midnight.Add(local.Sub(midnight) / interval * interval)
+}
+
+// parseClockTime accepts times such as "9:45 AM", "9:45am", "9 AM" or "21:30".
+func parseClockTime(value string) (time.Time, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	for _, layout := range []string{timeLayout12Hour, "3:04PM", "3 PM", "3PM", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// resolveClockTime turns a typed wall-clock time on date into an instant in
+// the site time zone. If the text still shows the pre-filled value, the
+// original instant is kept so a repeated hour on a DST change day keeps
+// pointing at the slot that was selected.
+func resolveClockTime(date, text string, prefilled time.Time) (time.Time, error) {
+	if !prefilled.IsZero() && strings.TrimSpace(text) == formatClock(prefilled) {
+		return prefilled, nil
+	}
+	clock, err := parseClockTime(text)
+	if err != nil {
+		return time.Time{}, err
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, siteLocation())
+	if err != nil {
+		return time.Time{}, errors.New("invalid date format")
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, day.Location()), nil
+}
+
+// newBlockReservation builds the reservation for one block on the given date
+// from the start and end times typed in the form.
+func newBlockReservation(date string, block bookingBlock, startText, endText, purpose, leader, student string) (Reservation, error) {
+	startDateTime, err := resolveClockTime(date, startText, block.Start)
+	if err != nil {
+		return Reservation{}, errors.New("invalid start time format")
+	}
+	endDateTime, err := resolveClockTime(date, endText, block.End)
+	if err != nil {
+		return Reservation{}, errors.New("invalid end time format")
+	}
+
+	// A block ending at midnight finishes on the following day
+	if end := endDateTime.In(siteLocation()); !endDateTime.After(startDateTime) && end.Hour() == 0 && end.Minute() == 0 {
+		endDateTime = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, end.Location())
+	}
+
+	if !startDateTime.Before(endDateTime) {
+		return Reservation{}, errors.New("end time must be after start time")
+	}
+
+	reservation := Reservation{
+		RoomID:    block.RoomID,
+		RoomName:  block.RoomName,
+		Date:      startDateTime.In(siteLocation()).Format("2006-01-02"),
+		StartTime: startDateTime,
+		EndTime:   endDateTime,
+		TimeZone:  siteLocation().String(),
+		Purpose:   purpose,
+		Leader:    leader,
+		Student:   student,
+		Priority:  getPriority(purpose),
+		Active:    true,
+	}
+	if currentUser != nil {
+		reservation.BookedBy = currentUser.Username
+	}
+	reservation.BookedAt = time.Now()
+	return reservation, nil
+}
+
+// formatForViewer shows t in the site time zone and, when the viewer's
+// machine is in a different zone, in their local time as well.
+func formatForViewer(t time.Time) string {
+	site := t.In(siteLocation())
+	local := t.In(time.Local)
+	_, siteOffset := site.Zone()
+	_, localOffset := local.Zone()
+	if siteOffset != localOffset {
+		return fmt.Sprintf("%s (%s your time)", site.Format(timeLayout12Hour+" MST"), local.Format(timeLayout12Hour+" MST"))
+	}
+	return site.Format(timeLayout12Hour)
+}
+
+// businessHours returns when the bookable day opens and closes on date in
+// the site time zone, as set in the opening hours (8 AM until midnight by
+// default).
+func businessHours(date string) (time.Time, time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, siteLocation())
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	openAt, closeAt := openingHours()
+	wallClock := func(d time.Duration) time.Time {
+		// Built from the wall clock rather than added to midnight, so a DST
+		// change does not shift the hours
+		return time.Date(day.Year(), day.Month(), day.Day(), int(d.Hours()), int(d.Minutes())%60, 0, 0, day.Location())
+	}
+	return wallClock(openAt), wallClock(closeAt), nil
+}
+
+// roomDaySchedule returns the indexes of a room's active reservations during
+// business hours on date, in start order, and the free windows between them.
+func roomDaySchedule(room *Room, date string) ([]int, []bookingBlock) {
+	open, closing, err := businessHours(date)
+	if err != nil {
+		return nil, nil
+	}
+
+	room.mu.Lock()
+	busy := []int{}
+	for i, res := range room.Reservations {
+		if res.Active && res.StartTime.Before(closing) && res.EndTime.After(open) {
+			busy = append(busy, i)
+		}
+	}
+	sort.Slice(busy, func(i, j int) bool {
+		return room.Reservations[busy[i]].StartTime.Before(room.Reservations[busy[j]].StartTime)
+	})
+
+	free := []bookingBlock{}
+	cursor := open
+	for _, i := range busy {
+		res := room.Reservations[i]
+		if res.StartTime.After(cursor) {
+			free = append(free, bookingBlock{RoomID: room.ID, RoomName: room.Name, Start: cursor, End: res.StartTime})
+		}
+		if res.EndTime.After(cursor) {
+			cursor = res.EndTime
+		}
+	}
+	room.mu.Unlock()
+	if cursor.Before(closing) {
+		free = append(free, bookingBlock{RoomID: room.ID, RoomName: room.Name, Start: cursor, End: closing})
+	}
+	return busy, free
+}
@@ -0,0 +1,341 @@
+// booking_test.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// useTestSiteZone sets the site time zone for the length of the test.
+func useTestSiteZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	useTestDataDir(t)
+	settings.TimeZone = name
+	saveSettings()
+	return siteLocation()
+}
+
+func TestSiteLocationLoadedWithSettings(t *testing.T) {
+	loc := useTestSiteZone(t, "America/Chicago")
+	if loc.String() != "America/Chicago" {
+		t.Fatalf("site zone is %s after saving America/Chicago", loc)
+	}
+
+	settings = Settings{}
+	loadSettings()
+	if siteLocation().String() != "America/Chicago" {
+		t.Errorf("site zone is %s after loading the saved settings", siteLocation())
+	}
+
+	settings.TimeZone = "Nowhere/Special"
+	saveSettings()
+	if siteLocation() != time.Local {
+		t.Errorf("an unknown zone gave %s, want the local zone", siteLocation())
+	}
+}
+
+func TestNewBlockReservationInSiteZone(t *testing.T) {
+	chicago := useTestSiteZone(t, "America/Chicago")
+	block := bookingBlock{RoomID: "room-1", RoomName: "Lab A"}
+
+	res, err := newBlockReservation("2026-03-02", block, "9:00 AM", "10:30 AM", "Meeting", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC); !res.StartTime.Equal(want) {
+		t.Errorf("9:00 AM in Chicago starts at %v, want %v", res.StartTime.UTC(), want)
+	}
+	if res.EndTime.Sub(res.StartTime) != 90*time.Minute || res.TimeZone != "America/Chicago" || res.Date != "2026-03-02" {
+		t.Errorf("reservation is %v - %v in %s on %s", res.StartTime, res.EndTime, res.TimeZone, res.Date)
+	}
+
+	// A booking ending at midnight ends on the following day
+	res, err = newBlockReservation("2026-03-02", block, "11:00 PM", "12:00 AM", "Late", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 3, 0, 0, 0, 0, chicago); !res.EndTime.Equal(want) {
+		t.Errorf("booking to midnight ends at %v, want %v", res.EndTime, want)
+	}
+
+	if _, err := newBlockReservation("2026-03-02", block, "10:00 AM", "9:00 AM", "Backwards", "", ""); err == nil {
+		t.Error("a booking ending before it starts was accepted")
+	}
+}
+
+func TestResolveClockTimeKeepsRepeatedHour(t *testing.T) {
+	useTestSiteZone(t, "America/Chicago")
+	// Clocks go back from 2:00 CDT to 1:00 CST on 2026-11-01, so 1:30 AM
+	// happens twice; the second one is 7:30 UTC
+	second := time.Date(2026, 11, 1, 7, 30, 0, 0, time.UTC)
+	got, err := resolveClockTime("2026-11-01", formatClock(second), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(second) {
+		t.Errorf("unchanged pre-filled 1:30 AM resolved to %v, want %v", got.UTC(), second)
+	}
+
+	got, err = resolveClockTime("2026-11-01", "1:45 AM", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.In(siteLocation()).Hour() != 1 || got.In(siteLocation()).Minute() != 45 {
+		t.Errorf("typed 1:45 AM resolved to %v", got.In(siteLocation()))
+	}
+	if _, err := resolveClockTime("2026-11-01", "half past", second); err == nil {
+		t.Error("an invalid time was accepted")
+	}
+}
+
+func TestAlignToSlotInSiteZone(t *testing.T) {
+	chicago := useTestSiteZone(t, "America/Chicago")
+	// 15:47 UTC is 9:47 AM in Chicago
+	got := alignToSlot(time.Date(2026, 3, 2, 15, 47, 0, 0, time.UTC), 30*time.Minute)
+	if want := time.Date(2026, 3, 2, 9, 30, 0, 0, chicago); !got.Equal(want) {
+		t.Errorf("aligned to %v, want %v", got, want)
+	}
+}
+
+// slotKeyAt is the grid's key for the slot of room starting at t.
+func slotKeyAt(room *Room, t time.Time) string {
+	return fmt.Sprintf("%s_%d", room.ID, t.Unix())
+}
+
+func TestBatchBookingAcrossRoomsAndBlocks(t *testing.T) {
+	labA := useTestRoom(t, "Lab A")
+	labB := &Room{ID: "room-2", Name: "Lab B"}
+	rooms = append(rooms, labB)
+	nine := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	interval := 30 * time.Minute
+
+	keys := []string{
+		slotKeyAt(labB, nine),
+		slotKeyAt(labA, nine.Add(2*time.Hour)),
+		slotKeyAt(labA, nine.Add(interval)),
+		slotKeyAt(labA, nine),
+	}
+	blocks := groupSelectedSlots(keys, interval)
+	want := []bookingBlock{
+		{RoomID: labA.ID, RoomName: "Lab A", Start: nine, End: nine.Add(time.Hour)},
+		{RoomID: labA.ID, RoomName: "Lab A", Start: nine.Add(2 * time.Hour), End: nine.Add(2*time.Hour + interval)},
+		{RoomID: labB.ID, RoomName: "Lab B", Start: nine, End: nine.Add(interval)},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("got %d blocks, want %d: %+v", len(blocks), len(want), blocks)
+	}
+	var reservations []Reservation
+	for i, block := range blocks {
+		if block.RoomID != want[i].RoomID || !block.Start.Equal(want[i].Start) || !block.End.Equal(want[i].End) {
+			t.Errorf("block %d is %+v, want %+v", i, block, want[i])
+		}
+		res, err := newBlockReservation("2026-03-02", block, formatClock(block.Start), formatClock(block.End), "Workshop", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reservations = append(reservations, res)
+	}
+
+	batch, err := newBatchReservationCommand(reservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := executeCommand(batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(labA.Reservations) != 2 || len(labB.Reservations) != 1 {
+		t.Fatalf("booked %d in Lab A and %d in Lab B, want 2 and 1", len(labA.Reservations), len(labB.Reservations))
+	}
+
+	// The whole batch is undone and redone as one action
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range rooms {
+		for _, res := range room.Reservations {
+			if res.Active {
+				t.Errorf("%s at %s is still booked after undo", room.Name, formatClock(res.StartTime))
+			}
+		}
+	}
+	if err := redo(); err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range rooms {
+		for _, res := range room.Reservations {
+			if !res.Active {
+				t.Errorf("%s at %s is not booked after redo", room.Name, formatClock(res.StartTime))
+			}
+		}
+	}
+}
+
+func TestBatchBookingRefusesConflicts(t *testing.T) {
+	labA := useTestRoom(t, "Lab A")
+	labB := &Room{ID: "room-2", Name: "Lab B"}
+	rooms = append(rooms, labB)
+	nine := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := labB.Reserve(testReservation(nine.Add(30*time.Minute), "Taken")); err != nil {
+		t.Fatal(err)
+	}
+
+	free := testReservation(nine, "Free")
+	free.RoomID = labA.ID
+	clash := testReservation(nine, "Clash")
+	clash.RoomID, clash.RoomName = labB.ID, labB.Name
+	conflicts := findBookingConflicts([]Reservation{free, clash}, 30*time.Minute)
+	if len(conflicts) != 1 || conflicts[0] != "Lab B at 9:30 AM" {
+		t.Errorf("conflicts %v, want [Lab B at 9:30 AM]", conflicts)
+	}
+
+	batch, err := newBatchReservationCommand([]Reservation{free, clash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := executeCommand(batch); err == nil {
+		t.Error("a batch with a taken slot was booked")
+	}
+	// Two blocks of the same batch may not overlap either
+	overlapping := free
+	overlapping.StartTime = nine.Add(30 * time.Minute)
+	batch, err = newBatchReservationCommand([]Reservation{free, overlapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := executeCommand(batch); err == nil {
+		t.Error("a batch overlapping itself was booked")
+	}
+	if len(labA.Reservations) != 0 || len(labB.Reservations) != 1 {
+		t.Errorf("refused batches left %d bookings in Lab A and %d in Lab B", len(labA.Reservations), len(labB.Reservations))
+	}
+	if len(undoStack) != 0 {
+		t.Errorf("refused batches left %d commands to undo", len(undoStack))
+	}
+
+	if _, err := newBatchReservationCommand([]Reservation{{RoomID: "gone", RoomName: "Gone"}}); err == nil {
+		t.Error("a batch for a missing room was built")
+	}
+}
+
+func TestParseClockTime(t *testing.T) {
+	for text, want := range map[string]string{
+		"9:45 AM":  "09:45",
+		"9:45am":   "09:45",
+		" 9 pm ":   "21:00",
+		"12:00 AM": "00:00",
+		"21:30":    "21:30",
+	} {
+		got, err := parseClockTime(text)
+		if err != nil {
+			t.Errorf("parseClockTime(%q): %v", text, err)
+			continue
+		}
+		if got.Format("15:04") != want {
+			t.Errorf("parseClockTime(%q) = %s, want %s", text, got.Format("15:04"), want)
+		}
+	}
+	for _, text := range []string{"", "noon", "25:00", "9:75 AM"} {
+		if _, err := parseClockTime(text); err == nil {
+			t.Errorf("parseClockTime(%q) accepted", text)
+		}
+	}
+}
+
+func TestGenerateTimeSlotsGranularity(t *testing.T) {
+	useTestSiteZone(t, "UTC")
+	settings.OpenTime, settings.CloseTime = "9:00", "12:00"
+	for interval, want := range map[time.Duration]int{15 * time.Minute: 12, 30 * time.Minute: 6, time.Hour: 3} {
+		slots := generateTimeSlots("2026-03-02", interval)
+		if len(slots) != want {
+			t.Errorf("%v slots: got %d, want %d", interval, len(slots), want)
+			continue
+		}
+		if first := slots[0]; first.Hour() != 9 || first.Minute() != 0 {
+			t.Errorf("%v slots start at %s", interval, formatClock(first))
+		}
+		if last := slots[len(slots)-1]; !last.Add(interval).Equal(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("%v slots end at %s", interval, formatClock(last.Add(interval)))
+		}
+	}
+}
+
+func TestSlotOccupancyWithFreeFormTimes(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	nine := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	block := bookingBlock{RoomID: room.ID, RoomName: room.Name, Start: nine, End: nine.Add(time.Hour)}
+	res, err := newBlockReservation("2026-03-02", block, "9:45 AM", "10:30 AM", "Tutorial", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := room.Reserve(res); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start    time.Time
+		interval time.Duration
+		taken    bool
+		full     bool
+	}{
+		{nine, time.Hour, true, false},                             // 9-10 is partly booked
+		{nine.Add(time.Hour), time.Hour, true, false},              // 10-11 is partly booked
+		{nine.Add(45 * time.Minute), 15 * time.Minute, true, true}, // 9:45-10 is fully booked
+		{nine.Add(30 * time.Minute), 15 * time.Minute, false, false},
+		{nine.Add(time.Hour), 30 * time.Minute, true, true},
+		{nine.Add(90 * time.Minute), 30 * time.Minute, false, false},
+	}
+	for _, tt := range tests {
+		index, full := slotOccupancy(room, tt.start, tt.interval)
+		if (index >= 0) != tt.taken || full != tt.full {
+			t.Errorf("%v slot at %s: index %d, full %v; want taken %v, full %v",
+				tt.interval, formatClock(tt.start), index, full, tt.taken, tt.full)
+		}
+	}
+
+	// Only the cells the booking actually touches conflict
+	probe := testReservation(nine.Add(30*time.Minute), "Probe")
+	probe.RoomID = room.ID
+	probe.RoomName = room.Name
+	conflicts := findBookingConflicts([]Reservation{probe}, 15*time.Minute)
+	want := []string{"Lab A at 9:45 AM", "Lab A at 10:00 AM", "Lab A at 10:15 AM"}
+	if fmt.Sprint(conflicts) != fmt.Sprint(want) {
+		t.Errorf("conflicts %v, want %v", conflicts, want)
+	}
+}
+
+func TestRoomDaySchedule(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	settings.OpenTime, settings.CloseTime = "8:00", "18:00"
+	at := func(hour int) time.Time { return time.Date(2026, 3, 2, hour, 0, 0, 0, time.UTC) }
+	for _, hour := range []int{13, 10, 15} {
+		if err := room.Reserve(testReservation(at(hour), "Meeting")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room.DeleteReservation(2)
+	// A booking from the day before that runs past opening
+	if err := room.Reserve(Reservation{StartTime: at(7), EndTime: at(9)}); err != nil {
+		t.Fatal(err)
+	}
+
+	busy, free := roomDaySchedule(room, "2026-03-02")
+	if fmt.Sprint(busy) != "[3 1 0]" {
+		t.Errorf("busy reservations %v, want [3 1 0] in start order", busy)
+	}
+	want := [][2]int{{9, 10}, {11, 13}, {14, 18}}
+	if len(free) != len(want) {
+		t.Fatalf("free windows %+v, want %v", free, want)
+	}
+	for i, window := range free {
+		if !window.Start.Equal(at(want[i][0])) || !window.End.Equal(at(want[i][1])) || window.RoomID != room.ID {
+			t.Errorf("free window %d is %s - %s, want %d:00 - %d:00", i, formatClock(window.Start), formatClock(window.End), want[i][0], want[i][1])
+		}
+	}
+
+	busy, free = roomDaySchedule(room, "2026-03-03")
+	if len(busy) != 0 || len(free) != 1 || free[0].End.Sub(free[0].Start) != 10*time.Hour {
+		t.Errorf("empty day has busy %v and free %+v, want one 10-hour window", busy, free)
+	}
+}
@@ -0,0 +1,509 @@
+// commands.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+)
+
+// Command interface for undo/redo functionality
+type Command interface {
+	Execute() error
+	Undo() error
+}
+
+// Undo/Redo functionality
+var undoStack []Command
+var redoStack []Command
+
+// executeCommand runs cmd and records it on the undo stack if it succeeds.
+func executeCommand(cmd Command) error {
+	if err := cmd.Execute(); err != nil {
+		return err
+	}
+	undoStack = append(undoStack, cmd)
+	touchSession()
+	// Clear redo stack
+	redoStack = []Command{}
+	return nil
+}
+
+// Undo and Redo functions
+func undo() error {
+	if len(undoStack) == 0 {
+		return nil
+	}
+	cmd := undoStack[len(undoStack)-1]
+	if err := cmd.Undo(); err != nil {
+		return err
+	}
+	undoStack = undoStack[:len(undoStack)-1]
+	redoStack = append(redoStack, cmd)
+	return nil
+}
+
+func redo() error {
+	if len(redoStack) == 0 {
+		return nil
+	}
+	cmd := redoStack[len(redoStack)-1]
+	if err := cmd.Execute(); err != nil {
+		return err
+	}
+	redoStack = redoStack[:len(redoStack)-1]
+	undoStack = append(undoStack, cmd)
+	return nil
+}
+
+// CompositeCommand groups several commands so they execute and undo as one.
+// Its bookings are checked before anything is changed, and if a step still
+// fails, the steps already applied are rolled back.
+type CompositeCommand struct {
+	Commands []Command
+}
+
+// Execute applies the steps in order. Webhook events are held back until
+// every step has been applied, so receivers never hear of bookings that are
+// rolled back.
+func (c *CompositeCommand) Execute() error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	holdWebhooks()
+	for i, cmd := range c.Commands {
+		if err := cmd.Execute(); err != nil {
+			c.rollback(i)
+			releaseWebhooks(false)
+			return err
+		}
+	}
+	releaseWebhooks(true)
+	return nil
+}
+
+// check refuses the batch if any of its bookings could not be made, either
+// because of an existing reservation or one earlier in the batch.
+func (c *CompositeCommand) check() error {
+	pending := map[*Room][]Reservation{}
+	for _, cmd := range c.Commands {
+		booking, ok := cmd.(*ReservationCommand)
+		if !ok {
+			continue
+		}
+		if err := booking.room.checkReservation(booking.reservation, pending[booking.room]); err != nil {
+			return err
+		}
+		pending[booking.room] = append(pending[booking.room], booking.reservation)
+	}
+	return nil
+}
+
+// rollback undoes the first n steps after a failure. Bookings made by the
+// batch are marked undone like any other undone booking, so they keep their
+// place in the room and are not counted as cancellations.
+func (c *CompositeCommand) rollback(n int) {
+	for j := n - 1; j >= 0; j-- {
+		c.Commands[j].Undo()
+	}
+}
+
+// Undo undoes the steps in reverse order. If one fails, the steps already
+// undone are applied again so the group stays whole; any of those that fail
+// too are reported with the first error.
+func (c *CompositeCommand) Undo() error {
+	for i := len(c.Commands) - 1; i >= 0; i-- {
+		if err := c.Commands[i].Undo(); err != nil {
+			errs := []error{err}
+			for j := i + 1; j < len(c.Commands); j++ {
+				if err := c.Commands[j].Execute(); err != nil {
+					errs = append(errs, fmt.Errorf("could not reapply a change after the failed undo: %v", err))
+				}
+			}
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+// ReservationCommand for undo/redo
+type ReservationCommand struct {
+	reservation Reservation
+	room        *Room
+	index       int  // Index in the room's reservation slice
+	booked      bool // Booked once, so redo restores the same reservation
+}
+
+func (c *ReservationCommand) Execute() error {
+	if c.booked {
+		return c.room.RestoreReservation(c.index)
+	}
+	if err := c.room.Reserve(c.reservation); err != nil {
+		return err
+	}
+	c.index = len(c.room.Reservations) - 1
+	c.booked = true
+	return nil
+}
+
+func (c *ReservationCommand) Undo() error {
+	return c.room.Unreserve(c.index)
+}
+
+// CancelReservationCommand soft-deletes an existing reservation.
+type CancelReservationCommand struct {
+	room  *Room
+	index int
+}
+
+func (c *CancelReservationCommand) Execute() error {
+	if c.index < 0 || c.index >= len(c.room.Reservations) || !c.room.Reservations[c.index].Active {
+		return fmt.Errorf("reservation not found")
+	}
+	c.room.DeleteReservation(c.index)
+	return nil
+}
+
+func (c *CancelReservationCommand) Undo() error {
+	return c.room.RestoreReservation(c.index)
+}
+
+// EditReservationCommand changes the details or time of a reservation in place.
+type EditReservationCommand struct {
+	room   *Room
+	index  int
+	before Reservation
+	after  Reservation
+}
+
+func (c *EditReservationCommand) Execute() error {
+	return c.room.UpdateReservation(c.index, c.after)
+}
+
+func (c *EditReservationCommand) Undo() error {
+	return c.room.UpdateReservation(c.index, c.before)
+}
+
+// AddRoomCommand appends a new room to the room list.
+type AddRoomCommand struct {
+	room *Room
+}
+
+func (c *AddRoomCommand) Execute() error {
+	c.room.Name = normalizeRoomName(c.room.Name)
+	if err := validateRoomName(c.room.Name, nil); err != nil {
+		return err
+	}
+	if c.room.ID == "" {
+		c.room.ID = newLocationID()
+	} else if findRoomByID(c.room.ID) != nil {
+		return fmt.Errorf("a room with ID %s already exists", c.room.ID)
+	}
+	rooms = append(rooms, c.room)
+	saveReservations()
+	emitWebhook(webhookRoomAdded, roomPayload{ID: c.room.ID, Name: c.room.Name, FloorID: c.room.FloorID})
+	return nil
+}
+
+func (c *AddRoomCommand) Undo() error {
+	removeRoomAt(indexOfRoom(c.room))
+	return nil
+}
+
+// RemoveRoomCommand removes a room, remembering where it was so undo can put
+// it back in the same place.
+type RemoveRoomCommand struct {
+	room  *Room
+	index int
+}
+
+func (c *RemoveRoomCommand) Execute() error {
+	c.index = indexOfRoom(c.room)
+	if c.index < 0 {
+		return fmt.Errorf("room not found")
+	}
+	removeRoomAt(c.index)
+	return nil
+}
+
+func (c *RemoveRoomCommand) Undo() error {
+	if c.index > len(rooms) {
+		c.index = len(rooms)
+	}
+	rooms = append(rooms[:c.index], append([]*Room{c.room}, rooms[c.index:]...)...)
+	saveReservations()
+	return nil
+}
+
+// RenameRoomCommand renames a room along with the reservations that refer to it.
+type RenameRoomCommand struct {
+	room    *Room
+	oldName string
+	newName string
+}
+
+func (c *RenameRoomCommand) Execute() error {
+	c.newName = normalizeRoomName(c.newName)
+	if err := validateRoomName(c.newName, c.room); err != nil {
+		return err
+	}
+	return renameRoom(c.room, c.newName)
+}
+
+func (c *RenameRoomCommand) Undo() error {
+	return renameRoom(c.room, c.oldName)
+}
+
+// ArchiveRoomCommand hides a room from booking, or brings it back.
+type ArchiveRoomCommand struct {
+	room     *Room
+	archived bool
+}
+
+func (c *ArchiveRoomCommand) Execute() error {
+	c.room.Archived = c.archived
+	saveReservations()
+	return nil
+}
+
+func (c *ArchiveRoomCommand) Undo() error {
+	c.room.Archived = !c.archived
+	saveReservations()
+	return nil
+}
+
+// PlaceRoomCommand moves a room's marker on the floor plan.
+type PlaceRoomCommand struct {
+	room   *Room
+	oldPos fyne.Position
+	newPos fyne.Position
+}
+
+func (c *PlaceRoomCommand) Execute() error {
+	c.room.Position = c.newPos
+	saveReservations()
+	return nil
+}
+
+func (c *PlaceRoomCommand) Undo() error {
+	c.room.Position = c.oldPos
+	saveReservations()
+	return nil
+}
+
+// CreateUserCommand adds a user account. The plaintext password is only
+// needed the first time; redo re-adds the hashed account.
+type CreateUserCommand struct {
+	username string
+	password string
+	role     string
+	email    string
+	user     *User
+}
+
+func (c *CreateUserCommand) Execute() error {
+	if c.user != nil {
+		return (&DeleteUserCommand{user: *c.user}).Undo()
+	}
+	c.username = normalizeUsername(c.username)
+	if err := createUser(c.username, c.password, c.role, c.email); err != nil {
+		return err
+	}
+	created := *findUser(c.username)
+	c.user = &created
+	c.password = ""
+	return nil
+}
+
+func (c *CreateUserCommand) Undo() error {
+	return removeUser(c.username)
+}
+
+// DeleteUserCommand removes a user account, keeping a copy for undo.
+type DeleteUserCommand struct {
+	user User
+}
+
+func (c *DeleteUserCommand) Execute() error {
+	return removeUser(c.user.Username)
+}
+
+func (c *DeleteUserCommand) Undo() error {
+	if findUser(c.user.Username) != nil {
+		return fmt.Errorf("username already exists")
+	}
+	users = append(users, c.user)
+	refreshCurrentUser()
+	saveUsers()
+	return nil
+}
+
+// SetUserRoleCommand changes the role of a user account.
+type SetUserRoleCommand struct {
+	username string
+	oldRole  string
+	newRole  string
+}
+
+func (c *SetUserRoleCommand) Execute() error {
+	return setUserRole(c.username, c.newRole)
+}
+
+func (c *SetUserRoleCommand) Undo() error {
+	return setUserRole(c.username, c.oldRole)
+}
+
+// newBatchReservationCommand builds a single undoable command that books all
+// of the given reservations or none of them.
+func newBatchReservationCommand(reservations []Reservation) (*CompositeCommand, error) {
+	batch := &CompositeCommand{}
+	for _, res := range reservations {
+		room := reservationRoom(res)
+		if room == nil {
+			return nil, fmt.Errorf("room '%s' not found", res.RoomName)
+		}
+		batch.Commands = append(batch.Commands, &ReservationCommand{reservation: res, room: room})
+	}
+	return batch, nil
+}
+
+// SetRoomShapeCommand replaces the outline drawn for a room on the floor plan.
+type SetRoomShapeCommand struct {
+	room     *Room
+	oldShape []fyne.Position
+	newShape []fyne.Position
+}
+
+func (c *SetRoomShapeCommand) Execute() error {
+	c.room.Shape = c.newShape
+	saveReservations()
+	return nil
+}
+
+func (c *SetRoomShapeCommand) Undo() error {
+	c.room.Shape = c.oldShape
+	saveReservations()
+	return nil
+}
+
+// SetLocationsCommand replaces the site, building and floor hierarchy.
+type SetLocationsCommand struct {
+	before []Site
+	after  []Site
+}
+
+func (c *SetLocationsCommand) Execute() error {
+	sites = cloneSites(c.after)
+	saveLocations()
+	return nil
+}
+
+func (c *SetLocationsCommand) Undo() error {
+	sites = cloneSites(c.before)
+	saveLocations()
+	return nil
+}
+
+// AssignRoomFloorCommand moves a room to another floor. Its marker and shape
+// belong to the old floor's plan, so they are cleared and restored on undo.
+type AssignRoomFloorCommand struct {
+	room       *Room
+	oldFloorID string
+	newFloorID string
+	oldPos     fyne.Position
+	oldShape   []fyne.Position
+}
+
+func (c *AssignRoomFloorCommand) Execute() error {
+	c.room.FloorID = c.newFloorID
+	c.room.Position = fyne.Position{}
+	c.room.Shape = nil
+	saveReservations()
+	return nil
+}
+
+func (c *AssignRoomFloorCommand) Undo() error {
+	c.room.FloorID = c.oldFloorID
+	c.room.Position = c.oldPos
+	c.room.Shape = c.oldShape
+	saveReservations()
+	return nil
+}
+
+// roomPlacement is where a room sits on the floor plans
+type roomPlacement struct {
+	FloorID   string
+	ElementID string
+	Position  fyne.Position
+	Shape     []fyne.Position
+}
+
+func placementOf(room *Room) roomPlacement {
+	return roomPlacement{FloorID: room.FloorID, ElementID: room.PlanElementID, Position: room.Position, Shape: room.Shape}
+}
+
+// SetRoomPlacementCommand moves a room to a floor, position and shape at once,
+// as when binding it to an element of an SVG floor plan.
+type SetRoomPlacementCommand struct {
+	room   *Room
+	before roomPlacement
+	after  roomPlacement
+}
+
+func (c *SetRoomPlacementCommand) apply(p roomPlacement) {
+	c.room.FloorID = p.FloorID
+	c.room.PlanElementID = p.ElementID
+	c.room.Position = p.Position
+	c.room.Shape = p.Shape
+	saveReservations()
+}
+
+func (c *SetRoomPlacementCommand) Execute() error {
+	c.apply(c.after)
+	return nil
+}
+
+func (c *SetRoomPlacementCommand) Undo() error {
+	c.apply(c.before)
+	return nil
+}
+
+// userProfile is the part of an account users edit themselves
+type userProfile struct {
+	Email              string
+	MutedNotifications []string
+	ReminderMinutes    int
+}
+
+func profileOf(user *User) userProfile {
+	return userProfile{Email: user.Email, MutedNotifications: user.MutedNotifications, ReminderMinutes: user.ReminderMinutes}
+}
+
+// SetUserProfileCommand changes a user's email and notification preferences.
+type SetUserProfileCommand struct {
+	username string
+	before   userProfile
+	after    userProfile
+}
+
+func (c *SetUserProfileCommand) apply(p userProfile) error {
+	user := findUser(c.username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	user.Email = p.Email
+	user.MutedNotifications = p.MutedNotifications
+	user.ReminderMinutes = p.ReminderMinutes
+	saveUsers()
+	return nil
+}
+
+func (c *SetUserProfileCommand) Execute() error {
+	return c.apply(c.after)
+}
+
+func (c *SetUserProfileCommand) Undo() error {
+	return c.apply(c.before)
+}
@@ -0,0 +1,313 @@
+// commands_test.go
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStep is a command that records its calls and fails when told to.
+type testStep struct {
+	name     string
+	log      *[]string
+	failDo   error
+	failUndo error
+}
+
+func (s *testStep) Execute() error {
+	*s.log = append(*s.log, "do "+s.name)
+	return s.failDo
+}
+
+func (s *testStep) Undo() error {
+	*s.log = append(*s.log, "undo "+s.name)
+	return s.failUndo
+}
+
+func TestUndoRedoStacks(t *testing.T) {
+	useTestDataDir(t)
+	if err := executeCommand(&AddRoomCommand{room: &Room{Name: "Lab A"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := executeCommand(&RenameRoomCommand{room: rooms[0], oldName: "Lab A", newName: "Lab B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if rooms[0].Name != "Lab A" {
+		t.Errorf("after undo the room is named %q", rooms[0].Name)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 0 {
+		t.Errorf("after undoing the add there are %d rooms", len(rooms))
+	}
+	if err := undo(); err != nil {
+		t.Errorf("undo with nothing to undo: %v", err)
+	}
+	if err := redo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "Lab A" {
+		t.Fatalf("redo gave rooms %v", roomNames())
+	}
+	// A new change drops what could be redone
+	if err := executeCommand(&ArchiveRoomCommand{room: rooms[0], archived: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(redoStack) != 0 {
+		t.Errorf("%d commands left to redo after a new change", len(redoStack))
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if rooms[0].Archived {
+		t.Error("undo did not restore the room")
+	}
+}
+
+func TestFailedUndoStaysOnStack(t *testing.T) {
+	useTestDataDir(t)
+	log := []string{}
+	step := &testStep{name: "a", log: &log, failUndo: errors.New("no")}
+	if err := executeCommand(step); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(); err == nil {
+		t.Fatal("a failed undo reported no error")
+	}
+	if len(undoStack) != 1 || len(redoStack) != 0 {
+		t.Errorf("after a failed undo: %d to undo, %d to redo", len(undoStack), len(redoStack))
+	}
+}
+
+func TestCompositeUndoReappliesAfterFailure(t *testing.T) {
+	log := []string{}
+	first := &testStep{name: "a", log: &log, failUndo: errors.New("cannot undo a")}
+	second := &testStep{name: "b", log: &log}
+	third := &testStep{name: "c", log: &log}
+	composite := &CompositeCommand{Commands: []Command{first, second, third}}
+	if err := composite.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	log = nil
+	if err := composite.Undo(); err == nil || !strings.Contains(err.Error(), "cannot undo a") {
+		t.Fatalf("got %v, want the undo error", err)
+	}
+	want := "undo c, undo b, undo a, do b, do c"
+	if got := strings.Join(log, ", "); got != want {
+		t.Errorf("steps %s, want %s", got, want)
+	}
+
+	// A step that cannot be reapplied is reported too
+	log = nil
+	third.failDo = errors.New("cannot redo c")
+	err := composite.Undo()
+	if err == nil || !strings.Contains(err.Error(), "cannot undo a") || !strings.Contains(err.Error(), "cannot redo c") {
+		t.Errorf("got %v, want both errors", err)
+	}
+}
+
+func TestCompositeExecuteRollsBack(t *testing.T) {
+	log := []string{}
+	composite := &CompositeCommand{Commands: []Command{
+		&testStep{name: "a", log: &log},
+		&testStep{name: "b", log: &log},
+		&testStep{name: "c", log: &log, failDo: errors.New("no")},
+	}}
+	if err := composite.Execute(); err == nil {
+		t.Fatal("a failing step was not reported")
+	}
+	want := "do a, do b, do c, undo b, undo a"
+	if got := strings.Join(log, ", "); got != want {
+		t.Errorf("steps %s, want %s", got, want)
+	}
+}
+
+func TestReservationChangesUndo(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := room.Reserve(testReservation(start, "Seminar")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := room.Reservations[0]
+	after := before
+	after.Purpose = "Workshop"
+	after.EndTime = start.Add(2 * time.Hour)
+	if err := executeCommand(&EditReservationCommand{room: room, index: 0, before: before, after: after}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := room.Reservations[0]; got.Purpose != "Seminar" || !got.EndTime.Equal(before.EndTime) {
+		t.Errorf("undoing the edit gave %+v", got)
+	}
+
+	if err := executeCommand(&CancelReservationCommand{room: room, index: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if room.Reservations[0].Active {
+		t.Fatal("the reservation is still active after cancelling")
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if !room.Reservations[0].Active {
+		t.Error("undoing the cancellation did not restore the reservation")
+	}
+
+	// The slot was taken while the booking was cancelled
+	if err := executeCommand(&CancelReservationCommand{room: room, index: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.Reserve(testReservation(start, "Someone else")); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(); err == nil {
+		t.Error("restoring a cancellation over a new booking was allowed")
+	}
+}
+
+func TestSetUserRoleUndo(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "root", Role: "Admin"}, {Username: "alice", Role: "User"}}
+	if err := executeCommand(&SetUserRoleCommand{username: "alice", oldRole: "User", newRole: "Admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if findUser("alice").Role != "User" {
+		t.Error("undo did not restore the role")
+	}
+	if err := executeCommand(&SetUserRoleCommand{username: "root", oldRole: "Admin", newRole: "User"}); err == nil {
+		t.Error("the last admin was demoted")
+	}
+}
+
+func TestCreateUserCommandUndoesTypedName(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "root", Role: "Admin"}}
+
+	create := &CreateUserCommand{username: "  Alice ", password: "correct horse battery", role: "User"}
+	if err := create.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if create.username != "Alice" {
+		t.Errorf("the command kept the username %q, want %q", create.username, "Alice")
+	}
+	if err := create.Undo(); err != nil {
+		t.Fatalf("undoing the new account: %v", err)
+	}
+	if findUser("alice") != nil {
+		t.Error("the account is still there after undo")
+	}
+	if err := create.Execute(); err != nil {
+		t.Fatalf("redoing the new account: %v", err)
+	}
+	if user := findUser("ALICE"); user == nil || user.Username != "Alice" {
+		t.Errorf("redo gave %+v", user)
+	}
+}
+
+func TestRemoveUserIgnoresCase(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "root", Role: "Admin"}, {Username: "Alice", Role: "User"}}
+	if err := removeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("%d accounts left, want 1", len(users))
+	}
+}
+
+func TestCaseDuplicateUsers(t *testing.T) {
+	useTestDataDir(t)
+	// As earlier versions could leave them
+	users = []User{{Username: "root", Role: "Admin"}, {Username: "Alice"}, {Username: "alice"}}
+	duplicates := caseDuplicateUsers()
+	if len(duplicates) != 1 || len(duplicates["alice"]) != 2 {
+		t.Fatalf("duplicates %v, want Alice and alice", duplicates)
+	}
+
+	// Deleting the second account removes that one, not the first match
+	if err := (&DeleteUserCommand{user: users[2]}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].Username != "Alice" {
+		t.Errorf("accounts left %+v, want root and Alice", users)
+	}
+	if len(caseDuplicateUsers()) != 0 {
+		t.Error("duplicates remain after deleting one")
+	}
+}
+
+func TestUndoneBookingKeepsItsPlace(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	first := &ReservationCommand{room: room, reservation: testReservation(start, "First")}
+	second := &ReservationCommand{room: room, reservation: testReservation(start.Add(time.Hour), "Second")}
+	for _, c := range []*ReservationCommand{first, second} {
+		if err := executeCommand(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Cancel the second booking, then go back past the first
+	if err := executeCommand(&CancelReservationCommand{room: room, index: second.index}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(room.Reservations) != 2 {
+		t.Fatalf("undo left %d reservations, want both kept in place", len(room.Reservations))
+	}
+	for i, res := range room.Reservations {
+		if res.Active || !res.Undone {
+			t.Errorf("reservation %d is %+v, want inactive and undone", i, res)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := redo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(room.Reservations) != 2 {
+		t.Fatalf("redo left %d reservations, want the same two", len(room.Reservations))
+	}
+	if got := room.Reservations[0]; !got.Active || got.Undone || got.Purpose != "First" {
+		t.Errorf("first reservation after redo is %+v", got)
+	}
+	if got := room.Reservations[1]; got.Active || got.Undone || got.Purpose != "Second" {
+		t.Errorf("second reservation after redo is %+v, want cancelled", got)
+	}
+}
+
+func TestBatchRollbackMarksBookingsUndone(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	log := []string{}
+	batch := &CompositeCommand{Commands: []Command{
+		&ReservationCommand{room: room, reservation: testReservation(start, "Batch")},
+		&testStep{name: "fails", log: &log, failDo: errors.New("no")},
+	}}
+	if err := batch.Execute(); err == nil {
+		t.Fatal("the failing batch was applied")
+	}
+	if len(room.Reservations) != 1 || room.Reservations[0].Active || !room.Reservations[0].Undone {
+		t.Errorf("after rollback the room has %+v", room.Reservations)
+	}
+	if err := room.Reserve(testReservation(start, "Later")); err != nil {
+		t.Errorf("the rolled-back slot is still taken: %v", err)
+	}
+}
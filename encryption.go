@@ -0,0 +1,772 @@
+// encryption.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/crypto/argon2"
+)
+
+// Where the key that unlocks the data files comes from
+const (
+	keySourcePassphrase = "Passphrase"
+	keySourceFile       = "Key File"
+)
+
+const (
+	keyringFile         = "encryption.json"
+	encryptedHeader     = "roomy-encrypted v1 " // Followed by the data key ID and a newline
+	minPassphraseLength = 12
+	minKeyFileSize      = 32
+
+	// Argon2id cost for passphrases, stored with the keyring so it can be
+	// raised later without breaking existing installs
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+// dataFiles are encrypted when encryption is on. Floor plan images are not.
+var dataFiles = []string{
+	"settings.json",
+	"reservations.json",
+	"users.json",
+	"locations.json",
+	"webhooks.json",
+	"webhook_deliveries.json",
+	"sessions.json",
+	"login_attempts.json",
+	"invites.json",
+	"reports.json",
+}
+
+// DataKey is a random AES-256 key that encrypts data files, stored sealed
+// with the key from the passphrase or key file.
+type DataKey struct {
+	ID      string
+	Wrapped []byte
+}
+
+// Keyring describes how the data files are encrypted. It is stored in
+// encryption.json, which holds no secrets in the clear.
+type Keyring struct {
+	Source  string // keySourcePassphrase or keySourceFile
+	KeyFile string // Path of the key file for keySourceFile
+	Salt    []byte
+	Time    uint32 // Argon2id parameters for passphrases
+	Memory  uint32
+	Threads uint8
+	Keys    []DataKey // More than one only while a rotation is under way
+	Current string    // ID of the key new writes use
+	Sealed  bool      // Every data file has been encrypted; plaintext files are refused
+	Created time.Time
+	Rotated time.Time
+}
+
+// keyring is nil when encryption is off. dataKeys holds the unsealed data
+// keys and is nil until the files are unlocked.
+var keyring *Keyring
+var dataKeys map[string][]byte
+
+// storageMu is held for reading by every data file read and write, and for
+// writing while files are re-encrypted.
+var storageMu sync.RWMutex
+
+var errDataLocked = errors.New("the data files are encrypted and have not been unlocked")
+var errWrongKey = errors.New("incorrect passphrase or key file")
+
+// loadKeyring reads encryption.json and, for key file encryption, unlocks
+// the data files straight away.
+func loadKeyring() {
+	data, err := os.ReadFile(keyringFile)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Error opening encryption keyring: %v\n", err)
+		keyring = &Keyring{}
+		return
+	}
+	var k Keyring
+	if err := json.Unmarshal(data, &k); err != nil {
+		log.Printf("Error decoding encryption keyring: %v\n", err)
+		keyring = &Keyring{}
+		return
+	}
+	keyring = &k
+	if k.Source == keySourceFile {
+		if err := unlockWithKeyFile(); err != nil {
+			log.Printf("Error unlocking data files with %s: %v\n", k.KeyFile, err)
+		}
+	}
+}
+
+func saveKeyring(k *Keyring) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(keyringFile, data)
+}
+
+// dataLocked reports whether the data files are encrypted and still locked.
+func dataLocked() bool {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	return keyring != nil && dataKeys == nil
+}
+
+// encryptionEnabled reports whether the data files are encrypted.
+func encryptionEnabled() bool {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	return keyring != nil
+}
+
+// readKeyFile returns the contents of a key file, which must hold at least
+// 32 bytes.
+func readKeyFile(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) < minKeyFileSize {
+		return nil, fmt.Errorf("the key file must contain at least %d bytes", minKeyFileSize)
+	}
+	return secret, nil
+}
+
+// wrappingKey derives the key that seals the data keys.
+func (k *Keyring) wrappingKey(secret []byte) []byte {
+	if k.Source == keySourceFile {
+		// Key files are already random, so a hash is enough
+		sum := sha256.Sum256(append(append([]byte{}, k.Salt...), secret...))
+		return sum[:]
+	}
+	return argon2.IDKey(secret, k.Salt, k.Time, k.Memory, k.Threads, 32)
+}
+
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func unseal(key, sealed, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+func dataKeyLabel(id string) []byte {
+	return []byte("roomy data key " + id)
+}
+
+// unwrapKeys unseals every data key in the keyring with secret.
+func (k *Keyring) unwrapKeys(secret []byte) (map[string][]byte, error) {
+	wrapping := k.wrappingKey(secret)
+	keys := map[string][]byte{}
+	for _, key := range k.Keys {
+		plain, err := unseal(wrapping, key.Wrapped, dataKeyLabel(key.ID))
+		if err != nil {
+			return nil, errWrongKey
+		}
+		keys[key.ID] = plain
+	}
+	if keys[k.Current] == nil {
+		return nil, errors.New("the encryption keyring has no current key")
+	}
+	return keys, nil
+}
+
+// unlockData unlocks the data files with a passphrase or key file contents.
+func unlockData(secret []byte) error {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if keyring == nil {
+		return nil
+	}
+	keys, err := keyring.unwrapKeys(secret)
+	if err != nil {
+		return err
+	}
+	dataKeys = keys
+	if !keyring.Sealed {
+		// Encryption was turned on by an earlier version or stopped part-way
+		if err := sealDataFiles(); err != nil {
+			log.Printf("Error encrypting the remaining data files: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func unlockWithKeyFile() error {
+	secret, err := readKeyFile(keyring.KeyFile)
+	if err != nil {
+		return err
+	}
+	return unlockData(secret)
+}
+
+// unlockFromEnvironment unlocks passphrase encryption with $ROOMY_PASSPHRASE,
+// for commands run without a display.
+func unlockFromEnvironment() error {
+	if !dataLocked() {
+		return nil
+	}
+	if keyring.Source == keySourceFile {
+		return unlockWithKeyFile()
+	}
+	passphrase := os.Getenv("ROOMY_PASSPHRASE")
+	if passphrase == "" {
+		return errors.New("the data files are encrypted; set ROOMY_PASSPHRASE to unlock them")
+	}
+	return unlockData([]byte(passphrase))
+}
+
+// encryptFile seals a data file with the current key. The header names the
+// key, and the header and file name are authenticated so files cannot be
+// swapped.
+func encryptFile(name string, plaintext []byte, keyID string, key []byte) ([]byte, error) {
+	header := encryptedHeader + keyID + "\n"
+	sealed, err := seal(key, plaintext, []byte(header+filepath.Base(name)))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(header), sealed...), nil
+}
+
+// decryptFile opens an encrypted data file. Plaintext files are returned as
+// they are until every file has been encrypted, so files written before
+// encryption was turned on still load; after that they are refused, as a
+// plaintext file can only have been put there from outside roomy. Callers
+// must hold storageMu.
+func decryptFile(name string, data []byte, keys map[string][]byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedHeader)) {
+		if keyring != nil && keyring.Sealed {
+			return nil, fmt.Errorf("%s is not encrypted although encryption is on; restore it from a backup", name)
+		}
+		return data, nil
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, fmt.Errorf("%s: damaged encryption header", name)
+	}
+	if keys == nil {
+		return nil, errDataLocked
+	}
+	header := string(data[:end+1])
+	key := keys[strings.TrimSpace(strings.TrimPrefix(header, encryptedHeader))]
+	if key == nil {
+		return nil, fmt.Errorf("%s is encrypted with a key that is not in the keyring", name)
+	}
+	plain, err := unseal(key, data[end+1:], []byte(header+filepath.Base(name)))
+	if err != nil {
+		return nil, fmt.Errorf("%s could not be decrypted: it is damaged or was not written by this install", name)
+	}
+	return plain, nil
+}
+
+// writeFileAtomic replaces a file in one step, so a crash never leaves it
+// half-written.
+func writeFileAtomic(name string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), name)
+}
+
+// readDataFile reads a data file, decrypting it if needed. A missing file
+// gives an error for which os.IsNotExist is true.
+func readDataFile(name string) ([]byte, error) {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return decryptFile(name, data, dataKeys)
+}
+
+// writeDataFile writes a data file, encrypted when encryption is on.
+func writeDataFile(name string, data []byte) error {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	return writeDataFileLocked(name, data)
+}
+
+func writeDataFileLocked(name string, data []byte) error {
+	if keyring != nil {
+		key := dataKeys[keyring.Current]
+		if key == nil {
+			// Never fall back to writing plaintext
+			return errDataLocked
+		}
+		var err error
+		if data, err = encryptFile(name, data, keyring.Current, key); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(name, data)
+}
+
+// loadJSONFile decodes a JSON data file into v.
+func loadJSONFile(name string, v interface{}) error {
+	data, err := readDataFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile encodes v as JSON into a data file.
+func saveJSONFile(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeDataFile(name, data)
+}
+
+// rewriteDataFiles reads every existing data file and writes it again with
+// the current keyring. Callers must hold storageMu for writing.
+func rewriteDataFiles() error {
+	for _, name := range dataFiles {
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		plain, err := decryptFile(name, data, dataKeys)
+		if err != nil {
+			return err
+		}
+		if err := writeDataFileLocked(name, plain); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// sealDataFiles encrypts every data file with the current key and records
+// in the keyring that plaintext files are no longer accepted. Callers must
+// hold storageMu for writing.
+func sealDataFiles() error {
+	if err := rewriteDataFiles(); err != nil {
+		return err
+	}
+	keyring.Sealed = true
+	return saveKeyring(keyring)
+}
+
+func newDataKey() (string, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	return newLocationID(), key, nil
+}
+
+// newKeyring returns a keyring for source with a fresh salt and no keys.
+func newKeyring(source, keyFile string) (*Keyring, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	k := &Keyring{Source: source, Salt: salt, Created: time.Now()}
+	if source == keySourceFile {
+		k.KeyFile = keyFile
+	} else {
+		k.Time, k.Memory, k.Threads = argon2Time, argon2Memory, argon2Threads
+	}
+	return k, nil
+}
+
+// enableEncryption encrypts every data file. The keyring is saved first, so
+// if the app stops part-way the files already encrypted can still be read
+// and the rest are finished when the files are next unlocked.
+func enableEncryption(source string, secret []byte, keyFile string) error {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if keyring != nil {
+		return errors.New("encryption is already on")
+	}
+	k, err := newKeyring(source, keyFile)
+	if err != nil {
+		return err
+	}
+	id, key, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := seal(k.wrappingKey(secret), key, dataKeyLabel(id))
+	if err != nil {
+		return err
+	}
+	k.Keys = []DataKey{{ID: id, Wrapped: wrapped}}
+	k.Current = id
+	if err := saveKeyring(k); err != nil {
+		return err
+	}
+	keyring = k
+	dataKeys = map[string][]byte{id: key}
+	return sealDataFiles()
+}
+
+// rotateEncryption re-encrypts every data file with a new data key sealed
+// by a new passphrase or key file. Old keys stay in the keyring until every
+// file has been rewritten, so an interrupted rotation loses nothing.
+func rotateEncryption(source string, secret []byte, keyFile string) error {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if keyring == nil || dataKeys == nil {
+		return errDataLocked
+	}
+	k, err := newKeyring(source, keyFile)
+	if err != nil {
+		return err
+	}
+	k.Created = keyring.Created
+	k.Rotated = time.Now()
+	k.Sealed = keyring.Sealed
+	id, key, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	keys := map[string][]byte{id: key}
+	for oldID, oldKey := range dataKeys {
+		keys[oldID] = oldKey
+	}
+	wrapping := k.wrappingKey(secret)
+	for keyID, plain := range keys {
+		wrapped, err := seal(wrapping, plain, dataKeyLabel(keyID))
+		if err != nil {
+			return err
+		}
+		k.Keys = append(k.Keys, DataKey{ID: keyID, Wrapped: wrapped})
+	}
+	k.Current = id
+	if err := saveKeyring(k); err != nil {
+		return err
+	}
+	keyring = k
+	dataKeys = keys
+	if err := rewriteDataFiles(); err != nil {
+		return err
+	}
+
+	// Every file now uses the new key
+	for i := range k.Keys {
+		if k.Keys[i].ID == id {
+			k.Keys = []DataKey{k.Keys[i]}
+			break
+		}
+	}
+	dataKeys = map[string][]byte{id: key}
+	k.Sealed = true
+	return saveKeyring(k)
+}
+
+// disableEncryption writes every data file back as plaintext.
+func disableEncryption() error {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if keyring == nil || dataKeys == nil {
+		return errDataLocked
+	}
+	k := keyring
+	if k.Sealed {
+		// Plaintext files are accepted again in case this stops part-way
+		k.Sealed = false
+		if err := saveKeyring(k); err != nil {
+			return err
+		}
+	}
+	keyring = nil // Files are written back as plaintext
+	if err := rewriteDataFiles(); err != nil {
+		keyring = k
+		return err
+	}
+	dataKeys = nil
+	if err := os.Remove(keyringFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkCurrentSecret confirms the admin knows the current passphrase before
+// the key is changed or encryption turned off.
+func checkCurrentSecret(passphrase string) error {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	if keyring == nil || keyring.Source != keySourcePassphrase {
+		return nil
+	}
+	_, err := keyring.unwrapKeys([]byte(passphrase))
+	return err
+}
+
+// createKeyFile writes 32 random bytes, base64 encoded, to a new key file.
+func createKeyFile(writer io.Writer) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, base64.StdEncoding.EncodeToString(secret)+"\n")
+	return err
+}
+
+// createUnlockScreen is shown at startup while the data files are locked.
+func createUnlockScreen(w fyne.Window, onUnlocked func()) fyne.CanvasObject {
+	title := widget.NewLabelWithStyle("Data Locked", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	var body fyne.CanvasObject
+	if keyring.Keys == nil {
+		body = wrappedLabel("encryption.json could not be read, so the data files cannot be opened. Restore it from a backup and start roomy again.")
+	} else if keyring.Source == keySourceFile {
+		message := wrappedLabel(fmt.Sprintf("The data files are encrypted with the key file %s, which could not be read. Make it available and try again.", keyring.KeyFile))
+		retry := widget.NewButtonWithIcon("Try Again", theme.ViewRefreshIcon(), func() {
+			if err := unlockWithKeyFile(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			onUnlocked()
+		})
+		body = container.NewVBox(message, retry)
+	} else {
+		message := wrappedLabel("The data files are encrypted. Enter the passphrase to open them.")
+		passphraseEntry := widget.NewPasswordEntry()
+		passphraseEntry.SetPlaceHolder("Passphrase")
+		unlock := func() {
+			if err := unlockData([]byte(passphraseEntry.Text)); err != nil {
+				passphraseEntry.SetText("")
+				dialog.ShowError(err, w)
+				return
+			}
+			onUnlocked()
+		}
+		passphraseEntry.OnSubmitted = func(string) { unlock() }
+		body = container.NewVBox(message, passphraseEntry, widget.NewButtonWithIcon("Unlock", theme.LoginIcon(), unlock))
+	}
+	box := container.NewVBox(title, body)
+	return container.NewCenter(container.NewGridWrap(fyne.NewSize(400, box.MinSize().Height), box))
+}
+
+// encryptionKeyForm returns form items for choosing a passphrase or key
+// file, and a function reading the choice.
+func encryptionKeyForm(w fyne.Window) ([]*widget.FormItem, func() (string, []byte, string, error)) {
+	passphraseEntry := widget.NewPasswordEntry()
+	confirmEntry := widget.NewPasswordEntry()
+	keyFileEntry := widget.NewEntry()
+	keyFileEntry.SetPlaceHolder("/path/to/roomy.key")
+	createButton := widget.NewButton("Create Key File...", func() {
+		dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+			if err := createKeyFile(writer); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			keyFileEntry.SetText(writer.URI().Path())
+		}, w)
+	})
+	keyFileRow := container.NewBorder(nil, nil, nil, createButton, keyFileEntry)
+
+	sourceSelect := widget.NewSelect([]string{keySourcePassphrase, keySourceFile}, func(source string) {
+		if source == keySourceFile {
+			passphraseEntry.Disable()
+			confirmEntry.Disable()
+			keyFileEntry.Enable()
+			createButton.Enable()
+		} else {
+			passphraseEntry.Enable()
+			confirmEntry.Enable()
+			keyFileEntry.Disable()
+			createButton.Disable()
+		}
+	})
+	sourceSelect.SetSelected(keySourcePassphrase)
+	if keyring != nil && keyring.Source == keySourceFile {
+		sourceSelect.SetSelected(keySourceFile)
+		keyFileEntry.SetText(keyring.KeyFile)
+	}
+
+	items := []*widget.FormItem{
+		{Text: "Unlock With", Widget: sourceSelect, HintText: "A passphrase is asked for at startup; a key file is read automatically"},
+		{Text: "Passphrase", Widget: passphraseEntry, HintText: fmt.Sprintf("At least %d characters", minPassphraseLength)},
+		{Text: "Confirm Passphrase", Widget: confirmEntry},
+		{Text: "Key File", Widget: keyFileRow, HintText: "Keep it off this disk, e.g. on removable or network storage"},
+	}
+	current := func() (string, []byte, string, error) {
+		if sourceSelect.Selected == keySourceFile {
+			path := strings.TrimSpace(keyFileEntry.Text)
+			secret, err := readKeyFile(path)
+			if err != nil {
+				return "", nil, "", err
+			}
+			return keySourceFile, secret, path, nil
+		}
+		if len(passphraseEntry.Text) < minPassphraseLength {
+			return "", nil, "", fmt.Errorf("the passphrase must be at least %d characters", minPassphraseLength)
+		}
+		if passphraseEntry.Text != confirmEntry.Text {
+			return "", nil, "", errors.New("passphrases do not match")
+		}
+		return keySourcePassphrase, []byte(passphraseEntry.Text), "", nil
+	}
+	return items, current
+}
+
+// showEncryptionSettings lets admins turn encryption of the data files on
+// or off and rotate the key.
+func showEncryptionSettings(w fyne.Window) {
+	var d dialog.Dialog
+	status := "The data files are not encrypted."
+	if encryptionEnabled() {
+		status = fmt.Sprintf("The data files are encrypted (unlocked with a %s) since %s.",
+			strings.ToLower(keyring.Source), keyring.Created.In(siteLocation()).Format("Jan 2 2006"))
+		if !keyring.Rotated.IsZero() {
+			status += fmt.Sprintf(" The key was last rotated on %s.", keyring.Rotated.In(siteLocation()).Format("Jan 2 2006"))
+		}
+	}
+
+	// Changing the key or turning encryption off needs the current passphrase
+	currentEntry := widget.NewPasswordEntry()
+	currentItems := func() []*widget.FormItem {
+		if keyring != nil && keyring.Source == keySourcePassphrase {
+			return []*widget.FormItem{{Text: "Current Passphrase", Widget: currentEntry}}
+		}
+		return nil
+	}
+	done := func(message string) {
+		dialog.ShowInformation("Encryption", message, w)
+	}
+
+	turnOnButton := widget.NewButtonWithIcon("Turn On", theme.ConfirmIcon(), func() {
+		d.Hide()
+		items, current := encryptionKeyForm(w)
+		form := dialog.NewForm("Turn On Encryption", "Encrypt", "Cancel", items, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			source, secret, keyFile, err := current()
+			if err == nil {
+				err = enableEncryption(source, secret, keyFile)
+			}
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			done("The data files are now encrypted. Keep the passphrase or key file safe: without it the data cannot be recovered.")
+		}, w)
+		form.Resize(fyne.NewSize(550, 380))
+		form.Show()
+	})
+	rotateButton := widget.NewButtonWithIcon("Rotate Key", theme.ViewRefreshIcon(), func() {
+		d.Hide()
+		items, current := encryptionKeyForm(w)
+		form := dialog.NewForm("Rotate Key", "Rotate", "Cancel", append(currentItems(), items...), func(confirm bool) {
+			if !confirm {
+				return
+			}
+			if err := checkCurrentSecret(currentEntry.Text); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			source, secret, keyFile, err := current()
+			if err == nil {
+				err = rotateEncryption(source, secret, keyFile)
+			}
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			done("The data files have been re-encrypted with a new key.")
+		}, w)
+		form.Resize(fyne.NewSize(550, 420))
+		form.Show()
+	})
+	turnOffButton := widget.NewButtonWithIcon("Turn Off", theme.CancelIcon(), func() {
+		d.Hide()
+		items := append(currentItems(), &widget.FormItem{Text: "", Widget: wrappedLabel("The data files will be written back unencrypted.")})
+		dialog.ShowForm("Turn Off Encryption", "Decrypt", "Cancel", items, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			if err := checkCurrentSecret(currentEntry.Text); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if err := disableEncryption(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			done("The data files are no longer encrypted.")
+		}, w)
+	})
+
+	buttons := container.NewHBox(turnOnButton)
+	if encryptionEnabled() {
+		buttons = container.NewHBox(rotateButton, turnOffButton)
+	}
+	d = dialog.NewCustom("Encryption", "Close", container.NewVBox(wrappedLabel(status), buttons), w)
+	d.Resize(fyne.NewSize(450, 200))
+	d.Show()
+}
@@ -0,0 +1,107 @@
+// encryption_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useTestEncryption turns encryption off for the test and restores the
+// previous keyring afterwards.
+func useTestEncryption(t *testing.T) {
+	t.Helper()
+	useTestDataDir(t)
+	savedKeyring, savedKeys := keyring, dataKeys
+	keyring, dataKeys = nil, nil
+	t.Cleanup(func() { keyring, dataKeys = savedKeyring, savedKeys })
+}
+
+var testKeyFileSecret = bytes.Repeat([]byte("k"), minKeyFileSize)
+
+func TestEncryptionRefusesPlaintextOnceSealed(t *testing.T) {
+	useTestEncryption(t)
+	if err := os.WriteFile("invites.json", []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := enableEncryption(keySourceFile, testKeyFileSecret, "roomy.key"); err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.Sealed {
+		t.Fatal("the keyring is not marked sealed after turning encryption on")
+	}
+	data, err := os.ReadFile("invites.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(encryptedHeader)) {
+		t.Fatal("invites.json was not encrypted")
+	}
+
+	if err := os.WriteFile("users.json", []byte(`[{"Username":"mallory","Role":"Admin"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var loaded []User
+	err = loadJSONFile("users.json", &loaded)
+	if err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Fatalf("loading a plaintext file after sealing: got %v, want an error", err)
+	}
+	if len(loaded) != 0 {
+		t.Error("the plaintext file was loaded")
+	}
+}
+
+func TestUnlockFinishesInterruptedEncryption(t *testing.T) {
+	useTestEncryption(t)
+	if err := enableEncryption(keySourceFile, testKeyFileSecret, "roomy.key"); err != nil {
+		t.Fatal(err)
+	}
+	// As left by a version without sealing, or an interrupted Turn On
+	keyring.Sealed = false
+	if err := saveKeyring(keyring); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("invites.json", []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("roomy.key", testKeyFileSecret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, dataKeys = nil, nil
+	loadKeyring() // Reads encryption.json and unlocks with the key file
+	if dataLocked() {
+		t.Fatal("the key file did not unlock the data files")
+	}
+	if !keyring.Sealed {
+		t.Error("unlocking did not seal the keyring")
+	}
+	data, err := os.ReadFile("invites.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(encryptedHeader)) {
+		t.Error("the leftover plaintext file was not encrypted on unlock")
+	}
+}
+
+func TestDisableEncryptionAcceptsPlaintextAgain(t *testing.T) {
+	useTestEncryption(t)
+	if err := enableEncryption(keySourceFile, testKeyFileSecret, "roomy.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveJSONFile("invites.json", []Invite{{Code: "ABC"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := disableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	var loaded []Invite
+	if err := loadJSONFile("invites.json", &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].Code != "ABC" {
+		t.Errorf("loaded %+v after turning encryption off", loaded)
+	}
+}
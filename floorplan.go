@@ -0,0 +1,570 @@
+// floorplan.go
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Register decoders for uploaded floor plans
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Floor plan editing modes for admins
+const (
+	floorPlanModeView      = "View"
+	floorPlanModeLayout    = "Edit Layout"
+	floorPlanModeRectangle = "Draw Rectangle"
+	floorPlanModePolygon   = "Draw Polygon"
+)
+
+// Occupancy colours used to fill room shapes
+var (
+	occupancyFree = color.NRGBA{R: 40, G: 167, B: 69, A: 110} // Success green
+	occupancySoon = color.NRGBA{R: 255, G: 193, B: 7, A: 110} // Warning amber
+	occupancyBusy = color.NRGBA{R: 220, G: 53, B: 69, A: 110} // Danger color
+	draftColor    = color.NRGBA{R: 0, G: 123, B: 255, A: 255} // Primary blue
+)
+
+// upcomingWindow is how far ahead a reservation turns a room amber
+const upcomingWindow = 30 * time.Minute
+
+// stopFloorPlanRefresh stops the live refresh of the previously built view
+var stopFloorPlanRefresh func()
+
+// floorPlanView holds the state of the floor plan screen
+type floorPlanView struct {
+	window      fyne.Window
+	floorID     string
+	date        string
+	at          time.Time
+	live        bool // Follow the current time
+	mode        string
+	draft       []fyne.Position
+	layout      *layoutEdit // Unsaved layout while in Edit Layout mode
+	markerRooms []*Room     // Room of each marker, in marker order
+
+	plan       *floorPlanCanvas
+	side       *fyne.Container // Holds the palette while editing the layout
+	slider     *widget.Slider
+	atLabel    *widget.Label
+	finish     *widget.Button
+	modeSelect *widget.Select
+	modeTools  *fyne.Container
+}
+
+// Floor plan view
+func createFloorPlanView(w fyne.Window) fyne.CanvasObject {
+	floors := allFloors()
+	if len(floors) == 0 {
+		return widget.NewLabel("No floors defined. Admins can add them under Manage Locations.")
+	}
+
+	// Reopen the floor shown last time
+	current := floors[0]
+	labels := []string{}
+	for _, floor := range floors {
+		labels = append(labels, floor.Label())
+		if floor.Floor.ID == selectedFloorID {
+			current = floor
+		}
+	}
+	selectedFloorID = current.Floor.ID
+
+	holder := container.NewMax(createFloorView(current, w))
+	floorSelect := widget.NewSelect(labels, nil)
+	floorSelect.SetSelected(current.Label())
+	floorSelect.OnChanged = func(label string) {
+		for _, floor := range floors {
+			if floor.Label() == label && floor.Floor.ID != selectedFloorID {
+				selectedFloorID = floor.Floor.ID
+				holder.Objects = []fyne.CanvasObject{createFloorView(floor, w)}
+				holder.Refresh()
+			}
+		}
+	}
+	return container.NewBorder(container.NewHBox(widget.NewLabel("Floor:"), floorSelect), nil, nil, nil, holder)
+}
+
+// createFloorView shows one floor's plan with the rooms assigned to it.
+func createFloorView(floor floorRef, w fyne.Window) fyne.CanvasObject {
+	if floor.Floor.PlanPath == "" {
+		return widget.NewLabel("Floor plan not uploaded for this floor.")
+	}
+
+	// Load the floor plan image
+	data, err := os.ReadFile(floor.Floor.PlanPath)
+	if err != nil {
+		return widget.NewLabel("Floor plan not uploaded for this floor.")
+	}
+	img, err := decodePlanImage(floor.Floor.PlanPath, data)
+	if err != nil {
+		dialog.ShowError(err, w)
+		return widget.NewLabel("Error loading floor plan image.")
+	}
+
+	bounds := img.Bounds()
+	migrateLegacyPlanCoordinates(floor.Floor.ID, fyne.NewSize(float32(bounds.Dx()), float32(bounds.Dy())))
+
+	view := &floorPlanView{
+		window:  w,
+		floorID: floor.Floor.ID,
+		live:    true,
+		mode:    floorPlanModeView,
+	}
+	view.at = time.Now().In(siteLocation())
+	view.date = view.at.Format("2006-01-02")
+
+	view.plan = newFloorPlanCanvas(img)
+	view.plan.OnTapped = view.tapped
+	view.plan.Shapes = func() []planShape {
+		shapes := []planShape{}
+		for _, room := range bookableRooms(roomsOnFloor(view.floorID)) {
+			if shape := view.shapeOf(room); len(shape) >= 3 {
+				shapes = append(shapes, planShape{Points: shape, Fill: roomOccupancyAt(room, view.at)})
+			}
+		}
+		return shapes
+	}
+	view.rebuildMarkers()
+
+	// The scroll container only clips; the canvas handles zoom and pan itself
+	clip := container.NewScroll(view.plan)
+	clip.Direction = container.ScrollNone
+	view.side = container.NewMax()
+
+	view.startLiveRefresh()
+	return container.NewBorder(view.createToolbar(), nil, nil, view.side, clip)
+}
+
+// decodePlanImage decodes an uploaded floor plan, rasterising SVG drawings.
+func decodePlanImage(path string, data []byte) (image.Image, error) {
+	if isSVGPlan(path) {
+		return rasterizeSVG(data)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func isSVGPlan(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".svg")
+}
+
+// migrateLegacyPlanCoordinates converts the positions and shapes of a floor's
+// rooms saved as raw pixel offsets into plan coordinates. Plan coordinates
+// never exceed 1, so anything larger must be a pixel value from an older version.
+func migrateLegacyPlanCoordinates(floorID string, imageSize fyne.Size) {
+	if imageSize.Width <= 0 || imageSize.Height <= 0 {
+		return
+	}
+	legacy := func(p fyne.Position) bool {
+		return p.X > 1 || p.Y > 1
+	}
+	normalize := func(p fyne.Position) fyne.Position {
+		return fyne.NewPos(p.X/imageSize.Width, p.Y/imageSize.Height)
+	}
+
+	changed := false
+	for _, room := range roomsOnFloor(floorID) {
+		if legacy(room.Position) {
+			room.Position = normalize(room.Position)
+			changed = true
+		}
+		for i, p := range room.Shape {
+			if legacy(p) {
+				room.Shape[i] = normalize(p)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		saveReservations()
+	}
+}
+
+// createToolbar builds the day and time controls, plus drawing tools for admins.
+func (v *floorPlanView) createToolbar() fyne.CanvasObject {
+	dateEntry := widget.NewEntry()
+	dateEntry.SetText(v.date)
+	dateEntry.OnSubmitted = func(value string) {
+		if _, err := time.ParseInLocation("2006-01-02", value, siteLocation()); err != nil {
+			dialog.ShowError(errors.New("please enter the date as YYYY-MM-DD"), v.window)
+			return
+		}
+		v.date = value
+		v.live = false
+		v.setTimeFromSlider()
+	}
+
+	v.atLabel = widget.NewLabel("")
+	openAt, closeAt := openingHours()
+	v.slider = widget.NewSlider(openAt.Hours(), closeAt.Hours()-0.25) // Business hours, in hours
+	v.slider.Step = 0.25
+	v.slider.OnChanged = func(float64) {
+		v.live = false
+		v.setTimeFromSlider()
+	}
+	v.syncSlider()
+
+	nowButton := widget.NewButton("Now", func() {
+		v.live = true
+		v.at = time.Now().In(siteLocation())
+		v.date = v.at.Format("2006-01-02")
+		dateEntry.SetText(v.date)
+		v.syncSlider()
+		v.refresh()
+	})
+
+	zoomIn := widget.NewButtonWithIcon("", theme.ZoomInIcon(), func() {
+		v.plan.ZoomBy(1.25, fyne.NewPos(v.plan.Size().Width/2, v.plan.Size().Height/2))
+	})
+	zoomOut := widget.NewButtonWithIcon("", theme.ZoomOutIcon(), func() {
+		v.plan.ZoomBy(0.8, fyne.NewPos(v.plan.Size().Width/2, v.plan.Size().Height/2))
+	})
+	zoomFit := widget.NewButtonWithIcon("", theme.ZoomFitIcon(), func() {
+		v.plan.ResetZoom()
+	})
+
+	timeRow := container.NewBorder(nil, nil, container.NewHBox(widget.NewLabel("Day:"), dateEntry), container.NewHBox(v.atLabel, nowButton, zoomIn, zoomOut, zoomFit), v.slider)
+	if currentUser == nil || currentUser.Role != "Admin" {
+		return timeRow
+	}
+
+	v.finish = widget.NewButton("Finish Shape", v.finishPolygon)
+	v.finish.Disable()
+	cancelButton := widget.NewButton("Cancel Shape", func() {
+		v.clearDraft()
+	})
+	clearButton := widget.NewButton("Clear Shape", func() {
+		v.chooseRoom("Clear Shape", func(room *Room) {
+			if err := executeCommand(&SetRoomShapeCommand{room: room, oldShape: room.Shape}); err != nil {
+				dialog.ShowError(err, v.window)
+			}
+			v.refresh()
+		})
+	})
+	drawTools := []fyne.CanvasObject{cancelButton, clearButton}
+	layoutTools := v.createLayoutTools()
+
+	v.modeTools = container.NewHBox()
+	v.modeSelect = widget.NewSelect([]string{floorPlanModeView, floorPlanModeLayout, floorPlanModeRectangle, floorPlanModePolygon}, nil)
+	v.modeSelect.SetSelected(v.mode)
+	v.modeSelect.OnChanged = func(mode string) {
+		if mode == v.mode {
+			return
+		}
+		switchMode := func() {
+			v.setMode(mode)
+			switch mode {
+			case floorPlanModeLayout:
+				v.modeTools.Objects = layoutTools
+			case floorPlanModeRectangle:
+				v.modeTools.Objects = drawTools
+			case floorPlanModePolygon:
+				v.modeTools.Objects = append([]fyne.CanvasObject{v.finish}, drawTools...)
+			default:
+				v.modeTools.Objects = nil
+			}
+			v.modeTools.Refresh()
+		}
+		if v.layout == nil || !v.layout.changed() {
+			switchMode()
+			return
+		}
+		dialog.ShowConfirm("Discard Layout", "Discard the unsaved layout changes?", func(discard bool) {
+			if discard {
+				switchMode()
+			} else {
+				v.modeSelect.SetSelected(v.mode)
+			}
+		}, v.window)
+	}
+
+	editRow := container.NewHBox(widget.NewLabel("Mode:"), v.modeSelect, v.modeTools)
+	if floor, ok := findFloor(v.floorID); ok && isSVGPlan(floor.Floor.PlanPath) {
+		editRow.Add(widget.NewButton("Bind Rooms", func() {
+			v.bindSVGRooms(floor)
+		}))
+	}
+	return container.NewVBox(timeRow, editRow)
+}
+
+// bindSVGRooms reads the floor's SVG plan and opens the room binding dialog.
+func (v *floorPlanView) bindSVGRooms(floor floorRef) {
+	data, err := os.ReadFile(floor.Floor.PlanPath)
+	if err != nil {
+		dialog.ShowError(err, v.window)
+		return
+	}
+	plan, err := parseSVGPlan(data)
+	if err != nil {
+		dialog.ShowError(err, v.window)
+		return
+	}
+	showSVGBinding(floor, plan, v.refresh, v.window)
+}
+
+// setMode leaves the current mode, dropping any unsaved layout, and enters mode.
+func (v *floorPlanView) setMode(mode string) {
+	if v.layout != nil {
+		v.stopLayoutEdit()
+	}
+	v.mode = mode
+	v.clearDraft()
+	if mode == floorPlanModeLayout {
+		v.startLayoutEdit()
+	}
+}
+
+// startLiveRefresh keeps the colours current while the view follows the clock.
+func (v *floorPlanView) startLiveRefresh() {
+	if stopFloorPlanRefresh != nil {
+		stopFloorPlanRefresh()
+	}
+	ticker := time.NewTicker(time.Minute)
+	done := make(chan struct{})
+	stopFloorPlanRefresh = func() {
+		ticker.Stop()
+		close(done)
+	}
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if v.live {
+					v.at = time.Now().In(siteLocation())
+					v.syncSlider()
+					v.refresh()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// setTimeFromSlider moves the preview time to the slider position on the selected day.
+func (v *floorPlanView) setTimeFromSlider() {
+	open, _, err := businessHours(v.date)
+	if err != nil {
+		return
+	}
+	v.at = open.Add(time.Duration((v.slider.Value - v.slider.Min) * float64(time.Hour)))
+	v.atLabel.SetText(formatClock(v.at))
+	v.refresh()
+}
+
+// syncSlider moves the slider to the preview time without leaving live mode.
+func (v *floorPlanView) syncSlider() {
+	local := v.at.In(siteLocation())
+	hours := float64(local.Hour()) + float64(local.Minute())/60
+	hours = math.Max(v.slider.Min, math.Min(v.slider.Max, hours))
+	onChanged := v.slider.OnChanged
+	v.slider.OnChanged = nil
+	v.slider.SetValue(hours)
+	v.slider.OnChanged = onChanged
+	v.atLabel.SetText(formatClock(v.at))
+}
+
+func (v *floorPlanView) refresh() {
+	v.rebuildMarkers()
+	if v.layout != nil {
+		v.rebuildHandles()
+	}
+}
+
+// rebuildMarkers places a button for each positioned room on the floor,
+// tinted by occupancy.
+func (v *floorPlanView) rebuildMarkers() {
+	markers := []fyne.CanvasObject{}
+	anchors := []fyne.Position{}
+	v.markerRooms = nil
+	for _, room := range bookableRooms(roomsOnFloor(v.floorID)) {
+		position := v.positionOf(room)
+		if position == (fyne.Position{}) {
+			continue
+		}
+		roomCopy := room // Capture variable for closure
+		roomButton := widget.NewButton(room.Name, func() {
+			// Handle room booking from floor plan
+			if v.layout == nil {
+				openRoomBooking(roomCopy, v.window)
+			}
+		})
+		switch roomOccupancyAt(room, v.at) {
+		case occupancyBusy:
+			roomButton.Importance = widget.DangerImportance
+		case occupancySoon:
+			roomButton.Importance = widget.WarningImportance
+		default:
+			roomButton.Importance = widget.SuccessImportance
+		}
+		markers = append(markers, roomButton)
+		anchors = append(anchors, position)
+		v.markerRooms = append(v.markerRooms, room)
+	}
+	v.plan.SetMarkers(markers, anchors)
+}
+
+// roomOccupancyAt returns the fill colour for a room at the given time: busy
+// if a reservation is in progress, soon if one starts shortly, free otherwise.
+func roomOccupancyAt(room *Room, at time.Time) color.NRGBA {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	state := occupancyFree
+	for _, res := range room.Reservations {
+		if !res.Active {
+			continue
+		}
+		if !res.StartTime.After(at) && res.EndTime.After(at) {
+			return occupancyBusy
+		}
+		if res.StartTime.After(at) && !res.StartTime.After(at.Add(upcomingWindow)) {
+			state = occupancySoon
+		}
+	}
+	return state
+}
+
+func shapeBounds(shape []fyne.Position) (minX, minY, maxX, maxY float32) {
+	minX, minY = shape[0].X, shape[0].Y
+	maxX, maxY = minX, minY
+	for _, p := range shape[1:] {
+		minX = float32(math.Min(float64(minX), float64(p.X)))
+		maxX = float32(math.Max(float64(maxX), float64(p.X)))
+		minY = float32(math.Min(float64(minY), float64(p.Y)))
+		maxY = float32(math.Max(float64(maxY), float64(p.Y)))
+	}
+	return
+}
+
+func clampInt(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
+
+// pointInPolygon uses the even-odd rule to test whether p lies inside shape.
+func pointInPolygon(p fyne.Position, shape []fyne.Position) bool {
+	inside := false
+	for i, j := 0, len(shape)-1; i < len(shape); j, i = i, i+1 {
+		a, b := shape[i], shape[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// roomAt returns the room of candidates whose shape contains p, if any.
+func roomAt(p fyne.Position, candidates []*Room) *Room {
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if len(candidates[i].Shape) >= 3 && pointInPolygon(p, candidates[i].Shape) {
+			return candidates[i]
+		}
+	}
+	return nil
+}
+
+// tapped handles taps on the floor plan, given in plan coordinates, according
+// to the current mode.
+func (v *floorPlanView) tapped(p fyne.Position) {
+	switch v.mode {
+	case floorPlanModeLayout:
+		// Rooms are moved by dragging in this mode
+	case floorPlanModeRectangle:
+		v.addDraftPoint(p)
+		if len(v.draft) == 2 {
+			a, b := v.draft[0], v.draft[1]
+			v.completeShape([]fyne.Position{a, fyne.NewPos(b.X, a.Y), b, fyne.NewPos(a.X, b.Y)})
+		}
+	case floorPlanModePolygon:
+		v.addDraftPoint(p)
+		if len(v.draft) >= 3 {
+			v.finish.Enable()
+		}
+	default:
+		if room := roomAt(p, bookableRooms(roomsOnFloor(v.floorID))); room != nil {
+			openRoomBooking(room, v.window)
+		}
+	}
+}
+
+// addDraftPoint adds a vertex to the shape being drawn.
+func (v *floorPlanView) addDraftPoint(p fyne.Position) {
+	v.draft = append(v.draft, p)
+	v.plan.SetDraft(v.draft)
+}
+
+func (v *floorPlanView) clearDraft() {
+	v.draft = nil
+	v.plan.SetDraft(nil)
+	if v.finish != nil {
+		v.finish.Disable()
+	}
+}
+
+func (v *floorPlanView) finishPolygon() {
+	if len(v.draft) < 3 {
+		dialog.ShowError(errors.New("a shape needs at least three points"), v.window)
+		return
+	}
+	v.completeShape(append([]fyne.Position{}, v.draft...))
+}
+
+// completeShape asks which room the drawn shape belongs to and saves it.
+func (v *floorPlanView) completeShape(shape []fyne.Position) {
+	v.chooseRoom("Assign Shape", func(room *Room) {
+		if err := executeCommand(&SetRoomShapeCommand{room: room, oldShape: room.Shape, newShape: shape}); err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+		v.clearDraft()
+		v.refresh()
+	})
+}
+
+// chooseRoom shows a picker of the floor's rooms and calls onChosen with the selection.
+func (v *floorPlanView) chooseRoom(title string, onChosen func(*Room)) {
+	names := []string{}
+	for _, room := range bookableRooms(roomsOnFloor(v.floorID)) {
+		names = append(names, room.Name)
+	}
+	if len(names) == 0 {
+		dialog.ShowInformation(title, "No rooms are assigned to this floor. Assign them under Manage Locations.", v.window)
+		if v.mode == floorPlanModeRectangle && len(v.draft) == 2 {
+			v.clearDraft()
+		}
+		return
+	}
+	var d dialog.Dialog
+	roomSelect := widget.NewSelect(names, func(selected string) {
+		if room := findRoom(selected); room != nil {
+			d.Hide()
+			onChosen(room)
+		}
+	})
+	d = dialog.NewCustom(title, "Close", container.NewVBox(widget.NewLabel("Choose a room:"), roomSelect), v.window)
+	d.SetOnClosed(func() {
+		if v.mode == floorPlanModeRectangle && len(v.draft) == 2 {
+			v.clearDraft()
+		}
+	})
+	d.Show()
+}
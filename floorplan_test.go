@@ -0,0 +1,99 @@
+// floorplan_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestPointInPolygon(t *testing.T) {
+	// An L shape missing its top right quarter
+	shape := []fyne.Position{{X: 0, Y: 0}, {X: 0.5, Y: 0}, {X: 0.5, Y: 0.5}, {X: 1, Y: 0.5}, {X: 1, Y: 1}, {X: 0, Y: 1}}
+	tests := []struct {
+		p    fyne.Position
+		want bool
+	}{
+		{fyne.NewPos(0.25, 0.25), true},
+		{fyne.NewPos(0.75, 0.75), true},
+		{fyne.NewPos(0.75, 0.25), false},
+		{fyne.NewPos(1.5, 0.75), false},
+	}
+	for _, tt := range tests {
+		if got := pointInPolygon(tt.p, shape); got != tt.want {
+			t.Errorf("pointInPolygon(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestRoomAtPrefersTopmostShape(t *testing.T) {
+	square := func(x0, y0, x1, y1 float32) []fyne.Position {
+		return []fyne.Position{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}
+	}
+	hall := &Room{Name: "Hall", Shape: square(0, 0, 1, 1)}
+	office := &Room{Name: "Office", Shape: square(0.6, 0.6, 0.9, 0.9)}
+	unplaced := &Room{Name: "Store"}
+	candidates := []*Room{hall, office, unplaced}
+
+	if got := roomAt(fyne.NewPos(0.7, 0.7), candidates); got != office {
+		t.Errorf("inside the office found %v", got)
+	}
+	if got := roomAt(fyne.NewPos(0.2, 0.2), candidates); got != hall {
+		t.Errorf("inside the hall found %v", got)
+	}
+	if got := roomAt(fyne.NewPos(1.2, 0.2), candidates); got != nil {
+		t.Errorf("outside every shape found %s", got.Name)
+	}
+}
+
+func TestRoomOccupancyColours(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	nine := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := room.Reserve(testReservation(nine, "Meeting")); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.Reserve(testReservation(nine.Add(3*time.Hour), "Cancelled")); err != nil {
+		t.Fatal(err)
+	}
+	room.DeleteReservation(1)
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{nine.Add(-time.Hour), "free"},
+		{nine.Add(-upcomingWindow), "soon"},
+		{nine, "busy"},
+		{nine.Add(59 * time.Minute), "busy"},
+		{nine.Add(time.Hour), "free"},
+		{nine.Add(3 * time.Hour), "free"}, // Cancelled bookings do not count
+	}
+	names := map[interface{}]string{occupancyFree: "free", occupancySoon: "soon", occupancyBusy: "busy"}
+	for _, tt := range tests {
+		if got := names[roomOccupancyAt(room, tt.at)]; got != tt.want {
+			t.Errorf("at %s the room is %s, want %s", formatClock(tt.at), got, tt.want)
+		}
+	}
+}
+
+func TestSetRoomShapeUndo(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	old := []fyne.Position{{X: 0, Y: 0}, {X: 0.1, Y: 0}, {X: 0.1, Y: 0.1}}
+	room.Shape = old
+	drawn := []fyne.Position{{X: 0.2, Y: 0.2}, {X: 0.4, Y: 0.2}, {X: 0.4, Y: 0.4}, {X: 0.2, Y: 0.4}}
+
+	if err := executeCommand(&SetRoomShapeCommand{room: room, oldShape: old, newShape: drawn}); err != nil {
+		t.Fatal(err)
+	}
+	if len(room.Shape) != 4 {
+		t.Errorf("room has %d corners after drawing, want 4", len(room.Shape))
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(room.Shape) != 3 {
+		t.Errorf("room has %d corners after undo, want 3", len(room.Shape))
+	}
+}
@@ -0,0 +1,55 @@
+// helpers_test.go
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// useTestDataDir runs the test in an empty data directory with no accounts,
+// rooms, locations or undo history and the default settings, restoring the
+// previous state afterwards.
+func useTestDataDir(t *testing.T) {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	savedUsers, savedCurrent, savedRooms, savedSites := users, currentUser, rooms, sites
+	savedSettings, savedZone, savedUndo, savedRedo := settings, siteZone, undoStack, redoStack
+	users, currentUser, rooms, sites = nil, nil, nil, nil
+	settings, siteZone, undoStack, redoStack = Settings{}, time.Local, nil, nil
+	t.Cleanup(func() {
+		os.Chdir(dir)
+		users, currentUser, rooms, sites = savedUsers, savedCurrent, savedRooms, savedSites
+		settings, siteZone, undoStack, redoStack = savedSettings, savedZone, savedUndo, savedRedo
+	})
+}
+
+// useTestRoom replaces the rooms with one empty room in a UTC site for the
+// length of the test.
+func useTestRoom(t *testing.T, name string) *Room {
+	t.Helper()
+	useTestDataDir(t)
+	settings.TimeZone = "UTC"
+	loadSiteLocation()
+	room := &Room{ID: "room-1", Name: name}
+	rooms = []*Room{room}
+	return room
+}
+
+// testReservation is an hour-long booking starting at start.
+func testReservation(start time.Time, purpose string) Reservation {
+	return Reservation{
+		Date:      start.Format("2006-01-02"),
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+		Purpose:   purpose,
+		BookedBy:  "alice",
+	}
+}
@@ -0,0 +1,344 @@
+// layouteditor.go
+
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Snap grid spacings offered in Edit Layout mode, in plan coordinates
+var snapSteps = map[string]float32{
+	"Off":    0,
+	"Fine":   0.01,
+	"Medium": 0.025,
+	"Coarse": 0.05,
+}
+
+// roomLayout is a room's marker position and shape while the layout is edited
+type roomLayout struct {
+	Position fyne.Position
+	Shape    []fyne.Position
+}
+
+// layoutEdit holds the unsaved layout of a floor in Edit Layout mode. Changes
+// only reach the rooms when saved, as one undoable command.
+type layoutEdit struct {
+	rooms   []*Room
+	layouts map[*Room]*roomLayout
+	snap    float32
+	handles []vertexRef         // Owner of each handle, in handle order
+	drag    func(fyne.Position) // Applies the drag in progress
+	palette *fyne.Container
+}
+
+// vertexRef identifies one vertex of a room's shape
+type vertexRef struct {
+	room   *Room
+	vertex int
+}
+
+// changed reports whether any room differs from its saved layout.
+func (e *layoutEdit) changed() bool {
+	for _, room := range e.rooms {
+		layout := e.layouts[room]
+		if layout.Position != room.Position || !samePoints(layout.Shape, room.Shape) {
+			return true
+		}
+	}
+	return false
+}
+
+func samePoints(a, b []fyne.Position) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// snapPoint rounds p to the snap grid and keeps it on the plan.
+func (e *layoutEdit) snapPoint(p fyne.Position) fyne.Position {
+	if e.snap > 0 {
+		p.X = float32(math.Round(float64(p.X/e.snap))) * e.snap
+		p.Y = float32(math.Round(float64(p.Y/e.snap))) * e.snap
+	}
+	return fyne.NewPos(float32(math.Max(0, math.Min(1, float64(p.X)))), float32(math.Max(0, math.Min(1, float64(p.Y)))))
+}
+
+// positionOf returns where a room's marker is shown, honouring unsaved edits.
+func (v *floorPlanView) positionOf(room *Room) fyne.Position {
+	if v.layout != nil {
+		if layout, ok := v.layout.layouts[room]; ok {
+			return layout.Position
+		}
+	}
+	return room.Position
+}
+
+// shapeOf returns the outline shown for a room, honouring unsaved edits.
+func (v *floorPlanView) shapeOf(room *Room) []fyne.Position {
+	if v.layout != nil {
+		if layout, ok := v.layout.layouts[room]; ok {
+			return layout.Shape
+		}
+	}
+	return room.Shape
+}
+
+// createLayoutTools builds the snap, save and cancel controls of Edit Layout mode.
+func (v *floorPlanView) createLayoutTools() []fyne.CanvasObject {
+	snapSelect := widget.NewSelect([]string{"Off", "Fine", "Medium", "Coarse"}, func(value string) {
+		if v.layout == nil {
+			return
+		}
+		v.layout.snap = snapSteps[value]
+		v.plan.GridStep = v.layout.snap
+		v.plan.Refresh()
+	})
+	snapSelect.SetSelected("Off")
+
+	saveButton := widget.NewButton("Save Layout", v.saveLayout)
+	saveButton.Importance = widget.HighImportance
+	cancelButton := widget.NewButton("Cancel", func() {
+		v.stopLayoutEdit()
+		v.modeSelect.SetSelected(floorPlanModeView)
+	})
+	return []fyne.CanvasObject{widget.NewLabel("Snap:"), snapSelect, saveButton, cancelButton}
+}
+
+// startLayoutEdit copies the floor's layout for editing and shows the palette.
+func (v *floorPlanView) startLayoutEdit() {
+	v.layout = &layoutEdit{
+		rooms:   bookableRooms(roomsOnFloor(v.floorID)),
+		layouts: map[*Room]*roomLayout{},
+		palette: container.NewVBox(),
+	}
+	for _, room := range v.layout.rooms {
+		v.layout.layouts[room] = &roomLayout{Position: room.Position, Shape: append([]fyne.Position(nil), room.Shape...)}
+	}
+	v.plan.OnDragStart = v.layoutDragStart
+	v.plan.OnDragMoved = func(p fyne.Position) {
+		if v.layout != nil && v.layout.drag != nil {
+			v.layout.drag(p)
+			v.refresh()
+		}
+	}
+	v.plan.OnDragEnded = func() {
+		if v.layout != nil {
+			v.layout.drag = nil
+			v.rebuildPalette()
+		}
+	}
+
+	scroll := container.NewVScroll(v.layout.palette)
+	scroll.SetMinSize(fyne.NewSize(170, 0))
+	title := widget.NewLabelWithStyle("Unplaced Rooms", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	hint := widget.NewLabel("Drag a room onto the plan.\nDrag a marker off the plan\nto unplace it.")
+	v.side.Objects = []fyne.CanvasObject{container.NewBorder(container.NewVBox(title, hint), nil, nil, nil, scroll)}
+	v.side.Refresh()
+	v.rebuildPalette()
+	v.refresh()
+}
+
+// stopLayoutEdit leaves Edit Layout mode, discarding unsaved changes.
+func (v *floorPlanView) stopLayoutEdit() {
+	v.layout = nil
+	v.plan.OnDragStart, v.plan.OnDragMoved, v.plan.OnDragEnded = nil, nil, nil
+	v.plan.GridStep = 0
+	v.plan.SetHandles(nil)
+	v.side.Objects = nil
+	v.side.Refresh()
+	v.refresh()
+}
+
+// saveLayout applies every changed marker and shape as one undoable change.
+func (v *floorPlanView) saveLayout() {
+	if v.layout == nil {
+		return
+	}
+	composite := &CompositeCommand{}
+	for _, room := range v.layout.rooms {
+		layout := v.layout.layouts[room]
+		if layout.Position != room.Position {
+			composite.Commands = append(composite.Commands, &PlaceRoomCommand{room: room, oldPos: room.Position, newPos: layout.Position})
+		}
+		if !samePoints(layout.Shape, room.Shape) {
+			composite.Commands = append(composite.Commands, &SetRoomShapeCommand{room: room, oldShape: room.Shape, newShape: layout.Shape})
+		}
+	}
+	if len(composite.Commands) > 0 {
+		if err := executeCommand(composite); err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+	}
+	v.stopLayoutEdit()
+	v.modeSelect.SetSelected(floorPlanModeView)
+}
+
+// rebuildHandles shows a handle on every vertex of the floor's shapes.
+func (v *floorPlanView) rebuildHandles() {
+	points := []fyne.Position{}
+	v.layout.handles = nil
+	for _, room := range v.layout.rooms {
+		for i, p := range v.layout.layouts[room].Shape {
+			points = append(points, p)
+			v.layout.handles = append(v.layout.handles, vertexRef{room: room, vertex: i})
+		}
+	}
+	v.plan.SetHandles(points)
+}
+
+// layoutDragStart picks what a drag starting at p moves: a shape vertex, a
+// marker, or a whole shape with its marker. Drags elsewhere pan the plan.
+func (v *floorPlanView) layoutDragStart(p fyne.Position) bool {
+	edit := v.layout
+	if edit == nil {
+		return false
+	}
+
+	if i := v.plan.HandleAt(p); i >= 0 && i < len(edit.handles) {
+		ref := edit.handles[i]
+		layout := edit.layouts[ref.room]
+		if isAxisRectangle(layout.Shape) {
+			// Rectangles stay rectangles, resized from the opposite corner
+			opposite := layout.Shape[(ref.vertex+2)%4]
+			edit.drag = func(at fyne.Position) {
+				a := edit.snapPoint(at)
+				layout.Shape = []fyne.Position{a, fyne.NewPos(opposite.X, a.Y), opposite, fyne.NewPos(a.X, opposite.Y)}
+			}
+		} else {
+			vertex := ref.vertex
+			edit.drag = func(at fyne.Position) {
+				layout.Shape[vertex] = edit.snapPoint(at)
+			}
+		}
+		return true
+	}
+
+	if i := v.plan.MarkerAt(p); i >= 0 && i < len(v.markerRooms) {
+		layout := edit.layouts[v.markerRooms[i]]
+		edit.drag = func(at fyne.Position) {
+			if at.X < 0 || at.Y < 0 || at.X > 1 || at.Y > 1 {
+				layout.Position = fyne.Position{} // Dropping off the plan unplaces the room
+				return
+			}
+			layout.Position = edit.snapPoint(at)
+		}
+		return true
+	}
+
+	for i := len(edit.rooms) - 1; i >= 0; i-- {
+		room := edit.rooms[i]
+		layout := edit.layouts[room]
+		if len(layout.Shape) < 3 || !pointInPolygon(p, layout.Shape) {
+			continue
+		}
+		startShape := append([]fyne.Position(nil), layout.Shape...)
+		startPos := layout.Position
+		moveMarker := startPos != (fyne.Position{}) && pointInPolygon(startPos, startShape)
+		edit.drag = func(at fyne.Position) {
+			offset := at.Subtract(p)
+			if edit.snap > 0 {
+				offset.X = float32(math.Round(float64(offset.X/edit.snap))) * edit.snap
+				offset.Y = float32(math.Round(float64(offset.Y/edit.snap))) * edit.snap
+			}
+			// Keep the whole shape on the plan
+			minX, minY, maxX, maxY := shapeBounds(startShape)
+			offset.X = float32(math.Max(float64(-minX), math.Min(float64(1-maxX), float64(offset.X))))
+			offset.Y = float32(math.Max(float64(-minY), math.Min(float64(1-maxY), float64(offset.Y))))
+			for j, q := range startShape {
+				layout.Shape[j] = q.Add(offset)
+			}
+			if moveMarker {
+				layout.Position = startPos.Add(offset)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isAxisRectangle reports whether shape is a rectangle as drawn in Draw
+// Rectangle mode: four corners with horizontal and vertical edges.
+func isAxisRectangle(shape []fyne.Position) bool {
+	return len(shape) == 4 &&
+		shape[0].Y == shape[1].Y && shape[1].X == shape[2].X &&
+		shape[2].Y == shape[3].Y && shape[3].X == shape[0].X
+}
+
+// rebuildPalette lists the floor's rooms that have no marker yet.
+func (v *floorPlanView) rebuildPalette() {
+	edit := v.layout
+	edit.palette.Objects = nil
+	for _, room := range edit.rooms {
+		if edit.layouts[room].Position != (fyne.Position{}) {
+			continue
+		}
+		layout := edit.layouts[room]
+		item := newPaletteItem(room.Name)
+		item.OnDragged = func(absolute fyne.Position) {
+			// Convert the pointer into the canvas's plan coordinates
+			origin := fyne.CurrentApp().Driver().AbsolutePositionForObject(v.plan)
+			at := v.plan.toPlan(absolute.Subtract(origin))
+			if at.X < 0 || at.Y < 0 || at.X > 1 || at.Y > 1 {
+				layout.Position = fyne.Position{}
+			} else {
+				layout.Position = edit.snapPoint(at)
+			}
+			v.refresh()
+		}
+		item.OnDragEnd = v.rebuildPalette
+		edit.palette.Add(item)
+	}
+	if len(edit.palette.Objects) == 0 {
+		edit.palette.Add(widget.NewLabel("All rooms placed."))
+	}
+	edit.palette.Refresh()
+}
+
+// paletteItem is a room in the palette that can be dragged onto the plan
+type paletteItem struct {
+	widget.BaseWidget
+	label string
+
+	// OnDragged is called with the pointer's absolute position during a drag
+	OnDragged func(fyne.Position)
+	OnDragEnd func()
+}
+
+func newPaletteItem(label string) *paletteItem {
+	item := &paletteItem{label: label}
+	item.ExtendBaseWidget(item)
+	return item
+}
+
+func (p *paletteItem) Dragged(event *fyne.DragEvent) {
+	if p.OnDragged != nil {
+		p.OnDragged(event.AbsolutePosition)
+	}
+}
+
+func (p *paletteItem) DragEnd() {
+	if p.OnDragEnd != nil {
+		p.OnDragEnd()
+	}
+}
+
+func (p *paletteItem) CreateRenderer() fyne.WidgetRenderer {
+	background := canvas.NewRectangle(draftColor)
+	background.CornerRadius = 4
+	text := canvas.NewText(p.label, color.White)
+	return widget.NewSimpleRenderer(container.NewMax(background, container.NewPadded(text)))
+}
@@ -0,0 +1,132 @@
+// layouteditor_test.go
+
+package main
+
+import (
+	"image"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newTestLayoutView returns a floor plan view of floor-1 drawn 400 pixels
+// square, with the given rooms on the floor.
+func newTestLayoutView(t *testing.T, floorRooms ...*Room) *floorPlanView {
+	t.Helper()
+	useTestDataDir(t)
+	test.NewApp()
+	for _, room := range floorRooms {
+		room.FloorID = "floor-1"
+	}
+	rooms = floorRooms
+	v := &floorPlanView{
+		window:     test.NewWindow(nil),
+		floorID:    "floor-1",
+		mode:       floorPlanModeLayout,
+		plan:       newFloorPlanCanvas(image.NewNRGBA(image.Rect(0, 0, 100, 100))),
+		side:       container.NewMax(),
+		modeSelect: widget.NewSelect([]string{floorPlanModeView, floorPlanModeLayout}, nil),
+	}
+	v.plan.Resize(fyne.NewSize(400, 400))
+	t.Cleanup(v.window.Close)
+	return v
+}
+
+// drag moves the pointer from one plan point to another in Edit Layout mode.
+func drag(t *testing.T, v *floorPlanView, from, to fyne.Position) {
+	t.Helper()
+	if !v.plan.OnDragStart(from) {
+		t.Fatalf("nothing to drag at %v", from)
+	}
+	v.plan.OnDragMoved(to)
+	v.plan.OnDragEnded()
+}
+
+func rectangle(x0, y0, x1, y1 float32) []fyne.Position {
+	return []fyne.Position{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}
+}
+
+func TestSnapPoint(t *testing.T) {
+	edit := &layoutEdit{snap: 0.05}
+	if got := edit.snapPoint(fyne.NewPos(0.12, 0.38)); !closeTo(got, fyne.NewPos(0.1, 0.4)) {
+		t.Errorf("snapped to %v, want (0.1, 0.4)", got)
+	}
+	if got := edit.snapPoint(fyne.NewPos(-0.2, 1.3)); got != fyne.NewPos(0, 1) {
+		t.Errorf("off-plan point snapped to %v, want (0, 1)", got)
+	}
+	edit.snap = 0
+	if got := edit.snapPoint(fyne.NewPos(0.123, 0.456)); got != fyne.NewPos(0.123, 0.456) {
+		t.Errorf("with snapping off the point moved to %v", got)
+	}
+
+	if !isAxisRectangle(rectangle(0.1, 0.1, 0.3, 0.2)) {
+		t.Error("a drawn rectangle is not recognised")
+	}
+	if isAxisRectangle([]fyne.Position{{X: 0.1, Y: 0.1}, {X: 0.3, Y: 0.1}, {X: 0.35, Y: 0.2}, {X: 0.1, Y: 0.2}}) {
+		t.Error("a skewed outline was taken for a rectangle")
+	}
+}
+
+func TestLayoutEditSavesAsOneChange(t *testing.T) {
+	lab := &Room{ID: "room-1", Name: "Lab", Position: fyne.NewPos(0.2, 0.2), Shape: rectangle(0.1, 0.1, 0.3, 0.3)}
+	v := newTestLayoutView(t, lab)
+	v.startLayoutEdit()
+	v.layout.snap = 0.05
+
+	// Moving the shape from inside it takes the marker along
+	drag(t, v, fyne.NewPos(0.15, 0.27), fyne.NewPos(0.27, 0.39))
+	// Dragging a corner resizes it from the opposite corner
+	drag(t, v, fyne.NewPos(0.4, 0.4), fyne.NewPos(0.52, 0.49))
+	layout := v.layout.layouts[lab]
+	minX, minY, maxX, maxY := shapeBounds(layout.Shape)
+	if !isAxisRectangle(layout.Shape) || !closeTo(fyne.NewPos(minX, minY), fyne.NewPos(0.2, 0.2)) || !closeTo(fyne.NewPos(maxX, maxY), fyne.NewPos(0.5, 0.5)) {
+		t.Errorf("shape is %v, want the rectangle from (0.2, 0.2) to (0.5, 0.5)", layout.Shape)
+	}
+	if !closeTo(layout.Position, fyne.NewPos(0.3, 0.3)) {
+		t.Errorf("marker is at %v, want (0.3, 0.3)", layout.Position)
+	}
+	if !v.layout.changed() || lab.Position != fyne.NewPos(0.2, 0.2) {
+		t.Fatal("the edit was applied before saving")
+	}
+
+	v.saveLayout()
+	if v.layout != nil || len(undoStack) != 1 {
+		t.Fatalf("after saving, editing is %v with %d commands to undo", v.layout != nil, len(undoStack))
+	}
+	if !closeTo(lab.Position, fyne.NewPos(0.3, 0.3)) || !samePoints(lab.Shape, layout.Shape) {
+		t.Errorf("saved layout is %v with shape %v", lab.Position, lab.Shape)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if lab.Position != fyne.NewPos(0.2, 0.2) || !samePoints(lab.Shape, rectangle(0.1, 0.1, 0.3, 0.3)) {
+		t.Errorf("after undo the room is at %v with shape %v", lab.Position, lab.Shape)
+	}
+}
+
+func TestLayoutEditCancelAndUnplace(t *testing.T) {
+	lab := &Room{ID: "room-1", Name: "Lab", Position: fyne.NewPos(0.5, 0.5)}
+	store := &Room{ID: "room-2", Name: "Store"}
+	v := newTestLayoutView(t, lab, store)
+	v.startLayoutEdit()
+	if got := len(v.layout.palette.Objects); got != 1 {
+		t.Errorf("palette lists %d unplaced rooms, want 1", got)
+	}
+
+	// Dropping a marker off the plan unplaces the room
+	drag(t, v, fyne.NewPos(0.5, 0.5), fyne.NewPos(1.2, 0.5))
+	if v.layout.layouts[lab].Position != (fyne.Position{}) {
+		t.Errorf("marker dropped off the plan is at %v", v.layout.layouts[lab].Position)
+	}
+	if got := len(v.layout.palette.Objects); got != 2 {
+		t.Errorf("palette lists %d unplaced rooms, want 2", got)
+	}
+
+	v.stopLayoutEdit()
+	if lab.Position != fyne.NewPos(0.5, 0.5) || len(undoStack) != 0 {
+		t.Errorf("cancelled edit left the room at %v with %d commands to undo", lab.Position, len(undoStack))
+	}
+}
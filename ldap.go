@@ -0,0 +1,552 @@
+// ldap.go
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A minimal LDAPv3 client (RFC 4511): simple bind, search and StartTLS,
+// which is all roomy needs to authenticate against a directory.
+
+// BER tags used by the LDAP messages below
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSearchResultRef   = 0x73
+	ldapExtendedRequest   = 0x77
+	ldapExtendedResponse  = 0x78
+
+	ldapSimpleAuth = 0x80 // [0] in BindRequest
+)
+
+const (
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+	ldapStartTLSOID  = "1.3.6.1.4.1.1466.20037"
+	ldapTimeout      = 10 * time.Second
+)
+
+// ldapResultCode values roomy distinguishes
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+// berElement is a decoded BER value
+type berElement struct {
+	Tag   byte
+	Value []byte
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var digits []byte
+	for n > 0 {
+		digits = append([]byte{byte(n)}, digits...)
+		n >>= 8
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+// berEncode wraps value in a tag and length.
+func berEncode(tag byte, value []byte) []byte {
+	out := append([]byte{tag}, berLength(len(value))...)
+	return append(out, value...)
+}
+
+// berConstructed encodes children inside a constructed tag such as a sequence.
+func berConstructed(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	return berEncode(tag, value)
+}
+
+func berInt(tag byte, n int) []byte {
+	// Minimal two's complement big-endian encoding
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if (n >= -128 && n < 128) || len(value) >= 8 {
+			break
+		}
+		n >>= 8
+	}
+	return berEncode(tag, value)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berBool(b bool) []byte {
+	if b {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+	return berEncode(berBoolean, []byte{0})
+}
+
+// berRead reads one element from r.
+func berRead(r *bufio.Reader) (berElement, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return berElement{}, errors.New("ldap: unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return berElement{}, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 16<<20 {
+		return berElement{}, errors.New("ldap: message too large")
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return berElement{}, err
+	}
+	return berElement{Tag: tag, Value: value}, nil
+}
+
+// children decodes the elements inside a constructed element.
+func (e berElement) children() ([]berElement, error) {
+	r := bufio.NewReader(strings.NewReader(string(e.Value)))
+	var out []berElement
+	for {
+		child, err := berRead(r)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, child)
+	}
+}
+
+func (e berElement) int() int {
+	n := 0
+	for i, b := range e.Value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+// ldapError is a non-success result returned by the server
+type ldapError struct {
+	Code    int
+	Message string
+}
+
+func (e *ldapError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("ldap: result code %d", e.Code)
+}
+
+// ldapEntry is a search result
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string // Keyed by lowercase attribute name
+}
+
+func (e ldapEntry) first(attribute string) string {
+	if values := e.Attributes[strings.ToLower(attribute)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ldapConn is a connection to a directory server
+type ldapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL, upgrading ldap:// with
+// StartTLS when requested.
+func dialLDAP(rawURL string, startTLS, skipVerify bool) (*ldapConn, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+	host := parsed.Hostname()
+	port := parsed.Port()
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: skipVerify}
+
+	var conn net.Conn
+	switch parsed.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(host, port), ldapTimeout)
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}, "tcp", net.JoinHostPort(host, port), tlsConfig)
+	default:
+		return nil, fmt.Errorf("LDAP URL must start with ldap:// or ldaps://")
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+	c := &ldapConn{conn: conn, reader: bufio.NewReader(conn), nextID: 1}
+
+	if startTLS && parsed.Scheme == "ldap" {
+		op := berConstructed(ldapExtendedRequest, berString(0x80, ldapStartTLSOID))
+		if _, err := c.roundTrip(op, ldapExtendedResponse); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %v", err)
+		}
+		secure := tls.Client(conn, tlsConfig)
+		if err := secure.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn = secure
+		c.reader = bufio.NewReader(secure)
+	}
+	return c, nil
+}
+
+func (c *ldapConn) Close() {
+	c.send(berEncode(ldapUnbindRequest, nil))
+	c.conn.Close()
+}
+
+func (c *ldapConn) send(op []byte) (int, error) {
+	id := c.nextID
+	c.nextID++
+	_, err := c.conn.Write(berConstructed(berSequence, berInt(berInteger, id), op))
+	return id, err
+}
+
+// readMessage returns the protocol operation of the next message for id.
+func (c *ldapConn) readMessage(id int) (berElement, error) {
+	for {
+		message, err := berRead(c.reader)
+		if err != nil {
+			return berElement{}, err
+		}
+		parts, err := message.children()
+		if err != nil || len(parts) < 2 || parts[0].Tag != berInteger {
+			return berElement{}, errors.New("ldap: malformed message")
+		}
+		if parts[0].int() == id {
+			return parts[1], nil
+		}
+	}
+}
+
+// ldapResult checks the LDAPResult at the start of a response.
+func ldapResult(op berElement) error {
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 || fields[0].Tag != berEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	if code := fields[0].int(); code != ldapSuccess {
+		return &ldapError{Code: code, Message: string(fields[2].Value)}
+	}
+	return nil
+}
+
+// roundTrip sends op and waits for a single response with the given tag.
+func (c *ldapConn) roundTrip(op []byte, responseTag byte) (berElement, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return berElement{}, err
+	}
+	response, err := c.readMessage(id)
+	if err != nil {
+		return berElement{}, err
+	}
+	if response.Tag != responseTag {
+		return berElement{}, fmt.Errorf("ldap: unexpected response 0x%02x", response.Tag)
+	}
+	return response, ldapResult(response)
+}
+
+// Bind authenticates the connection with a DN and password.
+func (c *ldapConn) Bind(dn, password string) error {
+	op := berConstructed(ldapBindRequest,
+		berInt(berInteger, 3), // LDAP version
+		berString(berOctetString, dn),
+		berString(ldapSimpleAuth, password),
+	)
+	_, err := c.roundTrip(op, ldapBindResponse)
+	return err
+}
+
+// Search returns the entries under base matching filter.
+func (c *ldapConn) Search(base string, scope int, filter string, attributes []string) ([]ldapEntry, error) {
+	encodedFilter, err := encodeLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrs [][]byte
+	for _, a := range attributes {
+		attrs = append(attrs, berString(berOctetString, a))
+	}
+	op := berConstructed(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 3), // Always dereference aliases
+		berInt(berInteger, 100),  // Size limit
+		berInt(berInteger, int(ldapTimeout.Seconds())),
+		berBool(false), // Types only
+		encodedFilter,
+		berConstructed(berSequence, attrs...),
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ldapEntry{}
+	for {
+		response, err := c.readMessage(id)
+		if err != nil {
+			return nil, err
+		}
+		switch response.Tag {
+		case ldapSearchResultEntry:
+			entry, err := decodeLDAPEntry(response)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchResultRef:
+			// Referrals to other servers are not followed
+		case ldapSearchResultDone:
+			return entries, ldapResult(response)
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x", response.Tag)
+		}
+	}
+}
+
+func decodeLDAPEntry(op berElement) (ldapEntry, error) {
+	fields, err := op.children()
+	if err != nil || len(fields) < 2 {
+		return ldapEntry{}, errors.New("ldap: malformed search entry")
+	}
+	entry := ldapEntry{DN: string(fields[0].Value), Attributes: map[string][]string{}}
+	attributes, err := fields[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, attribute := range attributes {
+		parts, err := attribute.children()
+		if err != nil || len(parts) < 2 {
+			return ldapEntry{}, errors.New("ldap: malformed attribute")
+		}
+		values, err := parts[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		name := strings.ToLower(string(parts[0].Value))
+		for _, v := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], string(v.Value))
+		}
+	}
+	return entry, nil
+}
+
+// encodeLDAPFilter encodes a string filter (RFC 4515) such as
+// "(&(objectClass=person)(uid=jdoe))". Equality, presence and substring
+// matches combined with &, | and ! are supported.
+func encodeLDAPFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("invalid LDAP filter %q", filter)
+	}
+	return encoded, nil
+}
+
+func parseLDAPFilter(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("invalid LDAP filter near %q", s)
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if s[0] == '|' {
+			tag = 0xa1
+		}
+		s = s[1:]
+		var children [][]byte
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseLDAPFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' {
+			return nil, "", errors.New("invalid LDAP filter: missing ')'")
+		}
+		return berConstructed(tag, children...), s[1:], nil
+	case '!':
+		child, rest, err := parseLDAPFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("invalid LDAP filter: missing ')'")
+		}
+		return berConstructed(0xa2, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("invalid LDAP filter: missing ')'")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("invalid LDAP filter item %q", item)
+	}
+	attribute, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attribute, "<>~") {
+		return nil, "", fmt.Errorf("unsupported LDAP filter item %q", item)
+	}
+	if value == "*" {
+		return berString(0x87, attribute), rest, nil // Present
+	}
+	if !strings.Contains(value, "*") {
+		unescaped, err := unescapeLDAPFilterValue(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return berConstructed(0xa3, berString(berOctetString, attribute), berString(berOctetString, unescaped)), rest, nil
+	}
+
+	// Substrings: initial*any*final
+	parts := strings.Split(value, "*")
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		unescaped, err := unescapeLDAPFilterValue(part)
+		if err != nil {
+			return nil, "", err
+		}
+		tag := byte(0x81) // any
+		if i == 0 {
+			tag = 0x80 // initial
+		} else if i == len(parts)-1 {
+			tag = 0x82 // final
+		}
+		subs = append(subs, berString(tag, unescaped))
+	}
+	return berConstructed(0xa4, berString(berOctetString, attribute), berConstructed(berSequence, subs...)), rest, nil
+}
+
+func unescapeLDAPFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("invalid escape in LDAP filter value %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in LDAP filter value %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeLDAPFilterValue escapes a value for use inside a filter (RFC 4515).
+func escapeLDAPFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// escapeLDAPDN escapes a value for use as an attribute value in a DN (RFC 4514).
+func escapeLDAPDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString("\\00")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
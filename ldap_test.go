@@ -0,0 +1,402 @@
+// ldap_test.go
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testBaseDN     = "dc=example,dc=com"
+	testServiceDN  = "cn=roomy,dc=example,dc=com"
+	testServicePwd = "service-secret"
+)
+
+// ldapTestEntry is an entry in the stand-in directory
+type ldapTestEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string // Keyed by lowercase attribute name
+}
+
+// ldapStandIn is a small LDAP server that answers binds and searches from
+// a fixed set of entries, decoding requests with the client's BER reader.
+type ldapStandIn struct {
+	listener net.Listener
+	entries  []ldapTestEntry
+
+	mu       sync.Mutex
+	binds    []string // DNs of successful binds
+	equality []string // attribute=value of every equality match searched for
+	other    []byte   // Tags of substring and presence filters searched for
+}
+
+func startLDAPStandIn(t *testing.T, entries ...ldapTestEntry) *ldapStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStandIn{listener: listener, entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *ldapStandIn) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := berRead(reader)
+		if err != nil {
+			return
+		}
+		parts, err := message.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id, op := parts[0].int(), parts[1]
+		reply := func(ops ...[]byte) {
+			for _, op := range ops {
+				conn.Write(berConstructed(berSequence, berInt(berInteger, id), op))
+			}
+		}
+		fields, _ := op.children()
+		switch op.Tag {
+		case ldapBindRequest:
+			code := ldapInvalidCredentials
+			if s.authenticate(string(fields[1].Value), string(fields[2].Value)) {
+				code = ldapSuccess
+			}
+			reply(ldapTestResult(ldapBindResponse, code))
+		case ldapSearchRequest:
+			base, scope, filter := string(fields[0].Value), fields[1].int(), fields[6]
+			var ops [][]byte
+			for _, entry := range s.entries {
+				inScope := entry.DN == base
+				if scope == ldapScopeSubtree {
+					inScope = strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(base))
+				}
+				if inScope && s.matches(filter, entry) {
+					ops = append(ops, ldapTestSearchEntry(entry))
+				}
+			}
+			reply(append(ops, ldapTestResult(ldapSearchResultDone, ldapSuccess))...)
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapStandIn) authenticate(dn, password string) bool {
+	ok := dn == testServiceDN && password == testServicePwd
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			ok = true
+		}
+	}
+	if ok {
+		s.mu.Lock()
+		s.binds = append(s.binds, dn)
+		s.mu.Unlock()
+	}
+	return ok
+}
+
+// matches evaluates the and, or, not, equality and presence filters the
+// client sends. Substring filters never match.
+func (s *ldapStandIn) matches(filter berElement, entry ldapTestEntry) bool {
+	children, _ := filter.children()
+	switch filter.Tag {
+	case 0xa0: // and
+		for _, child := range children {
+			if !s.matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case 0xa1: // or
+		for _, child := range children {
+			if s.matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case 0xa2: // not
+		return len(children) == 1 && !s.matches(children[0], entry)
+	case 0xa3: // equality
+		attribute, value := string(children[0].Value), string(children[1].Value)
+		s.mu.Lock()
+		s.equality = append(s.equality, attribute+"="+value)
+		s.mu.Unlock()
+		for _, v := range entry.Attributes[strings.ToLower(attribute)] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case 0x87: // present
+		s.mu.Lock()
+		s.other = append(s.other, filter.Tag)
+		s.mu.Unlock()
+		return len(entry.Attributes[strings.ToLower(string(filter.Value))]) > 0
+	}
+	s.mu.Lock()
+	s.other = append(s.other, filter.Tag)
+	s.mu.Unlock()
+	return false
+}
+
+func ldapTestResult(tag byte, code int) []byte {
+	return berConstructed(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+}
+
+func ldapTestSearchEntry(entry ldapTestEntry) []byte {
+	var attributes [][]byte
+	for name, values := range entry.Attributes {
+		var encoded [][]byte
+		for _, v := range values {
+			encoded = append(encoded, berString(berOctetString, v))
+		}
+		attributes = append(attributes, berConstructed(berSequence, berString(berOctetString, name), berConstructed(berSet, encoded...)))
+	}
+	return berConstructed(ldapSearchResultEntry, berString(berOctetString, entry.DN), berConstructed(berSequence, attributes...))
+}
+
+func ldapTestPerson(uid, password string, groups ...string) ldapTestEntry {
+	return ldapTestEntry{
+		DN:       "uid=" + uid + ",ou=people," + testBaseDN,
+		Password: password,
+		Attributes: map[string][]string{
+			"objectclass": {"person"},
+			"uid":         {uid},
+			"mail":        {uid + "@example.com"},
+			"memberof":    groups,
+		},
+	}
+}
+
+func ldapTestGroup(cn string, members ...string) ldapTestEntry {
+	return ldapTestEntry{
+		DN: "cn=" + cn + ",ou=groups," + testBaseDN,
+		Attributes: map[string][]string{
+			"objectclass": {"groupOfNames"},
+			"cn":          {cn},
+			"member":      members,
+		},
+	}
+}
+
+func ldapTestConfig(s *ldapStandIn) LDAPSettings {
+	return LDAPSettings{
+		Enabled:      true,
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePwd,
+		BaseDN:       testBaseDN,
+		AdminGroups:  []string{"admins"},
+	}
+}
+
+func TestLDAPBind(t *testing.T) {
+	s := startLDAPStandIn(t, ldapTestPerson("jdoe", "right-password"))
+	conn, err := dialLDAP(s.url(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind("uid=jdoe,ou=people,"+testBaseDN, "right-password"); err != nil {
+		t.Fatalf("bind with the right password: %v", err)
+	}
+	err = conn.Bind("uid=jdoe,ou=people,"+testBaseDN, "wrong-password")
+	var ldapErr *ldapError
+	if !errors.As(err, &ldapErr) || ldapErr.Code != ldapInvalidCredentials {
+		t.Fatalf("bind with the wrong password = %v, want result code %d", err, ldapInvalidCredentials)
+	}
+}
+
+func TestLDAPLookupSearchesAndBindsAsUser(t *testing.T) {
+	s := startLDAPStandIn(t,
+		ldapTestPerson("jdoe", "right-password", "cn=staff,ou=groups,"+testBaseDN),
+		ldapTestPerson("other", "other-password"),
+	)
+	provider := ldapAuthProvider{config: ldapTestConfig(s)}
+
+	identity, err := provider.lookup("jdoe", "right-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "uid=jdoe,ou=people," + testBaseDN; identity.ID != want {
+		t.Errorf("ID = %q, want %q", identity.ID, want)
+	}
+	if identity.Email != "jdoe@example.com" {
+		t.Errorf("Email = %q, want jdoe@example.com", identity.Email)
+	}
+	if identity.Role != "User" {
+		t.Errorf("Role = %q, want User", identity.Role)
+	}
+	s.mu.Lock()
+	if want := []string{testServiceDN, identity.ID}; strings.Join(s.binds, ";") != strings.Join(want, ";") {
+		t.Errorf("binds = %q, want %q", s.binds, want)
+	}
+	s.mu.Unlock()
+
+	if _, err := provider.lookup("jdoe", "wrong-password"); err != errInvalidCredentials {
+		t.Errorf("wrong password: err = %v, want errInvalidCredentials", err)
+	}
+	if _, err := provider.lookup("nobody", "any-password"); err != errUnknownUser {
+		t.Errorf("unknown user: err = %v, want errUnknownUser", err)
+	}
+	if _, err := provider.lookup("jdoe", ""); err != errInvalidCredentials {
+		t.Errorf("empty password: err = %v, want errInvalidCredentials", err)
+	}
+}
+
+func TestLDAPLookupDNTemplate(t *testing.T) {
+	s := startLDAPStandIn(t, ldapTestPerson(`smith\, j`, "right-password"))
+	config := ldapTestConfig(s)
+	config.BindDN, config.BindPassword = "", ""
+	config.UserDNTemplate = "uid={username},ou=people," + testBaseDN
+	provider := ldapAuthProvider{config: config}
+
+	identity, err := provider.lookup("smith, j", "right-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `uid=smith\, j,ou=people,` + testBaseDN; identity.ID != want {
+		t.Errorf("ID = %q, want %q", identity.ID, want)
+	}
+	if _, err := provider.lookup("smith, j", "wrong-password"); err != errInvalidCredentials {
+		t.Errorf("wrong password: err = %v, want errInvalidCredentials", err)
+	}
+}
+
+func TestLDAPGroupRoles(t *testing.T) {
+	adminDN := "uid=alice,ou=people," + testBaseDN
+	staffDN := "uid=bob,ou=people," + testBaseDN
+	s := startLDAPStandIn(t,
+		ldapTestPerson("alice", "alice-password", "cn=Admins,ou=groups,"+testBaseDN),
+		ldapTestPerson("bob", "bob-password", "cn=staff,ou=groups,"+testBaseDN),
+		ldapTestPerson("carol", "carol-password"),
+		ldapTestPerson("dave", "dave-password"),
+		ldapTestGroup("admins", adminDN),
+		ldapTestGroup("staff", staffDN),
+		ldapTestGroup("maintainers", "uid=dave,ou=people,"+testBaseDN),
+	)
+
+	tests := []struct {
+		name        string
+		groupFilter string
+		adminGroups []string
+		userGroups  []string
+		username    string
+		wantRole    string // Empty if sign-in should be refused
+	}{
+		{name: "memberOf admin by name", adminGroups: []string{"admins"}, username: "alice", wantRole: "Admin"},
+		{name: "memberOf admin by DN", adminGroups: []string{"cn=admins,ou=groups," + testBaseDN}, username: "alice", wantRole: "Admin"},
+		{name: "memberOf user group", adminGroups: []string{"admins"}, userGroups: []string{"staff"}, username: "bob", wantRole: "User"},
+		{name: "no user groups configured", adminGroups: []string{"admins"}, username: "carol", wantRole: "User"},
+		{name: "not in a user group", adminGroups: []string{"admins"}, userGroups: []string{"staff"}, username: "carol"},
+		{name: "group search", groupFilter: "(&(objectClass=groupOfNames)(member={dn}))", adminGroups: []string{"maintainers"}, userGroups: []string{"staff"}, username: "dave", wantRole: "Admin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := ldapTestConfig(s)
+			config.GroupFilter = test.groupFilter
+			config.AdminGroups, config.UserGroups = test.adminGroups, test.userGroups
+			identity, err := ldapAuthProvider{config: config}.lookup(test.username, test.username+"-password")
+			if test.wantRole == "" {
+				if err == nil {
+					t.Fatalf("signed in as %s, want refused", identity.Role)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Role != test.wantRole {
+				t.Errorf("Role = %q, want %q", identity.Role, test.wantRole)
+			}
+		})
+	}
+}
+
+func TestLDAPLookupEscapesFilterValues(t *testing.T) {
+	s := startLDAPStandIn(t,
+		ldapTestPerson("jdoe", "right-password"),
+		ldapTestPerson("admin", "admin-password", "cn=admins,ou=groups,"+testBaseDN),
+	)
+	provider := ldapAuthProvider{config: ldapTestConfig(s)}
+
+	for _, username := range []string{"*", "j*", "jdoe)(uid=*", `admin\`, "admin)(|(uid=*", "x\x00y"} {
+		s.mu.Lock()
+		s.equality, s.other = nil, nil
+		s.mu.Unlock()
+
+		if _, err := provider.lookup(username, "right-password"); err != errUnknownUser {
+			t.Errorf("%q: err = %v, want errUnknownUser", username, err)
+		}
+		s.mu.Lock()
+		if want := "uid=" + username; !containsString(s.equality, want) {
+			t.Errorf("%q: equality matches searched = %q, want one for %q", username, s.equality, want)
+		}
+		if len(s.other) > 0 {
+			t.Errorf("%q: wildcard filters were searched: % x", username, s.other)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestEncodeLDAPFilterRoundTrip(t *testing.T) {
+	for _, value := range []string{"plain", "a*b", "(x)", `back\slash`, "nul\x00"} {
+		encoded, err := encodeLDAPFilter("(cn=" + escapeLDAPFilterValue(value) + ")")
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		want := berConstructed(0xa3, berString(berOctetString, "cn"), berString(berOctetString, value))
+		if string(encoded) != string(want) {
+			t.Errorf("%q: encoded % x, want % x", value, encoded, want)
+		}
+	}
+}
+
+func TestLDAPAccountNeedsValidUsername(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "alice", Role: "Admin"}}
+	identity := directoryIdentity{ID: "uid=x,dc=example,dc=com", Role: "User"}
+
+	for _, name := range []string{"john smith", "jo", "ALICE"} {
+		if _, err := provisionDirectoryUser(name, authSourceLDAP, identity); err == nil {
+			t.Errorf("created a directory account named %q", name)
+		}
+	}
+	if len(users) != 1 {
+		t.Fatalf("%d accounts after refused sign-ins, want 1", len(users))
+	}
+
+	user, err := provisionDirectoryUser(" jdoe ", authSourceLDAP, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "jdoe" || user.Source != authSourceLDAP {
+		t.Errorf("created %q from %s, want jdoe from LDAP", user.Username, user.Source)
+	}
+}
@@ -0,0 +1,537 @@
+// locations.go
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Site is a campus or address containing one or more buildings
+type Site struct {
+	Name      string
+	Buildings []Building
+}
+
+// Building is a building on a site
+type Building struct {
+	Name   string
+	Floors []Floor
+}
+
+// Floor is one level of a building with its own floor plan
+type Floor struct {
+	ID       string // Stable identifier referenced by rooms
+	Name     string
+	PlanPath string // Uploaded floor plan image, empty until one is uploaded
+}
+
+var sites = []Site{}
+
+// floorPlanDir holds the uploaded floor plan images, one or more per floor
+const floorPlanDir = "floorplans"
+
+// selectedFloorID is the floor last shown in the floor plan view
+var selectedFloorID string
+
+// newLocationID returns a random identifier for a new floor.
+func newLocationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Load and save locations
+func loadLocations() {
+	err := loadJSONFile("locations.json", &sites)
+	if os.IsNotExist(err) {
+		log.Println("locations.json file not found, creating a default location.")
+		createDefaultLocation()
+	} else if err != nil {
+		log.Printf("Error loading locations: %v\n", err)
+	}
+}
+
+func saveLocations() {
+	if err := saveJSONFile("locations.json", &sites); err != nil {
+		log.Printf("Error saving locations: %v\n", err)
+	}
+}
+
+// createDefaultLocation sets up a single site, building and floor for
+// installations from before locations existed. The old floor plan becomes
+// that floor's plan and every room is placed on it, so nothing moves.
+func createDefaultLocation() {
+	floor := Floor{ID: newLocationID(), Name: "Ground Floor"}
+	if _, err := os.Stat(legacyFloorPlanPath); err == nil {
+		floor.PlanPath = legacyFloorPlanPath
+	}
+	sites = []Site{{
+		Name:      "Main Site",
+		Buildings: []Building{{Name: "Main Building", Floors: []Floor{floor}}},
+	}}
+	saveLocations()
+
+	for _, room := range rooms {
+		if room.FloorID == "" {
+			room.FloorID = floor.ID
+		}
+	}
+	saveReservations()
+}
+
+// cloneSites returns a deep copy of the location hierarchy.
+func cloneSites(source []Site) []Site {
+	copied := make([]Site, len(source))
+	for i, site := range source {
+		copied[i] = Site{Name: site.Name, Buildings: make([]Building, len(site.Buildings))}
+		for j, building := range site.Buildings {
+			copied[i].Buildings[j] = Building{Name: building.Name, Floors: append([]Floor{}, building.Floors...)}
+		}
+	}
+	return copied
+}
+
+// floorRef is a floor together with the names of its building and site
+type floorRef struct {
+	Floor    *Floor
+	Site     string
+	Building string
+}
+
+// Label names the floor in full, e.g. "Main Site / Library / Floor 2".
+func (f floorRef) Label() string {
+	return fmt.Sprintf("%s / %s / %s", f.Site, f.Building, f.Floor.Name)
+}
+
+// allFloors lists every floor in hierarchy order.
+func allFloors() []floorRef {
+	floors := []floorRef{}
+	for i := range sites {
+		for j := range sites[i].Buildings {
+			building := &sites[i].Buildings[j]
+			for k := range building.Floors {
+				floors = append(floors, floorRef{Floor: &building.Floors[k], Site: sites[i].Name, Building: building.Name})
+			}
+		}
+	}
+	return floors
+}
+
+// findFloor returns the floor with the given ID, if it exists.
+func findFloor(id string) (floorRef, bool) {
+	for _, floor := range allFloors() {
+		if floor.Floor.ID == id {
+			return floor, true
+		}
+	}
+	return floorRef{}, false
+}
+
+// floorLabel names a room's floor, or says it has none.
+func floorLabel(id string) string {
+	if floor, ok := findFloor(id); ok {
+		return floor.Label()
+	}
+	return "Unassigned"
+}
+
+// roomsOnFloor returns the rooms assigned to a floor, in room order.
+func roomsOnFloor(id string) []*Room {
+	onFloor := []*Room{}
+	for _, room := range rooms {
+		if room.FloorID == id {
+			onFloor = append(onFloor, room)
+		}
+	}
+	return onFloor
+}
+
+// locationFilter is an entry of the grid's location filter
+type locationFilter struct {
+	Label   string
+	Matches func(*Room) bool
+}
+
+const allLocationsLabel = "All Locations"
+
+// locationFilters lists "All Locations", then each building followed by its floors.
+func locationFilters() []locationFilter {
+	filters := []locationFilter{{Label: allLocationsLabel, Matches: func(*Room) bool { return true }}}
+	for _, site := range sites {
+		for _, building := range site.Buildings {
+			floorIDs := map[string]bool{}
+			for _, floor := range building.Floors {
+				floorIDs[floor.ID] = true
+			}
+			filters = append(filters, locationFilter{
+				Label:   fmt.Sprintf("%s / %s", site.Name, building.Name),
+				Matches: func(room *Room) bool { return floorIDs[room.FloorID] },
+			})
+			for _, floor := range building.Floors {
+				id := floor.ID
+				filters = append(filters, locationFilter{
+					Label:   fmt.Sprintf("%s / %s / %s", site.Name, building.Name, floor.Name),
+					Matches: func(room *Room) bool { return room.FloorID == id },
+				})
+			}
+		}
+	}
+	return filters
+}
+
+// filterRooms returns the rooms matching the filter with the given label;
+// unknown labels match every room.
+func filterRooms(label string) []*Room {
+	for _, filter := range locationFilters() {
+		if filter.Label != label {
+			continue
+		}
+		matched := []*Room{}
+		for _, room := range rooms {
+			if filter.Matches(room) {
+				matched = append(matched, room)
+			}
+		}
+		return matched
+	}
+	return rooms
+}
+
+// floorHasRooms reports whether any room is assigned to one of the floors.
+func floorHasRooms(floors []Floor) bool {
+	for _, floor := range floors {
+		if len(roomsOnFloor(floor.ID)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// editLocations applies change to a copy of the hierarchy and commits the
+// result as one undoable command.
+func editLocations(change func([]Site) ([]Site, error)) error {
+	after, err := change(cloneSites(sites))
+	if err != nil {
+		return err
+	}
+	return executeCommand(&SetLocationsCommand{before: cloneSites(sites), after: after})
+}
+
+// validLocationName trims a typed site, building or floor name.
+func validLocationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name cannot be empty")
+	}
+	if strings.Contains(name, "/") {
+		return "", errors.New("name cannot contain '/'")
+	}
+	return name, nil
+}
+
+// askLocationName shows a single-field form and calls onName with the valid name.
+func askLocationName(title, current string, onName func(string) error, w fyne.Window) {
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(current)
+	form := dialog.NewForm(title, "Save", "Cancel", []*widget.FormItem{
+		{Text: "Name", Widget: nameEntry},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		name, err := validLocationName(nameEntry.Text)
+		if err == nil {
+			err = onName(name)
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+	form.Resize(fyne.NewSize(400, 200))
+	form.Show()
+}
+
+// manageLocations lets admins edit the site, building and floor hierarchy and
+// assign rooms to floors.
+func manageLocations(w fyne.Window) {
+	hierarchy := container.NewVBox()
+	assignments := container.NewVBox()
+
+	var rebuild func()
+	edit := func(change func([]Site) ([]Site, error)) error {
+		err := editLocations(change)
+		rebuild()
+		return err
+	}
+	row := func(indent int, label string, buttons ...fyne.CanvasObject) fyne.CanvasObject {
+		text := widget.NewLabel(strings.Repeat("    ", indent) + label)
+		return container.NewBorder(nil, nil, nil, container.NewHBox(buttons...), text)
+	}
+	removeButton := func(what string, inUse bool, remove func([]Site) []Site) fyne.CanvasObject {
+		return widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			if inUse {
+				dialog.ShowError(fmt.Errorf("move the rooms off this %s before removing it", what), w)
+				return
+			}
+			if err := edit(func(s []Site) ([]Site, error) { return remove(s), nil }); err != nil {
+				dialog.ShowError(err, w)
+			}
+		})
+	}
+
+	rebuild = func() {
+		hierarchy.Objects = nil
+		for i, site := range sites {
+			si := i
+			hierarchy.Add(row(0, site.Name,
+				widget.NewButtonWithIcon("Building", theme.ContentAddIcon(), func() {
+					askLocationName("Add Building", "", func(name string) error {
+						return edit(func(s []Site) ([]Site, error) {
+							s[si].Buildings = append(s[si].Buildings, Building{Name: name})
+							return s, nil
+						})
+					}, w)
+				}),
+				widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+					askLocationName("Rename Site", sites[si].Name, func(name string) error {
+						return edit(func(s []Site) ([]Site, error) {
+							s[si].Name = name
+							return s, nil
+						})
+					}, w)
+				}),
+				removeButton("site", siteHasRooms(site), func(s []Site) []Site {
+					return append(s[:si], s[si+1:]...)
+				}),
+			))
+			for j, building := range site.Buildings {
+				bi := j
+				hierarchy.Add(row(1, building.Name,
+					widget.NewButtonWithIcon("Floor", theme.ContentAddIcon(), func() {
+						askLocationName("Add Floor", "", func(name string) error {
+							return edit(func(s []Site) ([]Site, error) {
+								floors := &s[si].Buildings[bi].Floors
+								*floors = append(*floors, Floor{ID: newLocationID(), Name: name})
+								return s, nil
+							})
+						}, w)
+					}),
+					widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+						askLocationName("Rename Building", sites[si].Buildings[bi].Name, func(name string) error {
+							return edit(func(s []Site) ([]Site, error) {
+								s[si].Buildings[bi].Name = name
+								return s, nil
+							})
+						}, w)
+					}),
+					removeButton("building", floorHasRooms(building.Floors), func(s []Site) []Site {
+						s[si].Buildings = append(s[si].Buildings[:bi], s[si].Buildings[bi+1:]...)
+						return s
+					}),
+				))
+				for k, floor := range building.Floors {
+					fi := k
+					plan := "no plan"
+					if floor.PlanPath != "" {
+						plan = "plan uploaded"
+					}
+					label := fmt.Sprintf("%s (%d rooms, %s)", floor.Name, len(roomsOnFloor(floor.ID)), plan)
+					hierarchy.Add(row(2, label,
+						widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+							askLocationName("Rename Floor", sites[si].Buildings[bi].Floors[fi].Name, func(name string) error {
+								return edit(func(s []Site) ([]Site, error) {
+									s[si].Buildings[bi].Floors[fi].Name = name
+									return s, nil
+								})
+							}, w)
+						}),
+						removeButton("floor", floorHasRooms([]Floor{floor}), func(s []Site) []Site {
+							floors := s[si].Buildings[bi].Floors
+							s[si].Buildings[bi].Floors = append(floors[:fi], floors[fi+1:]...)
+							return s
+						}),
+					))
+				}
+			}
+		}
+		hierarchy.Refresh()
+
+		// One floor picker per room
+		labels := []string{"Unassigned"}
+		ids := map[string]string{"Unassigned": ""}
+		for _, floor := range allFloors() {
+			labels = append(labels, floor.Label())
+			ids[floor.Label()] = floor.Floor.ID
+		}
+		assignments.Objects = nil
+		for _, room := range rooms {
+			roomCopy := room
+			floorSelect := widget.NewSelect(labels, nil)
+			floorSelect.SetSelected(floorLabel(roomCopy.FloorID))
+			floorSelect.OnChanged = func(selected string) {
+				if ids[selected] == roomCopy.FloorID {
+					return
+				}
+				err := executeCommand(&AssignRoomFloorCommand{room: roomCopy, oldFloorID: roomCopy.FloorID, newFloorID: ids[selected],
+					oldPos: roomCopy.Position, oldShape: roomCopy.Shape})
+				if err != nil {
+					dialog.ShowError(err, w)
+				}
+				rebuild()
+			}
+			assignments.Add(container.NewBorder(nil, nil, widget.NewLabel(roomCopy.Name), nil, floorSelect))
+		}
+		assignments.Refresh()
+	}
+	rebuild()
+
+	addSiteButton := widget.NewButtonWithIcon("Add Site", theme.ContentAddIcon(), func() {
+		askLocationName("Add Site", "", func(name string) error {
+			return edit(func(s []Site) ([]Site, error) {
+				return append(s, Site{Name: name}), nil
+			})
+		}, w)
+	})
+
+	hierarchyScroll := container.NewVScroll(hierarchy)
+	hierarchyScroll.SetMinSize(fyne.NewSize(520, 320))
+	assignmentScroll := container.NewVScroll(assignments)
+	assignmentScroll.SetMinSize(fyne.NewSize(520, 320))
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Sites and Floors", container.NewBorder(nil, addSiteButton, nil, nil, hierarchyScroll)),
+		container.NewTabItem("Room Floors", assignmentScroll),
+	)
+	dialog.ShowCustom("Manage Locations", "Close", tabs, w)
+}
+
+// siteHasRooms reports whether any room is on a floor of the site.
+func siteHasRooms(site Site) bool {
+	for _, building := range site.Buildings {
+		if floorHasRooms(building.Floors) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadFloorPlan asks which floor the plan is for, then stores the chosen
+// image under a new name so the upload can be undone.
+// storeFloorPlan checks an uploaded floor plan image, copies it into
+// floorPlanDir and makes it the plan for the floor. SVG drawings are
+// returned parsed so their shapes can be bound to rooms.
+func storeFloorPlan(floorID, name string, data []byte) (*svgPlan, error) {
+	extension := strings.ToLower(filepath.Ext(name))
+	var drawing *svgPlan
+	var err error
+	if extension == ".svg" {
+		if drawing, err = parseSVGPlan(data); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := decodePlanImage(name, data); err != nil {
+		return nil, fmt.Errorf("could not read the floor plan: %v", err)
+	}
+	if err := os.MkdirAll(floorPlanDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(floorPlanDir, fmt.Sprintf("%s-%d%s", floorID, time.Now().Unix(), extension))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	err = editLocations(func(s []Site) ([]Site, error) {
+		for i := range s {
+			for j := range s[i].Buildings {
+				for k := range s[i].Buildings[j].Floors {
+					if s[i].Buildings[j].Floors[k].ID == floorID {
+						s[i].Buildings[j].Floors[k].PlanPath = path
+					}
+				}
+			}
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drawing, nil
+}
+
+func uploadFloorPlan(w fyne.Window) {
+	floors := allFloors()
+	if len(floors) == 0 {
+		dialog.ShowError(errors.New("add a floor under Manage Locations first"), w)
+		return
+	}
+	labels := []string{}
+	for _, floor := range floors {
+		labels = append(labels, floor.Label())
+	}
+	floorSelect := widget.NewSelect(labels, nil)
+	if floor, ok := findFloor(selectedFloorID); ok {
+		floorSelect.SetSelected(floor.Label())
+	} else {
+		floorSelect.SetSelected(labels[0])
+	}
+
+	form := dialog.NewForm("Upload Floor Plan", "Choose Image", "Cancel", []*widget.FormItem{
+		{Text: "Floor", Widget: floorSelect},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		var floorID string
+		for _, floor := range floors {
+			if floor.Label() == floorSelect.Selected {
+				floorID = floor.Floor.ID
+			}
+		}
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			// Save the uploaded image
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			drawing, err := storeFloorPlan(floorID, reader.URI().Name(), data)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			selectedFloorID = floorID
+			if drawing != nil && len(drawing.Shapes) > 0 {
+				// Offer to bind the rooms outlined in the drawing straight away
+				floor, _ := findFloor(floorID)
+				showSVGBinding(floor, drawing, nil, w)
+				return
+			}
+			dialog.ShowInformation("Success", "Floor plan uploaded successfully.", w)
+		}, w)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png", ".jpg", ".jpeg", ".svg"}))
+		fileDialog.Show()
+	}, w)
+	form.Resize(fyne.NewSize(400, 200))
+	form.Show()
+}
@@ -0,0 +1,135 @@
+// locations_test.go
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+// useTestLocations sets up one site with a library of two floors and a
+// hall of one, with a room on each floor of the library.
+func useTestLocations(t *testing.T) (ground, first, hall *Room) {
+	t.Helper()
+	useTestDataDir(t)
+	sites = []Site{{Name: "Campus", Buildings: []Building{
+		{Name: "Library", Floors: []Floor{{ID: "lib-0", Name: "Ground"}, {ID: "lib-1", Name: "First"}}},
+		{Name: "Hall", Floors: []Floor{{ID: "hall-0", Name: "Ground"}}},
+	}}}
+	ground = &Room{ID: "room-1", Name: "Reading Room", FloorID: "lib-0"}
+	first = &Room{ID: "room-2", Name: "Study 1", FloorID: "lib-1"}
+	hall = &Room{ID: "room-3", Name: "Main Hall", FloorID: "hall-0"}
+	rooms = []*Room{ground, first, hall}
+	return ground, first, hall
+}
+
+func TestLocationFilters(t *testing.T) {
+	ground, first, hall := useTestLocations(t)
+
+	labels := []string{}
+	for _, filter := range locationFilters() {
+		labels = append(labels, filter.Label)
+	}
+	want := []string{allLocationsLabel, "Campus / Library", "Campus / Library / Ground", "Campus / Library / First", "Campus / Hall", "Campus / Hall / Ground"}
+	if len(labels) != len(want) {
+		t.Fatalf("filters %v, want %v", labels, want)
+	}
+	for i := range want {
+		if labels[i] != want[i] {
+			t.Errorf("filter %d is %q, want %q", i, labels[i], want[i])
+		}
+	}
+
+	tests := map[string][]*Room{
+		"Campus / Library":         {ground, first},
+		"Campus / Library / First": {first},
+		"Campus / Hall":            {hall},
+		allLocationsLabel:          {ground, first, hall},
+		"Somewhere / Else":         {ground, first, hall},
+	}
+	for label, want := range tests {
+		got := filterRooms(label)
+		if len(got) != len(want) {
+			t.Errorf("%s matches %d rooms, want %d", label, len(got), len(want))
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s matches %s, want %s", label, got[i].Name, want[i].Name)
+			}
+		}
+	}
+
+	if got := floorLabel("lib-1"); got != "Campus / Library / First" {
+		t.Errorf("floorLabel = %q", got)
+	}
+	if got := floorLabel("gone"); got != "Unassigned" {
+		t.Errorf("floorLabel of a missing floor = %q", got)
+	}
+}
+
+func TestEditLocationsUndo(t *testing.T) {
+	useTestLocations(t)
+	err := editLocations(func(s []Site) ([]Site, error) {
+		s[0].Buildings[0].Name = "New Library"
+		s[0].Buildings[1].Floors = append(s[0].Buildings[1].Floors, Floor{ID: "hall-1", Name: "Gallery"})
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allFloors()) != 4 || sites[0].Buildings[0].Name != "New Library" {
+		t.Fatalf("after the edit the sites are %+v", sites)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(allFloors()) != 3 || sites[0].Buildings[0].Name != "Library" {
+		t.Errorf("after undo the sites are %+v", sites)
+	}
+
+	for name, valid := range map[string]bool{" Annex ": true, "": false, "  ": false, "A/B": false} {
+		if _, err := validLocationName(name); (err == nil) != valid {
+			t.Errorf("validLocationName(%q) error = %v", name, err)
+		}
+	}
+}
+
+func TestAssignRoomFloorUndo(t *testing.T) {
+	ground, _, _ := useTestLocations(t)
+	ground.Position = fyne.NewPos(0.5, 0.5)
+	ground.Shape = []fyne.Position{{X: 0.4, Y: 0.4}, {X: 0.6, Y: 0.4}, {X: 0.6, Y: 0.6}}
+
+	err := executeCommand(&AssignRoomFloorCommand{room: ground, oldFloorID: ground.FloorID, newFloorID: "lib-1", oldPos: ground.Position, oldShape: ground.Shape})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roomsOnFloor("lib-1")) != 2 || ground.Shape != nil || ground.Position != (fyne.Position{}) {
+		t.Errorf("moved room is on %s at %v with shape %v", ground.FloorID, ground.Position, ground.Shape)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if ground.FloorID != "lib-0" || ground.Position != fyne.NewPos(0.5, 0.5) || len(ground.Shape) != 3 {
+		t.Errorf("after undo the room is on %s at %v with shape %v", ground.FloorID, ground.Position, ground.Shape)
+	}
+}
+
+func TestCreateDefaultLocation(t *testing.T) {
+	useTestDataDir(t)
+	rooms = []*Room{{ID: "room-1", Name: "Lab A"}, {ID: "room-2", Name: "Lab B", FloorID: "kept"}}
+	if err := os.WriteFile(legacyFloorPlanPath, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	createDefaultLocation()
+	floors := allFloors()
+	if len(floors) != 1 || floors[0].Floor.PlanPath != legacyFloorPlanPath {
+		t.Fatalf("default location is %+v", sites)
+	}
+	if rooms[0].FloorID != floors[0].Floor.ID || rooms[1].FloorID != "kept" {
+		t.Errorf("rooms are on floors %q and %q", rooms[0].FloorID, rooms[1].FloorID)
+	}
+}
@@ -0,0 +1,336 @@
+// loginguard.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	defaultMaxLoginFailures = 10
+	defaultLockoutMinutes   = 15
+	freeLoginFailures       = 3  // Failures allowed for an account before backoff starts
+	freeSourceFailures      = 20 // Failures allowed from a source, which may be a shared machine
+	maxLoginBackoff         = 5 * time.Minute
+	maxLoginAttemptRecords  = 1000
+	loginThrottleExpiry     = time.Hour // Idle time after which failures are forgotten
+)
+
+// Outcomes recorded for a refused sign-in
+const (
+	loginFailed    = "Failed"
+	loginThrottled = "Throttled"
+	loginLocked    = "Locked"
+)
+
+// LoginSettings controls lockout after repeated failed sign-ins and whether
+// admins must use two-factor authentication
+type LoginSettings struct {
+	MaxFailures           int // Failures before an account is locked; 0 uses the default
+	LockoutMinutes        int
+	RequireAdminTwoFactor bool
+}
+
+func (c LoginSettings) maxFailures() int {
+	if c.MaxFailures <= 0 {
+		return defaultMaxLoginFailures
+	}
+	return c.MaxFailures
+}
+
+func (c LoginSettings) lockout() time.Duration {
+	if c.LockoutMinutes <= 0 {
+		return defaultLockoutMinutes * time.Minute
+	}
+	return time.Duration(c.LockoutMinutes) * time.Minute
+}
+
+// LoginAttempt records a refused sign-in for admins to review
+type LoginAttempt struct {
+	Time     time.Time
+	Username string
+	Source   string
+	Result   string
+}
+
+// loginThrottle counts consecutive failures for one account or source.
+// Sources back off only after many more failures than accounts and are
+// never locked, so a few people mistyping on a shared machine do not lock
+// each other out, but one source trying many usernames is slowed down.
+type loginThrottle struct {
+	failures    int
+	lastFailure time.Time
+	nextAllowed time.Time
+	lockedUntil time.Time
+}
+
+var loginAttempts []LoginAttempt
+var accountThrottles = map[string]*loginThrottle{}
+var sourceThrottles = map[string]*loginThrottle{}
+var loginMu sync.Mutex
+
+// errTooManyAttempts is shown while an account or source is backing off or
+// locked. It is the same for unknown usernames so lockout reveals nothing.
+var errTooManyAttempts = errors.New("too many failed sign-in attempts; please try again later")
+
+// Load and save failed sign-in records
+func loadLoginAttempts() {
+	err := loadJSONFile("login_attempts.json", &loginAttempts)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error loading login attempts: %v\n", err)
+	}
+}
+
+func saveLoginAttempts() {
+	loginMu.Lock()
+	records := append([]LoginAttempt(nil), loginAttempts...)
+	loginMu.Unlock()
+
+	if err := saveJSONFile("login_attempts.json", records); err != nil {
+		log.Printf("Error saving login attempts: %v\n", err)
+	}
+}
+
+// localLoginSource identifies sign-ins made in this app window in the
+// failed sign-in records.
+func localLoginSource() string {
+	return "desktop:" + hostName()
+}
+
+func throttleFor(throttles map[string]*loginThrottle, key string) *loginThrottle {
+	t := throttles[key]
+	if t == nil {
+		t = &loginThrottle{}
+		throttles[key] = t
+	}
+	return t
+}
+
+// pruneLoginThrottles forgets accounts and sources that are not locked and
+// have had no failures for loginThrottleExpiry, so usernames that were only
+// tried once do not pile up. Callers must hold loginMu.
+func pruneLoginThrottles(now time.Time) {
+	for _, throttles := range []map[string]*loginThrottle{accountThrottles, sourceThrottles} {
+		for key, t := range throttles {
+			if !t.lockedUntil.After(now) && now.Sub(t.lastFailure) >= loginThrottleExpiry {
+				delete(throttles, key)
+			}
+		}
+	}
+}
+
+// loginBackoff is the wait after the given number of consecutive failures:
+// nothing for the first free ones, then doubling from one second.
+func loginBackoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	shift := failures - free
+	if shift > 16 {
+		return maxLoginBackoff
+	}
+	wait := time.Second << uint(shift)
+	if wait > maxLoginBackoff {
+		return maxLoginBackoff
+	}
+	return wait
+}
+
+// checkLoginAllowed refuses a sign-in attempt while the account is locked
+// or the account or source is backing off.
+func checkLoginAllowed(username, source string) error {
+	now := time.Now()
+	loginMu.Lock()
+	var locked, throttled bool
+	if account := accountThrottles[usernameKey(username)]; account != nil {
+		locked = account.lockedUntil.After(now)
+		throttled = account.nextAllowed.After(now)
+	}
+	if from := sourceThrottles[source]; from != nil && from.nextAllowed.After(now) {
+		throttled = true
+	}
+	if user := findUser(username); user != nil && user.LockedUntil.After(now) {
+		locked = true
+	}
+	loginMu.Unlock()
+
+	if locked || throttled {
+		result := loginThrottled
+		if locked {
+			result = loginLocked
+		}
+		recordLoginAttempt(username, source, result)
+		return errTooManyAttempts
+	}
+	return nil
+}
+
+// attemptLogin authenticates with rate limiting per account and source,
+// and locks an account after too many consecutive failures.
+func attemptLogin(username, password, source string) (*User, error) {
+	if err := checkLoginAllowed(username, source); err != nil {
+		return nil, err
+	}
+
+	user, err := authenticateUser(username, password)
+	if err == errInvalidCredentials {
+		recordLoginFailure(username, source)
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		// Directory outages and the like are not the user's fault
+		return nil, err
+	}
+	resetLoginThrottles(username)
+	return user, nil
+}
+
+func resetLoginThrottles(username string) {
+	loginMu.Lock()
+	delete(accountThrottles, usernameKey(username))
+	loginMu.Unlock()
+}
+
+func recordLoginFailure(username, source string) {
+	key := usernameKey(username)
+	now := time.Now()
+	loginMu.Lock()
+	pruneLoginThrottles(now)
+	from := throttleFor(sourceThrottles, source)
+	from.failures++
+	from.lastFailure = now
+	from.nextAllowed = now.Add(loginBackoff(from.failures, freeSourceFailures))
+	account := throttleFor(accountThrottles, key)
+	account.failures++
+	account.lastFailure = now
+	account.nextAllowed = now.Add(loginBackoff(account.failures, freeLoginFailures))
+	failures := account.failures
+	lock := failures >= settings.Login.maxFailures()
+	if lock {
+		account.lockedUntil = now.Add(settings.Login.lockout())
+	}
+	lockedUntil := account.lockedUntil
+	loginMu.Unlock()
+
+	if lock {
+		if user := findUser(username); user != nil {
+			user.LockedUntil = lockedUntil
+			saveUsers()
+		}
+		log.Printf("Locked sign-in for %q after %d failed attempts\n", username, failures)
+	}
+	recordLoginAttempt(username, source, loginFailed)
+}
+
+func recordLoginAttempt(username, source, result string) {
+	loginMu.Lock()
+	loginAttempts = append(loginAttempts, LoginAttempt{
+		Time:     time.Now(),
+		Username: username,
+		Source:   source,
+		Result:   result,
+	})
+	if len(loginAttempts) > maxLoginAttemptRecords {
+		loginAttempts = loginAttempts[len(loginAttempts)-maxLoginAttemptRecords:]
+	}
+	loginMu.Unlock()
+	saveLoginAttempts()
+}
+
+// isLockedOut reports whether an account is locked after failed sign-ins.
+func isLockedOut(user User) bool {
+	return user.LockedUntil.After(time.Now())
+}
+
+// unlockUser clears a lockout so the user can sign in again at once.
+func unlockUser(username string) error {
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	loginMu.Lock()
+	delete(accountThrottles, usernameKey(username))
+	loginMu.Unlock()
+	user.LockedUntil = time.Time{}
+	saveUsers()
+	return nil
+}
+
+// showLoginAttempts lists refused sign-ins, newest first.
+func showLoginAttempts(w fyne.Window) {
+	loginMu.Lock()
+	records := append([]LoginAttempt(nil), loginAttempts...)
+	loginMu.Unlock()
+
+	list := container.NewVBox()
+	if len(records) == 0 {
+		list.Add(widget.NewLabel("No failed sign-ins recorded."))
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		a := records[i]
+		list.Add(widget.NewLabel(fmt.Sprintf("%s  %s  %q from %s",
+			a.Time.In(siteLocation()).Format("Jan 2 "+timeLayout12Hour), a.Result, a.Username, a.Source)))
+	}
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(550, 400))
+	dialog.ShowCustom("Failed Sign-Ins", "Close", scroll, w)
+}
+
+// loginSettingsForm returns the sign-in tab of the authentication settings
+// and a function reading the entered values.
+func loginSettingsForm() (fyne.CanvasObject, func() (LoginSettings, error)) {
+	maxFailuresEntry := widget.NewEntry()
+	maxFailuresEntry.SetPlaceHolder(strconv.Itoa(defaultMaxLoginFailures))
+	if settings.Login.MaxFailures > 0 {
+		maxFailuresEntry.SetText(strconv.Itoa(settings.Login.MaxFailures))
+	}
+	lockoutEntry := widget.NewEntry()
+	lockoutEntry.SetPlaceHolder(strconv.Itoa(defaultLockoutMinutes))
+	if settings.Login.LockoutMinutes > 0 {
+		lockoutEntry.SetText(strconv.Itoa(settings.Login.LockoutMinutes))
+	}
+	twoFactorCheck := widget.NewCheck("Require two-factor authentication for admins", nil)
+	twoFactorCheck.SetChecked(settings.Login.RequireAdminTwoFactor)
+
+	current := func() (LoginSettings, error) {
+		updated := LoginSettings{RequireAdminTwoFactor: twoFactorCheck.Checked}
+		if text := strings.TrimSpace(maxFailuresEntry.Text); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n <= 0 {
+				return updated, fmt.Errorf("failed attempts must be a positive number")
+			}
+			updated.MaxFailures = n
+		}
+		if text := strings.TrimSpace(lockoutEntry.Text); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n <= 0 {
+				return updated, fmt.Errorf("lockout must be a positive number of minutes")
+			}
+			updated.LockoutMinutes = n
+		}
+		return updated, nil
+	}
+
+	form := widget.NewForm(
+		&widget.FormItem{Text: "Lock After Failures", Widget: maxFailuresEntry, HintText: "Consecutive failed sign-ins before the account is locked"},
+		&widget.FormItem{Text: "Lockout (minutes)", Widget: lockoutEntry, HintText: "Admins can unlock accounts sooner under Manage Users"},
+		&widget.FormItem{Text: "Two-Factor", Widget: twoFactorCheck, HintText: "Admins without it set it up at their next sign-in"},
+	)
+	help := widget.NewLabel(fmt.Sprintf("After %d failed sign-ins for an account, each further attempt waits twice as long, up to %d minutes.",
+		freeLoginFailures, int(maxLoginBackoff.Minutes())))
+	help.Wrapping = fyne.TextWrapWord
+	return container.NewVBox(form, help), current
+}
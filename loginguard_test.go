@@ -0,0 +1,154 @@
+// loginguard_test.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// useTestLoginGuard starts the test with one local account, alice, whose
+// password is "correct horse", and no failed sign-ins on record.
+func useTestLoginGuard(t *testing.T) {
+	t.Helper()
+	useTestDataDir(t)
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users = []User{{Username: "alice", PasswordHash: hash, Role: "User"}}
+	savedAccounts, savedSources, savedAttempts := accountThrottles, sourceThrottles, loginAttempts
+	accountThrottles, sourceThrottles, loginAttempts = map[string]*loginThrottle{}, map[string]*loginThrottle{}, nil
+	t.Cleanup(func() {
+		accountThrottles, sourceThrottles, loginAttempts = savedAccounts, savedSources, savedAttempts
+	})
+}
+
+// failLogins records n failed sign-ins for username without waiting out
+// the backoff between them.
+func failLogins(username, source string, n int) {
+	for i := 0; i < n; i++ {
+		recordLoginFailure(username, source)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{7, 16 * time.Second},
+		{11, 256 * time.Second},
+		{12, maxLoginBackoff},
+		{100, maxLoginBackoff},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures, freeLoginFailures); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+	if got := loginBackoff(freeSourceFailures-1, freeSourceFailures); got != 0 {
+		t.Errorf("a source waits %v before its free failures are used up", got)
+	}
+	if got := loginBackoff(freeSourceFailures, freeSourceFailures); got != time.Second {
+		t.Errorf("a source waits %v after its free failures, want 1s", got)
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	useTestLoginGuard(t)
+	failLogins("alice", "desktop:a", defaultMaxLoginFailures)
+
+	if !isLockedOut(*findUser("alice")) {
+		t.Fatal("alice is not locked after the maximum failures")
+	}
+	if _, err := attemptLogin("alice", "correct horse", "desktop:a"); err != errTooManyAttempts {
+		t.Errorf("locked sign-in with the right password: err = %v, want errTooManyAttempts", err)
+	}
+	if last := loginAttempts[len(loginAttempts)-1]; last.Result != loginLocked {
+		t.Errorf("refused sign-in recorded as %q, want %q", last.Result, loginLocked)
+	}
+
+	// Move the lockout and backoff into the past
+	past := time.Now().Add(-time.Second)
+	findUser("alice").LockedUntil = past
+	accountThrottles["alice"].lockedUntil = past
+	accountThrottles["alice"].nextAllowed = past
+	if _, err := attemptLogin("alice", "correct horse", "desktop:a"); err != nil {
+		t.Fatalf("sign-in after the lockout expired: %v", err)
+	}
+	if accountThrottles["alice"] != nil {
+		t.Error("a successful sign-in did not clear the account's failures")
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	useTestLoginGuard(t)
+	failLogins("Alice", "desktop:a", defaultMaxLoginFailures)
+
+	if err := unlockUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if isLockedOut(*findUser("alice")) {
+		t.Error("alice is still locked after unlocking")
+	}
+	if _, err := attemptLogin("alice", "correct horse", "desktop:a"); err != nil {
+		t.Errorf("sign-in after unlocking: %v", err)
+	}
+	if err := unlockUser("bob"); err == nil {
+		t.Error("unlocking an unknown user succeeded")
+	}
+}
+
+func TestSourceThrottleSpansUsernames(t *testing.T) {
+	useTestLoginGuard(t)
+	for i := 0; i < freeSourceFailures-1; i++ {
+		failLogins(fmt.Sprintf("user%d", i), "desktop:shared", 1)
+	}
+	if err := checkLoginAllowed("alice", "desktop:shared"); err != nil {
+		t.Fatalf("a shared machine was throttled before its free failures were used: %v", err)
+	}
+
+	failLogins("user-last", "desktop:shared", 1)
+	if err := checkLoginAllowed("alice", "desktop:shared"); err != errTooManyAttempts {
+		t.Errorf("a source trying many usernames: err = %v, want errTooManyAttempts", err)
+	}
+	if err := checkLoginAllowed("alice", "desktop:other"); err != nil {
+		t.Errorf("another source was throttled: %v", err)
+	}
+	if isLockedOut(*findUser("alice")) {
+		t.Error("failures on other usernames locked alice")
+	}
+
+	// Sources are forgotten like accounts once they have been idle
+	loginMu.Lock()
+	pruneLoginThrottles(time.Now().Add(loginThrottleExpiry))
+	remaining := len(sourceThrottles)
+	loginMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d sources kept after an idle hour", remaining)
+	}
+}
+
+func TestLoginErrorsDoNotRevealAccounts(t *testing.T) {
+	useTestLoginGuard(t)
+	if _, err := attemptLogin("alice", "wrong", "desktop:a"); err != errInvalidCredentials {
+		t.Errorf("wrong password: err = %v, want errInvalidCredentials", err)
+	}
+	if _, err := attemptLogin("nobody", "wrong", "desktop:b"); err != errInvalidCredentials {
+		t.Errorf("unknown user: err = %v, want errInvalidCredentials", err)
+	}
+
+	failLogins("alice", "desktop:a", defaultMaxLoginFailures)
+	failLogins("nobody", "desktop:b", defaultMaxLoginFailures)
+	for _, username := range []string{"alice", "nobody"} {
+		if _, err := attemptLogin(username, "wrong", "desktop:c"); err != errTooManyAttempts {
+			t.Errorf("%s after too many failures: err = %v, want errTooManyAttempts", username, err)
+		}
+	}
+}
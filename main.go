@@ -3,12 +3,9 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"image"
 	"image/color"
-	"io"
 	"log"
 	"os"
 	"sort"
@@ -25,36 +22,39 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
-	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// Command interface for undo/redo functionality
-type Command interface {
-	Execute()
-	Undo()
-}
-
 // Define data structures and variables
 type Reservation struct {
-	RoomName  string
-	Date      string
+	RoomID    string // ID of the room, which stays the same when it is renamed
+	RoomName  string // Name of the room, updated when it is renamed
+	Date      string // Day of StartTime in the site time zone
 	StartTime time.Time
 	EndTime   time.Time
 	Purpose   string
 	Leader    string
 	Student   string
 	Priority  int
-	Active    bool // For soft delete
+	TimeZone  string    // Site time zone the reservation was made in
+	BookedBy  string    // Username of the account that made the booking
+	BookedAt  time.Time // When the booking was made; zero for older bookings
+	Active    bool      // For soft delete
+	Undone    bool      // Inactive because the booking was undone, not cancelled
+	NoShow    bool      // Marked by an admin when nobody came
 }
 
 type Room struct {
-	Name         string
-	Reservations []Reservation
-	mu           sync.Mutex
-	Position     fyne.Position // For floor plan
+	ID            string // Stable identifier, see rooms.go
+	Name          string
+	Reservations  []Reservation
+	mu            sync.Mutex
+	Position      fyne.Position   // For floor plan
+	Shape         []fyne.Position // Outline drawn on the floor plan
+	FloorID       string          // Floor the room is on, see locations.go
+	PlanElementID string          // Element id of the room's outline in an SVG floor plan
+	Archived      bool            // Hidden from booking but kept for reports
 }
 
 var rooms = []*Room{
@@ -67,9 +67,15 @@ var rooms = []*Room{
 	{Name: "LRE Room"},
 }
 
+// gridInterval is the slot granularity last chosen in the grid view
+var gridInterval = 1 * time.Hour // Hourly intervals
+
+// gridLocation is the building or floor the grid view is filtered to
+var gridLocation = allLocationsLabel
+
 const (
-	timeLayout12Hour   = "3:04 PM"
-	floorPlanImagePath = "floorplan.png" // Path to the uploaded floor plan image
+	timeLayout12Hour    = "3:04 PM"
+	legacyFloorPlanPath = "floorplan.png" // Single floor plan used before floors were added
 )
 
 func getPriority(purpose string) int {
@@ -82,52 +88,178 @@ func (r *Room) Reserve(reservation Reservation) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Check for overlapping reservations
-	for _, res := range r.Reservations {
-		if res.Active && res.Date == reservation.Date && (reservation.StartTime.Before(res.EndTime) && reservation.EndTime.After(res.StartTime)) {
-			return fmt.Errorf("time slot already reserved")
-		}
+	if err := r.canReserve(reservation, nil); err != nil {
+		return err
 	}
 
+	reservation.RoomID = r.ID
+	reservation.RoomName = r.Name
 	reservation.Active = true
 	r.Reservations = append(r.Reservations, reservation)
 	saveReservations()
+	emitWebhook(webhookReservationCreated, reservation)
+
+	return nil
+}
+
+// checkReservation reports why reservation could not be made in the room
+// alongside pending ones that are about to be, without changing anything.
+func (r *Room) checkReservation(reservation Reservation, pending []Reservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.canReserve(reservation, pending)
+}
 
+// canReserve is checkReservation for callers that hold r.mu.
+func (r *Room) canReserve(reservation Reservation, pending []Reservation) error {
+	if r.Archived {
+		return fmt.Errorf("room '%s' is archived", r.Name)
+	}
+	// Check for overlapping reservations
+	if r.overlaps(reservation, -1) {
+		return fmt.Errorf("time slot already reserved")
+	}
+	for _, other := range pending {
+		if reservation.StartTime.Before(other.EndTime) && reservation.EndTime.After(other.StartTime) {
+			return fmt.Errorf("time slot already reserved")
+		}
+	}
+	return nil
+}
+
+// Unreserve takes back the reservation at index when its booking is undone.
+// Like a cancellation it stays in place, so the indexes of later
+// reservations do not change, but it is marked as undone.
+func (r *Room) Unreserve(index int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if index < 0 || index >= len(r.Reservations) {
+		return fmt.Errorf("reservation not found")
+	}
+	wasActive := r.Reservations[index].Active
+	r.Reservations[index].Active = false
+	r.Reservations[index].Undone = true
+	saveReservations()
+	if wasActive {
+		emitWebhook(webhookReservationCancelled, r.Reservations[index])
+	}
 	return nil
 }
 
+// overlaps reports whether reservation collides with an active reservation
+// other than the one at index skip. Callers must hold r.mu.
+func (r *Room) overlaps(reservation Reservation, skip int) bool {
+	for i, res := range r.Reservations {
+		if i == skip {
+			continue
+		}
+		// Compare absolute instants; the Date label alone is ambiguous across zones
+		if res.Active && reservation.StartTime.Before(res.EndTime) && reservation.EndTime.After(res.StartTime) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Room) DeleteReservation(index int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if index >= 0 && index < len(r.Reservations) {
+		wasActive := r.Reservations[index].Active
 		r.Reservations[index].Active = false // Soft delete
 		saveReservations()
+		if wasActive {
+			emitWebhook(webhookReservationCancelled, r.Reservations[index])
+		}
+	}
+}
+
+// RestoreReservation reactivates a soft-deleted reservation, failing if its
+// slot has been taken in the meantime.
+func (r *Room) RestoreReservation(index int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if index < 0 || index >= len(r.Reservations) {
+		return fmt.Errorf("reservation not found")
+	}
+	if r.overlaps(r.Reservations[index], index) {
+		return fmt.Errorf("time slot already reserved")
+	}
+	r.Reservations[index].Active = true
+	r.Reservations[index].Undone = false
+	saveReservations()
+	emitWebhook(webhookReservationCreated, r.Reservations[index])
+	return nil
+}
+
+// UpdateReservation replaces the reservation at index, keeping it active.
+func (r *Room) UpdateReservation(index int, reservation Reservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if index < 0 || index >= len(r.Reservations) {
+		return fmt.Errorf("reservation not found")
+	}
+	if r.overlaps(reservation, index) {
+		return fmt.Errorf("time slot already reserved")
+	}
+	reservation.Active = true
+	before := r.Reservations[index]
+	r.Reservations[index] = reservation
+	saveReservations()
+	emitWebhook(webhookReservationUpdated, reservationUpdate{Before: before, After: reservation})
+	return nil
+}
+
+func findRoom(name string) *Room {
+	for _, r := range rooms {
+		if r.Name == name {
+			return r
+		}
 	}
+	return nil
 }
 
 // User authentication
 type User struct {
-	Username     string
-	PasswordHash []byte
-	Role         string
+	Username           string
+	PasswordHash       []byte
+	Role               string
+	Email              string    // Where notifications are sent; empty for none
+	MutedNotifications []string  // Notification events the user opted out of
+	ReminderMinutes    int       // Reminder lead time; 0 uses the site default
+	Source             string    // Where the password is checked; empty for local accounts
+	Issuer             string    // OpenID provider of an SSO account
+	Subject            string    // The provider's permanent ID for an SSO account ("sub")
+	LockedUntil        time.Time // Set after too many failed sign-ins
+	PasswordHistory    [][]byte  // Previous password hashes, newest first
+	MustChangePassword bool      // Set by an admin reset
+	TOTPSecret         string    // Base32 authenticator secret
+	TOTPEnabled        bool
+	TOTPLastStep       int64    // Time step of the last accepted code, so codes are not reused
+	RecoveryCodes      []string // SHA-256 hashes of unused recovery codes
+	Pending            bool     // Registered in approval mode and not yet approved
 }
 
 var users []User
 var currentUser *User
 
-func createUser(username, password, role string) error {
-	if len(password) < 8 {
-		return fmt.Errorf("password must be at least 8 characters long")
+func createUser(username, password, role, email string) error {
+	username = normalizeUsername(username)
+	if err := validateNewUsername(username); err != nil {
+		return err
 	}
-
-	for _, user := range users {
-		if user.Username == username {
-			return fmt.Errorf("username already exists")
-		}
+	if err := validatePassword(username, password, nil); err != nil {
+		return asFieldError(fieldPassword, err)
+	}
+	if err := validateEmail(email); err != nil {
+		return err
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	passwordHash, err := hashPassword(password)
 	if err != nil {
 		return err
 	}
@@ -135,47 +267,155 @@ func createUser(username, password, role string) error {
 		Username:     username,
 		PasswordHash: passwordHash,
 		Role:         role,
+		Email:        email,
 	})
+	refreshCurrentUser()
 	saveUsers()
+	emitWebhook(webhookUserCreated, userPayload{Username: username, Role: role})
 	return nil
 }
 
-func authenticateUser(username, password string) (*User, error) {
-	for i, user := range users {
+// findUser returns the account with the given username, ignoring case.
+func findUser(username string) *User {
+	key := usernameKey(username)
+	for i := range users {
+		if usernameKey(users[i].Username) == key {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// refreshCurrentUser re-points currentUser into the users slice after it has
+// been modified, since appends and removals may move the underlying array.
+func refreshCurrentUser() {
+	if currentUser != nil {
+		currentUser = findUser(currentUser.Username)
+	}
+}
+
+func countAdmins() int {
+	count := 0
+	for _, user := range users {
+		if user.Role == "Admin" {
+			count++
+		}
+	}
+	return count
+}
+
+// removeUser deletes the account with the given username, ignoring case.
+// An exact match is preferred, so either of two accounts whose names differ
+// only in case, left by earlier versions, can be removed.
+func removeUser(username string) error {
+	i := -1
+	for j, user := range users {
 		if user.Username == username {
-			err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password))
-			if err != nil {
-				return nil, fmt.Errorf("incorrect password")
-			}
-			return &users[i], nil
+			i = j
+			break
 		}
+		if i < 0 && usernameKey(user.Username) == usernameKey(username) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("user not found")
+	}
+	if users[i].Role == "Admin" && countAdmins() == 1 {
+		return fmt.Errorf("cannot remove the last admin account")
 	}
-	return nil, fmt.Errorf("user not found")
+	users = append(users[:i], users[i+1:]...)
+	refreshCurrentUser()
+	saveUsers()
+	return nil
+}
+
+func setUserRole(username, role string) error {
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	if user.Role == "Admin" && role != "Admin" && countAdmins() == 1 {
+		return fmt.Errorf("cannot demote the last admin account")
+	}
+	user.Role = role
+	saveUsers()
+	return nil
 }
 
-func showRegistration(content *fyne.Container, w fyne.Window) {
+// showRegistration lets someone create a User account, subject to the
+// registration mode. invite pre-fills the invite code from an invite link.
+func showRegistration(content *fyne.Container, w fyne.Window, invite string) {
 	usernameEntry := widget.NewEntry()
+	emailEntry := widget.NewEntry()
+	emailEntry.SetPlaceHolder("Optional, for notifications")
 	passwordEntry := widget.NewPasswordEntry()
 	confirmPasswordEntry := widget.NewPasswordEntry()
+	inviteEntry := widget.NewEntry()
+	inviteEntry.SetText(invite)
+	usernameEntry.Validator = entryValidator(validateNewUsername)
+	emailEntry.Validator = entryValidator(validateEmail)
+	passwordEntry.Validator = func(password string) error {
+		return validatePassword(usernameEntry.Text, password, nil)
+	}
+	confirmPasswordEntry.Validator = func(password string) error {
+		if password != passwordEntry.Text {
+			return errors.New("passwords do not match")
+		}
+		return nil
+	}
 
-	form := dialog.NewForm("Register", "Register", "Cancel", []*widget.FormItem{
+	items := []*widget.FormItem{
 		{Text: "Username", Widget: usernameEntry},
-		{Text: "Password", Widget: passwordEntry},
+		{Text: "Email", Widget: emailEntry},
+		{Text: "Password", Widget: passwordEntry, HintText: passwordPolicyHint()},
 		{Text: "Confirm Password", Widget: confirmPasswordEntry},
-	}, func(confirmed bool) {
+	}
+	switch settings.Registration.mode() {
+	case registrationInvite:
+		inviteEntry.SetPlaceHolder("Code or link from your invite")
+	case registrationDomain:
+		emailEntry.SetPlaceHolder("Your address at " + strings.Join(settings.Registration.AllowedDomains, ", "))
+		inviteEntry.SetPlaceHolder("Optional")
+	default:
+		inviteEntry.SetPlaceHolder("Optional")
+	}
+	items = append(items, &widget.FormItem{Text: "Invite Code", Widget: inviteEntry})
+
+	form := dialog.NewForm("Register", "Register", "Cancel", items, func(confirmed bool) {
 		if confirmed {
 			if passwordEntry.Text != confirmPasswordEntry.Text {
 				dialog.ShowError(errors.New("passwords do not match"), w)
 				return
 			}
-			err := createUser(usernameEntry.Text, passwordEntry.Text, "User")
-			if err != nil {
+			request := registrationRequest{
+				Username: usernameEntry.Text,
+				Password: passwordEntry.Text,
+				Email:    strings.TrimSpace(emailEntry.Text),
+				Invite:   parseInviteCode(inviteEntry.Text),
+			}
+			if err := checkRegistration(request); err != nil {
 				dialog.ShowError(err, w)
-			} else {
-				dialog.ShowInformation("Success", "User registered successfully", w)
+				return
+			}
+			register := func() {
+				user, err := registerUser(request)
+				if err != nil {
+					dialog.ShowError(err, w)
+				} else if user.Pending {
+					dialog.ShowInformation("Success", "User registered successfully. An administrator must approve your account before you can book rooms.", w)
+				} else {
+					dialog.ShowInformation("Success", "User registered successfully", w)
+				}
 			}
+			if needsEmailVerification(request) {
+				showEmailVerification(request.Email, w, register)
+				return
+			}
+			register()
 		}
 	}, w)
+	form.Resize(fyne.NewSize(450, 360))
 	form.Show()
 }
 
@@ -183,94 +423,143 @@ func showLogin(content *fyne.Container, w fyne.Window, onSuccess func(*User)) {
 	usernameEntry := widget.NewEntry()
 	passwordEntry := widget.NewPasswordEntry()
 
-	form := dialog.NewForm("Login", "Login", "Cancel", []*widget.FormItem{
+	items := []*widget.FormItem{
 		{Text: "Username", Widget: usernameEntry},
 		{Text: "Password", Widget: passwordEntry},
-	}, func(confirmed bool) {
+	}
+	var form dialog.Dialog
+	if settings.OIDC.Enabled {
+		ssoButton := widget.NewButton("Sign in with SSO", func() {
+			form.Hide()
+			showSSOSignIn(w, onSuccess)
+		})
+		items = append(items, &widget.FormItem{Text: "", Widget: ssoButton})
+	}
+
+	form = dialog.NewForm("Login", "Login", "Cancel", items, func(confirmed bool) {
 		if confirmed {
-			user, err := authenticateUser(usernameEntry.Text, passwordEntry.Text)
+			user, err := attemptLogin(usernameEntry.Text, passwordEntry.Text, localLoginSource())
 			if err != nil {
 				dialog.ShowError(err, w)
-			} else {
-				currentUser = user
-				onSuccess(user)
+				return
 			}
+			finishSignIn(user, w, onSuccess)
 		}
 	}, w)
 	form.Show()
 }
 
-// Undo/Redo functionality
-var undoStack []Command
-var redoStack []Command
-
-// ReservationCommand for undo/redo
-type ReservationCommand struct {
-	reservation Reservation
-	room        *Room
-	index       int // Index in the room's reservation slice
-}
-
-func (c *ReservationCommand) Execute() {
-	c.room.Reserve(c.reservation)
-	c.index = len(c.room.Reservations) - 1
-}
-
-func (c *ReservationCommand) Undo() {
-	c.room.DeleteReservation(c.index)
-}
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "setup" {
+		os.Exit(runSetupCommand(os.Args[2:]))
+	}
+
 	a := app.NewWithID("com.example.roomreservation")
 	a.Settings().SetTheme(&customtheme.CustomTheme{})
 	w := a.NewWindow("Room Booking")
+	w.Resize(fyne.NewSize(1024, 768))
 
-	// Load reservations and users
+	// The data files are loaded once they can be decrypted
+	loadKeyring()
+	if dataLocked() {
+		w.SetContent(createUnlockScreen(w, func() { startApp(w) }))
+	} else {
+		startApp(w)
+	}
+	w.ShowAndRun()
+}
+
+// startApp loads the data files and shows the main window, or the setup
+// wizard if there is no admin yet.
+func startApp(w fyne.Window) {
+	// Load settings, reservations and users
+	loadSettings()
 	loadReservations()
-	loadUsers(w) // Pass 'w' here
+	loadLocations()
+	loadWebhooks()
+	loadUsers()
+	loadSessions()
+	loadLoginAttempts()
+	loadInvites()
+	loadReports()
+	w.SetTitle(windowTitle())
+	startReminders() // Pass 'w' here
 
 	// Create initial content
 	content := container.NewMax()
 	content.Objects = []fyne.CanvasObject{widget.NewLabel("Please log in to continue.")}
 
 	// Create sidebar
-	sidebar := createSidebar(content, w)
+	shell.window = w
+	shell.content = content
+	shell.sidebar = container.NewMax()
+	refreshSidebar()
 
 	// Main layout
-	mainLayout := container.NewBorder(nil, nil, sidebar, nil, content)
+	mainLayout := container.NewBorder(nil, nil, shell.sidebar, nil, content)
+	shell.main = newActivityWatcher(mainLayout)
 
 	// Implement global keyboard shortcuts
 	w.Canvas().AddShortcut(&desktop.CustomShortcut{
 		KeyName:  fyne.KeyZ,
 		Modifier: fyne.KeyModifierControl,
 	}, func(shortcut fyne.Shortcut) {
-		undo()
-		content.Refresh()
+		if !sessionActive() {
+			return
+		}
+		if err := undo(); err != nil {
+			dialog.ShowError(err, w)
+		}
+		refreshView(content)
 	})
 
 	w.Canvas().AddShortcut(&desktop.CustomShortcut{
 		KeyName:  fyne.KeyY,
 		Modifier: fyne.KeyModifierControl,
 	}, func(shortcut fyne.Shortcut) {
-		redo()
-		content.Refresh()
+		if !sessionActive() {
+			return
+		}
+		if err := redo(); err != nil {
+			dialog.ShowError(err, w)
+		}
+		refreshView(content)
 	})
 
-	w.SetContent(mainLayout)
-	w.Resize(fyne.NewSize(1024, 768))
-	w.ShowAndRun()
+	w.Canvas().SetOnTypedKey(func(*fyne.KeyEvent) { touchSession() })
+	w.SetOnClosed(func() { closeSession(sessionEndClosed) })
+	startIdleWatcher()
+
+	if setupNeeded() {
+		// Nothing else is reachable until there is an admin account
+		w.SetContent(createSetupWizard(w, func() {
+			w.SetTitle(windowTitle())
+			w.SetContent(shell.main)
+			refreshSidebar()
+			content.Objects = []fyne.CanvasObject{widget.NewLabel("Please log in to continue.")}
+			content.Refresh()
+			showLogin(content, w, beginSession)
+		}))
+	} else {
+		w.SetContent(shell.main)
+		// Opened from an invite link
+		if invite := inviteFromArgs(os.Args[1:]); invite != "" {
+			showRegistration(content, w, invite)
+		}
+	}
 }
 
 func createSidebar(content *fyne.Container, w fyne.Window) *fyne.Container {
 	reservationViewsButton := widget.NewButtonWithIcon("Reservation Views", theme.ContentCopyIcon(), func() {
-		interval := 1 * time.Hour // Hourly intervals
-		content.Objects = []fyne.CanvasObject{createGridScheduleView(content, interval, w)}
-		content.Refresh()
+		showView(content, func() fyne.CanvasObject {
+			return createGridScheduleView(content, gridInterval, w)
+		})
 	})
 
 	floorPlanButton := widget.NewButtonWithIcon("Floor Plan View", theme.NavigateNextIcon(), func() {
-		content.Objects = []fyne.CanvasObject{createFloorPlanView(w)}
-		content.Refresh()
+		showView(content, func() fyne.CanvasObject {
+			return createFloorPlanView(w)
+		})
 	})
 
 	adminButton := widget.NewButtonWithIcon("Admin Panel", theme.SettingsIcon(), func() {
@@ -285,25 +574,36 @@ func createSidebar(content *fyne.Container, w fyne.Window) *fyne.Container {
 
 	if currentUser != nil {
 		logoutButton := widget.NewButtonWithIcon("Logout", theme.LogoutIcon(), func() {
-			currentUser = nil
-			content.Objects = []fyne.CanvasObject{widget.NewLabel("Please log in to continue.")}
-			content.Refresh()
+			endSession(sessionEndLogout)
 		})
-		buttons = append(buttons, logoutButton)
+		lockButton := widget.NewButtonWithIcon("Lock", theme.VisibilityOffIcon(), lockSession)
+		switchUserButton := widget.NewButtonWithIcon("Switch User", theme.AccountIcon(), switchUser)
+		accountButton := widget.NewButtonWithIcon("My Account", theme.AccountIcon(), func() {
+			showAccountSettings(w)
+		})
+		buttons = append(buttons, accountButton)
+		if currentUser.Source == authSourceLocal {
+			changePasswordButton := widget.NewButtonWithIcon("Change Password", theme.DocumentCreateIcon(), func() {
+				showChangePassword(currentUser, false, w, nil)
+			})
+			buttons = append(buttons, changePasswordButton)
+		}
+		if currentUser.Source != authSourceOIDC && (currentUser.Role == "Admin" || currentUser.TOTPEnabled) {
+			twoFactorButton := widget.NewButtonWithIcon("Two-Factor Auth", theme.VisibilityIcon(), func() {
+				showTwoFactorSettings(w)
+			})
+			buttons = append(buttons, twoFactorButton)
+		}
+		buttons = append(buttons, lockButton, switchUserButton, logoutButton)
 		if currentUser.Role == "Admin" {
 			buttons = append(buttons, adminButton)
 		}
 	} else {
 		loginButton := widget.NewButtonWithIcon("Login", theme.LoginIcon(), func() {
-			showLogin(content, w, func(user *User) {
-				currentUser = user
-				interval := 1 * time.Hour // Hourly intervals
-				content.Objects = []fyne.CanvasObject{createGridScheduleView(content, interval, w)}
-				content.Refresh()
-			})
+			showLogin(content, w, beginSession)
 		})
 		registerButton := widget.NewButtonWithIcon("Register", theme.DocumentCreateIcon(), func() {
-			showRegistration(content, w)
+			showRegistration(content, w, "")
 		})
 		buttons = append(buttons, loginButton, registerButton)
 	}
@@ -314,148 +614,55 @@ func createSidebar(content *fyne.Container, w fyne.Window) *fyne.Container {
 	}
 
 	sidebar := container.NewVBox()
+	if currentUser != nil && currentUser.Pending {
+		notice := widget.NewLabel("Awaiting approval")
+		notice.Alignment = fyne.TextAlignCenter
+		sidebar.Add(notice)
+	}
 	for _, btn := range buttons {
 		sidebar.Add(btn)
 	}
 	return sidebar
 }
 
-func NewTappableImage(img image.Image) *TappableImage {
-	tappableImage := &TappableImage{
-		Image: canvas.NewImageFromImage(img),
-	}
-	tappableImage.Image.FillMode = canvas.ImageFillContain // Set FillMode here
-	tappableImage.ExtendBaseWidget(tappableImage)
-	return tappableImage
-}
-
-// Floor plan view
-func createFloorPlanView(w fyne.Window) fyne.CanvasObject {
-	// Load the floor plan image
-	imgFile, err := os.Open(floorPlanImagePath)
-	if err != nil {
-		return widget.NewLabel("Floor plan not uploaded.")
-	}
-	defer imgFile.Close()
-
-	img, _, err := image.Decode(imgFile)
-	if err != nil {
-		dialog.ShowError(err, w)
-		return widget.NewLabel("Error loading floor plan image.")
-	}
-
-	// Here you call NewTappableImage to create your image with the correct FillMode
-	floorPlanImage := NewTappableImage(img)
-
-	floorPlan := container.NewWithoutLayout(floorPlanImage)
-	// Add room icons to the floor plan
-	for _, room := range rooms {
-		roomCopy := room // Capture variable for closure
-		roomButton := widget.NewButton(room.Name, func() {
-			// Handle room booking from floor plan
-			openRoomBooking(roomCopy, w)
-		})
-		// Position the button
-		roomButton.Move(room.Position)
-		floorPlan.Add(roomButton)
-	}
-
-	// If admin, allow placing rooms on the floor plan
-	if currentUser != nil && currentUser.Role == "Admin" {
-		floorPlanImage.OnTapped = func(event *fyne.PointEvent) {
-			// Show a dialog to select a room to place
-			roomNames := []string{}
-			for _, room := range rooms {
-				roomNames = append(roomNames, room.Name)
-			}
-			roomSelect := widget.NewSelect(roomNames, func(selected string) {
-				// Update the room's position
-				for _, room := range rooms {
-					if room.Name == selected {
-						room.Position = event.Position
-						saveReservations()
-						// Refresh the content
-						content := createFloorPlanView(w)
-						w.SetContent(content)
-						break
-					}
-				}
-			})
-			dialog.ShowCustom("Select Room", "Close", roomSelect, w)
-		}
-	}
-
-	scroll := container.NewScroll(floorPlan)
-	return scroll
+// refreshSidebar rebuilds the sidebar after a user signs in or out.
+func refreshSidebar() {
+	shell.sidebar.Objects = []fyne.CanvasObject{createSidebar(shell.content, shell.window)}
+	shell.sidebar.Refresh()
 }
 
-// Define TappableImage
-// Define TappableImage
-type TappableImage struct {
-	widget.BaseWidget
-	Image    *canvas.Image
-	OnTapped func(*fyne.PointEvent)
-}
+// currentView builds the view in the content area, so it can be built
+// again when undo or redo changes what it shows.
+var currentView func() fyne.CanvasObject
 
-func (t *TappableImage) Tapped(event *fyne.PointEvent) {
-	if t.OnTapped != nil {
-		t.OnTapped(event)
-	}
+// showView shows the view made by build in content.
+func showView(content *fyne.Container, build func() fyne.CanvasObject) {
+	currentView = build
+	content.Objects = []fyne.CanvasObject{build()}
+	content.Refresh()
 }
 
-func (t *TappableImage) TappedSecondary(event *fyne.PointEvent) {}
-
-// Implement Widget interface
-func (t *TappableImage) CreateRenderer() fyne.WidgetRenderer {
-	return &tappableImageRenderer{
-		image: t.Image,
+// refreshView builds the current view again.
+func refreshView(content *fyne.Container) {
+	if currentView != nil {
+		showView(content, currentView)
 	}
 }
 
-type tappableImageRenderer struct {
-	image *canvas.Image
-}
-
-func (r *tappableImageRenderer) Layout(size fyne.Size) {
-	r.image.Resize(size)
-}
-
-func (r *tappableImageRenderer) MinSize() fyne.Size {
-	return r.image.MinSize()
-}
-
-func (r *tappableImageRenderer) Refresh() {
-	r.image.Refresh()
-}
-
-func (r *tappableImageRenderer) BackgroundColor() color.Color {
-	return color.Transparent
-}
-
-func (r *tappableImageRenderer) Objects() []fyne.CanvasObject {
-	return []fyne.CanvasObject{r.image}
-}
-
-func (r *tappableImageRenderer) Destroy() {}
-
-// Implement openRoomBooking function
-func openRoomBooking(room *Room, w fyne.Window) {
-	// Implement room booking from floor plan
-	dialog.ShowInformation("Room Booking", fmt.Sprintf("Booking for room: %s", room.Name), w)
-}
-
 // Implement createGridScheduleView
 func createGridScheduleView(content *fyne.Container, interval time.Duration, w fyne.Window) fyne.CanvasObject {
-	today := time.Now().Format("2006-01-02")
-	timeSlots := generateTimeSlots(interval)
+	today := time.Now().In(siteLocation()).Format("2006-01-02")
+	timeSlots := generateTimeSlots(today, interval)
 	grid := container.NewGridWithRows(len(timeSlots) + 1)
+	gridInterval = interval
 
 	selectedSlots := make(map[string]*ColorButton)
+	visibleRooms := bookableRooms(filterRooms(gridLocation))
 
 	// Header row with room names
-	header := container.NewGridWithColumns(len(rooms) + 1)
+	header := container.NewGridWithColumns(len(visibleRooms) + 1)
 	header.Add(widget.NewLabel("Time Slots"))
-	for _, room := range rooms {
+	for _, room := range visibleRooms {
 		header.Add(widget.NewLabel(room.Name))
 	}
 	grid.Add(header)
@@ -463,29 +670,44 @@ func createGridScheduleView(content *fyne.Container, interval time.Duration, w f
 	// Generate grid rows for each time slot
 	for _, slot := range timeSlots {
 		slotCopy := slot // capture variable
-		row := container.NewGridWithColumns(len(rooms) + 1)
-		row.Add(widget.NewLabel(slot))
+		row := container.NewGridWithColumns(len(visibleRooms) + 1)
+		row.Add(widget.NewLabel(formatSlot(slot, timeSlots)))
 
-		for _, room := range rooms {
+		for _, room := range visibleRooms {
 			roomCopy := room // capture variable
-			reserved := checkRoomReservation(roomCopy, today, slotCopy)
+			index, full := slotOccupancy(roomCopy, slotCopy, interval)
 			button := NewColorButton("", nil)
 			button.Disable()
 
-			if reserved {
-				button.Text = "Booked"
-				button.BackgroundColor = color.NRGBA{R: 220, G: 53, B: 69, A: 255} // Danger color
+			if index >= 0 {
+				res := roomCopy.Reservations[index]
+				if full {
+					button.Text = "Booked"
+					button.BackgroundColor = color.NRGBA{R: 220, G: 53, B: 69, A: 255} // Danger color
+				} else {
+					// Only part of the slot is taken, show when
+					button.Text = fmt.Sprintf("%s-%s", formatClock(res.StartTime), formatClock(res.EndTime))
+					button.BackgroundColor = color.NRGBA{R: 255, G: 193, B: 7, A: 255} // Warning amber
+				}
+				button.Enable()
+				button.OnTapped = func() {
+					showReservationDetails(roomCopy, index, func() {
+						showView(content, func() fyne.CanvasObject {
+							return createGridScheduleView(content, interval, w)
+						})
+					}, w)
+				}
 				button.Refresh()
 			} else {
 				// Make the button selectable
 				button.Enable()
 				button.Text = "" // Keep the button text empty
 				// Copy variables for closure
-				roomNameCopy := roomCopy.Name
+				roomIDCopy := roomCopy.ID
 				slotTimeCopy := slotCopy
 
 				button.OnTapped = func() {
-					handleSlotSelection(selectedSlots, roomNameCopy, slotTimeCopy, button, interval, w)
+					handleSlotSelection(selectedSlots, roomIDCopy, slotTimeCopy, button)
 				}
 				button.Refresh()
 			}
@@ -501,51 +723,59 @@ func createGridScheduleView(content *fyne.Container, interval time.Duration, w f
 			return
 		}
 
-		// Group selected slots by room
-		roomSlotsMap := make(map[string][]string)
+		keys := make([]string, 0, len(selectedSlots))
 		for key := range selectedSlots {
-			roomName, slot := parseSlotKey(key)
-			roomSlotsMap[roomName] = append(roomSlotsMap[roomName], slot)
+			keys = append(keys, key)
 		}
 
-		// For simplicity, we'll only allow booking one room at a time
-		if len(roomSlotsMap) > 1 {
-			dialog.ShowInformation("Error", "Please select time slots for only one room at a time.", w)
-			return
-		}
+		// Open reservation form with every selected block pre-filled
+		openReservationForm(today, groupSelectedSlots(keys, interval), interval, func() {
+			// Refresh the grid view
+			showView(content, func() fyne.CanvasObject {
+				return createGridScheduleView(content, interval, w)
+			})
+		}, w)
+	})
 
-		var roomName string
-		var slots []string
-		for rn, sl := range roomSlotsMap {
-			roomName = rn
-			slots = sl
-			break
-		}
+	// Adjust the button's appearance
+	confirmButton.Importance = widget.HighImportance
 
-		// Sort slots by time
-		sort.Slice(slots, func(i, j int) bool {
-			t1, _ := time.Parse(timeLayout12Hour, slots[i])
-			t2, _ := time.Parse(timeLayout12Hour, slots[j])
-			return t1.Before(t2)
+	// Let the user pick the slot granularity
+	granularitySelect := widget.NewSelect([]string{"15 min", "30 min", "60 min"}, nil)
+	granularitySelect.SetSelected(fmt.Sprintf("%d min", int(interval.Minutes())))
+	granularitySelect.OnChanged = func(value string) {
+		var minutes int
+		fmt.Sscanf(value, "%d min", &minutes)
+		if minutes <= 0 || time.Duration(minutes)*time.Minute == interval {
+			return
+		}
+		showView(content, func() fyne.CanvasObject {
+			return createGridScheduleView(content, time.Duration(minutes)*time.Minute, w)
 		})
+	}
 
-		// Check if slots are contiguous
-		if !areSlotsContiguous(slots, interval) {
-			dialog.ShowInformation("Error", "Please select contiguous time slots.", w)
+	// Narrow the columns to one building or floor
+	locationLabels := []string{}
+	for _, filter := range locationFilters() {
+		locationLabels = append(locationLabels, filter.Label)
+	}
+	locationSelect := widget.NewSelect(locationLabels, nil)
+	locationSelect.SetSelected(allLocationsLabel)
+	for _, label := range locationLabels {
+		if label == gridLocation {
+			locationSelect.SetSelected(label)
+		}
+	}
+	locationSelect.OnChanged = func(value string) {
+		if value == gridLocation {
 			return
 		}
-
-		// Get start and end times
-		startTimeStr := slots[0]
-		lastSlot := slots[len(slots)-1]
-		endTimeStr := incrementTimeSlot(lastSlot, interval)
-
-		// Open reservation form with pre-filled data
-		openReservationForm(content, roomName, today, startTimeStr, endTimeStr, interval, w)
-	})
-
-	// Adjust the button's appearance
-	confirmButton.Importance = widget.HighImportance
+		gridLocation = value
+		showView(content, func() fyne.CanvasObject {
+			return createGridScheduleView(content, interval, w)
+		})
+	}
+	toolbar := container.NewHBox(widget.NewLabel("Slot size:"), granularitySelect, widget.NewLabel("Location:"), locationSelect)
 
 	scroll := container.NewVScroll(grid)
 	scroll.SetMinSize(fyne.NewSize(800, 600))
@@ -554,94 +784,109 @@ func createGridScheduleView(content *fyne.Container, interval time.Duration, w f
 	buttonContainer := container.NewHBox(layout.NewSpacer(), confirmButton, layout.NewSpacer())
 
 	// Use container.NewBorder to place the button at the bottom without stretching
-	return container.NewBorder(nil, buttonContainer, nil, nil, scroll)
+	return container.NewBorder(toolbar, buttonContainer, nil, nil, scroll)
 }
 
 // Handle slot selection logic
-func handleSlotSelection(selectedSlots map[string]*ColorButton, roomNameCopy, slotTimeCopy string, button *ColorButton, interval time.Duration, w fyne.Window) {
-	slotKey := fmt.Sprintf("%s_%s", roomNameCopy, slotTimeCopy)
+func handleSlotSelection(selectedSlots map[string]*ColorButton, roomIDCopy string, slotTimeCopy time.Time, button *ColorButton) {
+	slotKey := fmt.Sprintf("%s_%d", roomIDCopy, slotTimeCopy.Unix())
 	if _, exists := selectedSlots[slotKey]; exists {
 		delete(selectedSlots, slotKey)
 		button.BackgroundColor = customtheme.ButtonColor
 		button.Refresh()
 	} else {
-		// Ensure only one room's slots are selected at a time
-		for key := range selectedSlots {
-			existingRoomName, _ := parseSlotKey(key)
-			if existingRoomName != roomNameCopy {
-				dialog.ShowInformation("Error", "Please select time slots for only one room at a time.", w)
-				return
-			}
-		}
 		selectedSlots[slotKey] = button
 		button.BackgroundColor = color.NRGBA{R: 40, G: 167, B: 69, A: 255} // Success green
 		button.Refresh()
 	}
 }
 
-// Implement other necessary functions
-func parseSlotKey(key string) (roomName, timeSlot string) {
-	parts := strings.SplitN(key, "_", 2)
-	if len(parts) == 2 {
-		return parts[0], parts[1]
+// parseSlotKey splits a slot key into the room ID and slot start. The
+// timestamp follows the last "_", so the room part may contain one.
+func parseSlotKey(key string) (roomID string, slotStart time.Time) {
+	cut := strings.LastIndex(key, "_")
+	if cut >= 0 {
+		var seconds int64
+		if _, err := fmt.Sscanf(key[cut+1:], "%d", &seconds); err == nil {
+			return key[:cut], time.Unix(seconds, 0).In(siteLocation())
+		}
 	}
-	return "", ""
+	return "", time.Time{}
 }
 
-func incrementTimeSlot(timeSlot string, interval time.Duration) string {
-	t, err := time.Parse(timeLayout12Hour, timeSlot)
+// generateTimeSlots returns the start instants of the slots for date in the
+// site time zone. Slots are stepped in absolute time, so on DST transition
+// days no hour is lost or counted twice; only the wall-clock labels jump.
+func generateTimeSlots(date string, interval time.Duration) []time.Time {
+	var slots []time.Time
+	start, end, err := businessHours(date)
 	if err != nil {
-		return timeSlot
+		return slots
 	}
-	t = t.Add(interval)
-	return t.Format(timeLayout12Hour)
+	for t := start; t.Before(end); t = t.Add(interval) {
+		slots = append(slots, t)
+	}
+	return slots
 }
 
-func areSlotsContiguous(slots []string, interval time.Duration) bool {
-	if len(slots) <= 1 {
-		return true
-	}
-	for i := 1; i < len(slots); i++ {
-		prevTime, err1 := time.Parse(timeLayout12Hour, slots[i-1])
-		currTime, err2 := time.Parse(timeLayout12Hour, slots[i])
-		if err1 != nil || err2 != nil {
-			return false
-		}
-		if currTime.Sub(prevTime) != interval {
-			return false
-		}
-	}
-	return true
+// formatClock formats t as a wall-clock time in the site time zone.
+func formatClock(t time.Time) string {
+	return t.In(siteLocation()).Format(timeLayout12Hour)
 }
 
-func generateTimeSlots(interval time.Duration) []string {
-	var slots []string
-	start := time.Date(0, 0, 0, 8, 0, 0, 0, time.UTC) // Start at 8 AM
-	end := time.Date(0, 0, 0, 23, 0, 0, 0, time.UTC)  // End at 11 PM
-	for t := start; t.Before(end) || t.Equal(end); t = t.Add(interval) {
-		slots = append(slots, t.Format("3:04 PM"))
+// formatSlot labels a slot, adding the zone abbreviation on days where the
+// UTC offset changes so repeated wall-clock times can be told apart.
+func formatSlot(slot time.Time, day []time.Time) string {
+	if len(day) > 0 {
+		_, firstOffset := day[0].In(siteLocation()).Zone()
+		_, lastOffset := day[len(day)-1].In(siteLocation()).Zone()
+		if firstOffset != lastOffset {
+			return slot.In(siteLocation()).Format(timeLayout12Hour + " MST")
+		}
 	}
-	return slots
+	return formatClock(slot)
 }
 
-func checkRoomReservation(room *Room, date, timeSlot string) bool {
+// slotOccupancy returns the index of the first active reservation overlapping
+// the slot, or -1 if it is free, and whether reservations cover all of it.
+func slotOccupancy(room *Room, slotStart time.Time, interval time.Duration) (int, bool) {
+	slotEnd := slotStart.Add(interval)
+
 	room.mu.Lock()
 	defer room.mu.Unlock()
-	slotTime, err := time.Parse(timeLayout12Hour, timeSlot)
-	if err != nil {
-		return false
-	}
-	for _, res := range room.Reservations {
-		if res.Active && res.Date == date {
-			if slotTime.Equal(res.StartTime) || (slotTime.After(res.StartTime) && slotTime.Before(res.EndTime)) {
-				return true
+	first := -1
+	covered := []Reservation{}
+	for i, res := range room.Reservations {
+		if res.Active && res.StartTime.Before(slotEnd) && res.EndTime.After(slotStart) {
+			if first < 0 {
+				first = i
 			}
+			covered = append(covered, res)
 		}
 	}
-	return false
+
+	// Sweep the overlapping reservations to see whether any gap remains
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].StartTime.Before(covered[j].StartTime)
+	})
+	cursor := slotStart
+	for _, res := range covered {
+		if res.StartTime.After(cursor) {
+			break
+		}
+		if res.EndTime.After(cursor) {
+			cursor = res.EndTime
+		}
+	}
+	return first, !cursor.Before(slotEnd)
 }
 
-func openReservationForm(content *fyne.Container, roomName, date, startTimeStr, endTimeStr string, interval time.Duration, w fyne.Window) {
+func openReservationForm(date string, blocks []bookingBlock, interval time.Duration, onBooked func(), w fyne.Window) {
+	if err := checkCanBook(); err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+
 	purposeSelect := widget.NewSelect([]string{
 		"Meeting",
 		"Study Session",
@@ -655,16 +900,29 @@ func openReservationForm(content *fyne.Container, roomName, date, startTimeStr,
 	studentEntry := widget.NewEntry()
 	studentEntry.SetPlaceHolder("Additional Info")
 
+	items := []*widget.FormItem{
+		{Text: "Date:", Widget: widget.NewLabel(date)},
+	}
+	// Times can be typed freely, e.g. 9:45 AM - 10:30 AM
+	startEntries := make([]*widget.Entry, len(blocks))
+	endEntries := make([]*widget.Entry, len(blocks))
+	for i, block := range blocks {
+		startEntries[i] = widget.NewEntry()
+		startEntries[i].SetText(formatClock(block.Start))
+		endEntries[i] = widget.NewEntry()
+		endEntries[i].SetText(formatClock(block.End))
+		times := container.NewGridWithColumns(3, startEntries[i], widget.NewLabel("to"), endEntries[i])
+		items = append(items, &widget.FormItem{Text: block.RoomName + ":", Widget: times})
+	}
+	items = append(items,
+		&widget.FormItem{Text: "Purpose:", Widget: purposeSelect},
+		&widget.FormItem{Text: "Your Name:", Widget: leaderEntry},
+		&widget.FormItem{Text: "Additional Info:", Widget: studentEntry},
+	)
+
+	var d dialog.Dialog
 	form := &widget.Form{
-		Items: []*widget.FormItem{
-			{Text: "Room:", Widget: widget.NewLabel(roomName)},
-			{Text: "Date:", Widget: widget.NewLabel(date)},
-			{Text: "Start Time:", Widget: widget.NewLabel(startTimeStr)},
-			{Text: "End Time:", Widget: widget.NewLabel(endTimeStr)},
-			{Text: "Purpose:", Widget: purposeSelect},
-			{Text: "Your Name:", Widget: leaderEntry},
-			{Text: "Additional Info:", Widget: studentEntry},
-		},
+		Items: items,
 		OnSubmit: func() {
 			purpose := purposeSelect.Selected
 			leader := leaderEntry.Text
@@ -679,89 +937,65 @@ func openReservationForm(content *fyne.Container, roomName, date, startTimeStr,
 				return
 			}
 
-			var room *Room
-			for _, r := range rooms {
-				if r.Name == roomName {
-					room = r
-					break
+			reservations := []Reservation{}
+			for i, block := range blocks {
+				reservation, err := newBlockReservation(date, block, startEntries[i].Text, endEntries[i].Text, purpose, leader, student)
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
 				}
-			}
-			if room == nil {
-				dialog.ShowError(errors.New("room not found"), w)
-				return
+				reservations = append(reservations, reservation)
 			}
 
-			// Parse date and time
-			startTime, err := time.Parse(timeLayout12Hour, startTimeStr)
-			if err != nil {
-				dialog.ShowError(errors.New("invalid start time format"), w)
+			// Report every conflicting cell up front rather than failing on the first
+			if conflicts := findBookingConflicts(reservations, interval); len(conflicts) > 0 {
+				dialog.ShowError(fmt.Errorf("the following slots are already booked:\n%s", strings.Join(conflicts, "\n")), w)
 				return
 			}
 
-			endTime, err := time.Parse(timeLayout12Hour, endTimeStr)
-			if err != nil {
-				dialog.ShowError(errors.New("invalid end time format"), w)
-				return
-			}
-
-			// Combine date with time
-			dateOnly, _ := time.Parse("2006-01-02", date)
-			startDateTime := time.Date(dateOnly.Year(), dateOnly.Month(), dateOnly.Day(), startTime.Hour(), startTime.Minute(), 0, 0, time.Local)
-			endDateTime := time.Date(dateOnly.Year(), dateOnly.Month(), dateOnly.Day(), endTime.Hour(), endTime.Minute(), 0, 0, time.Local)
-
-			if startDateTime.After(endDateTime) {
-				dialog.ShowError(errors.New("end time cannot be before start time"), w)
-				return
-			}
-
-			reservation := Reservation{
-				RoomName:  roomName,
-				Date:      date,
-				StartTime: startDateTime,
-				EndTime:   endDateTime,
-				Purpose:   purpose,
-				Leader:    leader,
-				Student:   student,
-				Priority:  getPriority(purpose),
-				Active:    true,
-			}
-
 			// Show booking confirmation
-			showBookingConfirmation(reservation, w, func() {
-				// Proceed with reservation using Command pattern
-				cmd := &ReservationCommand{
-					reservation: reservation,
-					room:        room,
+			showBookingConfirmation(reservations, w, func() {
+				// Proceed with reservation using Command pattern so the whole batch undoes at once
+				cmd, err := newBatchReservationCommand(reservations)
+				if err == nil {
+					err = executeCommand(cmd)
 				}
-				err := cmd.room.Reserve(cmd.reservation)
 				if err != nil {
+					if conflicts := findBookingConflicts(reservations, interval); len(conflicts) > 0 {
+						err = fmt.Errorf("the following slots are already booked:\n%s", strings.Join(conflicts, "\n"))
+					}
 					dialog.ShowError(err, w)
 				} else {
-					undoStack = append(undoStack, cmd)
-					// Clear redo stack
-					redoStack = []Command{}
-					dialog.ShowInformation("Success", fmt.Sprintf("Room '%s' has been reserved on %s from %s to %s.", roomName, date, startTimeStr, endTimeStr), w)
-					// Refresh the grid view
-					content.Objects = []fyne.CanvasObject{createGridScheduleView(content, interval, w)}
-					content.Refresh()
+					d.Hide()
+					notifyReservations(eventBooked, reservations)
+					dialog.ShowInformation("Success", fmt.Sprintf("%d reservation(s) have been made on %s.", len(reservations), date), w)
+					onBooked()
 				}
 			})
 		},
 	}
 
-	dialog.ShowCustom("Make Reservation", "Close", container.NewVBox(form), w)
+	d = dialog.NewCustom("Make Reservation", "Close", container.NewVBox(form), w)
+	d.Show()
 }
 
-func showBookingConfirmation(reservation Reservation, w fyne.Window, onConfirm func()) {
+func showBookingConfirmation(reservations []Reservation, w fyne.Window, onConfirm func()) {
+	lines := []string{}
+	for _, reservation := range reservations {
+		lines = append(lines, fmt.Sprintf("%s: %s - %s",
+			reservation.RoomName,
+			formatForViewer(reservation.StartTime),
+			formatForViewer(reservation.EndTime),
+		))
+	}
+	first := reservations[0]
 	content := widget.NewLabel(fmt.Sprintf(
-		"Room: %s\nDate: %s\nTime: %s - %s\nPurpose: %s\nName: %s\nInfo: %s",
-		reservation.RoomName,
-		reservation.Date,
-		reservation.StartTime.Format("3:04 PM"),
-		reservation.EndTime.Format("3:04 PM"),
-		reservation.Purpose,
-		reservation.Leader,
-		reservation.Student,
+		"Date: %s\n%s\nPurpose: %s\nName: %s\nInfo: %s",
+		first.Date,
+		strings.Join(lines, "\n"),
+		first.Purpose,
+		first.Leader,
+		first.Student,
 	))
 	dialog.ShowCustomConfirm("Confirm Booking", "Confirm", "Cancel", content, func(confirmed bool) {
 		if confirmed {
@@ -770,44 +1004,143 @@ func showBookingConfirmation(reservation Reservation, w fyne.Window, onConfirm f
 	}, w)
 }
 
-// Undo and Redo functions
-func undo() {
-	if len(undoStack) == 0 {
-		return
+// canModifyReservation reports whether the current user may cancel or edit res.
+func canModifyReservation(res Reservation) bool {
+	if currentUser == nil {
+		return false
 	}
-	cmd := undoStack[len(undoStack)-1]
-	undoStack = undoStack[:len(undoStack)-1]
-	cmd.Undo()
-	redoStack = append(redoStack, cmd)
+	return currentUser.Role == "Admin" || (res.BookedBy != "" && res.BookedBy == currentUser.Username)
 }
 
-func redo() {
-	if len(redoStack) == 0 {
+func showReservationDetails(room *Room, index int, onChanged func(), w fyne.Window) {
+	res := room.Reservations[index]
+	details := widget.NewLabel(fmt.Sprintf(
+		"Room: %s\nDate: %s\nTime: %s - %s\nPurpose: %s\nName: %s\nInfo: %s",
+		res.RoomName,
+		res.Date,
+		formatForViewer(res.StartTime),
+		formatForViewer(res.EndTime),
+		res.Purpose,
+		res.Leader,
+		res.Student,
+	))
+	if res.NoShow {
+		details.SetText(details.Text + "\nMarked as a no-show")
+	}
+	if !canModifyReservation(res) {
+		dialog.ShowCustom("Reservation", "Close", details, w)
 		return
 	}
-	cmd := redoStack[len(redoStack)-1]
-	redoStack = redoStack[:len(redoStack)-1]
-	cmd.Execute()
-	undoStack = append(undoStack, cmd)
+
+	var d dialog.Dialog
+	cancelButton := widget.NewButton("Cancel Reservation", func() {
+		dialog.ShowConfirm("Cancel Reservation", "Are you sure you want to cancel this reservation?", func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			if err := executeCommand(&CancelReservationCommand{room: room, index: index}); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			notifyCancellation(res)
+			d.Hide()
+			onChanged()
+		}, w)
+	})
+	rescheduleButton := widget.NewButton("Reschedule", func() {
+		d.Hide()
+		openRescheduleForm(room, index, onChanged, w)
+	})
+	body := container.NewVBox(details, rescheduleButton, cancelButton)
+	// Admins record no-shows once a booking has started, for the analytics
+	if currentUser.Role == "Admin" && res.Active && res.StartTime.Before(time.Now()) {
+		noShowLabel := "Mark No-Show"
+		if res.NoShow {
+			noShowLabel = "Clear No-Show"
+		}
+		body.Add(widget.NewButton(noShowLabel, func() {
+			after := res
+			after.NoShow = !res.NoShow
+			if err := executeCommand(&EditReservationCommand{room: room, index: index, before: res, after: after}); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			d.Hide()
+			onChanged()
+		}))
+	}
+	d = dialog.NewCustom("Reservation", "Close", body, w)
+	d.Show()
+}
+
+// openRescheduleForm lets the owner of a reservation change its time and details.
+func openRescheduleForm(room *Room, index int, onDone func(), w fyne.Window) {
+	before := room.Reservations[index]
+
+	startEntry := widget.NewEntry()
+	startEntry.SetText(formatClock(before.StartTime))
+	endEntry := widget.NewEntry()
+	endEntry.SetText(formatClock(before.EndTime))
+	purposeSelect := widget.NewSelect([]string{
+		"Meeting",
+		"Study Session",
+		"Presentation",
+		"Other",
+	}, nil)
+	purposeSelect.SetSelected(before.Purpose)
+	leaderEntry := widget.NewEntry()
+	leaderEntry.SetText(before.Leader)
+	studentEntry := widget.NewEntry()
+	studentEntry.SetText(before.Student)
+
+	form := dialog.NewForm("Reschedule Reservation", "Save", "Cancel", []*widget.FormItem{
+		{Text: "Room", Widget: widget.NewLabel(room.Name)},
+		{Text: "Date", Widget: widget.NewLabel(before.Date)},
+		{Text: "Start Time", Widget: startEntry},
+		{Text: "End Time", Widget: endEntry},
+		{Text: "Purpose", Widget: purposeSelect},
+		{Text: "Your Name", Widget: leaderEntry},
+		{Text: "Additional Info", Widget: studentEntry},
+	}, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if leaderEntry.Text == "" {
+			dialog.ShowError(errors.New("please enter your name"), w)
+			return
+		}
+
+		block := bookingBlock{RoomID: room.ID, RoomName: room.Name, Start: before.StartTime, End: before.EndTime}
+		after, err := newBlockReservation(before.Date, block, startEntry.Text, endEntry.Text, purposeSelect.Selected, leaderEntry.Text, studentEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		after.BookedBy = before.BookedBy
+		after.BookedAt = before.BookedAt
+
+		err = executeCommand(&EditReservationCommand{room: room, index: index, before: before, after: after})
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		notifyChange(before, after)
+		onDone()
+	}, w)
+	form.Resize(fyne.NewSize(400, 400))
+	form.Show()
 }
 
 // Load and save reservations
 func loadReservations() {
-	file, err := os.Open("reservations.json")
+	err := loadJSONFile("reservations.json", &rooms)
 	if os.IsNotExist(err) {
 		log.Println("reservations.json file not found, creating a new one.")
+		assignRoomIDs()
 		saveReservations()
 		return
 	} else if err != nil {
-		log.Printf("Error opening reservations file: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&rooms)
-	if err != nil {
-		log.Printf("Error decoding reservations: %v\n", err)
+		log.Printf("Error loading reservations: %v\n", err)
 		return
 	}
 
@@ -817,98 +1150,57 @@ func loadReservations() {
 			room.Reservations = []Reservation{}
 		}
 	}
+	if assignRoomIDs() {
+		saveReservations()
+	}
 }
 
 func saveReservations() {
-	file, err := os.Create("reservations.json")
-	if err != nil {
+	scheduleReminders()
+	if err := saveJSONFile("reservations.json", &rooms); err != nil {
 		log.Printf("Error saving reservations: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(&rooms)
-	if err != nil {
-		log.Printf("Error encoding reservations: %v\n", err)
 	}
 }
 
-// Load and save users
-func loadUsers(w fyne.Window) {
-	file, err := os.Open("users.json")
+// Load and save users. A missing file is left for the setup wizard.
+func loadUsers() {
+	err := loadJSONFile("users.json", &users)
 	if os.IsNotExist(err) {
-		log.Println("users.json file not found, creating a new one.")
-		// Since no user exists, prompt admin creation
-		dialog.ShowInformation("First-time setup", "No admin found. Please create an admin account.", w)
-		showAdminRegistration(nil, w) // Show admin registration form
-		return
+		log.Println("users.json file not found, setup will create the first admin.")
 	} else if err != nil {
-		log.Printf("Error opening users file: %v\n", err)
-		return
+		log.Printf("Error loading users: %v\n", err)
+		usersUnreadable = true
 	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&users)
-	if err != nil {
-		log.Printf("Error decoding users: %v\n", err)
-		return
+	for _, names := range caseDuplicateUsers() {
+		log.Printf("Accounts %s differ only in case; only the first can sign in. Delete the others in Manage Users.\n", strings.Join(names, ", "))
 	}
+}
 
-	// Check if any admin exists
-	adminExists := false
+// caseDuplicateUsers returns the usernames of accounts whose names differ
+// only in case, which earlier versions allowed, grouped by username key.
+func caseDuplicateUsers() map[string][]string {
+	byKey := map[string][]string{}
 	for _, user := range users {
-		if user.Role == "Admin" {
-			adminExists = true
-			break
-		}
-	}
-
-	if !adminExists {
-		dialog.ShowInformation("No Admin Account", "There are no admin accounts. Please create an admin account.", w)
-		showAdminRegistration(nil, w) // Show admin registration form if no admin exists
+		key := usernameKey(user.Username)
+		byKey[key] = append(byKey[key], user.Username)
 	}
-}
-
-func showAdminRegistration(content *fyne.Container, w fyne.Window) {
-	usernameEntry := widget.NewEntry()
-	passwordEntry := widget.NewPasswordEntry()
-	confirmPasswordEntry := widget.NewPasswordEntry()
-
-	form := dialog.NewForm("Admin Registration", "Register", "Cancel", []*widget.FormItem{
-		{Text: "Username", Widget: usernameEntry},
-		{Text: "Password", Widget: passwordEntry},
-		{Text: "Confirm Password", Widget: confirmPasswordEntry},
-	}, func(confirmed bool) {
-		if confirmed {
-			if passwordEntry.Text != confirmPasswordEntry.Text {
-				dialog.ShowError(errors.New("passwords do not match"), w)
-				return
-			}
-			err := createUser(usernameEntry.Text, passwordEntry.Text, "Admin")
-			if err != nil {
-				dialog.ShowError(err, w)
-			} else {
-				dialog.ShowInformation("Success", "Admin account created successfully", w)
-			}
+	for key, names := range byKey {
+		if len(names) < 2 {
+			delete(byKey, key)
 		}
-	}, w)
-	form.Show()
+	}
+	return byKey
 }
 
 func saveUsers() {
-	file, err := os.Create("users.json")
-	if err != nil {
-		log.Printf("Error saving users: %v\n", err)
+	scheduleReminders()
+	if usersUnreadable {
+		// Never replace accounts that failed to load
+		log.Println("Not saving users: users.json could not be read")
 		return
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(&users)
-	if err != nil {
-		log.Printf("Error encoding users: %v\n", err)
+	if err := saveJSONFile("users.json", &users); err != nil {
+		log.Printf("Error saving users: %v\n", err)
 	}
 }
 
@@ -918,32 +1210,58 @@ func showAdminTab(content *fyne.Container, w fyne.Window) {
 		dialog.ShowInformation("Access Denied", "You do not have permission to access this feature.", w)
 		return
 	}
-	content.Objects = []fyne.CanvasObject{createAdminPanel(content, w)}
-	content.Refresh()
+	showView(content, func() fyne.CanvasObject {
+		return createAdminPanel(content, w)
+	})
 }
 
 func createAdminPanel(content *fyne.Container, w fyne.Window) fyne.CanvasObject {
 	addRoomButton := widget.NewButton("Add Room", func() {
 		roomNameEntry := widget.NewEntry()
+		roomNameEntry.Validator = entryValidator(func(name string) error {
+			return validateRoomName(name, nil)
+		})
+		floorIDs := map[string]string{}
+		floorLabels := []string{}
+		for _, floor := range allFloors() {
+			floorLabels = append(floorLabels, floor.Label())
+			floorIDs[floor.Label()] = floor.Floor.ID
+		}
+		floorSelect := widget.NewSelect(floorLabels, nil)
+		if floor, ok := findFloor(selectedFloorID); ok {
+			floorSelect.SetSelected(floor.Label())
+		} else if len(floorLabels) > 0 {
+			floorSelect.SetSelected(floorLabels[0])
+		}
 		form := dialog.NewForm("Add Room", "Add", "Cancel", []*widget.FormItem{
 			{Text: "Room Name", Widget: roomNameEntry},
+			{Text: "Floor", Widget: floorSelect},
 		}, func(confirm bool) {
 			if confirm {
-				roomName := roomNameEntry.Text
-				if roomName == "" {
-					dialog.ShowError(errors.New("room name cannot be empty"), w)
+				roomName := normalizeRoomName(roomNameEntry.Text)
+				if err := validateRoomName(roomName, nil); err != nil {
+					dialog.ShowError(err, w)
 					return
 				}
-				addRoom(roomName, w)
-				content.Objects = []fyne.CanvasObject{createAdminPanel(content, w)}
-				content.Refresh()
+				addRoom(roomName, floorIDs[floorSelect.Selected], w)
+				showView(content, func() fyne.CanvasObject {
+					return createAdminPanel(content, w)
+				})
 			}
 		}, w)
 		form.Resize(fyne.NewSize(400, 200))
 		form.Show()
 	})
 
-	manageUsersButton := widget.NewButton("Manage Users", func() {
+	manageRoomsButton := widget.NewButton("Manage Rooms", func() {
+		manageRooms(w)
+	})
+
+	manageUsersLabel := "Manage Users"
+	if pending := countPendingUsers(); pending > 0 {
+		manageUsersLabel = fmt.Sprintf("Manage Users (%d awaiting approval)", pending)
+	}
+	manageUsersButton := widget.NewButton(manageUsersLabel, func() {
 		manageUsers(w)
 	})
 
@@ -951,56 +1269,240 @@ func createAdminPanel(content *fyne.Container, w fyne.Window) fyne.CanvasObject
 		uploadFloorPlan(w)
 	})
 
+	manageLocationsButton := widget.NewButton("Manage Locations", func() {
+		manageLocations(w)
+	})
+
+	analyticsButton := widget.NewButton("Analytics", func() {
+		showAnalytics(w)
+	})
+
+	reportsButton := widget.NewButton("Reports", func() {
+		showReports(w)
+	})
+
+	webhooksButton := widget.NewButton("Webhooks", func() {
+		manageWebhooks(w)
+	})
+
+	authButton := widget.NewButton("Authentication", func() {
+		showAuthSettings(w)
+	})
+
+	registrationButton := widget.NewButton("Registration", func() {
+		showRegistrationSettings(w)
+	})
+
+	sessionsButton := widget.NewButton("Sessions", func() {
+		showSessions(w)
+	})
+
+	encryptionButton := widget.NewButton("Encryption", func() {
+		showEncryptionSettings(w)
+	})
+
 	settingsButton := widget.NewButton("Settings", func() {
 		showSettings(w)
 	})
 
 	return container.NewVBox(
 		addRoomButton,
+		manageRoomsButton,
 		manageUsersButton,
+		manageLocationsButton,
 		uploadFloorPlanButton,
+		analyticsButton,
+		reportsButton,
+		webhooksButton,
+		authButton,
+		registrationButton,
+		sessionsButton,
+		encryptionButton,
 		settingsButton,
 	)
 }
 
-func addRoom(name string, w fyne.Window) {
-	rooms = append(rooms, &Room{Name: name})
-	saveReservations()
+func addRoom(name, floorID string, w fyne.Window) {
+	err := executeCommand(&AddRoomCommand{room: &Room{Name: name, FloorID: floorID}})
+	if err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
 	dialog.ShowInformation("Room Added", fmt.Sprintf("Room '%s' has been successfully added.", name), w)
 }
 
-func manageUsers(w fyne.Window) {
-	// Implement user management UI
-	dialog.ShowInformation("Manage Users", "User management is not implemented yet.", w)
+func indexOfRoom(room *Room) int {
+	for i, r := range rooms {
+		if r == room {
+			return i
+		}
+	}
+	return -1
 }
 
-func uploadFloorPlan(w fyne.Window) {
-	fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
-		if err != nil || reader == nil {
-			return
-		}
-		defer reader.Close()
+func removeRoomAt(index int) {
+	if index < 0 || index >= len(rooms) {
+		return
+	}
+	rooms = append(rooms[:index], rooms[index+1:]...)
+	saveReservations()
+}
 
-		// Save the uploaded image
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			dialog.ShowError(err, w)
-			return
+// renameRoom only checks that no other room has the name, so undo can
+// restore a name from before the current naming rules.
+func renameRoom(room *Room, name string) error {
+	if existing := roomNamed(name); existing != nil && existing != room {
+		return fmt.Errorf("room '%s' already exists", existing.Name)
+	}
+	room.mu.Lock()
+	room.Name = name
+	for i := range room.Reservations {
+		room.Reservations[i].RoomName = name
+	}
+	saveReservations()
+	room.mu.Unlock()
+	return nil
+}
+
+func roomNames() []string {
+	names := []string{}
+	for _, room := range rooms {
+		names = append(names, room.Name)
+	}
+	return names
+}
+
+func manageUsers(w fyne.Window) {
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.Objects = nil
+		duplicates := caseDuplicateUsers()
+		for _, user := range users {
+			userCopy := user
+			roleSelect := widget.NewSelect([]string{"User", "Admin"}, nil)
+			roleSelect.SetSelected(userCopy.Role)
+			roleSelect.OnChanged = func(role string) {
+				if role == userCopy.Role {
+					return
+				}
+				err := executeCommand(&SetUserRoleCommand{username: userCopy.Username, oldRole: userCopy.Role, newRole: role})
+				if err != nil {
+					dialog.ShowError(err, w)
+				}
+				rebuild()
+			}
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				if currentUser != nil && currentUser.Username == userCopy.Username {
+					dialog.ShowError(errors.New("you cannot delete your own account"), w)
+					return
+				}
+				dialog.ShowConfirm("Delete User", fmt.Sprintf("Delete user '%s'?", userCopy.Username), func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+					if err := executeCommand(&DeleteUserCommand{user: userCopy}); err != nil {
+						dialog.ShowError(err, w)
+					}
+					rebuild()
+				}, w)
+			})
+			name := userCopy.Username
+			if len(duplicates[usernameKey(name)]) > 1 {
+				// Sign-in and other lookups reach only the first of these
+				name += " (same name as another account)"
+			}
+			if userCopy.Source == authSourceLDAP {
+				// The role follows directory groups at each sign-in
+				name += " (LDAP)"
+				roleSelect.Disable()
+			}
+			actions := container.NewHBox(roleSelect, deleteButton)
+			if userCopy.Source == authSourceLocal {
+				resetButton := widget.NewButton("Reset Password", func() {
+					showResetPassword(userCopy.Username, w, rebuild)
+				})
+				actions.Objects = append([]fyne.CanvasObject{resetButton}, actions.Objects...)
+			}
+			if userCopy.Pending {
+				name += " (awaiting approval)"
+				approveButton := widget.NewButton("Approve", func() {
+					if err := approveUser(userCopy.Username); err != nil {
+						dialog.ShowError(err, w)
+					}
+					rebuild()
+				})
+				approveButton.Importance = widget.HighImportance
+				actions.Objects = append([]fyne.CanvasObject{approveButton}, actions.Objects...)
+			}
+			if userCopy.TOTPEnabled {
+				resetTwoFactorButton := widget.NewButton("Reset 2FA", func() {
+					dialog.ShowConfirm("Reset Two-Factor Authentication",
+						fmt.Sprintf("Turn off two-factor authentication for '%s'? They will need to set it up again.", userCopy.Username),
+						func(confirmed bool) {
+							if !confirmed {
+								return
+							}
+							if err := resetTwoFactor(userCopy.Username); err != nil {
+								dialog.ShowError(err, w)
+							}
+							rebuild()
+						}, w)
+				})
+				actions.Objects = append([]fyne.CanvasObject{resetTwoFactorButton}, actions.Objects...)
+			}
+			if isLockedOut(userCopy) {
+				name += " (locked)"
+				actions.Objects = append([]fyne.CanvasObject{widget.NewButton("Unlock", func() {
+					if err := unlockUser(userCopy.Username); err != nil {
+						dialog.ShowError(err, w)
+					}
+					rebuild()
+				})}, actions.Objects...)
+			}
+			list.Add(container.NewBorder(nil, nil, nil, actions, widget.NewLabel(name)))
 		}
-		err = os.WriteFile(floorPlanImagePath, data, 0644)
-		if err != nil {
-			dialog.ShowError(err, w)
-			return
+		list.Refresh()
+	}
+	rebuild()
+
+	addUserButton := widget.NewButtonWithIcon("Add User", theme.ContentAddIcon(), func() {
+		usernameEntry := widget.NewEntry()
+		emailEntry := widget.NewEntry()
+		passwordEntry := widget.NewPasswordEntry()
+		usernameEntry.Validator = entryValidator(validateNewUsername)
+		emailEntry.Validator = entryValidator(validateEmail)
+		passwordEntry.Validator = func(password string) error {
+			return validatePassword(usernameEntry.Text, password, nil)
 		}
-		dialog.ShowInformation("Success", "Floor plan uploaded successfully.", w)
-	}, w)
-	fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png", ".jpg", ".jpeg"}))
-	fileDialog.Show()
-}
+		roleSelect := widget.NewSelect([]string{"User", "Admin"}, nil)
+		roleSelect.SetSelected("User")
+		form := dialog.NewForm("Add User", "Add", "Cancel", []*widget.FormItem{
+			{Text: "Username", Widget: usernameEntry},
+			{Text: "Email", Widget: emailEntry},
+			{Text: "Password", Widget: passwordEntry, HintText: passwordPolicyHint()},
+			{Text: "Role", Widget: roleSelect},
+		}, func(confirm bool) {
+			if confirm {
+				err := executeCommand(&CreateUserCommand{username: usernameEntry.Text, password: passwordEntry.Text, role: roleSelect.Selected, email: strings.TrimSpace(emailEntry.Text)})
+				if err != nil {
+					dialog.ShowError(err, w)
+				}
+				rebuild()
+			}
+		}, w)
+		form.Resize(fyne.NewSize(400, 250))
+		form.Show()
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(450, 300))
+	failedButton := widget.NewButton("Failed Sign-Ins", func() {
+		showLoginAttempts(w)
+	})
 
-func showSettings(w fyne.Window) {
-	// Implement settings UI
-	dialog.ShowInformation("Settings", "Settings are not implemented yet.", w)
+	dialog.ShowCustom("Manage Users", "Close", container.NewBorder(nil, container.NewHBox(addUserButton, failedButton), nil, nil, scroll), w)
 }
 
 // Custom ColorButton with enhancements
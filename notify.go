@@ -0,0 +1,507 @@
+// notify.go
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Notification events, used as template names
+const (
+	eventBooked    = "booked"
+	eventChanged   = "changed"
+	eventCancelled = "cancelled"
+	eventBumped    = "bumped"
+	eventReminder  = "reminder"
+)
+
+// notificationEvents lists the events users can opt out of, with their labels
+var notificationEvents = []struct {
+	Event string
+	Label string
+}{
+	{eventBooked, "Booking confirmations"},
+	{eventChanged, "Booking changes"},
+	{eventCancelled, "Cancellations"},
+	{eventBumped, "Bookings cancelled by someone else"},
+	{eventReminder, "Reminders"},
+}
+
+// Notification senders selectable in the settings
+const (
+	senderOff  = "Off"
+	senderSMTP = "SMTP"
+	senderFile = "File"
+)
+
+// defaultReminderMinutes is the reminder lead time when none is configured
+const defaultReminderMinutes = 15
+
+// notificationTemplateDir holds optional overrides of the built-in templates,
+// named after the event, e.g. templates/booked.txt
+const notificationTemplateDir = "templates"
+
+// NotificationSettings configures how notifications are sent
+type NotificationSettings struct {
+	Sender          string // senderOff, senderSMTP or senderFile
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	From            string
+	OutputFile      string // Where the File sender writes; empty for stdout
+	ReminderMinutes int    // Default lead time for reminders
+}
+
+// Sender delivers a rendered notification
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends notifications through an SMTP server, using STARTTLS
+// when the server offers it.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	return smtp.SendMail(addr, auth, s.From, []string{to}, formatEmail(s.From, to, subject, body))
+}
+
+// FileSender appends notifications to a file, or prints them to stdout when
+// Path is empty. It is meant for testing without a mail server.
+type FileSender struct {
+	Path string
+	From string
+}
+
+var fileSenderMu sync.Mutex
+
+func (s *FileSender) Send(to, subject, body string) error {
+	fileSenderMu.Lock()
+	defer fileSenderMu.Unlock()
+
+	var out io.Writer = os.Stdout
+	if s.Path != "" {
+		file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	_, err := fmt.Fprintf(out, "%s\n", formatEmail(s.From, to, subject, body))
+	return err
+}
+
+// formatEmail builds a plain-text message with the usual headers.
+func formatEmail(from, to, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// currentSender returns the sender chosen in the settings, or nil when
+// notifications are off.
+func currentSender() Sender {
+	config := settings.Notifications
+	switch config.Sender {
+	case senderSMTP:
+		return &SMTPSender{Host: config.SMTPHost, Port: config.SMTPPort, Username: config.SMTPUsername, Password: config.SMTPPassword, From: config.From}
+	case senderFile:
+		return &FileSender{Path: config.OutputFile, From: config.From}
+	}
+	return nil
+}
+
+// Built-in templates. The first line is the subject, the rest after a blank
+// line is the body.
+var defaultNotificationTemplates = map[string]string{
+	eventBooked: `Subject: Booking confirmed: {{(index .Reservations 0).Room}} on {{(index .Reservations 0).Date}}
+
+Hello {{.User}},
+
+The following reservation(s) have been made for you:
+{{range .Reservations}}
+  {{.Room}}, {{.Date}} {{.Start}} - {{.End}} ({{.Purpose}})
+{{- end}}
+`,
+	eventChanged: `Subject: Booking changed: {{.Before.Room}} on {{.Before.Date}}
+
+Hello {{.User}},
+
+{{.Actor}} changed your reservation of {{.Before.Room}}.
+
+Before: {{.Before.Date}} {{.Before.Start}} - {{.Before.End}} ({{.Before.Purpose}})
+Now:    {{.After.Date}} {{.After.Start}} - {{.After.End}} ({{.After.Purpose}})
+`,
+	eventCancelled: `Subject: Booking cancelled: {{(index .Reservations 0).Room}} on {{(index .Reservations 0).Date}}
+
+Hello {{.User}},
+
+Your reservation has been cancelled:
+{{range .Reservations}}
+  {{.Room}}, {{.Date}} {{.Start}} - {{.End}} ({{.Purpose}})
+{{- end}}
+`,
+	eventBumped: `Subject: Your booking of {{(index .Reservations 0).Room}} was cancelled by {{.Actor}}
+
+Hello {{.User}},
+
+{{.Actor}} cancelled your reservation so the room can be used for something else:
+{{range .Reservations}}
+  {{.Room}}, {{.Date}} {{.Start}} - {{.End}} ({{.Purpose}})
+{{- end}}
+
+Please book another room or time if you still need one.
+`,
+	eventReminder: `Subject: Reminder: {{(index .Reservations 0).Room}} at {{(index .Reservations 0).Start}}
+
+Hello {{.User}},
+
+Your reservation starts in {{.Minutes}} minutes:
+{{range .Reservations}}
+  {{.Room}}, {{.Date}} {{.Start}} - {{.End}} ({{.Purpose}})
+{{- end}}
+`,
+}
+
+// reservationView is a reservation formatted for a notification
+type reservationView struct {
+	Room    string
+	Date    string
+	Start   string
+	End     string
+	Purpose string
+	Leader  string
+}
+
+func viewOf(res Reservation) reservationView {
+	return reservationView{
+		Room:    res.RoomName,
+		Date:    res.Date,
+		Start:   res.StartTime.In(siteLocation()).Format(timeLayout12Hour + " MST"),
+		End:     res.EndTime.In(siteLocation()).Format(timeLayout12Hour + " MST"),
+		Purpose: res.Purpose,
+		Leader:  res.Leader,
+	}
+}
+
+// notificationData is what templates can refer to
+type notificationData struct {
+	User         string
+	Actor        string // Who made the change
+	Reservations []reservationView
+	Before       reservationView // Changes only
+	After        reservationView
+	Minutes      int // Reminders only
+}
+
+// renderNotification fills in the template for event, preferring an
+// override in the templates directory.
+func renderNotification(event string, data notificationData) (string, string, error) {
+	text := defaultNotificationTemplates[event]
+	if custom, err := os.ReadFile(filepath.Join(notificationTemplateDir, event+".txt")); err == nil {
+		text = string(custom)
+	}
+	tmpl, err := template.New(event).Parse(text)
+	if err != nil {
+		return "", "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", "", err
+	}
+
+	subject, body, _ := strings.Cut(out.String(), "\n")
+	return strings.TrimSpace(strings.TrimPrefix(subject, "Subject:")), strings.TrimLeft(body, "\n"), nil
+}
+
+// isMuted reports whether the user has opted out of event.
+func isMuted(user *User, event string) bool {
+	for _, muted := range user.MutedNotifications {
+		if muted == event {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsNotification reports whether the user has an address and has not
+// opted out of event.
+func wantsNotification(user *User, event string) bool {
+	return user != nil && user.Email != "" && !isMuted(user, event)
+}
+
+// sendNotification renders and sends event to a user in the background, so
+// a slow mail server never blocks the UI.
+func sendNotification(username, event string, data notificationData) {
+	sender := currentSender()
+	user := findUser(username)
+	if sender == nil || !wantsNotification(user, event) {
+		return
+	}
+	data.User = user.Username
+	deliverNotification(sender, user.Email, event, data)
+}
+
+// deliverNotification renders event and sends it in the background. It
+// reads no app state, so the reminder goroutine can call it too.
+func deliverNotification(sender Sender, to, event string, data notificationData) {
+	subject, body, err := renderNotification(event, data)
+	if err != nil {
+		log.Printf("Error rendering %s notification: %v\n", event, err)
+		return
+	}
+	go func() {
+		if err := sender.Send(to, subject, body); err != nil {
+			log.Printf("Error sending %s notification to %s: %v\n", event, to, err)
+		}
+	}()
+}
+
+// notifyReservations sends one notification per owner for reservations
+// created or cancelled together.
+func notifyReservations(event string, reservations []Reservation) {
+	byOwner := map[string][]reservationView{}
+	owners := []string{}
+	for _, res := range reservations {
+		if res.BookedBy == "" {
+			continue
+		}
+		if _, ok := byOwner[res.BookedBy]; !ok {
+			owners = append(owners, res.BookedBy)
+		}
+		byOwner[res.BookedBy] = append(byOwner[res.BookedBy], viewOf(res))
+	}
+	for _, owner := range owners {
+		sendNotification(owner, event, notificationData{Actor: actorName(), Reservations: byOwner[owner]})
+	}
+}
+
+// notifyCancellation tells the owner their reservation was cancelled, as a
+// bump notice when someone else cancelled it.
+func notifyCancellation(res Reservation) {
+	event := eventCancelled
+	if currentUser != nil && currentUser.Username != res.BookedBy {
+		event = eventBumped
+	}
+	notifyReservations(event, []Reservation{res})
+}
+
+// notifyChange tells the owner their reservation was rescheduled or edited.
+func notifyChange(before, after Reservation) {
+	if after.BookedBy == "" {
+		return
+	}
+	sendNotification(after.BookedBy, eventChanged, notificationData{Actor: actorName(), Before: viewOf(before), After: viewOf(after)})
+}
+
+func actorName() string {
+	if currentUser == nil {
+		return "Someone"
+	}
+	return currentUser.Username
+}
+
+// siteReminderMinutes is the reminder lead time for users who have not chosen one.
+func siteReminderMinutes() int {
+	if settings.Notifications.ReminderMinutes > 0 {
+		return settings.Notifications.ReminderMinutes
+	}
+	return defaultReminderMinutes
+}
+
+// reminderLead returns how long before a reservation the user is reminded.
+func reminderLead(user *User) time.Duration {
+	minutes := user.ReminderMinutes
+	if minutes <= 0 {
+		minutes = siteReminderMinutes()
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// scheduledReminder is a reminder for one upcoming booking, worked out in
+// advance so the reminder goroutine never reads rooms, users or settings
+type scheduledReminder struct {
+	remindAt time.Time
+	to       string
+	data     notificationData
+}
+
+var reminderMu sync.Mutex
+var reminderSender Sender
+var scheduledReminders []scheduledReminder
+
+// scheduleReminders works out the reminders for bookings that have not
+// started yet. It is called on the UI goroutine whenever rooms, users or
+// settings are saved; saves happen with a room lock held, and all changes
+// are made on that goroutine, so it reads the rooms without locking them.
+func scheduleReminders() {
+	sender := currentSender()
+	now := time.Now()
+	scheduled := []scheduledReminder{}
+	for _, room := range rooms {
+		for _, res := range room.Reservations {
+			if !res.Active || !res.StartTime.After(now) {
+				continue
+			}
+			user := findUser(res.BookedBy)
+			if !wantsNotification(user, eventReminder) {
+				continue
+			}
+			lead := reminderLead(user)
+			scheduled = append(scheduled, scheduledReminder{
+				remindAt: res.StartTime.Add(-lead),
+				to:       user.Email,
+				data:     notificationData{User: user.Username, Reservations: []reservationView{viewOf(res)}, Minutes: int(lead.Minutes())},
+			})
+		}
+	}
+
+	reminderMu.Lock()
+	reminderSender = sender
+	scheduledReminders = scheduled
+	reminderMu.Unlock()
+}
+
+// startReminders checks every minute for reservations whose reminder time
+// has just passed. Only times after the app started are considered, so
+// restarting does not resend reminders.
+func startReminders() {
+	scheduleReminders()
+	last := time.Now()
+	go func() {
+		for now := range time.NewTicker(time.Minute).C {
+			sendDueReminders(last, now)
+			last = now
+		}
+	}()
+}
+
+// sendDueReminders sends the scheduled reminders falling after after and
+// up to until.
+func sendDueReminders(after, until time.Time) {
+	reminderMu.Lock()
+	sender := reminderSender
+	due := []scheduledReminder{}
+	for _, r := range scheduledReminders {
+		if r.remindAt.After(after) && !r.remindAt.After(until) {
+			due = append(due, r)
+		}
+	}
+	reminderMu.Unlock()
+
+	if sender == nil {
+		return
+	}
+	for _, r := range due {
+		deliverNotification(sender, r.to, eventReminder, r.data)
+	}
+}
+
+// validateEmail accepts an empty address or a single valid one.
+func validateEmail(address string) error {
+	if address == "" {
+		return nil
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return fieldErrorf(fieldEmail, "invalid email address %q", address)
+	}
+	return nil
+}
+
+// showAccountSettings lets the current user set their email address and
+// which notifications they receive.
+func showAccountSettings(w fyne.Window) {
+	if currentUser == nil {
+		return
+	}
+	emailEntry := widget.NewEntry()
+	emailEntry.SetText(currentUser.Email)
+	emailEntry.SetPlaceHolder("name@example.com")
+
+	checks := container.NewVBox()
+	eventChecks := map[string]*widget.Check{}
+	for _, event := range notificationEvents {
+		check := widget.NewCheck(event.Label, nil)
+		check.SetChecked(!isMuted(currentUser, event.Event))
+		eventChecks[event.Event] = check
+		checks.Add(check)
+	}
+	reminderEntry := widget.NewEntry()
+	if currentUser.ReminderMinutes > 0 {
+		reminderEntry.SetText(strconv.Itoa(currentUser.ReminderMinutes))
+	}
+	reminderEntry.SetPlaceHolder(fmt.Sprintf("Site default (%d)", siteReminderMinutes()))
+
+	form := dialog.NewForm("My Account", "Save", "Cancel", []*widget.FormItem{
+		{Text: "Email", Widget: emailEntry},
+		{Text: "Notify me about", Widget: checks},
+		{Text: "Remind me (minutes before)", Widget: reminderEntry},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		after := profileOf(currentUser)
+		after.Email = strings.TrimSpace(emailEntry.Text)
+		if err := validateEmail(after.Email); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		after.MutedNotifications = nil
+		for _, event := range notificationEvents {
+			if !eventChecks[event.Event].Checked {
+				after.MutedNotifications = append(after.MutedNotifications, event.Event)
+			}
+		}
+		after.ReminderMinutes = 0
+		if text := strings.TrimSpace(reminderEntry.Text); text != "" {
+			minutes, err := strconv.Atoi(text)
+			if err != nil || minutes <= 0 {
+				dialog.ShowError(fmt.Errorf("reminder must be a positive number of minutes"), w)
+				return
+			}
+			after.ReminderMinutes = minutes
+		}
+		err := executeCommand(&SetUserProfileCommand{username: currentUser.Username, before: profileOf(currentUser), after: after})
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+	form.Resize(fyne.NewSize(450, 400))
+	form.Show()
+}
@@ -0,0 +1,203 @@
+// notify_test.go
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSender records the notifications it is asked to send.
+type testSender struct {
+	sent chan string
+}
+
+func (s *testSender) Send(to, subject, body string) error {
+	s.sent <- to + ": " + subject
+	return nil
+}
+
+// useTestReminders schedules the reminders for the current rooms and users
+// and returns a sender that receives them.
+func useTestReminders(t *testing.T) *testSender {
+	t.Helper()
+	scheduleReminders()
+	sender := &testSender{sent: make(chan string, 10)}
+	reminderMu.Lock()
+	reminderSender = sender
+	reminderMu.Unlock()
+	t.Cleanup(func() {
+		reminderMu.Lock()
+		reminderSender, scheduledReminders = nil, nil
+		reminderMu.Unlock()
+	})
+	return sender
+}
+
+// received waits briefly for the notifications sent in the background.
+func (s *testSender) received(n int) []string {
+	var got []string
+	for len(got) < n {
+		select {
+		case msg := <-s.sent:
+			got = append(got, msg)
+		case <-time.After(2 * time.Second):
+			return got
+		}
+	}
+	// Anything more would be a reminder sent twice
+	select {
+	case msg := <-s.sent:
+		got = append(got, msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return got
+}
+
+func TestRenderNotification(t *testing.T) {
+	useTestDataDir(t)
+	settings.TimeZone = "UTC"
+	loadSiteLocation()
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	res := testReservation(start, "Study group")
+	res.RoomName = "Lab A"
+	data := notificationData{User: "alice", Actor: "bob", Reservations: []reservationView{viewOf(res)}}
+
+	subject, body, err := renderNotification(eventBumped, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Your booking of Lab A was cancelled by bob" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.HasPrefix(body, "Hello alice,") || !strings.Contains(body, "Lab A, 2026-03-02 9:00 AM UTC - 10:00 AM UTC (Study group)") {
+		t.Errorf("body = %q", body)
+	}
+
+	// A template in the templates directory replaces the built-in one
+	if err := os.Mkdir(notificationTemplateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	custom := "Subject: {{.Actor}} took {{(index .Reservations 0).Room}}\n\nSorry, {{.User}}.\n"
+	if err := os.WriteFile(filepath.Join(notificationTemplateDir, eventBumped+".txt"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	subject, body, err = renderNotification(eventBumped, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "bob took Lab A" || body != "Sorry, alice.\n" {
+		t.Errorf("custom template gave %q, %q", subject, body)
+	}
+
+	if err := os.WriteFile(filepath.Join(notificationTemplateDir, eventBooked+".txt"), []byte("Subject: {{.Missing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := renderNotification(eventBooked, data); err == nil {
+		t.Error("a broken template rendered without error")
+	}
+}
+
+func TestSendDueReminders(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	users = []User{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob", Email: "bob@example.com", ReminderMinutes: 60},
+	}
+	start := time.Now().Add(2 * time.Hour).Truncate(time.Minute)
+	for _, owner := range []string{"alice", "bob"} {
+		res := testReservation(start, "Meeting")
+		res.RoomName = room.Name
+		res.BookedBy = owner
+		res.Active = true
+		room.Reservations = append(room.Reservations, res)
+	}
+	sender := useTestReminders(t)
+
+	// alice is reminded at the site default of 15 minutes, bob after his own 60
+	aliceAt := start.Add(-defaultReminderMinutes * time.Minute)
+	if got := sender.received(0); len(got) != 0 {
+		t.Fatalf("reminders sent before any check: %v", got)
+	}
+	sendDueReminders(aliceAt.Add(-time.Minute), aliceAt)
+	if got := sender.received(1); len(got) != 1 || !strings.HasPrefix(got[0], "alice@example.com: Reminder: Lab A") {
+		t.Errorf("at alice's reminder time sent %v", got)
+	}
+	// The window excludes its start, so the next check does not repeat it
+	sendDueReminders(aliceAt, aliceAt.Add(time.Minute))
+	if got := sender.received(0); len(got) != 0 {
+		t.Errorf("the following check sent %v", got)
+	}
+	sendDueReminders(start.Add(-61*time.Minute), start.Add(-time.Hour))
+	if got := sender.received(1); len(got) != 1 || !strings.HasPrefix(got[0], "bob@example.com:") {
+		t.Errorf("at bob's reminder time sent %v", got)
+	}
+}
+
+func TestRemindersFollowPreferences(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	users = []User{
+		{Username: "alice", Email: "alice@example.com", MutedNotifications: []string{eventReminder}},
+		{Username: "bob"},
+		{Username: "carol", Email: "carol@example.com", MutedNotifications: []string{eventBooked}},
+	}
+	start := time.Now().Add(2 * time.Hour).Truncate(time.Minute)
+	for _, owner := range []string{"alice", "bob", "carol", "dave"} {
+		res := testReservation(start, "Meeting")
+		res.RoomName = room.Name
+		res.BookedBy = owner
+		res.Active = true
+		room.Reservations = append(room.Reservations, res)
+	}
+	cancelled := testReservation(start, "Cancelled")
+	cancelled.BookedBy = "carol"
+	room.Reservations = append(room.Reservations, cancelled)
+	sender := useTestReminders(t)
+
+	sendDueReminders(time.Now(), start)
+	got := sender.received(1)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "carol@example.com:") {
+		t.Errorf("sent %v, want one reminder to carol", got)
+	}
+
+	// Muting reminders takes effect once the change is saved
+	users[2].MutedNotifications = append(users[2].MutedNotifications, eventReminder)
+	saveUsers()
+	reminderMu.Lock()
+	remaining := len(scheduledReminders)
+	reminderMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d reminders still scheduled after carol muted them", remaining)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	useTestDataDir(t)
+	sender := &FileSender{Path: "outbox.txt", From: "rooms@example.com"}
+	if err := sender.Send("alice@example.com", "First", "Line one\nLine two"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send("bob@example.com", "Second", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("outbox.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"From: rooms@example.com\r\nTo: alice@example.com\r\nSubject: First\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\nLine one\r\nLine two\n",
+		"To: bob@example.com\r\nSubject: Second\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "Subject: First") > strings.Index(out, "Subject: Second") {
+		t.Error("the second message did not follow the first")
+	}
+}
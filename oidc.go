@@ -0,0 +1,638 @@
+// oidc.go
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // Registers SHA-384 and SHA-512 for RS384, RS512 and ES384
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCEmailClaim    = "email"
+	defaultOIDCGroupsClaim   = "groups"
+	oidcSignInTimeout        = 5 * time.Minute
+	oidcClockSkew            = 2 * time.Minute
+)
+
+// OIDCSettings configures single sign-on with an OpenID Connect provider.
+// roomy is registered with the provider as a native (public) client whose
+// redirect URI is http://127.0.0.1/callback on any port.
+type OIDCSettings struct {
+	Enabled       bool
+	Issuer        string // e.g. https://login.example.com/realms/staff
+	ClientID      string
+	ClientSecret  string // Only for providers that require one for native clients
+	Scopes        []string
+	UsernameClaim string
+	EmailClaim    string
+	GroupsClaim   string   // Claim holding the user's groups or roles
+	AdminGroups   []string // Values of GroupsClaim that give the Admin role
+	UserGroups    []string // If set, only these values or AdminGroups may sign in
+}
+
+func (c OIDCSettings) usernameClaim() string {
+	if c.UsernameClaim == "" {
+		return defaultOIDCUsernameClaim
+	}
+	return c.UsernameClaim
+}
+
+func (c OIDCSettings) emailClaim() string {
+	if c.EmailClaim == "" {
+		return defaultOIDCEmailClaim
+	}
+	return c.EmailClaim
+}
+
+func (c OIDCSettings) groupsClaim() string {
+	if c.GroupsClaim == "" {
+		return defaultOIDCGroupsClaim
+	}
+	return c.GroupsClaim
+}
+
+var oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+// oidcDiscovery is the part of the provider metadata roomy uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// getJSON fetches url and decodes the JSON response into v.
+func getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func discoverOIDC(ctx context.Context, issuer string) (oidcDiscovery, error) {
+	var discovery oidcDiscovery
+	err := getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return discovery, fmt.Errorf("could not load the provider configuration: %v", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return discovery, fmt.Errorf("provider reports issuer %q, expected %q", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return discovery, errors.New("provider configuration is missing an endpoint")
+	}
+	return discovery, nil
+}
+
+// randomToken returns n random bytes encoded for use in URLs.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcSignIn runs the authorization code flow with PKCE (RFC 7636) in the
+// system browser, receiving the code on a loopback redirect (RFC 8252), and
+// returns the claims of the validated ID token.
+func oidcSignIn(ctx context.Context, config OIDCSettings, openURL func(*url.URL) error) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, oidcSignInTimeout)
+	defer cancel()
+
+	discovery, err := discoverOIDC(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	state := randomToken(24)
+	nonce := randomToken(24)
+	verifier := randomToken(32)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	type callback struct {
+		code string
+		err  error
+	}
+	results := make(chan callback, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var result callback
+		switch {
+		case query.Get("state") != state:
+			result.err = errors.New("sign-in response did not match the request")
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("sign-in failed: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			result.err = errors.New("sign-in response had no authorization code")
+		default:
+			result.code = query.Get("code")
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<p>Sign-in failed. You can close this window and try again in roomy.</p>")
+		} else {
+			fmt.Fprint(w, "<p>Signed in. You can close this window and return to roomy.</p>")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(append([]string{"openid", "profile", "email"}, config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	if err := openURL(authURL); err != nil {
+		return nil, fmt.Errorf("could not open the browser: %v", err)
+	}
+
+	var result callback
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New("timed out waiting for sign-in")
+		}
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	idToken, err := exchangeOIDCCode(ctx, discovery, config, result.code, redirectURI, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return verifyIDToken(ctx, idToken, discovery, config.ClientID, nonce)
+}
+
+// exchangeOIDCCode redeems an authorization code for an ID token.
+func exchangeOIDCCode(ctx context.Context, discovery oidcDiscovery, config OIDCSettings, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("could not read the token response: %v", err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	return token.IDToken, nil
+}
+
+// jsonWebKey is one key of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWTSignature checks a signature made with one of the asymmetric
+// algorithms OpenID providers use. "none" and shared-secret HMAC are refused.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("signature does not match the key type")
+}
+
+// verifyIDToken checks the signature of an ID token against the provider's
+// keys and validates its issuer, audience, expiry and nonce.
+func verifyIDToken(ctx context.Context, token string, discovery oidcDiscovery, clientID, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errors.New("malformed ID token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("could not load the provider's signing keys: %v", err)
+	}
+	verified := false
+	for _, jwk := range jwks.Keys {
+		if (header.Kid != "" && jwk.Kid != header.Kid) || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		if verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("ID token signature is not valid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed ID token claims")
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed ID token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != discovery.Issuer {
+		return nil, fmt.Errorf("ID token was issued by %q", iss)
+	}
+	audiences := claimStrings(claims["aud"])
+	if !containsString(audiences, clientID) {
+		return nil, errors.New("ID token was not issued for roomy")
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audiences) > 1 && azp != clientID {
+		return nil, errors.New("ID token was not issued for roomy")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("ID token was issued in the future; check this machine's clock")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID token does not match the sign-in request")
+	}
+	return claims, nil
+}
+
+// claimStrings returns a claim that may be a single string or a list.
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcIdentity maps ID token claims to a username, the subject that
+// identifies the account, and a role.
+func oidcIdentity(config OIDCSettings, claims map[string]interface{}) (string, directoryIdentity, error) {
+	username, _ := claims[config.usernameClaim()].(string)
+	if username == "" {
+		return "", directoryIdentity{}, fmt.Errorf("ID token has no %q claim", config.usernameClaim())
+	}
+	identity := directoryIdentity{Groups: claimStrings(claims[config.groupsClaim()])}
+	identity.ID, _ = claims["sub"].(string)
+	identity.Issuer, _ = claims["iss"].(string)
+	if identity.ID == "" || identity.Issuer == "" {
+		return "", directoryIdentity{}, errors.New("ID token has no subject")
+	}
+	if verified, ok := claims["email_verified"].(bool); !ok || verified {
+		identity.Email, _ = claims[config.emailClaim()].(string)
+	}
+	role, ok := roleForGroups(identity.Groups, config.AdminGroups, config.UserGroups)
+	if !ok {
+		return "", directoryIdentity{}, errors.New("your account is not in a group allowed to use roomy")
+	}
+	identity.Role = role
+	return username, identity, nil
+}
+
+// validateOIDCSettings checks the provider settings before they are saved.
+func validateOIDCSettings(c OIDCSettings) error {
+	if !c.Enabled {
+		return nil
+	}
+	parsed, err := url.Parse(c.Issuer)
+	if err != nil || parsed.Host == "" {
+		return errors.New("issuer must be a URL such as https://login.example.com")
+	}
+	// Plain HTTP is only allowed for a provider on this machine, e.g. a test issuer
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && isLoopbackHost(parsed.Hostname())) {
+		return errors.New("issuer must use https")
+	}
+	if c.ClientID == "" {
+		return errors.New("client ID is required")
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// showSSOSignIn signs in with the configured OpenID provider in the browser.
+func showSSOSignIn(w fyne.Window, onSuccess func(*User)) {
+	runOIDCSignIn(settings.OIDC, w, func(claims map[string]interface{}) {
+		username, identity, err := oidcIdentity(settings.OIDC, claims)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		user, err := provisionDirectoryUser(username, authSourceOIDC, identity)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		currentUser = user
+		onSuccess(user)
+	})
+}
+
+// runOIDCSignIn shows a waiting dialog while the browser sign-in runs and
+// calls onClaims with the validated ID token claims.
+func runOIDCSignIn(config OIDCSettings, w fyne.Window, onClaims func(map[string]interface{})) {
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := dialog.NewCustom("Sign in with SSO", "Cancel",
+		widget.NewLabel("Complete the sign-in in your browser."), w)
+	waiting.SetOnClosed(cancel)
+	waiting.Show()
+
+	go func() {
+		claims, err := oidcSignIn(ctx, config, fyne.CurrentApp().OpenURL)
+		if ctx.Err() == context.Canceled {
+			return
+		}
+		waiting.SetOnClosed(nil)
+		waiting.Hide()
+		cancel()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		onClaims(claims)
+	}()
+}
+
+// oidcSettingsForm returns the OpenID Connect tab of the authentication
+// settings and a function reading the entered values.
+func oidcSettingsForm(w fyne.Window) (fyne.CanvasObject, func() OIDCSettings) {
+	config := settings.OIDC
+	enabledCheck := widget.NewCheck("Show Sign in with SSO", nil)
+	enabledCheck.SetChecked(config.Enabled)
+	issuerEntry := widget.NewEntry()
+	issuerEntry.SetText(config.Issuer)
+	issuerEntry.SetPlaceHolder("https://login.example.com/realms/staff")
+	clientIDEntry := widget.NewEntry()
+	clientIDEntry.SetText(config.ClientID)
+	clientSecretEntry := widget.NewPasswordEntry()
+	clientSecretEntry.SetText(config.ClientSecret)
+	scopesEntry := widget.NewEntry()
+	scopesEntry.SetText(strings.Join(config.Scopes, " "))
+	scopesEntry.SetPlaceHolder("Extra scopes, e.g. groups")
+	usernameClaimEntry := widget.NewEntry()
+	usernameClaimEntry.SetText(config.UsernameClaim)
+	usernameClaimEntry.SetPlaceHolder(defaultOIDCUsernameClaim)
+	emailClaimEntry := widget.NewEntry()
+	emailClaimEntry.SetText(config.EmailClaim)
+	emailClaimEntry.SetPlaceHolder(defaultOIDCEmailClaim)
+	groupsClaimEntry := widget.NewEntry()
+	groupsClaimEntry.SetText(config.GroupsClaim)
+	groupsClaimEntry.SetPlaceHolder(defaultOIDCGroupsClaim)
+	adminGroupsEntry := widget.NewMultiLineEntry()
+	adminGroupsEntry.SetText(strings.Join(config.AdminGroups, "\n"))
+	adminGroupsEntry.SetPlaceHolder("One group or role per line")
+	userGroupsEntry := widget.NewMultiLineEntry()
+	userGroupsEntry.SetText(strings.Join(config.UserGroups, "\n"))
+	userGroupsEntry.SetPlaceHolder("Empty allows anyone the provider signs in")
+
+	current := func() OIDCSettings {
+		return OIDCSettings{
+			Enabled:       enabledCheck.Checked,
+			Issuer:        strings.TrimSuffix(strings.TrimSpace(issuerEntry.Text), "/"),
+			ClientID:      strings.TrimSpace(clientIDEntry.Text),
+			ClientSecret:  clientSecretEntry.Text,
+			Scopes:        strings.Fields(scopesEntry.Text),
+			UsernameClaim: strings.TrimSpace(usernameClaimEntry.Text),
+			EmailClaim:    strings.TrimSpace(emailClaimEntry.Text),
+			GroupsClaim:   strings.TrimSpace(groupsClaimEntry.Text),
+			AdminGroups:   splitLines(adminGroupsEntry.Text),
+			UserGroups:    splitLines(userGroupsEntry.Text),
+		}
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("OpenID Connect", enabledCheck),
+		&widget.FormItem{Text: "Issuer", Widget: issuerEntry, HintText: "Register http://127.0.0.1/callback (any port) as the redirect URI"},
+		widget.NewFormItem("Client ID", clientIDEntry),
+		&widget.FormItem{Text: "Client Secret", Widget: clientSecretEntry, HintText: "Usually empty; roomy uses PKCE"},
+		widget.NewFormItem("Scopes", scopesEntry),
+		widget.NewFormItem("Username Claim", usernameClaimEntry),
+		widget.NewFormItem("Email Claim", emailClaimEntry),
+		widget.NewFormItem("Groups Claim", groupsClaimEntry),
+		widget.NewFormItem("Admin Groups", adminGroupsEntry),
+		widget.NewFormItem("User Groups", userGroupsEntry),
+	)
+
+	testButton := widget.NewButton("Test Sign-In", func() {
+		config := current()
+		config.Enabled = true
+		if err := validateOIDCSettings(config); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		runOIDCSignIn(config, w, func(claims map[string]interface{}) {
+			username, identity, err := oidcIdentity(config, claims)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			dialog.ShowInformation("Test Sign-In", fmt.Sprintf("Signed in as %s (subject %s)\nEmail: %s\nGroups: %s\nRole: %s",
+				username, identity.ID, identity.Email, strings.Join(identity.Groups, ", "), identity.Role), w)
+		})
+	})
+
+	return container.NewBorder(nil, testButton, nil, nil, container.NewVScroll(form)), current
+}
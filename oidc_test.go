@@ -0,0 +1,407 @@
+// oidc_test.go
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "roomy-test"
+
+// oidcTestIssuer is an OpenID provider serving discovery, JWKS and token
+// endpoints. Its authorization endpoint is played by the test's openURL.
+type oidcTestIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]oidcTestGrant // Issued authorization codes
+}
+
+// oidcTestGrant is what the issuer remembers about an authorization request
+type oidcTestGrant struct {
+	Challenge   string
+	RedirectURI string
+	IDToken     string
+}
+
+func startOIDCTestIssuer(t *testing.T) *oidcTestIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &oidcTestIssuer{key: key, codes: map[string]oidcTestGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                s.server.URL,
+			AuthorizationEndpoint: s.server.URL + "/authorize",
+			TokenEndpoint:         s.server.URL + "/token",
+			JWKSURI:               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test-key",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", s.token)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// token redeems a code, checking the PKCE verifier against the challenge
+// sent with the authorization request.
+func (s *oidcTestIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	grant, ok := s.codes[r.Form.Get("code")]
+	delete(s.codes, r.Form.Get("code"))
+	s.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method != http.MethodPost || r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("client_id") != testClientID:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+	case !ok || r.Form.Get("redirect_uri") != grant.RedirectURI:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.Challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"id_token": grant.IDToken, "token_type": "Bearer"})
+	}
+}
+
+func (s *oidcTestIssuer) discovery() oidcDiscovery {
+	return oidcDiscovery{
+		Issuer:                s.server.URL,
+		AuthorizationEndpoint: s.server.URL + "/authorize",
+		TokenEndpoint:         s.server.URL + "/token",
+		JWKSURI:               s.server.URL + "/jwks",
+	}
+}
+
+// claims returns valid ID token claims for subject, with changes applied.
+func (s *oidcTestIssuer) claims(subject, nonce string, changes map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                s.server.URL,
+		"sub":                subject,
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": subject,
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// signTestJWT returns claims as an RS256 JWT signed with key.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// browser plays the user's browser: it signs in at the issuer and follows
+// the redirect back to roomy. makeToken builds the ID token from the nonce
+// in the request.
+func (s *oidcTestIssuer) browser(t *testing.T, makeToken func(nonce string) string) func(*url.URL) error {
+	return func(authURL *url.URL) error {
+		query := authURL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			t.Errorf("authorization request has no S256 code challenge: %s", authURL)
+		}
+		code := randomToken(16)
+		s.mu.Lock()
+		s.codes[code] = oidcTestGrant{
+			Challenge:   query.Get("code_challenge"),
+			RedirectURI: query.Get("redirect_uri"),
+			IDToken:     makeToken(query.Get("nonce")),
+		}
+		s.mu.Unlock()
+
+		redirect, err := url.Parse(query.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		resp, err := http.Get(redirect.String())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	s := startOIDCTestIssuer(t)
+	config := OIDCSettings{Enabled: true, Issuer: s.server.URL, ClientID: testClientID}
+
+	claims, err := oidcSignIn(context.Background(), config, s.browser(t, func(nonce string) string {
+		return signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"preferred_username": "jdoe", "groups": []string{"staff"}}))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, identity, err := oidcIdentity(config, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "jdoe" || identity.ID != "user-1" || identity.Issuer != s.server.URL {
+		t.Errorf("identity = %q %+v, want jdoe with subject user-1 at %s", username, identity, s.server.URL)
+	}
+}
+
+func TestOIDCSignInRejectsWrongNonce(t *testing.T) {
+	s := startOIDCTestIssuer(t)
+	config := OIDCSettings{Enabled: true, Issuer: s.server.URL, ClientID: testClientID}
+
+	_, err := oidcSignIn(context.Background(), config, s.browser(t, func(nonce string) string {
+		return signTestJWT(t, s.key, s.claims("user-1", "replayed-"+nonce, nil))
+	}))
+	if err == nil || !strings.Contains(err.Error(), "does not match the sign-in request") {
+		t.Fatalf("err = %v, want a nonce mismatch", err)
+	}
+}
+
+func TestExchangeOIDCCodeSendsVerifier(t *testing.T) {
+	s := startOIDCTestIssuer(t)
+	config := OIDCSettings{Issuer: s.server.URL, ClientID: testClientID}
+	verifier := randomToken(32)
+	challenge := sha256.Sum256([]byte(verifier))
+	grant := func() string {
+		code := randomToken(16)
+		s.mu.Lock()
+		s.codes[code] = oidcTestGrant{
+			Challenge:   base64.RawURLEncoding.EncodeToString(challenge[:]),
+			RedirectURI: "http://127.0.0.1:1/callback",
+			IDToken:     "token",
+		}
+		s.mu.Unlock()
+		return code
+	}
+
+	token, err := exchangeOIDCCode(context.Background(), s.discovery(), config, grant(), "http://127.0.0.1:1/callback", verifier)
+	if err != nil || token != "token" {
+		t.Fatalf("exchange with the right verifier = %q, %v", token, err)
+	}
+	_, err = exchangeOIDCCode(context.Background(), s.discovery(), config, grant(), "http://127.0.0.1:1/callback", randomToken(32))
+	if err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Fatalf("exchange with the wrong verifier: err = %v, want a PKCE failure", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	s := startOIDCTestIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const nonce = "expected-nonce"
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // Empty if the token is valid
+	}{
+		{name: "valid", token: signTestJWT(t, s.key, s.claims("user-1", nonce, nil))},
+		{name: "several audiences with azp", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"aud": []string{testClientID, "other"}, "azp": testClientID}))},
+		{name: "signed with another key", token: signTestJWT(t, otherKey, s.claims("user-1", nonce, nil)), wantErr: "signature is not valid"},
+		{name: "claims changed after signing", token: tamperTestJWT(signTestJWT(t, s.key, s.claims("user-1", nonce, nil)), s.claims("admin", nonce, nil)), wantErr: "signature is not valid"},
+		{name: "unsigned", token: unsignedTestJWT(s.claims("user-1", nonce, nil)), wantErr: "signature is not valid"},
+		{name: "wrong audience", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"aud": "another-app"})), wantErr: "not issued for roomy"},
+		{name: "wrong azp", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"aud": []string{testClientID, "other"}, "azp": "other"})), wantErr: "not issued for roomy"},
+		{name: "wrong issuer", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"iss": "https://evil.example.com"})), wantErr: "issued by"},
+		{name: "expired", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"exp": past.Unix(), "iat": past.Add(-time.Hour).Unix()})), wantErr: "expired"},
+		{name: "no expiry", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"exp": nil})), wantErr: "expired"},
+		{name: "nonce mismatch", token: signTestJWT(t, s.key, s.claims("user-1", "other-nonce", nil)), wantErr: "does not match"},
+		{name: "no nonce", token: signTestJWT(t, s.key, s.claims("user-1", nonce, map[string]interface{}{"nonce": nil})), wantErr: "does not match"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifyIDToken(context.Background(), test.token, s.discovery(), testClientID, nonce)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims["sub"] != "user-1" {
+					t.Errorf("sub = %v, want user-1", claims["sub"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("err = %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// tamperTestJWT replaces the claims of a signed token, keeping its signature.
+func tamperTestJWT(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+func unsignedTestJWT(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestSSOAccountsFollowSubject(t *testing.T) {
+	useTestDataDir(t)
+	const issuer = "https://login.example.com"
+	config := OIDCSettings{AdminGroups: []string{"admins"}}
+	signIn := func(subject, username string, groups ...string) *User {
+		t.Helper()
+		claims := map[string]interface{}{"iss": issuer, "sub": subject, "preferred_username": username, "groups": toInterfaces(groups)}
+		name, identity, err := oidcIdentity(config, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := provisionDirectoryUser(name, authSourceOIDC, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	admin := signIn("subject-admin", "boss", "admins")
+	if admin.Username != "boss" || admin.Role != "Admin" {
+		t.Fatalf("first sign-in: %s (%s), want boss (Admin)", admin.Username, admin.Role)
+	}
+
+	// Someone else renames their provider account to the admin's name
+	impostor := signIn("subject-impostor", "boss")
+	if impostor.Subject == "subject-admin" || impostor.Role != "User" {
+		t.Fatalf("impostor signed in to %s (%s, subject %s)", impostor.Username, impostor.Role, impostor.Subject)
+	}
+	if impostor.Username == "boss" {
+		t.Fatalf("impostor was given the admin's username")
+	}
+
+	// The admin renames their own account and keeps it
+	again := signIn("subject-admin", "boss-renamed", "admins")
+	if again.Username != "boss" || again.Subject != "subject-admin" {
+		t.Fatalf("renamed admin signed in to %s (subject %s), want boss", again.Username, again.Subject)
+	}
+	if len(users) != 2 {
+		t.Errorf("%d accounts, want 2", len(users))
+	}
+
+	if _, _, err := oidcIdentity(config, map[string]interface{}{"iss": issuer, "preferred_username": "nosub"}); err == nil {
+		t.Errorf("token without a subject was accepted")
+	}
+}
+
+func TestSSOLegacyAccountIsBoundOnce(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "jdoe", Role: "User", Source: authSourceOIDC}}
+	identity := directoryIdentity{ID: "subject-1", Issuer: "https://login.example.com", Role: "User"}
+
+	user, err := provisionDirectoryUser("jdoe", authSourceOIDC, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "jdoe" || user.Subject != "subject-1" {
+		t.Fatalf("legacy account not bound: %+v", user)
+	}
+
+	identity.ID = "subject-2"
+	other, err := provisionDirectoryUser("jdoe", authSourceOIDC, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Username == "jdoe" {
+		t.Fatalf("a second subject signed in to the bound account")
+	}
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+func TestSSOUsernamesAreMadeValid(t *testing.T) {
+	useTestDataDir(t)
+	users = []User{{Username: "alice", Role: "Admin"}}
+	signIn := func(subject, username string) string {
+		t.Helper()
+		identity := directoryIdentity{ID: subject, Issuer: "https://login.example.com", Role: "User"}
+		user, err := provisionDirectoryUser(username, authSourceOIDC, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateUsername(user.Username); err != nil {
+			t.Errorf("%q was saved as the invalid username %q: %v", username, user.Username, err)
+		}
+		return user.Username
+	}
+
+	tests := []struct {
+		subject, username, want string
+	}{
+		{"s1", "John Smith", "John-Smith"},
+		{"s2", "jo", "jo-"},
+		{"s3", "ALICE", "ALICE-2"},
+		{"s4", "jdoe@example.com", "jdoe@example.com"},
+		{"s5", strings.Repeat("x", 70), strings.Repeat("x", maxUsernameLength)},
+		{"s6", strings.Repeat("x", 70), strings.Repeat("x", maxUsernameLength-2) + "-2"},
+	}
+	for _, tt := range tests {
+		if got := signIn(tt.subject, tt.username); got != tt.want {
+			t.Errorf("%q was named %q, want %q", tt.username, got, tt.want)
+		}
+	}
+	if findUser("alice").Source != authSourceLocal {
+		t.Error("the local alice account was changed")
+	}
+}
@@ -0,0 +1,370 @@
+// password.go
+
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHashCost is the bcrypt cost for new hashes. Older hashes with a
+// lower cost are upgraded the next time their owner signs in.
+const passwordHashCost = 12
+
+const (
+	defaultMinPasswordLength = 8
+	maxPasswordLength        = 72 // bcrypt ignores anything longer
+	defaultPasswordHistory   = 5
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords holds the bundled denylist, lowercased
+var commonPasswords = parseDenylist(commonPasswordsFile)
+
+// PasswordPolicy sets the rules for local account passwords
+type PasswordPolicy struct {
+	MinLength            int // 0 uses the default
+	RequireUpper         bool
+	RequireLower         bool
+	RequireDigit         bool
+	RequireSymbol        bool
+	HistoryCount         int  // Previous passwords that cannot be reused; 0 uses the default, -1 allows reuse
+	AllowCommonPasswords bool // Skip the bundled denylist
+}
+
+func (p PasswordPolicy) minLength() int {
+	if p.MinLength <= 0 {
+		return defaultMinPasswordLength
+	}
+	return p.MinLength
+}
+
+func (p PasswordPolicy) historyCount() int {
+	if p.HistoryCount < 0 {
+		return 0
+	}
+	if p.HistoryCount == 0 {
+		return defaultPasswordHistory
+	}
+	return p.HistoryCount
+}
+
+func parseDenylist(data string) map[string]bool {
+	denylist := map[string]bool{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denylist[strings.ToLower(line)] = true
+	}
+	return denylist
+}
+
+// passwordPolicyHint describes the policy for form hints.
+func passwordPolicyHint() string {
+	policy := settings.Passwords
+	parts := []string{fmt.Sprintf("At least %d characters", policy.minLength())}
+	var classes []string
+	if policy.RequireUpper {
+		classes = append(classes, "an uppercase letter")
+	}
+	if policy.RequireLower {
+		classes = append(classes, "a lowercase letter")
+	}
+	if policy.RequireDigit {
+		classes = append(classes, "a digit")
+	}
+	if policy.RequireSymbol {
+		classes = append(classes, "a symbol")
+	}
+	if len(classes) > 0 {
+		parts = append(parts, "with "+strings.Join(classes, ", "))
+	}
+	return strings.Join(parts, " ")
+}
+
+// validatePassword checks a new password against the policy. When user is
+// set, their recent passwords cannot be reused.
+func validatePassword(username, password string, user *User) error {
+	policy := settings.Passwords
+	if len(password) < policy.minLength() {
+		return fmt.Errorf("password must be at least %d characters long", policy.minLength())
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes long", maxPasswordLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case policy.RequireUpper && !upper:
+		return errors.New("password must contain an uppercase letter")
+	case policy.RequireLower && !lower:
+		return errors.New("password must contain a lowercase letter")
+	case policy.RequireDigit && !digit:
+		return errors.New("password must contain a digit")
+	case policy.RequireSymbol && !symbol:
+		return errors.New("password must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if !policy.AllowCommonPasswords {
+		if commonPasswords[lowered] {
+			return errors.New("this password is too common; choose another")
+		}
+		if name := strings.ToLower(strings.TrimSpace(username)); len(name) >= 3 && strings.Contains(lowered, name) {
+			return errors.New("password must not contain your username")
+		}
+	}
+
+	if user != nil {
+		previous := append([][]byte{user.PasswordHash}, user.PasswordHistory...)
+		if n := policy.historyCount(); len(previous) > n {
+			previous = previous[:n]
+		}
+		for _, hash := range previous {
+			if len(hash) > 0 && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+				return fmt.Errorf("password must differ from your last %d passwords", policy.historyCount())
+			}
+		}
+	}
+	return nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+}
+
+// setPassword validates and stores a new password, keeping the old hash in
+// the user's history.
+func setPassword(user *User, password string) error {
+	if user.Source != authSourceLocal {
+		return errors.New("this account's password is managed by your organisation's directory")
+	}
+	if err := validatePassword(user.Username, password, user); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if len(user.PasswordHash) > 0 {
+		user.PasswordHistory = append([][]byte{user.PasswordHash}, user.PasswordHistory...)
+	}
+	if n := settings.Passwords.historyCount(); len(user.PasswordHistory) > n {
+		user.PasswordHistory = user.PasswordHistory[:n]
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+// changePassword lets a signed-in user replace their password. The current
+// password is checked through the sign-in guard so it cannot be guessed.
+func changePassword(username, current, password string) error {
+	if _, err := attemptLogin(username, current, localLoginSource()); err != nil {
+		if err == errInvalidCredentials {
+			return errors.New("current password is incorrect")
+		}
+		return err
+	}
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	if err := setPassword(user, password); err != nil {
+		return err
+	}
+	user.MustChangePassword = false
+	saveUsers()
+	return nil
+}
+
+// resetPassword sets a temporary password chosen by an admin.
+func resetPassword(username, password string, mustChange bool) error {
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	if err := setPassword(user, password); err != nil {
+		return err
+	}
+	user.MustChangePassword = mustChange
+	saveUsers()
+	return unlockUser(username) // A reset also clears any lockout
+}
+
+// upgradePasswordHash rehashes a password whose bcrypt cost is below the
+// current one. It is called after the password has been verified.
+func upgradePasswordHash(user *User, password string) {
+	cost, err := bcrypt.Cost(user.PasswordHash)
+	if err != nil || cost >= passwordHashCost {
+		return
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error upgrading password hash for %s: %v\n", user.Username, err)
+		return
+	}
+	user.PasswordHash = hash
+	saveUsers()
+}
+
+// showChangePassword asks the signed-in user for a new password. When forced
+// is set the user must change it, and onDone only runs once they have.
+func showChangePassword(user *User, forced bool, w fyne.Window, onDone func()) {
+	currentEntry := widget.NewPasswordEntry()
+	newEntry := widget.NewPasswordEntry()
+	confirmEntry := widget.NewPasswordEntry()
+
+	items := []*widget.FormItem{
+		{Text: "Current Password", Widget: currentEntry},
+		{Text: "New Password", Widget: newEntry, HintText: passwordPolicyHint()},
+		{Text: "Confirm Password", Widget: confirmEntry},
+	}
+	title, dismiss := "Change Password", "Cancel"
+	if forced {
+		title, dismiss = "Choose a New Password", "Log Out"
+		notice := widget.NewLabel("Your password was reset by an administrator.\nChoose a new password to continue.")
+		items = append([]*widget.FormItem{{Text: "", Widget: notice}}, items...)
+	}
+	form := dialog.NewForm(title, "Change", dismiss, items, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		retry := func(err error) {
+			d := dialog.NewError(err, w)
+			if forced {
+				d.SetOnClosed(func() { showChangePassword(user, forced, w, onDone) })
+			}
+			d.Show()
+		}
+		if newEntry.Text != confirmEntry.Text {
+			retry(errors.New("passwords do not match"))
+			return
+		}
+		if err := changePassword(user.Username, currentEntry.Text, newEntry.Text); err != nil {
+			retry(err)
+			return
+		}
+		dialog.ShowInformation(title, "Your password has been changed.", w)
+		if onDone != nil {
+			onDone()
+		}
+	}, w)
+	form.Resize(fyne.NewSize(450, 300))
+	form.Show()
+}
+
+// showResetPassword lets an admin set a temporary password for a user.
+func showResetPassword(username string, w fyne.Window, onDone func()) {
+	passwordEntry := widget.NewPasswordEntry()
+	mustChangeCheck := widget.NewCheck("Must change at next sign-in", nil)
+	mustChangeCheck.SetChecked(true)
+	form := dialog.NewForm(fmt.Sprintf("Reset Password for %s", username), "Reset", "Cancel", []*widget.FormItem{
+		{Text: "Temporary Password", Widget: passwordEntry, HintText: passwordPolicyHint()},
+		{Text: "", Widget: mustChangeCheck},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		if err := resetPassword(username, passwordEntry.Text, mustChangeCheck.Checked); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if onDone != nil {
+			onDone()
+		}
+	}, w)
+	form.Resize(fyne.NewSize(450, 220))
+	form.Show()
+}
+
+// passwordSettingsForm returns the password policy tab of the
+// authentication settings and a function reading the entered values.
+func passwordSettingsForm() (fyne.CanvasObject, func() (PasswordPolicy, error)) {
+	policy := settings.Passwords
+	minLengthEntry := widget.NewEntry()
+	minLengthEntry.SetPlaceHolder(strconv.Itoa(defaultMinPasswordLength))
+	if policy.MinLength > 0 {
+		minLengthEntry.SetText(strconv.Itoa(policy.MinLength))
+	}
+	upperCheck := widget.NewCheck("Uppercase letter", nil)
+	upperCheck.SetChecked(policy.RequireUpper)
+	lowerCheck := widget.NewCheck("Lowercase letter", nil)
+	lowerCheck.SetChecked(policy.RequireLower)
+	digitCheck := widget.NewCheck("Digit", nil)
+	digitCheck.SetChecked(policy.RequireDigit)
+	symbolCheck := widget.NewCheck("Symbol", nil)
+	symbolCheck.SetChecked(policy.RequireSymbol)
+	historyEntry := widget.NewEntry()
+	historyEntry.SetPlaceHolder(strconv.Itoa(defaultPasswordHistory))
+	switch {
+	case policy.HistoryCount > 0:
+		historyEntry.SetText(strconv.Itoa(policy.HistoryCount))
+	case policy.HistoryCount < 0:
+		historyEntry.SetText("0")
+	}
+	denylistCheck := widget.NewCheck("Refuse common and breached passwords", nil)
+	denylistCheck.SetChecked(!policy.AllowCommonPasswords)
+
+	current := func() (PasswordPolicy, error) {
+		updated := PasswordPolicy{
+			RequireUpper:         upperCheck.Checked,
+			RequireLower:         lowerCheck.Checked,
+			RequireDigit:         digitCheck.Checked,
+			RequireSymbol:        symbolCheck.Checked,
+			AllowCommonPasswords: !denylistCheck.Checked,
+		}
+		if text := strings.TrimSpace(minLengthEntry.Text); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 8 || n > maxPasswordLength {
+				return updated, fmt.Errorf("minimum length must be between 8 and %d", maxPasswordLength)
+			}
+			updated.MinLength = n
+		}
+		if text := strings.TrimSpace(historyEntry.Text); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 0 || n > 24 {
+				return updated, fmt.Errorf("password history must be between 0 and 24")
+			}
+			updated.HistoryCount = n
+			if n == 0 {
+				updated.HistoryCount = -1
+			}
+		}
+		return updated, nil
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("Minimum Length", minLengthEntry),
+		widget.NewFormItem("Require", container.NewVBox(upperCheck, lowerCheck, digitCheck, symbolCheck)),
+		&widget.FormItem{Text: "Remember Last", Widget: historyEntry, HintText: "Previous passwords that cannot be reused; 0 allows reuse"},
+		widget.NewFormItem("Denylist", denylistCheck),
+	)
+	return form, current
+}
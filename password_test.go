@@ -0,0 +1,158 @@
+// password_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidatePassword(t *testing.T) {
+	useTestDataDir(t)
+	strict := PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	tests := []struct {
+		policy   PasswordPolicy
+		password string
+		wantErr  string
+	}{
+		{PasswordPolicy{}, "short", "at least 8 characters"},
+		{PasswordPolicy{}, "quiet meadow", ""},
+		{PasswordPolicy{}, strings.Repeat("a", maxPasswordLength+1), "at most 72 bytes"},
+		{PasswordPolicy{}, "Password", "too common"},
+		{PasswordPolicy{AllowCommonPasswords: true}, "password", ""},
+		{PasswordPolicy{}, "my-Alice-pass", "must not contain your username"},
+		{strict, "Meadow 7!", "at least 10 characters"},
+		{strict, "quiet meadows", "uppercase letter"},
+		{strict, "QUIET MEADOWS", "lowercase letter"},
+		{strict, "Quiet meadows", "digit"},
+		{strict, "Quiet meadows 7!", ""},
+		{strict, "Quietmeadows7", "symbol"},
+	}
+	for _, tt := range tests {
+		settings.Passwords = tt.policy
+		err := validatePassword("alice", tt.password, nil)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%+v refused %q: %v", tt.policy, tt.password, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%+v on %q gave %v, want %q", tt.policy, tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPasswordPolicyHint(t *testing.T) {
+	useTestDataDir(t)
+	if got := passwordPolicyHint(); got != "At least 8 characters" {
+		t.Errorf("default hint = %q", got)
+	}
+	settings.Passwords = PasswordPolicy{MinLength: 12, RequireUpper: true, RequireDigit: true}
+	if got := passwordPolicyHint(); got != "At least 12 characters with an uppercase letter, a digit" {
+		t.Errorf("hint = %q", got)
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	useTestDataDir(t)
+	settings.Passwords.HistoryCount = 2
+	user := &User{Username: "alice"}
+	for _, password := range []string{"first secret", "second secret", "third secret"} {
+		if err := setPassword(user, password); err != nil {
+			t.Fatalf("setting %q: %v", password, err)
+		}
+	}
+	if len(user.PasswordHistory) != 2 {
+		t.Errorf("kept %d old hashes, want 2", len(user.PasswordHistory))
+	}
+
+	// The current password and the one before it cannot be reused
+	for _, password := range []string{"third secret", "second secret"} {
+		if err := setPassword(user, password); err == nil || !strings.Contains(err.Error(), "last 2 passwords") {
+			t.Errorf("reusing %q gave %v", password, err)
+		}
+	}
+	if err := setPassword(user, "first secret"); err != nil {
+		t.Errorf("a password older than the history was refused: %v", err)
+	}
+
+	settings.Passwords.HistoryCount = -1
+	if err := setPassword(user, "first secret"); err != nil {
+		t.Errorf("reuse refused with history off: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	useTestLoginGuard(t)
+	users[0].MustChangePassword = true
+
+	if err := changePassword("alice", "wrong horse", "quiet meadow"); err == nil || err.Error() != "current password is incorrect" {
+		t.Errorf("a wrong current password gave %v", err)
+	}
+	if err := changePassword("alice", "correct horse", "short"); err == nil {
+		t.Error("a password breaking the policy was accepted")
+	}
+	if !users[0].MustChangePassword {
+		t.Error("a refused change cleared the forced change")
+	}
+	if err := changePassword("alice", "correct horse", "quiet meadow"); err != nil {
+		t.Fatal(err)
+	}
+	if users[0].MustChangePassword {
+		t.Error("the forced change is still pending after the password changed")
+	}
+	if _, err := authenticateUser("alice", "quiet meadow"); err != nil {
+		t.Errorf("signing in with the new password: %v", err)
+	}
+	if _, err := authenticateUser("alice", "correct horse"); err != errInvalidCredentials {
+		t.Errorf("signing in with the old password gave %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	useTestLoginGuard(t)
+	users = append(users, User{Username: "dana", Source: authSourceLDAP})
+	failLogins("alice", "10.0.0.1", settings.Login.maxFailures())
+	if !isLockedOut(users[0]) {
+		t.Fatal("alice was not locked out")
+	}
+
+	if err := resetPassword("alice", "quiet meadow", true); err != nil {
+		t.Fatal(err)
+	}
+	if isLockedOut(users[0]) || checkLoginAllowed("alice", "10.0.0.2") != nil {
+		t.Error("alice is still locked out after a reset")
+	}
+	if !users[0].MustChangePassword {
+		t.Error("the reset did not force a password change")
+	}
+	if _, err := authenticateUser("alice", "quiet meadow"); err != nil {
+		t.Errorf("signing in with the temporary password: %v", err)
+	}
+
+	if err := resetPassword("dana", "quiet meadow", true); err == nil || !strings.Contains(err.Error(), "directory") {
+		t.Errorf("resetting a directory account gave %v", err)
+	}
+	if err := resetPassword("nobody", "quiet meadow", true); err == nil {
+		t.Error("reset an account that does not exist")
+	}
+}
+
+func TestUpgradePasswordHash(t *testing.T) {
+	useTestDataDir(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("quiet meadow"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users = []User{{Username: "alice", PasswordHash: hash}}
+
+	if _, err := (localAuthProvider{}).Authenticate("alice", "quiet meadow"); err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost(users[0].PasswordHash); cost != passwordHashCost {
+		t.Errorf("hash cost after sign-in = %d, want %d", cost, passwordHashCost)
+	}
+	if bcrypt.CompareHashAndPassword(users[0].PasswordHash, []byte("quiet meadow")) != nil {
+		t.Error("the upgraded hash does not match the password")
+	}
+}
@@ -0,0 +1,368 @@
+// plancanvas.go
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	minPlanZoom = 1
+	maxPlanZoom = 8
+	handleSize  = 10 // Side of a vertex handle, in pixels
+)
+
+var gridLineColor = color.NRGBA{R: 128, G: 128, B: 128, A: 60}
+
+// planShape is an outline filled on the plan
+type planShape struct {
+	Points []fyne.Position
+	Fill   color.NRGBA
+}
+
+// floorPlanCanvas shows a floor plan image with room shapes, markers and an
+// in-progress drawing on top. It zooms with the scroll wheel and pans by
+// dragging. Everything placed on it uses plan coordinates, normalized to
+// the image so (0,0) is the top-left corner and (1,1) the bottom-right;
+// markers therefore stay anchored at any zoom level or window size.
+type floorPlanCanvas struct {
+	widget.BaseWidget
+	image     *canvas.Image
+	imageSize fyne.Size
+	shapes    *canvas.Raster
+	markers   []fyne.CanvasObject
+	anchors   []fyne.Position // Plan coordinates of each marker's centre
+	draft     []fyne.Position // Plan coordinates of a shape being drawn
+	handles   []fyne.Position // Plan coordinates of editable vertices
+	zoom      float32
+	pan       fyne.Position
+	dragging  bool
+	editDrag  bool // The current drag edits the layout rather than panning
+
+	// Shapes returns the outlines to fill
+	Shapes func() []planShape
+	// GridStep, when above zero, draws a grid with that spacing in plan coordinates
+	GridStep float32
+	// OnTapped is called with the plan coordinates of taps on the image
+	OnTapped func(fyne.Position)
+	// OnDragStart is called with the plan coordinates where a drag began;
+	// returning true sends the drag to OnDragMoved instead of panning.
+	OnDragStart func(fyne.Position) bool
+	OnDragMoved func(fyne.Position)
+	OnDragEnded func()
+}
+
+func newFloorPlanCanvas(img image.Image) *floorPlanCanvas {
+	bounds := img.Bounds()
+	c := &floorPlanCanvas{
+		image:     canvas.NewImageFromImage(img),
+		imageSize: fyne.NewSize(float32(bounds.Dx()), float32(bounds.Dy())),
+		zoom:      1,
+	}
+	c.image.FillMode = canvas.ImageFillStretch // planRect already keeps the aspect ratio
+	c.shapes = canvas.NewRaster(c.renderShapes)
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+// planRect returns where the image is drawn inside the widget: fitted to the
+// widget, then scaled by the zoom level and shifted by the pan offset.
+func (c *floorPlanCanvas) planRect() (fyne.Position, fyne.Size) {
+	size := c.Size()
+	if c.imageSize.Width <= 0 || c.imageSize.Height <= 0 {
+		return fyne.Position{}, size
+	}
+	fit := fyne.Min(size.Width/c.imageSize.Width, size.Height/c.imageSize.Height)
+	display := fyne.NewSize(c.imageSize.Width*fit*c.zoom, c.imageSize.Height*fit*c.zoom)
+	origin := fyne.NewPos((size.Width-display.Width)/2+c.pan.X, (size.Height-display.Height)/2+c.pan.Y)
+	return origin, display
+}
+
+// toPlan converts a position in the widget to plan coordinates.
+func (c *floorPlanCanvas) toPlan(pos fyne.Position) fyne.Position {
+	origin, display := c.planRect()
+	if display.Width <= 0 || display.Height <= 0 {
+		return fyne.Position{}
+	}
+	return fyne.NewPos((pos.X-origin.X)/display.Width, (pos.Y-origin.Y)/display.Height)
+}
+
+// fromPlan converts plan coordinates to a position in the widget.
+func (c *floorPlanCanvas) fromPlan(p fyne.Position) fyne.Position {
+	origin, display := c.planRect()
+	return fyne.NewPos(origin.X+p.X*display.Width, origin.Y+p.Y*display.Height)
+}
+
+// SetMarkers replaces the objects anchored on the plan.
+func (c *floorPlanCanvas) SetMarkers(markers []fyne.CanvasObject, anchors []fyne.Position) {
+	c.markers = markers
+	c.anchors = anchors
+	c.Refresh()
+}
+
+// SetDraft shows the outline of a shape being drawn.
+func (c *floorPlanCanvas) SetDraft(points []fyne.Position) {
+	c.draft = points
+	c.Refresh()
+}
+
+// SetHandles shows square handles on the given vertices.
+func (c *floorPlanCanvas) SetHandles(points []fyne.Position) {
+	c.handles = points
+	c.Refresh()
+}
+
+// HandleAt returns the index of the handle under p, in plan coordinates, or -1.
+func (c *floorPlanCanvas) HandleAt(p fyne.Position) int {
+	pos := c.fromPlan(p)
+	for i := len(c.handles) - 1; i >= 0; i-- {
+		h := c.fromPlan(c.handles[i])
+		if math.Abs(float64(h.X-pos.X)) <= handleSize/2+2 && math.Abs(float64(h.Y-pos.Y)) <= handleSize/2+2 {
+			return i
+		}
+	}
+	return -1
+}
+
+// MarkerAt returns the index of the marker under p, in plan coordinates, or -1.
+func (c *floorPlanCanvas) MarkerAt(p fyne.Position) int {
+	pos := c.fromPlan(p)
+	for i := len(c.markers) - 1; i >= 0; i-- {
+		size := c.markers[i].MinSize()
+		centre := c.fromPlan(c.anchors[i])
+		if math.Abs(float64(centre.X-pos.X)) <= float64(size.Width/2) && math.Abs(float64(centre.Y-pos.Y)) <= float64(size.Height/2) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ZoomBy scales the plan by factor, keeping the point under around fixed.
+func (c *floorPlanCanvas) ZoomBy(factor float32, around fyne.Position) {
+	anchor := c.toPlan(around)
+	c.zoom = float32(math.Max(minPlanZoom, math.Min(maxPlanZoom, float64(c.zoom*factor))))
+
+	// Shift the pan so the anchored plan point stays under the cursor
+	size := c.Size()
+	_, display := c.planRect()
+	origin := fyne.NewPos(around.X-anchor.X*display.Width, around.Y-anchor.Y*display.Height)
+	c.pan = fyne.NewPos(origin.X-(size.Width-display.Width)/2, origin.Y-(size.Height-display.Height)/2)
+	c.clampPan()
+	c.Refresh()
+}
+
+// ResetZoom fits the whole plan in the widget again.
+func (c *floorPlanCanvas) ResetZoom() {
+	c.zoom = 1
+	c.pan = fyne.Position{}
+	c.Refresh()
+}
+
+// clampPan stops the plan being dragged entirely out of view.
+func (c *floorPlanCanvas) clampPan() {
+	size := c.Size()
+	_, display := c.planRect()
+	limitX := fyne.Max(0, (display.Width-size.Width)/2)
+	limitY := fyne.Max(0, (display.Height-size.Height)/2)
+	c.pan.X = fyne.Max(-limitX, fyne.Min(limitX, c.pan.X))
+	c.pan.Y = fyne.Max(-limitY, fyne.Min(limitY, c.pan.Y))
+}
+
+func (c *floorPlanCanvas) Tapped(event *fyne.PointEvent) {
+	p := c.toPlan(event.Position)
+	if p.X < 0 || p.Y < 0 || p.X > 1 || p.Y > 1 {
+		return
+	}
+	if c.OnTapped != nil {
+		c.OnTapped(p)
+	}
+}
+
+func (c *floorPlanCanvas) TappedSecondary(*fyne.PointEvent) {}
+
+func (c *floorPlanCanvas) Scrolled(event *fyne.ScrollEvent) {
+	factor := float32(math.Pow(1.1, float64(event.Scrolled.DY)/10))
+	c.ZoomBy(factor, event.Position)
+}
+
+func (c *floorPlanCanvas) Dragged(event *fyne.DragEvent) {
+	if !c.dragging {
+		c.dragging = true
+		start := c.toPlan(event.Position.Subtract(event.Dragged))
+		c.editDrag = c.OnDragStart != nil && c.OnDragStart(start)
+	}
+	if c.editDrag {
+		if c.OnDragMoved != nil {
+			c.OnDragMoved(c.toPlan(event.Position))
+		}
+		return
+	}
+	c.pan = c.pan.Add(event.Dragged)
+	c.clampPan()
+	c.Refresh()
+}
+
+func (c *floorPlanCanvas) DragEnd() {
+	if c.editDrag && c.OnDragEnded != nil {
+		c.OnDragEnded()
+	}
+	c.dragging, c.editDrag = false, false
+}
+
+// renderShapes fills each shape, and the snap grid if shown, over the
+// visible part of the widget.
+func (c *floorPlanCanvas) renderShapes(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	size := c.Size()
+	if size.Width <= 0 || size.Height <= 0 {
+		return img
+	}
+	scale := float32(w) / size.Width
+	if c.GridStep > 0 {
+		c.renderGrid(img, scale)
+	}
+	if c.Shapes == nil {
+		return img
+	}
+	for _, shape := range c.Shapes() {
+		if len(shape.Points) < 3 || shape.Fill.A == 0 {
+			continue
+		}
+		fill := shape.Fill
+
+		// Only scan the shape's bounding box
+		minX, minY, maxX, maxY := shapeBounds(shape.Points)
+		topLeft := c.fromPlan(fyne.NewPos(minX, minY))
+		bottomRight := c.fromPlan(fyne.NewPos(maxX, maxY))
+		x0, y0 := clampInt(int(topLeft.X*scale), 0, w), clampInt(int(topLeft.Y*scale), 0, h)
+		x1, y1 := clampInt(int(bottomRight.X*scale)+1, 0, w), clampInt(int(bottomRight.Y*scale)+1, 0, h)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				p := c.toPlan(fyne.NewPos((float32(x)+0.5)/scale, (float32(y)+0.5)/scale))
+				if pointInPolygon(p, shape.Points) {
+					img.SetNRGBA(x, y, fill)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// renderGrid draws a line every GridStep across the plan.
+func (c *floorPlanCanvas) renderGrid(img *image.NRGBA, scale float32) {
+	bounds := img.Bounds()
+	for step := float32(0); step <= 1; step += c.GridStep {
+		x := int(c.fromPlan(fyne.NewPos(step, 0)).X * scale)
+		y := int(c.fromPlan(fyne.NewPos(0, step)).Y * scale)
+		top := clampInt(int(c.fromPlan(fyne.NewPos(0, 0)).Y*scale), 0, bounds.Dy())
+		bottom := clampInt(int(c.fromPlan(fyne.NewPos(0, 1)).Y*scale), 0, bounds.Dy())
+		left := clampInt(int(c.fromPlan(fyne.NewPos(0, 0)).X*scale), 0, bounds.Dx())
+		right := clampInt(int(c.fromPlan(fyne.NewPos(1, 0)).X*scale), 0, bounds.Dx())
+		if x >= 0 && x < bounds.Dx() {
+			for py := top; py < bottom; py++ {
+				img.SetNRGBA(x, py, gridLineColor)
+			}
+		}
+		if y >= 0 && y < bounds.Dy() {
+			for px := left; px < right; px++ {
+				img.SetNRGBA(px, y, gridLineColor)
+			}
+		}
+	}
+}
+
+func (c *floorPlanCanvas) CreateRenderer() fyne.WidgetRenderer {
+	r := &floorPlanCanvasRenderer{canvas: c}
+	r.rebuild()
+	return r
+}
+
+type floorPlanCanvasRenderer struct {
+	canvas  *floorPlanCanvas
+	lines   []*canvas.Line
+	dots    []*canvas.Circle
+	handles []*canvas.Rectangle
+	objects []fyne.CanvasObject
+}
+
+// rebuild collects the objects to draw, recreating the draft outline.
+func (r *floorPlanCanvasRenderer) rebuild() {
+	c := r.canvas
+	r.lines, r.dots, r.handles = nil, nil, nil
+	r.objects = []fyne.CanvasObject{c.image, c.shapes}
+	for i := range c.draft {
+		if i > 0 {
+			line := canvas.NewLine(draftColor)
+			line.StrokeWidth = 2
+			r.lines = append(r.lines, line)
+			r.objects = append(r.objects, line)
+		}
+		dot := canvas.NewCircle(draftColor)
+		r.dots = append(r.dots, dot)
+		r.objects = append(r.objects, dot)
+	}
+	r.objects = append(r.objects, c.markers...)
+	for range c.handles {
+		handle := canvas.NewRectangle(color.White)
+		handle.StrokeColor = draftColor
+		handle.StrokeWidth = 2
+		r.handles = append(r.handles, handle)
+		r.objects = append(r.objects, handle)
+	}
+}
+
+func (r *floorPlanCanvasRenderer) Layout(size fyne.Size) {
+	c := r.canvas
+	origin, display := c.planRect()
+	c.image.Move(origin)
+	c.image.Resize(display)
+	c.shapes.Move(fyne.Position{})
+	c.shapes.Resize(size)
+
+	for i, p := range c.draft {
+		pos := c.fromPlan(p)
+		if i > 0 {
+			r.lines[i-1].Position1 = c.fromPlan(c.draft[i-1])
+			r.lines[i-1].Position2 = pos
+		}
+		r.dots[i].Resize(fyne.NewSize(8, 8))
+		r.dots[i].Move(pos.Subtract(fyne.NewPos(4, 4)))
+	}
+
+	for i, marker := range c.markers {
+		markerSize := marker.MinSize()
+		marker.Resize(markerSize)
+		marker.Move(c.fromPlan(c.anchors[i]).Subtract(fyne.NewPos(markerSize.Width/2, markerSize.Height/2)))
+	}
+
+	for i, p := range c.handles {
+		r.handles[i].Resize(fyne.NewSize(handleSize, handleSize))
+		r.handles[i].Move(c.fromPlan(p).Subtract(fyne.NewPos(handleSize/2, handleSize/2)))
+	}
+}
+
+func (r *floorPlanCanvasRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(200, 150)
+}
+
+func (r *floorPlanCanvasRenderer) Refresh() {
+	r.rebuild()
+	r.Layout(r.canvas.Size())
+	canvas.Refresh(r.canvas.image)
+	r.canvas.shapes.Refresh()
+	for _, o := range r.objects[2:] {
+		o.Refresh()
+	}
+}
+
+func (r *floorPlanCanvasRenderer) Objects() []fyne.CanvasObject {
+	return r.objects
+}
+
+func (r *floorPlanCanvasRenderer) Destroy() {}
@@ -0,0 +1,76 @@
+// plancanvas_test.go
+
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+// closeTo compares positions with room for float rounding.
+func closeTo(a, b fyne.Position) bool {
+	return math.Abs(float64(a.X-b.X)) < 0.01 && math.Abs(float64(a.Y-b.Y)) < 0.01
+}
+
+func TestPlanCoordinatesFollowZoomAndPan(t *testing.T) {
+	test.NewApp()
+	// A 200x100 plan in a 400x400 widget is fitted to 400x200, centred
+	c := newFloorPlanCanvas(image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+	c.Resize(fyne.NewSize(400, 400))
+
+	if got := c.fromPlan(fyne.NewPos(0.5, 0.5)); !closeTo(got, fyne.NewPos(200, 200)) {
+		t.Errorf("plan centre drawn at %v, want the widget centre", got)
+	}
+	if got := c.toPlan(fyne.NewPos(0, 100)); !closeTo(got, fyne.NewPos(0, 0)) {
+		t.Errorf("top left of the plan is %v in plan coordinates", got)
+	}
+
+	// Zooming keeps the point under the cursor in place
+	around := fyne.NewPos(100, 200)
+	before := c.toPlan(around)
+	c.ZoomBy(2, around)
+	if got := c.toPlan(around); !closeTo(got, before) {
+		t.Errorf("zooming moved the point under the cursor from %v to %v", before, got)
+	}
+	for _, p := range []fyne.Position{{X: 0.1, Y: 0.9}, {X: 0.75, Y: 0.25}} {
+		if got := c.toPlan(c.fromPlan(p)); !closeTo(got, p) {
+			t.Errorf("plan point %v came back as %v when zoomed", p, got)
+		}
+	}
+
+	// The plan cannot be panned out of view
+	c.pan = fyne.NewPos(10000, -10000)
+	c.clampPan()
+	if origin, display := c.planRect(); origin.X > 0 || origin.X+display.Width < 400 {
+		t.Errorf("after panning the plan covers %v to %v across a 400 wide widget", origin.X, origin.X+display.Width)
+	}
+
+	c.ResetZoom()
+	if got := c.fromPlan(fyne.NewPos(0.5, 0.5)); !closeTo(got, fyne.NewPos(200, 200)) {
+		t.Errorf("after resetting, the plan centre is drawn at %v", got)
+	}
+}
+
+func TestMigrateLegacyPlanCoordinates(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	room.FloorID = "floor-1"
+	room.Position = fyne.NewPos(100, 50)
+	room.Shape = []fyne.Position{{X: 0, Y: 0}, {X: 200, Y: 0}, {X: 200, Y: 100}}
+	current := &Room{ID: "room-2", Name: "Lab B", FloorID: "floor-1", Position: fyne.NewPos(0.25, 0.5)}
+	rooms = append(rooms, current)
+
+	migrateLegacyPlanCoordinates("floor-1", fyne.NewSize(400, 200))
+	if !closeTo(room.Position, fyne.NewPos(0.25, 0.25)) {
+		t.Errorf("pixel position became %v, want (0.25, 0.25)", room.Position)
+	}
+	if !closeTo(room.Shape[2], fyne.NewPos(0.5, 0.5)) {
+		t.Errorf("pixel corner became %v, want (0.5, 0.5)", room.Shape[2])
+	}
+	if !closeTo(current.Position, fyne.NewPos(0.25, 0.5)) {
+		t.Errorf("a relative position was changed to %v", current.Position)
+	}
+}
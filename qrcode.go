@@ -0,0 +1,465 @@
+// qrcode.go
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// A minimal QR code encoder (ISO/IEC 18004) for the otpauth:// links shown
+// when setting up two-factor authentication: byte mode, error correction
+// level M, versions 1 to 10 (up to 213 bytes).
+
+// qrVersion describes the error correction blocks of one version at level M
+type qrVersion struct {
+	ECPerBlock int
+	Groups     [][2]int // {block count, data codewords per block}
+	Alignment  []int    // Alignment pattern centres
+}
+
+var qrVersions = []qrVersion{
+	1:  {10, [][2]int{{1, 16}}, nil},
+	2:  {16, [][2]int{{1, 28}}, []int{6, 18}},
+	3:  {26, [][2]int{{1, 44}}, []int{6, 22}},
+	4:  {18, [][2]int{{2, 32}}, []int{6, 26}},
+	5:  {24, [][2]int{{2, 43}}, []int{6, 30}},
+	6:  {16, [][2]int{{4, 27}}, []int{6, 34}},
+	7:  {18, [][2]int{{4, 31}}, []int{6, 22, 38}},
+	8:  {22, [][2]int{{2, 38}, {2, 39}}, []int{6, 24, 42}},
+	9:  {22, [][2]int{{3, 36}, {2, 37}}, []int{6, 26, 46}},
+	10: {26, [][2]int{{4, 43}, {1, 44}}, []int{6, 28, 50}},
+}
+
+const qrFormatLevelM = 0 // Format bits for error correction level M
+
+func (v qrVersion) dataCodewords() int {
+	n := 0
+	for _, g := range v.Groups {
+		n += g[0] * g[1]
+	}
+	return n
+}
+
+// qrCode is an encoded symbol; Modules[y][x] is true for dark modules
+type qrCode struct {
+	Size     int
+	Modules  [][]bool
+	function [][]bool // Finder, timing, alignment and format modules
+}
+
+// encodeQR encodes data in the smallest version that fits.
+func encodeQR(data []byte) (*qrCode, error) {
+	for version := 1; version < len(qrVersions); version++ {
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		capacity := qrVersions[version].dataCodewords() * 8
+		if 4+countBits+len(data)*8 > capacity {
+			continue
+		}
+
+		// Byte mode segment, terminator and padding
+		var bits qrBits
+		bits.append(0x4, 4)
+		bits.append(len(data), countBits)
+		for _, b := range data {
+			bits.append(int(b), 8)
+		}
+		terminator := capacity - len(bits)
+		if terminator > 4 {
+			terminator = 4
+		}
+		bits.append(0, terminator)
+		for len(bits)%8 != 0 {
+			bits = append(bits, false)
+		}
+		codewords := bits.bytes()
+		for pad := 0; len(codewords) < capacity/8; pad++ {
+			if pad%2 == 0 {
+				codewords = append(codewords, 0xEC)
+			} else {
+				codewords = append(codewords, 0x11)
+			}
+		}
+
+		qr := newQRCode(version)
+		qr.drawCodewords(qrInterleave(qrVersions[version], codewords))
+		qr.applyBestMask()
+		return qr, nil
+	}
+	return nil, errors.New("too much data for a QR code")
+}
+
+// qrBits is a bit buffer, most significant bit first
+type qrBits []bool
+
+func (b *qrBits) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 != 0)
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return out
+}
+
+// qrInterleave splits data into blocks, appends each block's error
+// correction codewords and interleaves the result.
+func qrInterleave(v qrVersion, data []byte) []byte {
+	var blocks, ecc [][]byte
+	divisor := qrRSDivisor(v.ECPerBlock)
+	for _, g := range v.Groups {
+		for i := 0; i < g[0]; i++ {
+			block := data[:g[1]]
+			data = data[g[1]:]
+			blocks = append(blocks, block)
+			ecc = append(ecc, qrRSRemainder(block, divisor))
+		}
+	}
+
+	var out []byte
+	longest := v.Groups[len(v.Groups)-1][1]
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ECPerBlock; i++ {
+		for _, block := range ecc {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// qrGFMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func qrGFMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrRSDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest coefficient first and the leading 1 omitted.
+func qrRSDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = qrGFMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrGFMultiply(root, 0x02)
+	}
+	return result
+}
+
+func qrRSRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= qrGFMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{Size: size, Modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range qr.Modules {
+		qr.Modules[i] = make([]bool, size)
+		qr.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+	qr.drawFinder(3, 3)
+	qr.drawFinder(size-4, 3)
+	qr.drawFinder(3, size-4)
+
+	positions := qrVersions[version].Alignment
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue // Overlaps a finder pattern
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	qr.drawFormat(0) // Reserve the format modules; redrawn once the mask is chosen
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 != 0
+			a, b := size-11+i%3, i/3
+			qr.setFunction(a, b, bit)
+			qr.setFunction(b, a, bit)
+		}
+	}
+	return qr
+}
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.Modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= qr.Size || y >= qr.Size {
+				continue
+			}
+			dist := qrMax(qrAbs(dx), qrAbs(dy))
+			qr.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// qrFormatBits returns the 15-bit format information for level M and mask.
+func qrFormatBits(mask int) int {
+	data := qrFormatLevelM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (qr *qrCode) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+	size := qr.Size
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, size-15+i, bit(i))
+	}
+	qr.setFunction(8, size-8, true) // Always dark
+}
+
+// drawCodewords places the data in the zigzag order, two columns at a time
+// from the bottom right.
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		for vert := 0; vert < qr.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.Size - 1 - vert // Upward column
+				}
+				if !qr.function[y][x] && i < len(data)*8 {
+					qr.Modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMaskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if !qr.function[y][x] && qrMaskBit(mask, x, y) {
+				qr.Modules[y][x] = !qr.Modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask tries all eight masks and keeps the one with the lowest
+// penalty score, which is the easiest for cameras to read.
+func (qr *qrCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // Masks are their own inverse
+	}
+	qr.applyMask(best)
+	qr.drawFormat(best)
+}
+
+// penalty scores the symbol with the four rules of the standard.
+func (qr *qrCode) penalty() int {
+	size := qr.Size
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.Modules[x][y]
+		}
+		return qr.Modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	penalty := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Rule 1: runs of five or more modules of one colour
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			// Rule 3: finder-like patterns with four light modules on a side
+			for x := 0; x+7 <= size; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if at(x+k, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				lightBefore, lightAfter := true, true
+				for k := 1; k <= 4; k++ {
+					if x-k >= 0 && at(x-k, y, vertical) {
+						lightBefore = false
+					}
+					if x+6+k < size && at(x+6+k, y, vertical) {
+						lightAfter = false
+					}
+				}
+				if lightBefore || lightAfter {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// Rule 2: 2x2 blocks of one colour
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.Modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := qr.Modules[y][x]
+				if qr.Modules[y][x+1] == c && qr.Modules[y+1][x] == c && qr.Modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4: 10 points for each full 5% the dark modules are away from half
+	total := size * size
+	penalty += qrAbs(dark*2-total) * 10 / total * 10
+	return penalty
+}
+
+// Image renders the code with scale pixels per module and the standard
+// four-module quiet zone.
+func (qr *qrCode) Image(scale int) image.Image {
+	const quiet = 4
+	side := (qr.Size + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			mx, my := x/scale-quiet, y/scale-quiet
+			if mx >= 0 && my >= 0 && mx < qr.Size && my < qr.Size && qr.Modules[my][mx] {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func qrAbs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,372 @@
+// qrcode_test.go
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The golden matrices were produced by an independent reference encoder
+// following ISO/IEC 18004 (byte mode, level M, penalty rules as in ZXing);
+// "#" is a dark module.
+
+const (
+	qrSampleAlice = "otpauth://totp/roomy:alice?algorithm=SHA1&digits=6&issuer=roomy&period=30&secret=JBSWY3DPEHPK3PXP"
+	qrSampleLong  = "otpauth://totp/roomy:alexandra.longname-testaccount@example.com?algorithm=SHA1&digits=6&issuer=roomy&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+var qrGoldenAlice = []string{
+	"#######..####.#..#.#...##.##...#..#######",
+	"#.....#..###.####.#.######.###..#.#.....#",
+	"#.###.#..##.#..#..##....#.#####.#.#.###.#",
+	"#.###.#..##....#####.#.#.######...#.###.#",
+	"#.###.#...####..###.####.#.#.##...#.###.#",
+	"#.....#.##..#..#...####.#########.#.....#",
+	"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+	"...........##..#######..#..####..........",
+	"#..#.##.#.##.##......#.###....##.#.#.....",
+	"#.#......###.#####..#.#.##..#.....#...##.",
+	"####..###..##....#.##....##....###.##..#.",
+	"#.###..#######.##..#.#####.##.#.#.###.#..",
+	"#.#####.#.#...####.#.#.####..###.###.#.#.",
+	"#####....#.##.#.##...#.###..#.#.#.#......",
+	"#.##..##.#..###.#.#.#.#.#....##..##..#.##",
+	"#...##.#...##...##.#.#..###..####...#...#",
+	".##.#####.####.#.#.###.#...#######.#.#...",
+	".#...#.#.#.##.##.......#.##.#.....##..###",
+	"#.#.#.#....#..#.#.#..#....#.......#######",
+	"##.......##..#..###.#.###..#.#####.#.#...",
+	"#.#.#.#.##..#..#.##.#...#.#.##.#.##.#.##.",
+	".#####.##.##.#...##.....#.###...###..####",
+	"#.#####.#..####.####.........#.##..#.#.#.",
+	"...###.####...###.##.##..#.#..##.##...#..",
+	"#..####..###.##.##.#######.#.#...###..#.#",
+	"..#.#...##.###..#..###..#.#...##.#.#.#...",
+	"###...#####.##...##.#..#.##..#.....#....#",
+	"#..#...######.######.#..##..####...#...#.",
+	"#.#.####..#......#.######..#....#......#.",
+	".##.##.######.###.##.##...###.##..##....#",
+	"#..##.#..#.#.......###..##..##...###..###",
+	"....##...##..###########..####.#.##..#...",
+	"#.##.#####.####.#..#.#.#.##.#...#####.#.#",
+	"........#.####.#..#.###.....#.###...#...#",
+	"#######..#.#.##.##.#....###...#.#.#.#.#..",
+	"#.....#.#....###.#..###.####....#...#.#..",
+	"#.###.#...#####.#.....#.#...#...######.##",
+	"#.###.#.####.#.#.#.#....#.##.####.###..#.",
+	"#.###.#...###..####.######...#.##...#.###",
+	"#.....#..##...##.....#.###...#..####...#.",
+	"#######.#.#..##.##.####.#....#..##..#.##.",
+}
+
+var qrGoldenLong = []string{
+	"#######.##..##.###.#.#..#.#.###.##..#...#.#######",
+	"#.....#..##..###.##.#.##.###.##.##.##.###.#.....#",
+	"#.###.#...#.####.#####...#...#..###.#..##.#.###.#",
+	"#.###.#.#.#.##.##..#..#.....#..#.#...#.#..#.###.#",
+	"#.###.#.#.#.#.#.#####.######.##..#.#......#.###.#",
+	"#.....#.#...#...###.###...##......#.#.#...#.....#",
+	"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+	"........#....##...#...#...##..#.##..###..........",
+	"#...#.####.#.....#.#.#######...##..##....#####..#",
+	"##.##..##.#..#.#..#.###.#.##.##.##.##.##.##.#....",
+	"..#.###.#######....#..#..#######.#...###...#..##.",
+	"#.####..###..#.#..##.##.####..#.#.#.###.....###..",
+	"###.##########.#..#####.####.#..##.##.###.#.#####",
+	"...#.....##.#####.......#.#.####.#.#.##..##.##.#.",
+	"##...##.#....####.#.#.#...#..##.##..###..##.#.#..",
+	".##.##.###....###.###.###...###..##....##..##.#.#",
+	"##.#..#...#.#.#.##.#...###.#....##.###..#.#.####.",
+	"#..#.#.##...###.#.#.###...#.###..#.#..##.##..#...",
+	"#..#.###.###..#...###..#..####.#.###.#...##..##..",
+	".##..#........##..#...####.#..#.#...##.##..##.###",
+	"#..##.#..####.....#.#.###..#..#.#######.##..###.#",
+	".#####........####..##....#..###.#.#..#..##.###.#",
+	"###########....##..#.##########..#.#..#.#####.#..",
+	"..###...##.###...######...##.#..######..#...#.##.",
+	".##.#.#.#.#...#.####..#.#.##.#..##.##.###.#.###..",
+	".#..#...####....#...###...########..#.###...#....",
+	"#.#.######..#.##.#..#.#####..#####.#..#######.#..",
+	"...#.#....##.###...####.##.#..###.####.#.###.##..",
+	".#..#.##.####...#.####.#####.#####..##..#..##.##.",
+	"..##...####..#####.##.####.#.##.##..#####...##...",
+	"...##.##..#..#.#..##..#.##.##.#.#..#..##...####..",
+	"####...#..###...#.#....#..###.......##...#.#.####",
+	"....#.#.#.#########..##...##..#.###.##.....#.####",
+	"..##...#..###.#.###.#.###...###.##.##.#.#.#.#..#.",
+	"...#..##....####....##..##...#...####..###...##..",
+	"##..#..#...###...#.#..#.#.#....##...###...##..#.#",
+	"#.#.#.###.##..##.....###.#.#.##.#####...#.#...#.#",
+	"###.#....#..#.##.#.##..##.#.###.##.##.##.##.##..#",
+	".#...####.##...#####....##..#.####.######..#.##..",
+	".###...####.##.##.###.#.#.#...#.#..#.##.####..#.#",
+	"###...##....#.#...##..#####..#..##.###..#####.#.#",
+	"........##..#..##.#.#.#...##.#####..#.#.#...##...",
+	"#######.#.#.#####..####.#.#..##......##.#.#.##...",
+	"#.....#....#.#...#.#.##...##.######.##.##...#.#..",
+	"#.###.#.#..####..#..########.##.#.####.######.#.#",
+	"#.###.#..#.#........#.#..#....#.#...#.#......####",
+	"#.###.#...#.####.#..####.#.#.####.....#.#..#..###",
+	"#.....#...#...###.#.###....##..#.#...#.##..#..##.",
+	"#######.####.####.##....#..#.#..#.###..#.#.##..##",
+}
+
+func qrRows(qr *qrCode) []string {
+	rows := make([]string, qr.Size)
+	for y, row := range qr.Modules {
+		var b strings.Builder
+		for _, dark := range row {
+			if dark {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		rows[y] = b.String()
+	}
+	return rows
+}
+
+func TestEncodeQRGolden(t *testing.T) {
+	if got := totpURI("alice", "JBSWY3DPEHPK3PXP"); got != qrSampleAlice {
+		t.Fatalf("totpURI = %q, want %q", got, qrSampleAlice)
+	}
+	tests := []struct {
+		name   string
+		data   string
+		golden []string
+	}{
+		{"version 6", qrSampleAlice, qrGoldenAlice},
+		{"version 8 with version info", qrSampleLong, qrGoldenLong},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			qr, err := encodeQR([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := qrRows(qr)
+			if len(got) != len(test.golden) {
+				t.Fatalf("size %d, want %d", len(got), len(test.golden))
+			}
+			for y := range got {
+				if got[y] != test.golden[y] {
+					t.Errorf("row %2d: %s\n    want: %s", y, got[y], test.golden[y])
+				}
+			}
+		})
+	}
+}
+
+// TestEncodeQRDecodes reads back a symbol of every supported version the way
+// a scanner would: it checks the format information, removes the mask,
+// checks every Reed-Solomon block and decodes the byte segment.
+func TestEncodeQRDecodes(t *testing.T) {
+	for version := 1; version < len(qrVersions); version++ {
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		// The longest payload that fits this version
+		length := (qrVersions[version].dataCodewords()*8 - 4 - countBits) / 8
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i*37 + version)
+		}
+		qr, err := encodeQR(data)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if want := version*4 + 17; qr.Size != want {
+			t.Fatalf("%d bytes: size %d, want %d (version %d)", length, qr.Size, want, version)
+		}
+		decoded, err := decodeTestQR(qr)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("version %d: decoded %x, want %x", version, decoded, data)
+		}
+	}
+
+	if _, err := encodeQR(make([]byte, 214)); err == nil {
+		t.Errorf("214 bytes were encoded, want an error")
+	}
+}
+
+type qrTestError string
+
+func (e qrTestError) Error() string { return string(e) }
+
+func decodeTestQR(qr *qrCode) ([]byte, error) {
+	size := qr.Size
+	version := (size - 17) / 4
+	dark := func(x, y int) int {
+		if qr.Modules[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Format information, both copies, least significant bit first
+	first := [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+	var format, second int
+	for i, p := range first {
+		format |= dark(p[0], p[1]) << uint(i)
+		if i < 8 {
+			second |= dark(size-1-i, 8) << uint(i)
+		} else {
+			second |= dark(8, size-7+i-8) << uint(i)
+		}
+	}
+	if format != second {
+		return nil, qrTestError("the two copies of the format information differ")
+	}
+	format ^= 0x5412
+	check := format >> 10 << 10
+	for bit := 14; bit >= 10; bit-- {
+		if check&(1<<uint(bit)) != 0 {
+			check ^= 0x537 << uint(bit-10)
+		}
+	}
+	if check != format&0x3ff {
+		return nil, qrTestError("format information fails its BCH check")
+	}
+	if level := format >> 13; level != 0 {
+		return nil, qrTestError("error correction level is not M")
+	}
+	if dark(8, size-8) != 1 {
+		return nil, qrTestError("dark module is missing")
+	}
+	mask := format >> 10 & 7
+
+	// Unmask and read the codewords in zigzag order
+	function := newQRCode(version).function
+	var bits []int
+	upward := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < size; i++ {
+			y := i
+			if upward {
+				y = size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if function[y][x] {
+					continue
+				}
+				bit := dark(x, y)
+				xy := x * y
+				masked := [...]bool{
+					(x+y)%2 == 0, y%2 == 0, x%3 == 0, (x+y)%3 == 0,
+					(y/2+x/3)%2 == 0, xy%2+xy%3 == 0, (xy%2+xy%3)%2 == 0, ((x+y)%2+xy%3)%2 == 0,
+				}[mask]
+				if masked {
+					bit ^= 1
+				}
+				bits = append(bits, bit)
+			}
+		}
+		upward = !upward
+	}
+	v := qrVersions[version]
+	total := v.dataCodewords() + v.ECPerBlock*len(qrTestBlocks(v))
+	if len(bits) < total*8 {
+		return nil, qrTestError("too few data modules")
+	}
+	codewords := make([]byte, total)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] = codewords[i]<<1 | byte(bit)
+		}
+	}
+
+	// Undo the interleaving and check each block's syndromes
+	sizes := qrTestBlocks(v)
+	blocks := make([][]byte, len(sizes))
+	next := 0
+	for i := 0; i < sizes[len(sizes)-1]; i++ {
+		for b, n := range sizes {
+			if i < n {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for i := 0; i < v.ECPerBlock; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+	var data []byte
+	for b, block := range blocks {
+		alpha := byte(1)
+		for i := 0; i < v.ECPerBlock; i++ {
+			var syndrome byte
+			for _, c := range block {
+				syndrome = qrTestMultiply(syndrome, alpha) ^ c
+			}
+			if syndrome != 0 {
+				return nil, qrTestError("a Reed-Solomon syndrome is not zero")
+			}
+			alpha = qrTestMultiply(alpha, 2)
+		}
+		data = append(data, block[:sizes[b]]...)
+	}
+
+	// Byte mode segment
+	read := func(offset, n int) int {
+		value := 0
+		for i := offset; i < offset+n; i++ {
+			value = value<<1 | int(data[i/8]>>uint(7-i%8)&1)
+		}
+		return value
+	}
+	if read(0, 4) != 0x4 {
+		return nil, qrTestError("segment is not in byte mode")
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	count := read(4, countBits)
+	out := make([]byte, count)
+	for i := range out {
+		out[i] = byte(read(4+countBits+i*8, 8))
+	}
+	return out, nil
+}
+
+// qrTestBlocks lists the data codewords in each block of a version.
+func qrTestBlocks(v qrVersion) []int {
+	var sizes []int
+	for _, g := range v.Groups {
+		for i := 0; i < g[0]; i++ {
+			sizes = append(sizes, g[1])
+		}
+	}
+	return sizes
+}
+
+// qrTestMultiply multiplies in GF(2^8) by shifting and reducing, separately
+// from the encoder's implementation.
+func qrTestMultiply(a, b byte) byte {
+	var product byte
+	for b != 0 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1d
+		}
+		b >>= 1
+	}
+	return product
+}
@@ -0,0 +1,491 @@
+// registration.go
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Who may create an account with Register
+const (
+	registrationOpen     = "Open"
+	registrationDomain   = "Email Domain"
+	registrationInvite   = "Invite Only"
+	registrationApproval = "Approval"
+)
+
+var registrationModes = []string{registrationOpen, registrationDomain, registrationInvite, registrationApproval}
+
+const (
+	inviteCodeLength        = 10
+	inviteCodeAlphabet      = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	inviteLinkPrefix        = "roomy://register"
+	verificationCodeExpiry  = 15 * time.Minute
+	maxVerificationAttempts = 5
+)
+
+// RegistrationSettings controls self-registration
+type RegistrationSettings struct {
+	Mode           string   // One of registrationModes; empty is open
+	AllowedDomains []string // Email domains accepted in registrationDomain mode, e.g. example.com
+}
+
+func (c RegistrationSettings) mode() string {
+	if c.Mode == "" {
+		return registrationOpen
+	}
+	return c.Mode
+}
+
+// Invite lets someone register whatever the registration mode. A valid
+// invite skips the domain check and approval.
+type Invite struct {
+	Code      string
+	Note      string // Who the invite is for, shown to admins
+	CreatedBy string
+	Created   time.Time
+	Expires   time.Time // Zero never expires
+	MaxUses   int       // 0 is unlimited
+	Uses      int
+}
+
+func (i Invite) usable(now time.Time) bool {
+	if !i.Expires.IsZero() && now.After(i.Expires) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+var invites []Invite
+var inviteMu sync.Mutex
+
+var errAwaitingApproval = errors.New("your account is awaiting approval by an administrator")
+var errInvalidInvite = errors.New("the invite code is not valid or has expired")
+var errDomainNeedsSender = errors.New("registration by email domain needs notifications to be set up to confirm addresses")
+
+// Load and save invites
+func loadInvites() {
+	err := loadJSONFile("invites.json", &invites)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error loading invites: %v\n", err)
+	}
+}
+
+// saveInvites writes the invites. Callers must hold inviteMu.
+func saveInvites() {
+	if err := saveJSONFile("invites.json", invites); err != nil {
+		log.Printf("Error saving invites: %v\n", err)
+	}
+}
+
+// randomCode returns n characters drawn uniformly from alphabet.
+func randomCode(n int, alphabet string) string {
+	code := make([]byte, n)
+	limit := big.NewInt(int64(len(alphabet)))
+	for i := range code {
+		index, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = alphabet[index.Int64()]
+	}
+	return string(code)
+}
+
+// inviteLink is the link shared with invitees. Opening it starts roomy with
+// the registration form filled in, where the link handler is installed.
+func inviteLink(code string) string {
+	return inviteLinkPrefix + "?invite=" + url.QueryEscape(code)
+}
+
+// parseInviteCode accepts a bare code or a whole invite link.
+func parseInviteCode(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, inviteLinkPrefix) {
+		if parsed, err := url.Parse(text); err == nil {
+			text = parsed.Query().Get("invite")
+		}
+	}
+	return strings.ToUpper(strings.ReplaceAll(text, "-", ""))
+}
+
+// inviteFromArgs returns the invite code from an invite link passed on the
+// command line, as the operating system does when a link is opened.
+func inviteFromArgs(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, inviteLinkPrefix) {
+			return parseInviteCode(arg)
+		}
+	}
+	return ""
+}
+
+// findInvite returns the index of a usable invite with the given code, or -1.
+// Callers must hold inviteMu.
+func findInvite(code string) int {
+	if code == "" {
+		return -1
+	}
+	now := time.Now()
+	for i, invite := range invites {
+		if subtle.ConstantTimeCompare([]byte(invite.Code), []byte(code)) == 1 && invite.usable(now) {
+			return i
+		}
+	}
+	return -1
+}
+
+func validInvite(code string) bool {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	return findInvite(code) >= 0
+}
+
+// useInvite runs create while holding the invite and counts one
+// registration against it only once create succeeds, so a failed
+// registration leaves the invite unused.
+func useInvite(code string, create func() error) error {
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+	i := findInvite(code)
+	if i < 0 {
+		return errInvalidInvite
+	}
+	if err := create(); err != nil {
+		return err
+	}
+	invites[i].Uses++
+	saveInvites()
+	return nil
+}
+
+// emailDomainAllowed reports whether address is in one of the allowed
+// domains or their subdomains.
+func emailDomainAllowed(address string, domains []string) bool {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(address[at+1:])
+	for _, allowed := range domains {
+		allowed = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(allowed), "@"))
+		if allowed != "" && (domain == allowed || strings.HasSuffix(domain, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// registrationRequest is what a person enters in the registration form
+type registrationRequest struct {
+	Username string
+	Password string
+	Email    string
+	Invite   string
+}
+
+// checkRegistration applies the registration mode to a request before any
+// account is created or verification email sent.
+func checkRegistration(r registrationRequest) error {
+	if err := validateNewUsername(r.Username); err != nil {
+		return err
+	}
+	if err := validatePassword(r.Username, r.Password, nil); err != nil {
+		return asFieldError(fieldPassword, err)
+	}
+	if err := validateEmail(r.Email); err != nil {
+		return err
+	}
+	if r.Invite != "" {
+		if !validInvite(r.Invite) {
+			return errInvalidInvite
+		}
+		return nil
+	}
+	switch settings.Registration.mode() {
+	case registrationInvite:
+		return errors.New("registration is by invitation only; ask an administrator for an invite code")
+	case registrationDomain:
+		if currentSender() == nil {
+			return errDomainNeedsSender
+		}
+		if r.Email == "" {
+			return errors.New("an email address is required to register")
+		}
+		if !emailDomainAllowed(r.Email, settings.Registration.AllowedDomains) {
+			return fmt.Errorf("registration is limited to addresses at %s", strings.Join(settings.Registration.AllowedDomains, ", "))
+		}
+	}
+	return nil
+}
+
+// needsEmailVerification reports whether the email address must be
+// confirmed with a code before the account is created. checkRegistration
+// refuses domain registrations while there is no sender to send the code.
+func needsEmailVerification(r registrationRequest) bool {
+	return r.Invite == "" && settings.Registration.mode() == registrationDomain
+}
+
+// registerUser creates the account for a checked request. In approval mode
+// the account is pending until an admin approves it.
+func registerUser(r registrationRequest) (*User, error) {
+	if err := checkRegistration(r); err != nil {
+		return nil, err
+	}
+	create := func() error {
+		return createUser(r.Username, r.Password, "User", r.Email)
+	}
+	if r.Invite != "" {
+		if err := useInvite(r.Invite, create); err != nil {
+			return nil, err
+		}
+	} else if err := create(); err != nil {
+		return nil, err
+	}
+	user := findUser(r.Username)
+	if r.Invite == "" && settings.Registration.mode() == registrationApproval {
+		user.Pending = true
+		saveUsers()
+	}
+	return user, nil
+}
+
+// checkCanBook refuses bookings from accounts awaiting approval.
+func checkCanBook() error {
+	if currentUser != nil && currentUser.Pending {
+		return errAwaitingApproval
+	}
+	return nil
+}
+
+// approveUser lets a pending account book rooms.
+func approveUser(username string) error {
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	user.Pending = false
+	saveUsers()
+	return nil
+}
+
+func countPendingUsers() int {
+	count := 0
+	for _, user := range users {
+		if user.Pending {
+			count++
+		}
+	}
+	return count
+}
+
+// sendVerificationCode emails a one-time code to address and returns it.
+func sendVerificationCode(address string) (string, error) {
+	code := randomCode(6, "0123456789")
+	body := fmt.Sprintf("Your roomy verification code is %s.\n\nIt expires in %d minutes. If you did not try to register, you can ignore this message.",
+		code, int(verificationCodeExpiry.Minutes()))
+	if err := currentSender().Send(address, "Confirm your email address", body); err != nil {
+		log.Printf("Error sending verification code to %s: %v\n", address, err)
+		return "", errors.New("the verification email could not be sent; please try again later")
+	}
+	return code, nil
+}
+
+// showEmailVerification sends a code to the registering address and calls
+// onVerified once it has been entered.
+func showEmailVerification(address string, w fyne.Window, onVerified func()) {
+	code, err := sendVerificationCode(address)
+	if err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	sent := time.Now()
+	attempts := 0
+
+	var prompt func()
+	prompt = func() {
+		codeEntry := widget.NewEntry()
+		dialog.ShowForm("Confirm Your Email", "Confirm", "Cancel", []*widget.FormItem{
+			{Text: "", Widget: widget.NewLabel(fmt.Sprintf("We sent a code to %s.", address))},
+			{Text: "Code", Widget: codeEntry},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			if time.Since(sent) > verificationCodeExpiry {
+				dialog.ShowError(errors.New("the code has expired; please register again"), w)
+				return
+			}
+			attempts++
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(codeEntry.Text)), []byte(code)) != 1 {
+				if attempts >= maxVerificationAttempts {
+					dialog.ShowError(errors.New("too many incorrect codes; please register again"), w)
+					return
+				}
+				d := dialog.NewError(errInvalidCode, w)
+				d.SetOnClosed(prompt)
+				d.Show()
+				return
+			}
+			onVerified()
+		}, w)
+	}
+	prompt()
+}
+
+// showRegistrationSettings lets admins choose the registration mode and
+// manage invites.
+func showRegistrationSettings(w fyne.Window) {
+	modeSelect := widget.NewSelect(registrationModes, nil)
+	modeSelect.SetSelected(settings.Registration.mode())
+	domainsEntry := widget.NewMultiLineEntry()
+	domainsEntry.SetPlaceHolder("example.com")
+	domainsEntry.SetText(strings.Join(settings.Registration.AllowedDomains, "\n"))
+	domainsEntry.SetMinRowsVisible(3)
+
+	help := widget.NewLabel("Open: anyone can register.\nEmail Domain: only addresses in the listed domains; a code is emailed to confirm the address, so notifications must be set up.\nInvite Only: an invite code or link is required.\nApproval: new accounts cannot book until approved in Manage Users.\nA valid invite always lets someone register.")
+	help.Wrapping = fyne.TextWrapWord
+
+	form := widget.NewForm(
+		&widget.FormItem{Text: "Who Can Register", Widget: modeSelect},
+		&widget.FormItem{Text: "Allowed Domains", Widget: domainsEntry, HintText: "One per line; subdomains are included"},
+	)
+
+	list := container.NewVBox()
+	var rebuild func()
+	rebuild = func() {
+		list.Objects = nil
+		inviteMu.Lock()
+		current := append([]Invite(nil), invites...)
+		inviteMu.Unlock()
+		now := time.Now()
+		for _, invite := range current {
+			code := invite.Code
+			uses := fmt.Sprintf("%d uses", invite.Uses)
+			if invite.MaxUses > 0 {
+				uses = fmt.Sprintf("%d of %d uses", invite.Uses, invite.MaxUses)
+			}
+			expiry := "never expires"
+			if !invite.Expires.IsZero() {
+				expiry = "expires " + invite.Expires.In(siteLocation()).Format("Jan 2 2006")
+			}
+			status := ""
+			if !invite.usable(now) {
+				status = " (used up or expired)"
+			}
+			label := widget.NewLabel(fmt.Sprintf("%s  %s%s\n%s, %s", code, invite.Note, status, uses, expiry))
+			copyButton := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+				w.Clipboard().SetContent(inviteLink(code))
+			})
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Revoke Invite", fmt.Sprintf("Revoke invite %s?", code), func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+					inviteMu.Lock()
+					for i := range invites {
+						if invites[i].Code == code {
+							invites = append(invites[:i], invites[i+1:]...)
+							break
+						}
+					}
+					saveInvites()
+					inviteMu.Unlock()
+					rebuild()
+				}, w)
+			})
+			list.Add(container.NewBorder(nil, nil, nil, container.NewHBox(copyButton, deleteButton), label))
+		}
+		if len(current) == 0 {
+			list.Add(widget.NewLabel("No invites."))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	newInviteButton := widget.NewButtonWithIcon("New Invite", theme.ContentAddIcon(), func() {
+		noteEntry := widget.NewEntry()
+		noteEntry.SetPlaceHolder("e.g. New starters, March")
+		usesEntry := widget.NewEntry()
+		usesEntry.SetText("1")
+		daysEntry := widget.NewEntry()
+		daysEntry.SetText("14")
+		dialog.ShowForm("New Invite", "Create", "Cancel", []*widget.FormItem{
+			{Text: "Note", Widget: noteEntry},
+			{Text: "Uses", Widget: usesEntry, HintText: "0 for unlimited"},
+			{Text: "Valid for (days)", Widget: daysEntry, HintText: "0 never expires"},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			maxUses, err := strconv.Atoi(strings.TrimSpace(usesEntry.Text))
+			if err != nil || maxUses < 0 {
+				dialog.ShowError(errors.New("uses must be 0 or a positive number"), w)
+				return
+			}
+			days, err := strconv.Atoi(strings.TrimSpace(daysEntry.Text))
+			if err != nil || days < 0 {
+				dialog.ShowError(errors.New("days must be 0 or a positive number"), w)
+				return
+			}
+			invite := Invite{
+				Code:      randomCode(inviteCodeLength, inviteCodeAlphabet),
+				Note:      strings.TrimSpace(noteEntry.Text),
+				CreatedBy: currentUser.Username,
+				Created:   time.Now(),
+				MaxUses:   maxUses,
+			}
+			if days > 0 {
+				invite.Expires = invite.Created.AddDate(0, 0, days)
+			}
+			inviteMu.Lock()
+			invites = append(invites, invite)
+			saveInvites()
+			inviteMu.Unlock()
+			rebuild()
+			w.Clipboard().SetContent(inviteLink(invite.Code))
+			dialog.ShowInformation("Invite Created", fmt.Sprintf("Invite code: %s\n\nThe link %s has been copied to the clipboard.", invite.Code, inviteLink(invite.Code)), w)
+		}, w)
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(500, 180))
+	invitesHeader := container.NewBorder(nil, nil, widget.NewLabelWithStyle("Invites", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}), newInviteButton)
+	content := container.NewBorder(container.NewVBox(form, help, widget.NewSeparator(), invitesHeader), nil, nil, nil, scroll)
+
+	d := dialog.NewCustomConfirm("Registration", "Save", "Close", content, func(save bool) {
+		if !save {
+			return
+		}
+		updated := RegistrationSettings{Mode: modeSelect.Selected, AllowedDomains: splitLines(domainsEntry.Text)}
+		if updated.Mode == registrationDomain && len(updated.AllowedDomains) == 0 {
+			dialog.ShowError(errors.New("enter at least one allowed domain"), w)
+			return
+		}
+		if updated.Mode == registrationDomain && currentSender() == nil {
+			dialog.ShowError(errors.New("set up notifications in Settings before limiting registration by email domain"), w)
+			return
+		}
+		settings.Registration = updated
+		saveSettings()
+	}, w)
+	d.Resize(fyne.NewSize(600, 680))
+	d.Show()
+}
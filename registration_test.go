@@ -0,0 +1,80 @@
+// registration_test.go
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// useTestInvite replaces the invites with one single-use invite for the
+// length of the test.
+func useTestInvite(t *testing.T, code string) {
+	t.Helper()
+	inviteMu.Lock()
+	saved := invites
+	invites = []Invite{{Code: code, Created: time.Now(), MaxUses: 1}}
+	inviteMu.Unlock()
+	t.Cleanup(func() {
+		inviteMu.Lock()
+		invites = saved
+		inviteMu.Unlock()
+	})
+}
+
+func TestUseInviteCountsOnlySuccessfulRegistrations(t *testing.T) {
+	useTestDataDir(t)
+	useTestInvite(t, "TESTCODE23")
+
+	failed := errors.New("create failed")
+	if err := useInvite("TESTCODE23", func() error { return failed }); err != failed {
+		t.Fatalf("useInvite returned %v, want the create error", err)
+	}
+	if !validInvite("TESTCODE23") {
+		t.Fatal("a failed registration used up the invite")
+	}
+
+	if err := useInvite("TESTCODE23", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if validInvite("TESTCODE23") {
+		t.Error("a single-use invite is still valid after a registration")
+	}
+	called := false
+	if err := useInvite("TESTCODE23", func() error { called = true; return nil }); err != errInvalidInvite {
+		t.Errorf("used-up invite: got %v, want errInvalidInvite", err)
+	}
+	if called {
+		t.Error("a used-up invite still created the account")
+	}
+}
+
+func TestDomainRegistrationNeedsSender(t *testing.T) {
+	useTestDataDir(t)
+	settings.Registration = RegistrationSettings{Mode: registrationDomain, AllowedDomains: []string{"example.com"}}
+	request := registrationRequest{Username: "alice", Password: "correct horse battery", Email: "alice@example.com"}
+
+	settings.Notifications = NotificationSettings{Sender: senderOff}
+	if err := checkRegistration(request); err != errDomainNeedsSender {
+		t.Errorf("without a sender: got %v, want errDomainNeedsSender", err)
+	}
+	if _, err := registerUser(request); err != errDomainNeedsSender {
+		t.Errorf("registerUser without a sender: got %v, want errDomainNeedsSender", err)
+	}
+	if findUser("alice") != nil {
+		t.Error("an account was created without verifying the address")
+	}
+
+	settings.Notifications = NotificationSettings{Sender: senderFile}
+	if err := checkRegistration(request); err != nil {
+		t.Errorf("with a sender: %v", err)
+	}
+	if !needsEmailVerification(request) {
+		t.Error("a domain registration does not need verification")
+	}
+	request.Email = "alice@example.org"
+	if err := checkRegistration(request); err == nil {
+		t.Error("an address outside the allowed domains was accepted")
+	}
+}
@@ -0,0 +1,569 @@
+// reports.go
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Report date ranges. Every period except the custom one is worked out
+// again each time a saved report is run.
+const (
+	reportPeriodCustom    = "Custom Dates"
+	reportPeriodToday     = "Today"
+	reportPeriodThisWeek  = "This Week"
+	reportPeriodLastWeek  = "Last Week"
+	reportPeriodThisMonth = "This Month"
+	reportPeriodLastMonth = "Last Month"
+	reportPeriodLast30    = "Last 30 Days"
+)
+
+var reportPeriods = []string{
+	reportPeriodCustom,
+	reportPeriodToday,
+	reportPeriodThisWeek,
+	reportPeriodLastWeek,
+	reportPeriodThisMonth,
+	reportPeriodLastMonth,
+	reportPeriodLast30,
+}
+
+// Reservation statuses shown in reports
+const (
+	reportStatusAll       = "All"
+	reportStatusUpcoming  = "Upcoming"
+	reportStatusCompleted = "Completed"
+	reportStatusNoShow    = "No-Show"
+	reportStatusCancelled = "Cancelled"
+)
+
+var reportStatuses = []string{reportStatusAll, reportStatusUpcoming, reportStatusCompleted, reportStatusNoShow, reportStatusCancelled}
+
+// Export formats
+const (
+	reportFormatCSV  = "CSV"
+	reportFormatXLSX = "XLSX"
+)
+
+const (
+	reportAllRooms = "All Rooms"
+	reportAllUsers = "All Users"
+)
+
+var reportHeader = []string{"Room", "Location", "Date", "Start", "End", "Duration (min)", "Purpose", "Leader", "Student", "Booked By", "Status"}
+var reportWidths = []float64{20, 36, 12, 17, 17, 14, 14, 20, 20, 14, 11}
+
+// ReportFilter selects the reservations in a report
+type ReportFilter struct {
+	Period string // One of reportPeriods
+	From   string // First day for custom dates, "2006-01-02"; empty is unbounded
+	To     string // Last day for custom dates, inclusive
+	Room   string // Empty for every room
+	User   string // Username of the booker; empty for everyone
+	Status string // One of reportStatuses; empty is all
+}
+
+// SavedReport is a named filter and format that admins can export again
+type SavedReport struct {
+	ID        string
+	Name      string
+	Filter    ReportFilter
+	Format    string
+	CreatedBy string
+	Created   time.Time
+	LastRun   time.Time
+}
+
+// reportRow is one reservation in a report, with times in the site zone
+type reportRow struct {
+	Room     string
+	Location string
+	Start    time.Time
+	End      time.Time
+	Purpose  string
+	Leader   string
+	Student  string
+	BookedBy string
+	Status   string
+}
+
+var (
+	savedReports = []SavedReport{}
+	reportMu     sync.Mutex
+)
+
+// Load and save saved reports
+func loadReports() {
+	err := loadJSONFile("reports.json", &savedReports)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error loading reports: %v\n", err)
+	}
+}
+
+func saveReports() {
+	if err := saveJSONFile("reports.json", &savedReports); err != nil {
+		log.Printf("Error saving reports: %v\n", err)
+	}
+}
+
+// dateRange returns the first and last day covered by the filter on the
+// day of now. An empty day leaves that end of the range open.
+func (f ReportFilter) dateRange(now time.Time) (from, to string) {
+	const layout = "2006-01-02"
+	now = now.In(siteLocation())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// Weeks start on Monday
+	weekStart := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	monthStart := today.AddDate(0, 0, 1-today.Day())
+	switch f.Period {
+	case reportPeriodToday:
+		return today.Format(layout), today.Format(layout)
+	case reportPeriodThisWeek:
+		return weekStart.Format(layout), weekStart.AddDate(0, 0, 6).Format(layout)
+	case reportPeriodLastWeek:
+		return weekStart.AddDate(0, 0, -7).Format(layout), weekStart.AddDate(0, 0, -1).Format(layout)
+	case reportPeriodThisMonth:
+		return monthStart.Format(layout), monthStart.AddDate(0, 1, -1).Format(layout)
+	case reportPeriodLastMonth:
+		return monthStart.AddDate(0, -1, 0).Format(layout), monthStart.AddDate(0, 0, -1).Format(layout)
+	case reportPeriodLast30:
+		return today.AddDate(0, 0, -29).Format(layout), today.Format(layout)
+	}
+	return f.From, f.To
+}
+
+// validate checks the custom dates and the choices in the filter.
+func (f ReportFilter) validate() error {
+	if f.Period == reportPeriodCustom || f.Period == "" {
+		for _, day := range []string{f.From, f.To} {
+			if day == "" {
+				continue
+			}
+			if _, err := time.ParseInLocation("2006-01-02", day, siteLocation()); err != nil {
+				return fmt.Errorf("invalid date %q, use YYYY-MM-DD", day)
+			}
+		}
+		if f.From != "" && f.To != "" && f.From > f.To {
+			return errors.New("the start date is after the end date")
+		}
+	}
+	if f.Status != "" && !containsString(reportStatuses, f.Status) {
+		return fmt.Errorf("unknown status %q", f.Status)
+	}
+	return nil
+}
+
+// describe summarises the filter, e.g. "Last Week, Study Room 1, Cancelled".
+func (f ReportFilter) describe() string {
+	parts := []string{}
+	if f.Period == reportPeriodCustom || f.Period == "" {
+		switch {
+		case f.From != "" && f.To != "":
+			parts = append(parts, f.From+" to "+f.To)
+		case f.From != "":
+			parts = append(parts, "From "+f.From)
+		case f.To != "":
+			parts = append(parts, "Until "+f.To)
+		default:
+			parts = append(parts, "All dates")
+		}
+	} else {
+		parts = append(parts, f.Period)
+	}
+	if f.Room != "" {
+		parts = append(parts, f.Room)
+	}
+	if f.User != "" {
+		parts = append(parts, "booked by "+f.User)
+	}
+	if f.Status != "" && f.Status != reportStatusAll {
+		parts = append(parts, f.Status)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reservationStatus names the state of a reservation at now.
+func reservationStatus(res Reservation, now time.Time) string {
+	switch {
+	case !res.Active:
+		return reportStatusCancelled
+	case res.NoShow:
+		return reportStatusNoShow
+	case res.EndTime.After(now):
+		return reportStatusUpcoming
+	}
+	return reportStatusCompleted
+}
+
+// collectReport returns the reservations matching the filter, earliest
+// first.
+func collectReport(filter ReportFilter, now time.Time) []reportRow {
+	from, to := filter.dateRange(now)
+	rows := []reportRow{}
+	for _, room := range rooms {
+		if filter.Room != "" && room.Name != filter.Room {
+			continue
+		}
+		location := floorLabel(room.FloorID)
+		room.mu.Lock()
+		for _, res := range room.Reservations {
+			if (from != "" && res.Date < from) || (to != "" && res.Date > to) {
+				continue
+			}
+			if filter.User != "" && !strings.EqualFold(res.BookedBy, filter.User) {
+				continue
+			}
+			if res.Undone {
+				continue
+			}
+			status := reservationStatus(res, now)
+			if filter.Status != "" && filter.Status != reportStatusAll && status != filter.Status {
+				continue
+			}
+			rows = append(rows, reportRow{
+				Room:     room.Name,
+				Location: location,
+				Start:    res.StartTime.In(siteLocation()),
+				End:      res.EndTime.In(siteLocation()),
+				Purpose:  res.Purpose,
+				Leader:   res.Leader,
+				Student:  res.Student,
+				BookedBy: res.BookedBy,
+				Status:   status,
+			})
+		}
+		room.mu.Unlock()
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].Start.Equal(rows[j].Start) {
+			return rows[i].Start.Before(rows[j].Start)
+		}
+		return rows[i].Room < rows[j].Room
+	})
+	return rows
+}
+
+// minutes returns the length of the reservation in whole minutes.
+func (r reportRow) minutes() int {
+	return int(r.End.Sub(r.Start) / time.Minute)
+}
+
+// csvSafe stops spreadsheet programs from running text that looks like a
+// formula, such as a purpose starting with "=".
+func csvSafe(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// writeReportCSV writes rows as CSV with a byte order mark and CRLF line
+// endings, which Excel needs to read UTF-8 names correctly.
+func writeReportCSV(w io.Writer, rows []reportRow) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	out.UseCRLF = true
+	if err := out.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			csvSafe(row.Room),
+			csvSafe(row.Location),
+			row.Start.Format("2006-01-02"),
+			row.Start.Format("2006-01-02 15:04"),
+			row.End.Format("2006-01-02 15:04"),
+			fmt.Sprint(row.minutes()),
+			csvSafe(row.Purpose),
+			csvSafe(row.Leader),
+			csvSafe(row.Student),
+			csvSafe(row.BookedBy),
+			row.Status,
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeReportXLSX writes rows as an Excel workbook with real dates and
+// numbers, so they can be sorted and summed.
+func writeReportXLSX(w io.Writer, rows []reportRow) error {
+	cells := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		cells[i] = []interface{}{
+			row.Room,
+			row.Location,
+			xlsxDate(row.Start),
+			row.Start,
+			row.End,
+			row.minutes(),
+			row.Purpose,
+			row.Leader,
+			row.Student,
+			row.BookedBy,
+			row.Status,
+		}
+	}
+	return writeXLSX(w, "Reservations", reportHeader, reportWidths, cells)
+}
+
+// exportReport writes the reservations matching filter in the given format
+// and returns how many there were.
+func exportReport(w io.Writer, filter ReportFilter, format string, now time.Time) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	rows := collectReport(filter, now)
+	var err error
+	if format == reportFormatXLSX {
+		err = writeReportXLSX(w, rows)
+	} else {
+		err = writeReportCSV(w, rows)
+	}
+	return len(rows), err
+}
+
+// reportFileName suggests a file name such as "last-week-2024-05-06.xlsx".
+func reportFileName(name, format string, now time.Time) string {
+	slug := strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, name), "-")
+	if slug == "" {
+		slug = "reservations"
+	}
+	return fmt.Sprintf("%s-%s.%s", slug, now.In(siteLocation()).Format("2006-01-02"), strings.ToLower(format))
+}
+
+// saveReportFile asks where to save an export and writes it there.
+func saveReportFile(name string, filter ReportFilter, format string, onSaved func(), w fyne.Window) {
+	if err := filter.validate(); err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	now := time.Now()
+	fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		count, err := exportReport(writer, filter, format, now)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("could not export the report: %v", err), w)
+			return
+		}
+		if onSaved != nil {
+			onSaved()
+		}
+		dialog.ShowInformation("Export Complete", fmt.Sprintf("Exported %d reservations to %s.", count, writer.URI().Name()), w)
+	}, w)
+	fileDialog.SetFileName(reportFileName(name, format, now))
+	fileDialog.Show()
+}
+
+// showReports lets admins export reservations and manage saved reports.
+func showReports(w fyne.Window) {
+	periodSelect := widget.NewSelect(reportPeriods, nil)
+	fromEntry := widget.NewEntry()
+	fromEntry.SetPlaceHolder("YYYY-MM-DD")
+	toEntry := widget.NewEntry()
+	toEntry.SetPlaceHolder("YYYY-MM-DD")
+	roomSelect := widget.NewSelect(append([]string{reportAllRooms}, roomNames()...), nil)
+	roomSelect.SetSelected(reportAllRooms)
+	usernames := []string{reportAllUsers}
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	userSelect := widget.NewSelect(usernames, nil)
+	userSelect.SetSelected(reportAllUsers)
+	statusSelect := widget.NewSelect(reportStatuses, nil)
+	statusSelect.SetSelected(reportStatusAll)
+	formatSelect := widget.NewSelect([]string{reportFormatCSV, reportFormatXLSX}, nil)
+	formatSelect.SetSelected(reportFormatXLSX)
+	matchLabel := widget.NewLabel("")
+
+	currentFilter := func() ReportFilter {
+		filter := ReportFilter{Period: periodSelect.Selected, Status: statusSelect.Selected}
+		if filter.Period == reportPeriodCustom {
+			filter.From = strings.TrimSpace(fromEntry.Text)
+			filter.To = strings.TrimSpace(toEntry.Text)
+		}
+		if roomSelect.Selected != reportAllRooms {
+			filter.Room = roomSelect.Selected
+		}
+		if userSelect.Selected != reportAllUsers {
+			filter.User = userSelect.Selected
+		}
+		return filter
+	}
+	updateCount := func() {
+		filter := currentFilter()
+		if err := filter.validate(); err != nil {
+			matchLabel.SetText(err.Error())
+			return
+		}
+		from, to := filter.dateRange(time.Now())
+		count := len(collectReport(filter, time.Now()))
+		switch {
+		case from != "" && to != "":
+			matchLabel.SetText(fmt.Sprintf("%d reservations from %s to %s.", count, from, to))
+		default:
+			matchLabel.SetText(fmt.Sprintf("%d reservations.", count))
+		}
+	}
+	periodSelect.OnChanged = func(period string) {
+		if period == reportPeriodCustom {
+			fromEntry.Enable()
+			toEntry.Enable()
+		} else {
+			fromEntry.Disable()
+			toEntry.Disable()
+		}
+		updateCount()
+	}
+	fromEntry.OnChanged = func(string) { updateCount() }
+	toEntry.OnChanged = func(string) { updateCount() }
+	roomSelect.OnChanged = func(string) { updateCount() }
+	userSelect.OnChanged = func(string) { updateCount() }
+	statusSelect.OnChanged = func(string) { updateCount() }
+	periodSelect.SetSelected(reportPeriodLastWeek)
+
+	form := widget.NewForm(
+		widget.NewFormItem("Period", periodSelect),
+		widget.NewFormItem("From", fromEntry),
+		widget.NewFormItem("To", toEntry),
+		widget.NewFormItem("Room", roomSelect),
+		widget.NewFormItem("Booked By", userSelect),
+		widget.NewFormItem("Status", statusSelect),
+		widget.NewFormItem("Format", formatSelect),
+	)
+
+	list := container.NewVBox()
+	var rebuild func()
+	rebuild = func() {
+		list.Objects = nil
+		reportMu.Lock()
+		saved := append([]SavedReport{}, savedReports...)
+		reportMu.Unlock()
+		for _, report := range saved {
+			report := report
+			markRun := func() {
+				reportMu.Lock()
+				for i := range savedReports {
+					if savedReports[i].ID == report.ID {
+						savedReports[i].LastRun = time.Now()
+					}
+				}
+				saveReports()
+				reportMu.Unlock()
+				rebuild()
+			}
+			runButton := widget.NewButtonWithIcon("Export", theme.DocumentSaveIcon(), func() {
+				saveReportFile(report.Name, report.Filter, report.Format, markRun, w)
+			})
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Report", fmt.Sprintf("Delete the saved report '%s'?", report.Name), func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+					reportMu.Lock()
+					for i := range savedReports {
+						if savedReports[i].ID == report.ID {
+							savedReports = append(savedReports[:i], savedReports[i+1:]...)
+							break
+						}
+					}
+					saveReports()
+					reportMu.Unlock()
+					rebuild()
+				}, w)
+			})
+			lastRun := "never exported"
+			if !report.LastRun.IsZero() {
+				lastRun = "last exported " + report.LastRun.In(siteLocation()).Format("Jan 2 2006 "+timeLayout12Hour)
+			}
+			label := widget.NewLabel(fmt.Sprintf("%s (%s)\n%s; %s", report.Name, report.Format, report.Filter.describe(), lastRun))
+			list.Add(container.NewBorder(nil, nil, nil, container.NewHBox(runButton, deleteButton), label))
+		}
+		if len(saved) == 0 {
+			list.Add(widget.NewLabel("No saved reports."))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	exportButton := widget.NewButtonWithIcon("Export...", theme.DocumentSaveIcon(), func() {
+		saveReportFile("reservations", currentFilter(), formatSelect.Selected, nil, w)
+	})
+	saveButton := widget.NewButtonWithIcon("Save as Report", theme.ContentAddIcon(), func() {
+		filter := currentFilter()
+		if err := filter.validate(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		nameEntry := widget.NewEntry()
+		nameEntry.SetPlaceHolder("Weekly bookings")
+		dialog.ShowForm("Save Report", "Save", "Cancel", []*widget.FormItem{
+			{Text: "Name", Widget: nameEntry, HintText: filter.describe()},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			name := strings.TrimSpace(nameEntry.Text)
+			if name == "" {
+				dialog.ShowError(errors.New("report name cannot be empty"), w)
+				return
+			}
+			reportMu.Lock()
+			savedReports = append(savedReports, SavedReport{
+				ID:        newLocationID(),
+				Name:      name,
+				Filter:    filter,
+				Format:    formatSelect.Selected,
+				CreatedBy: currentUser.Username,
+				Created:   time.Now(),
+			})
+			saveReports()
+			reportMu.Unlock()
+			rebuild()
+		}, w)
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(550, 180))
+	top := container.NewVBox(form, matchLabel, container.NewHBox(exportButton, saveButton), widget.NewSeparator(), widget.NewLabel("Saved Reports"))
+	d := dialog.NewCustom("Reports", "Close", container.NewBorder(top, nil, nil, nil, scroll), w)
+	d.Resize(fyne.NewSize(600, 650))
+	d.Show()
+}
@@ -0,0 +1,217 @@
+// reports_test.go
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportLeavesOutUndoneBookings(t *testing.T) {
+	room := useTestRoom(t, "Lab A")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	now := start.AddDate(0, 0, -1)
+
+	undone := &ReservationCommand{room: room, reservation: testReservation(start, "Undone")}
+	if err := undone.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if err := undone.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := &ReservationCommand{room: room, reservation: testReservation(start, "Kept")}
+	cancelled := &ReservationCommand{room: room, reservation: testReservation(start.Add(time.Hour), "Cancelled")}
+	for _, c := range []*ReservationCommand{kept, cancelled} {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (&CancelReservationCommand{room: room, index: cancelled.index}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	// A cancellation saved by an earlier version, which has no Undone flag
+	room.Reservations = append(room.Reservations, testReservation(start.Add(2*time.Hour), "Earlier"))
+
+	rows := collectReport(ReportFilter{}, now)
+	got := map[string]string{}
+	for _, row := range rows {
+		got[row.Purpose] = row.Status
+	}
+	want := map[string]string{"Kept": reportStatusUpcoming, "Cancelled": reportStatusCancelled, "Earlier": reportStatusCancelled}
+	if len(got) != len(want) {
+		t.Errorf("report statuses %v, want %v", got, want)
+	}
+	for purpose, status := range want {
+		if got[purpose] != status {
+			t.Errorf("%s booking has status %q, want %q", purpose, got[purpose], status)
+		}
+	}
+
+	restore := &CancelReservationCommand{room: room, index: cancelled.index}
+	if err := restore.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if status := reservationStatus(room.Reservations[cancelled.index], now); status != reportStatusUpcoming {
+		t.Errorf("restored booking has status %s, want %s", status, reportStatusUpcoming)
+	}
+}
+
+// xlsxTestCell is a cell as written in a worksheet
+type xlsxTestCell struct {
+	Ref     string `xml:"r,attr"`
+	Style   int    `xml:"s,attr"`
+	Type    string `xml:"t,attr"`
+	Value   string `xml:"v"`
+	Text    string `xml:"is>t"`
+	Formula string `xml:"f"`
+}
+
+type xlsxTestSheet struct {
+	Rows []struct {
+		Number int            `xml:"r,attr"`
+		Cells  []xlsxTestCell `xml:"c"`
+	} `xml:"sheetData>row"`
+	AutoFilter struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"autoFilter"`
+}
+
+// readTestXLSX returns the parts of a workbook by name, failing the test
+// if any of them is not well-formed XML.
+func readTestXLSX(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", f.Name, err)
+			}
+		}
+		parts[f.Name] = string(body)
+	}
+	return parts
+}
+
+func TestWriteReportXLSX(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	rows := []reportRow{{
+		Room:     `Lab <A> & "B"`,
+		Location: "Main > 1st Floor",
+		Start:    start,
+		End:      start.Add(90 * time.Minute),
+		Purpose:  "=SUM(A1:A9)",
+		Leader:   "O'Brien",
+		Student:  "Zoë",
+		BookedBy: "alice",
+		Status:   reportStatusUpcoming,
+	}}
+	var out bytes.Buffer
+	if err := writeReportXLSX(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	parts := readTestXLSX(t, out.Bytes())
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook has no %s", name)
+		}
+	}
+	// Strings are written inline, so there is no shared string table
+	if _, ok := parts["xl/sharedStrings.xml"]; ok || strings.Contains(parts["[Content_Types].xml"], "sharedStrings") {
+		t.Error("workbook has a shared string table")
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Reservations"`) {
+		t.Error("the sheet is not named Reservations")
+	}
+
+	raw := parts["xl/worksheets/sheet1.xml"]
+	for _, escaped := range []string{`Lab &lt;A&gt; &amp; &#34;B&#34;`, `Main &gt; 1st Floor`, `O&#39;Brien`} {
+		if !strings.Contains(raw, escaped) {
+			t.Errorf("sheet does not contain %s", escaped)
+		}
+	}
+	var sheet xlsxTestSheet
+	if err := xml.Unmarshal([]byte(raw), &sheet); err != nil {
+		t.Fatal(err)
+	}
+	if sheet.AutoFilter.Ref != "A1:K2" {
+		t.Errorf("autofilter covers %s, want A1:K2", sheet.AutoFilter.Ref)
+	}
+	if len(sheet.Rows) != 2 {
+		t.Fatalf("sheet has %d rows, want 2", len(sheet.Rows))
+	}
+	header := sheet.Rows[0].Cells
+	if len(header) != len(reportHeader) {
+		t.Fatalf("header has %d cells, want %d", len(header), len(reportHeader))
+	}
+	for i, cell := range header {
+		if cell.Text != reportHeader[i] || cell.Style != xlsxStyleHeader {
+			t.Errorf("header cell %s is %q in style %d", cell.Ref, cell.Text, cell.Style)
+		}
+	}
+
+	cells := map[string]xlsxTestCell{}
+	for _, cell := range sheet.Rows[1].Cells {
+		cells[cell.Ref] = cell
+	}
+	texts := map[string]string{"A2": rows[0].Room, "B2": rows[0].Location, "G2": rows[0].Purpose, "H2": rows[0].Leader, "I2": rows[0].Student, "K2": reportStatusUpcoming}
+	for ref, want := range texts {
+		cell := cells[ref]
+		if cell.Type != "inlineStr" || cell.Text != want || cell.Formula != "" {
+			t.Errorf("cell %s is %+v, want the inline string %q", ref, cell, want)
+		}
+	}
+	// 2026-03-02 is day 46083 of Excel's calendar and 9:30 is 0.3958333 of a
+	// day; the date column keeps the time but its format hides it
+	numbers := map[string]struct {
+		value string
+		style int
+	}{
+		"C2": {"46083.395833333336", xlsxStyleDate},
+		"D2": {"46083.395833333336", xlsxStyleDateTime},
+		"E2": {"46083.458333333336", xlsxStyleDateTime},
+		"F2": {"90", xlsxStyleDefault},
+	}
+	for ref, want := range numbers {
+		cell := cells[ref]
+		if cell.Type != "" || cell.Value != want.value || cell.Style != want.style {
+			t.Errorf("cell %s is %+v, want %s in style %d", ref, cell, want.value, want.style)
+		}
+	}
+}
+
+func TestWriteXLSXQuotesSheetName(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeXLSX(&out, "Bob's <Rooms>", []string{"A", "B"}, []float64{10, 10}, [][]interface{}{{"x", 1}}); err != nil {
+		t.Fatal(err)
+	}
+	workbook := readTestXLSX(t, out.Bytes())["xl/workbook.xml"]
+	if !strings.Contains(workbook, `name="Bob&#39;s &lt;Rooms&gt;"`) {
+		t.Error("the sheet name is not escaped")
+	}
+	if !strings.Contains(workbook, `&#39;Bob&#39;&#39;s &lt;Rooms&gt;&#39;!$A$1:$B$2`) {
+		t.Error("the filter range does not quote the sheet name")
+	}
+}
@@ -0,0 +1,174 @@
+// roompanel.go
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// scheduleEntry is one row of a room's day schedule
+type scheduleEntry struct {
+	start time.Time
+	row   fyne.CanvasObject
+}
+
+// openRoomBooking shows a room's details, today's timeline and free windows,
+// and lets the user book straight from the floor plan.
+func openRoomBooking(room *Room, w fyne.Window) {
+	now := time.Now().In(siteLocation())
+	today := now.Format("2006-01-02")
+	busy, free := roomDaySchedule(room, today)
+
+	var d dialog.Dialog
+	reopen := func() {
+		d.Hide()
+		openRoomBooking(room, w)
+	}
+
+	// Room details
+	status := "Available now"
+	for _, i := range busy {
+		res := room.Reservations[i]
+		if !res.StartTime.After(now) && res.EndTime.After(now) {
+			status = fmt.Sprintf("In use until %s", formatClock(res.EndTime))
+			break
+		}
+	}
+	placement := "Not placed on floor plan"
+	if room.Position != (fyne.Position{}) {
+		placement = "Placed on floor plan"
+	}
+	details := widget.NewLabel(fmt.Sprintf("Room: %s\nLocation: %s\nStatus: %s\nReservations today: %d\n%s", room.Name, floorLabel(room.FloorID), status, len(busy), placement))
+
+	// Today's schedule, reservations and free windows in time order
+	schedule := container.NewVBox()
+	entries := []scheduleEntry{}
+	for _, i := range busy {
+		index := i
+		res := room.Reservations[index]
+		label := fmt.Sprintf("%s - %s  %s (%s)", formatClock(res.StartTime), formatClock(res.EndTime), res.Purpose, res.Leader)
+		row := widget.NewButton(label, func() {
+			showReservationDetails(room, index, reopen, w)
+		})
+		entries = append(entries, scheduleEntry{start: res.StartTime, row: row})
+	}
+	for _, window := range free {
+		block := window
+		if !block.End.After(now) {
+			continue
+		}
+		label := widget.NewLabel(fmt.Sprintf("%s - %s  Free", formatClock(block.Start), formatClock(block.End)))
+		bookButton := widget.NewButton("Book", func() {
+			openRoomBookingForm(today, block, reopen, w)
+		})
+		bookButton.Importance = widget.HighImportance
+		entries = append(entries, scheduleEntry{start: block.Start, row: container.NewBorder(nil, nil, nil, bookButton, label)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].start.Before(entries[j].start)
+	})
+	for _, entry := range entries {
+		schedule.Add(entry.row)
+	}
+	if len(entries) == 0 {
+		schedule.Add(widget.NewLabel("No free time left today."))
+	}
+
+	scroll := container.NewVScroll(schedule)
+	scroll.SetMinSize(fyne.NewSize(420, 250))
+
+	body := container.NewVBox(
+		details,
+		widget.NewLabelWithStyle("Today", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		newDayTimeline(room, today),
+	)
+	d = dialog.NewCustom(room.Name, "Close", container.NewBorder(body, nil, nil, nil, scroll), w)
+	d.Resize(fyne.NewSize(480, 520))
+	d.Show()
+}
+
+// openRoomBookingForm opens the shared reservation form for a free window,
+// pre-filled with the next slot so the user only needs to adjust the times.
+func openRoomBookingForm(date string, window bookingBlock, onBooked func(), w fyne.Window) {
+	block := window
+	now := time.Now()
+	if block.Start.Before(now) {
+		block.Start = alignToSlot(now, gridInterval).Add(gridInterval)
+	}
+	if end := block.Start.Add(gridInterval); end.Before(block.End) {
+		block.End = end
+	}
+	if !block.Start.Before(block.End) {
+		block = window
+	}
+	openReservationForm(date, []bookingBlock{block}, gridInterval, onBooked, w)
+}
+
+// newDayTimeline draws business hours as a green bar with reservations in red
+// and a marker for the current time.
+func newDayTimeline(room *Room, date string) fyne.CanvasObject {
+	open, closing, err := businessHours(date)
+	if err != nil {
+		return widget.NewLabel("")
+	}
+	total := closing.Sub(open)
+	fraction := func(t time.Time) float32 {
+		if t.Before(open) {
+			return 0
+		}
+		if t.After(closing) {
+			return 1
+		}
+		return float32(t.Sub(open)) / float32(total)
+	}
+
+	background := canvas.NewRectangle(color.NRGBA{R: 40, G: 167, B: 69, A: 255}) // Success green
+	objects := []fyne.CanvasObject{background}
+	spans := [][2]float32{{0, 1}}
+
+	busy, _ := roomDaySchedule(room, date)
+	for _, i := range busy {
+		res := room.Reservations[i]
+		objects = append(objects, canvas.NewRectangle(color.NRGBA{R: 220, G: 53, B: 69, A: 255})) // Danger color
+		spans = append(spans, [2]float32{fraction(res.StartTime), fraction(res.EndTime)})
+	}
+	if now := time.Now(); now.After(open) && now.Before(closing) {
+		objects = append(objects, canvas.NewRectangle(color.Black))
+		at := fraction(now)
+		spans = append(spans, [2]float32{at, at + 0.004})
+	}
+
+	bar := container.New(&timelineLayout{spans: spans}, objects...)
+	startLabel := widget.NewLabel(formatClock(open))
+	endLabel := widget.NewLabel(formatClock(closing))
+	return container.NewBorder(nil, nil, startLabel, endLabel, bar)
+}
+
+// timelineLayout places each object across a fraction of the available width.
+type timelineLayout struct {
+	spans [][2]float32
+}
+
+func (l *timelineLayout) Layout(objects []fyne.CanvasObject, size fyne.Size) {
+	for i, o := range objects {
+		if i >= len(l.spans) {
+			break
+		}
+		span := l.spans[i]
+		o.Move(fyne.NewPos(span[0]*size.Width, 0))
+		o.Resize(fyne.NewSize((span[1]-span[0])*size.Width, size.Height))
+	}
+}
+
+func (l *timelineLayout) MinSize(objects []fyne.CanvasObject) fyne.Size {
+	return fyne.NewSize(200, 24)
+}
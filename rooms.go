@@ -0,0 +1,509 @@
+// rooms.go
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Ways to deal with upcoming reservations when a room is deleted
+const (
+	removeCancelReservations = "Cancel them and tell the people who booked"
+	removeMoveReservations   = "Move them to another room"
+)
+
+var roomCatalogHeader = []string{"ID", "Name", "Site", "Building", "Floor", "Archived"}
+
+// findRoomByID returns the room with the given ID, if any.
+func findRoomByID(id string) *Room {
+	for _, r := range rooms {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// reservationRoom returns the room a reservation belongs to. Reservations
+// made before rooms had IDs are matched by name.
+func reservationRoom(res Reservation) *Room {
+	if res.RoomID != "" {
+		return findRoomByID(res.RoomID)
+	}
+	return findRoom(res.RoomName)
+}
+
+// assignRoomIDs gives rooms saved by older versions an ID and links their
+// reservations to it. It reports whether anything changed.
+func assignRoomIDs() bool {
+	changed := false
+	for _, room := range rooms {
+		if room.ID == "" {
+			room.ID = newLocationID()
+			changed = true
+		}
+		for i := range room.Reservations {
+			if room.Reservations[i].RoomID != room.ID {
+				room.Reservations[i].RoomID = room.ID
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// bookableRooms drops archived rooms from list.
+func bookableRooms(list []*Room) []*Room {
+	bookable := []*Room{}
+	for _, room := range list {
+		if !room.Archived {
+			bookable = append(bookable, room)
+		}
+	}
+	return bookable
+}
+
+// upcomingReservations returns the indexes of a room's active reservations
+// that have not ended by now.
+func upcomingReservations(room *Room, now time.Time) []int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	upcoming := []int{}
+	for i, res := range room.Reservations {
+		if res.Active && res.EndTime.After(now) {
+			upcoming = append(upcoming, i)
+		}
+	}
+	return upcoming
+}
+
+// planRoomRemoval builds one undoable command that deletes room after
+// cancelling its upcoming reservations or, when target is set, moving them
+// there. It fails without changing anything if target is taken at any of
+// those times. The affected reservations are returned for notifications.
+func planRoomRemoval(room, target *Room, now time.Time) (*CompositeCommand, []reservationUpdate, error) {
+	composite := &CompositeCommand{}
+	affected := []reservationUpdate{}
+	conflicts := []string{}
+	for _, i := range upcomingReservations(room, now) {
+		before := room.Reservations[i]
+		composite.Commands = append(composite.Commands, &CancelReservationCommand{room: room, index: i})
+		if target == nil {
+			affected = append(affected, reservationUpdate{Before: before})
+			continue
+		}
+		after := before
+		after.RoomID = target.ID
+		after.RoomName = target.Name
+		target.mu.Lock()
+		taken := target.overlaps(after, -1)
+		target.mu.Unlock()
+		if taken {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s", after.Date, formatClock(after.StartTime)))
+			continue
+		}
+		composite.Commands = append(composite.Commands, &ReservationCommand{reservation: after, room: target})
+		affected = append(affected, reservationUpdate{Before: before, After: after})
+	}
+	if len(conflicts) > 0 {
+		return nil, nil, fmt.Errorf("%s is already booked at: %s", target.Name, strings.Join(conflicts, ", "))
+	}
+	composite.Commands = append(composite.Commands, &RemoveRoomCommand{room: room})
+	return composite, affected, nil
+}
+
+// writeRoomCatalog writes every room as CSV, in the format read by
+// planRoomImport.
+func writeRoomCatalog(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(roomCatalogHeader); err != nil {
+		return err
+	}
+	for _, room := range rooms {
+		site, building, floorName := "", "", ""
+		if floor, ok := findFloor(room.FloorID); ok {
+			site, building, floorName = floor.Site, floor.Building, floor.Floor.Name
+		}
+		if err := out.Write([]string{room.ID, room.Name, site, building, floorName, strconv.FormatBool(room.Archived)}); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// parseYesNo reads a spreadsheet yes/no value.
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y", "true", "1":
+		return true, nil
+	case "no", "n", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not yes or no", value)
+}
+
+// planRoomImport reads a room catalog and builds one undoable command that
+// applies it. The first row names the columns; only Name is required. Rows
+// are matched to rooms by ID, then by name; other rows add rooms. Blank
+// location or archived cells leave an existing room's value alone. Rooms
+// missing from the file are not changed. Rooms may swap names.
+func planRoomImport(r io.Reader) (command *CompositeCommand, added, updated int, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(records) == 0 {
+		return nil, 0, 0, errors.New("the file is empty")
+	}
+
+	columns := map[string]int{}
+	for i, title := range records[0] {
+		if i == 0 {
+			title = strings.TrimPrefix(title, "\ufeff") // Byte order mark added by Excel
+		}
+		columns[strings.ToLower(strings.TrimSpace(title))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, 0, 0, errors.New("the first row must name the columns and include Name")
+	}
+	cell := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	renames := []*RenameRoomCommand{}
+	changes := []Command{}
+	problems := []string{}
+	matched := map[*Room]bool{}
+	newIDs := map[string]bool{}
+	finalNames := map[string]string{} // Room name to where it comes from, to catch duplicates
+	for line, record := range records[1:] {
+		line += 2 // Line numbers as shown in a spreadsheet
+		id, name := cell(record, "id"), normalizeRoomName(cell(record, "name"))
+		if id == "" && name == "" {
+			continue // Blank row
+		}
+		// Clashes with other rooms are checked once the whole file is read,
+		// since it may rename them
+		if err := validateRoomName(name, roomNamed(name)); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if previous, ok := finalNames[strings.ToLower(name)]; ok {
+			problems = append(problems, fmt.Sprintf("line %d: '%s' is also used on %s", line, name, previous))
+			continue
+		}
+		finalNames[strings.ToLower(name)] = fmt.Sprintf("line %d", line)
+
+		var room *Room
+		if id != "" {
+			room = findRoomByID(id)
+		} else {
+			room = roomNamed(name)
+		}
+		if room != nil {
+			if matched[room] {
+				problems = append(problems, fmt.Sprintf("line %d: room '%s' appears more than once", line, room.Name))
+				continue
+			}
+			matched[room] = true
+		}
+
+		floorID, floorGiven := "", false
+		if site, building, floorName := cell(record, "site"), cell(record, "building"), cell(record, "floor"); site != "" || building != "" || floorName != "" {
+			floorGiven = true
+			found := false
+			for _, floor := range allFloors() {
+				if strings.EqualFold(floor.Site, site) && strings.EqualFold(floor.Building, building) && strings.EqualFold(floor.Floor.Name, floorName) {
+					floorID, found = floor.Floor.ID, true
+					break
+				}
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("line %d: there is no floor %s / %s / %s", line, site, building, floorName))
+				continue
+			}
+		}
+
+		archived, archivedGiven := false, false
+		if value := cell(record, "archived"); value != "" {
+			parsed, parseErr := parseYesNo(value)
+			if parseErr != nil {
+				problems = append(problems, fmt.Sprintf("line %d: Archived %v", line, parseErr))
+				continue
+			}
+			archived, archivedGiven = parsed, true
+		}
+
+		if room == nil {
+			if newIDs[id] && id != "" {
+				problems = append(problems, fmt.Sprintf("line %d: ID %s appears more than once", line, id))
+				continue
+			}
+			newIDs[id] = true
+			changes = append(changes, &AddRoomCommand{room: &Room{ID: id, Name: name, FloorID: floorID, Archived: archived, Reservations: []Reservation{}}})
+			added++
+			continue
+		}
+
+		changed := false
+		if room.Name != name {
+			renames = append(renames, &RenameRoomCommand{room: room, oldName: room.Name, newName: name})
+			changed = true
+		}
+		if floorGiven && room.FloorID != floorID {
+			changes = append(changes, &AssignRoomFloorCommand{room: room, oldFloorID: room.FloorID, newFloorID: floorID, oldPos: room.Position, oldShape: room.Shape})
+			changed = true
+		}
+		if archivedGiven && room.Archived != archived {
+			changes = append(changes, &ArchiveRoomCommand{room: room, archived: archived})
+			changed = true
+		}
+		if changed {
+			updated++
+		}
+	}
+
+	// Rooms left out of the file keep their names, which must not clash
+	for _, room := range rooms {
+		if previous, ok := finalNames[strings.ToLower(normalizeRoomName(room.Name))]; ok && !matched[room] {
+			problems = append(problems, fmt.Sprintf("%s: '%s' is already the name of another room", previous, room.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, 0, 0, errors.New(strings.Join(problems, "\n"))
+	}
+
+	// Renames run first, so added rooms can take names they free up. When a
+	// room takes the current name of another, as when two rooms swap names,
+	// every renamed room first moves to a temporary name.
+	command = &CompositeCommand{}
+	swapping := false
+	for _, rename := range renames {
+		if other := roomNamed(rename.newName); other != nil && other != rename.room {
+			swapping = true
+		}
+	}
+	for _, rename := range renames {
+		if swapping {
+			temporary := "Importing " + rename.room.ID
+			command.Commands = append(command.Commands, &RenameRoomCommand{room: rename.room, oldName: rename.oldName, newName: temporary})
+			rename.oldName = temporary
+		}
+	}
+	for _, rename := range renames {
+		command.Commands = append(command.Commands, rename)
+	}
+	command.Commands = append(command.Commands, changes...)
+	return command, added, updated, nil
+}
+
+// showRenameRoom asks for a new name for room.
+func showRenameRoom(room *Room, onDone func(), w fyne.Window) {
+	newNameEntry := widget.NewEntry()
+	newNameEntry.SetText(room.Name)
+	newNameEntry.Validator = entryValidator(func(name string) error {
+		return validateRoomName(name, room)
+	})
+	form := dialog.NewForm("Rename Room", "Rename", "Cancel", []*widget.FormItem{
+		{Text: "New Name", Widget: newNameEntry, HintText: "Reservations keep their history under the new name"},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		name := normalizeRoomName(newNameEntry.Text)
+		if name == room.Name {
+			return
+		}
+		if err := executeCommand(&RenameRoomCommand{room: room, oldName: room.Name, newName: name}); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		onDone()
+	}, w)
+	form.Resize(fyne.NewSize(400, 200))
+	form.Show()
+}
+
+// showRemoveRoom deletes room after asking what to do with its upcoming
+// reservations. Past reservations are deleted with the room.
+func showRemoveRoom(room *Room, onDone func(), w fyne.Window) {
+	upcoming := len(upcomingReservations(room, time.Now()))
+	message := widget.NewLabel(fmt.Sprintf("Delete '%s' and its past reservations? Archive the room instead to keep them in reports.", room.Name))
+	message.Wrapping = fyne.TextWrapWord
+
+	targets := []string{}
+	for _, other := range bookableRooms(rooms) {
+		if other != room {
+			targets = append(targets, other.Name)
+		}
+	}
+	targetSelect := widget.NewSelect(targets, nil)
+	targetSelect.Disable()
+	choice := widget.NewRadioGroup([]string{removeCancelReservations, removeMoveReservations}, func(selected string) {
+		if selected == removeMoveReservations {
+			targetSelect.Enable()
+		} else {
+			targetSelect.Disable()
+		}
+	})
+	choice.SetSelected(removeCancelReservations)
+
+	items := []*widget.FormItem{{Widget: message}}
+	if upcoming > 0 {
+		items = append(items,
+			&widget.FormItem{Text: fmt.Sprintf("%d Upcoming", upcoming), Widget: choice},
+			&widget.FormItem{Text: "Move To", Widget: targetSelect},
+		)
+	}
+	form := dialog.NewForm("Delete Room", "Delete", "Cancel", items, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		var target *Room
+		if upcoming > 0 && choice.Selected == removeMoveReservations {
+			if target = findRoom(targetSelect.Selected); target == nil {
+				dialog.ShowError(errors.New("please select the room to move the reservations to"), w)
+				return
+			}
+		}
+		command, affected, err := planRoomRemoval(room, target, time.Now())
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if err := executeCommand(command); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		for _, change := range affected {
+			if target == nil {
+				notifyCancellation(change.Before)
+			} else {
+				notifyChange(change.Before, change.After)
+			}
+		}
+		onDone()
+	}, w)
+	form.Resize(fyne.NewSize(500, 300))
+	form.Show()
+}
+
+// manageRooms lists every room with its location and state, and lets admins
+// rename, archive, restore or delete rooms and import or export the catalog.
+func manageRooms(w fyne.Window) {
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.Objects = nil
+		now := time.Now()
+		for _, room := range rooms {
+			room := room
+			status := fmt.Sprintf("%d upcoming reservations", len(upcomingReservations(room, now)))
+			if room.Archived {
+				status += ", archived"
+			}
+			label := widget.NewLabel(fmt.Sprintf("%s\n%s; %s", room.Name, floorLabel(room.FloorID), status))
+
+			renameButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+				showRenameRoom(room, rebuild, w)
+			})
+			archiveLabel := "Archive"
+			if room.Archived {
+				archiveLabel = "Restore"
+			}
+			archiveButton := widget.NewButton(archiveLabel, func() {
+				if err := executeCommand(&ArchiveRoomCommand{room: room, archived: !room.Archived}); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				rebuild()
+			})
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				showRemoveRoom(room, rebuild, w)
+			})
+			list.Add(container.NewBorder(nil, nil, nil, container.NewHBox(renameButton, archiveButton, deleteButton), label))
+		}
+		if len(rooms) == 0 {
+			list.Add(widget.NewLabel("No rooms yet."))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	exportButton := widget.NewButtonWithIcon("Export CSV", theme.DocumentSaveIcon(), func() {
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			err = writeRoomCatalog(writer)
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("could not export the rooms: %v", err), w)
+			}
+		}, w)
+		fileDialog.SetFileName("rooms.csv")
+		fileDialog.Show()
+	})
+	importButton := widget.NewButtonWithIcon("Import CSV", theme.FolderOpenIcon(), func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			command, added, updated, err := planRoomImport(reader)
+			reader.Close()
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("could not import the rooms:\n%v", err), w)
+				return
+			}
+			if added == 0 && updated == 0 {
+				dialog.ShowInformation("Import Rooms", "The rooms already match the file.", w)
+				return
+			}
+			dialog.ShowConfirm("Import Rooms", fmt.Sprintf("Add %d rooms and update %d?", added, updated), func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+				if err := executeCommand(command); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				rebuild()
+			}, w)
+		}, w)
+		fileDialog.Show()
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(600, 350))
+	dialog.ShowCustom("Manage Rooms", "Close", container.NewBorder(nil, container.NewHBox(importButton, exportButton), nil, nil, scroll), w)
+}
@@ -0,0 +1,91 @@
+// rooms_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// roomNamesByID returns each room's name keyed by its ID.
+func roomNamesByID() map[string]string {
+	names := map[string]string{}
+	for _, room := range rooms {
+		names[room.ID] = room.Name
+	}
+	return names
+}
+
+func TestRoomImportSwapsNames(t *testing.T) {
+	useTestDataDir(t)
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	rooms = []*Room{
+		{ID: "a1", Name: "Lab A", Reservations: []Reservation{{RoomID: "a1", RoomName: "Lab A", StartTime: start, EndTime: start.Add(time.Hour), Active: true}}},
+		{ID: "b1", Name: "Lab B"},
+		{ID: "c1", Name: "Lab C"},
+		{ID: "d1", Name: "Hall"},
+	}
+	before := roomNamesByID()
+
+	// A and B swap, C takes the hall's name and the hall gets a new one, and
+	// a new room with its own ID takes Lab C
+	csv := "ID,Name\na1,Lab B\nb1,Lab A\nc1,Hall\nd1,Main Hall\ne1,Lab C\n"
+	command, added, updated, err := planRoomImport(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || updated != 4 {
+		t.Errorf("%d added and %d updated, want 1 and 4", added, updated)
+	}
+	if err := command.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	after := roomNamesByID()
+	want := map[string]string{"a1": "Lab B", "b1": "Lab A", "c1": "Hall", "d1": "Main Hall"}
+	for id, name := range want {
+		if after[id] != name {
+			t.Errorf("room %s is named %q, want %q", id, after[id], name)
+		}
+	}
+	if len(rooms) != 5 || rooms[4].Name != "Lab C" {
+		t.Errorf("the added room is missing or misnamed: %v", after)
+	}
+	if got := rooms[0].Reservations[0].RoomName; got != "Lab B" {
+		t.Errorf("the reservation still names the room %q", got)
+	}
+
+	if err := command.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	restored := roomNamesByID()
+	if len(restored) != len(before) {
+		t.Errorf("undo left rooms %v", restored)
+	}
+	for id, name := range before {
+		if restored[id] != name {
+			t.Errorf("after undo room %s is named %q, want %q", id, restored[id], name)
+		}
+	}
+}
+
+func TestRoomImportRenameWithoutSwapUsesNoTemporaryNames(t *testing.T) {
+	useTestDataDir(t)
+	rooms = []*Room{{ID: "a1", Name: "Lab A"}, {ID: "b1", Name: "Lab B"}}
+	command, _, _, err := planRoomImport(strings.NewReader("ID,Name\na1,Lab X\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(command.Commands) != 1 {
+		t.Errorf("a plain rename takes %d steps, want 1", len(command.Commands))
+	}
+}
+
+func TestRoomImportRejectsNameClashes(t *testing.T) {
+	useTestDataDir(t)
+	rooms = []*Room{{ID: "a1", Name: "Lab A"}, {ID: "b1", Name: "Lab B"}}
+	// Lab B is left out of the file, so it keeps its name
+	if _, _, _, err := planRoomImport(strings.NewReader("ID,Name\na1,Lab B\n")); err == nil {
+		t.Error("renaming a room to the name of a room left out of the file was accepted")
+	}
+}
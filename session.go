@@ -0,0 +1,358 @@
+// session.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// What happens when a session has been idle for the configured time
+const (
+	idleActionLock   = "Lock"
+	idleActionLogout = "Log Out"
+)
+
+// Why a session ended
+const (
+	sessionEndLogout     = "Logged out"
+	sessionEndIdle       = "Idle timeout"
+	sessionEndSwitchUser = "Switched user"
+	sessionEndClosed     = "App closed"
+)
+
+const (
+	maxSessionRecords = 500
+	idleCheckInterval = 15 * time.Second
+	activitySaveEvery = time.Minute // How often LastActivity is written to disk
+)
+
+// SessionSettings controls idle handling on shared machines
+type SessionSettings struct {
+	IdleMinutes int    // 0 never times out
+	IdleAction  string // idleActionLock or idleActionLogout
+}
+
+// Session records one sign-in, kept for admins to review
+type Session struct {
+	ID           string
+	Username     string
+	Host         string
+	Started      time.Time
+	LastActivity time.Time
+	Ended        time.Time // Zero while the session is open
+	EndReason    string
+	Locked       bool
+}
+
+var sessions []Session
+var currentSession *Session
+var sessionMu sync.Mutex
+var lastActivitySave time.Time
+
+// shell holds the parts of the main window that change when a session
+// starts, locks or ends.
+var shell struct {
+	window  fyne.Window
+	content *fyne.Container
+	sidebar *fyne.Container
+	main    fyne.CanvasObject
+}
+
+// Load and save session records
+func loadSessions() {
+	err := loadJSONFile("sessions.json", &sessions)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Error loading sessions: %v\n", err)
+		return
+	}
+
+	// Sessions left open on this machine ended when the app last exited
+	host := hostName()
+	changed := false
+	for i := range sessions {
+		if sessions[i].Host == host && sessions[i].Ended.IsZero() {
+			sessions[i].Ended = sessions[i].LastActivity
+			sessions[i].EndReason = sessionEndClosed
+			sessions[i].Locked = false
+			changed = true
+		}
+	}
+	if changed {
+		saveSessions()
+	}
+}
+
+// saveSessions writes the session records; callers hold no lock.
+func saveSessions() {
+	sessionMu.Lock()
+	records := append([]Session(nil), sessions...)
+	sessionMu.Unlock()
+
+	if err := saveJSONFile("sessions.json", records); err != nil {
+		log.Printf("Error saving sessions: %v\n", err)
+	}
+}
+
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// sessionActive reports whether a user is signed in and the screen is unlocked.
+func sessionActive() bool {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return currentSession != nil && !currentSession.Locked
+}
+
+// beginSession signs user in and shows the booking grid.
+func beginSession(user *User) {
+	now := time.Now()
+	sessionMu.Lock()
+	sessions = append(sessions, Session{
+		ID:           newLocationID(),
+		Username:     user.Username,
+		Host:         hostName(),
+		Started:      now,
+		LastActivity: now,
+	})
+	if len(sessions) > maxSessionRecords {
+		sessions = sessions[len(sessions)-maxSessionRecords:]
+	}
+	currentSession = &sessions[len(sessions)-1]
+	sessionMu.Unlock()
+	lastActivitySave = now
+	saveSessions()
+
+	currentUser = user
+	refreshSidebar()
+	showView(shell.content, func() fyne.CanvasObject {
+		return createGridScheduleView(shell.content, gridInterval, shell.window)
+	})
+	shell.window.SetContent(shell.main)
+}
+
+// closeSession records the end of the current session.
+func closeSession(reason string) {
+	sessionMu.Lock()
+	if currentSession != nil {
+		currentSession.Ended = time.Now()
+		currentSession.EndReason = reason
+		currentSession.Locked = false
+		currentSession = nil
+	}
+	sessionMu.Unlock()
+	saveSessions()
+}
+
+// endSession signs the current user out. The undo history goes with them so
+// the next user cannot undo their changes.
+func endSession(reason string) {
+	closeSession(reason)
+	currentUser = nil
+	undoStack = nil
+	redoStack = nil
+	closeOverlays()
+	refreshSidebar()
+	currentView = nil
+	shell.content.Objects = []fyne.CanvasObject{widget.NewLabel("Please log in to continue.")}
+	shell.content.Refresh()
+	shell.window.SetContent(shell.main)
+}
+
+// touchSession records user activity, postponing the idle timeout.
+func touchSession() {
+	sessionMu.Lock()
+	if currentSession == nil || currentSession.Locked {
+		sessionMu.Unlock()
+		return
+	}
+	now := time.Now()
+	currentSession.LastActivity = now
+	save := now.Sub(lastActivitySave) >= activitySaveEvery
+	if save {
+		lastActivitySave = now
+	}
+	sessionMu.Unlock()
+	if save {
+		saveSessions()
+	}
+}
+
+// switchUser ends the current session and asks the next user to sign in.
+func switchUser() {
+	endSession(sessionEndSwitchUser)
+	showLogin(shell.content, shell.window, beginSession)
+}
+
+// closeOverlays dismisses open dialogs so they are not left on screen for
+// the next person.
+func closeOverlays() {
+	overlays := shell.window.Canvas().Overlays()
+	for _, overlay := range overlays.List() {
+		overlays.Remove(overlay)
+	}
+}
+
+// lockSession hides the app behind a screen that only the signed-in user
+// can unlock; anyone else may switch user.
+func lockSession() {
+	sessionMu.Lock()
+	if currentSession == nil || currentSession.Locked {
+		sessionMu.Unlock()
+		return
+	}
+	currentSession.Locked = true
+	sessionMu.Unlock()
+	saveSessions()
+
+	closeOverlays()
+	shell.window.SetContent(createLockScreen())
+}
+
+func unlockSession() {
+	sessionMu.Lock()
+	if currentSession != nil {
+		currentSession.Locked = false
+		currentSession.LastActivity = time.Now()
+	}
+	sessionMu.Unlock()
+	saveSessions()
+	shell.window.SetContent(shell.main)
+}
+
+func createLockScreen() fyne.CanvasObject {
+	w := shell.window
+	username := currentUser.Username
+	title := widget.NewLabelWithStyle("Locked", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	message := widget.NewLabelWithStyle(fmt.Sprintf("Signed in as %s", username), fyne.TextAlignCenter, fyne.TextStyle{})
+
+	var unlock fyne.CanvasObject
+	if currentUser.Source == authSourceOIDC {
+		unlock = widget.NewButtonWithIcon("Unlock with SSO", theme.LoginIcon(), func() {
+			showSSOSignIn(w, func(user *User) {
+				if user.Username != username {
+					// Someone else signed in; give them their own session
+					endSession(sessionEndSwitchUser)
+					beginSession(user)
+					return
+				}
+				unlockSession()
+			})
+		})
+	} else {
+		passwordEntry := widget.NewPasswordEntry()
+		passwordEntry.SetPlaceHolder("Password")
+		tryUnlock := func() {
+			if _, err := attemptLogin(username, passwordEntry.Text, localLoginSource()); err != nil {
+				passwordEntry.SetText("")
+				dialog.ShowError(err, w)
+				return
+			}
+			unlockSession()
+		}
+		passwordEntry.OnSubmitted = func(string) { tryUnlock() }
+		unlock = container.NewVBox(passwordEntry, widget.NewButtonWithIcon("Unlock", theme.LoginIcon(), tryUnlock))
+	}
+
+	switchButton := widget.NewButtonWithIcon("Switch User", theme.AccountIcon(), switchUser)
+	box := container.NewVBox(title, message, unlock, switchButton)
+	return container.NewCenter(container.NewGridWrap(fyne.NewSize(320, box.MinSize().Height), box))
+}
+
+// startIdleWatcher locks or signs out sessions that have been idle longer
+// than the configured time.
+func startIdleWatcher() {
+	go func() {
+		for range time.Tick(idleCheckInterval) {
+			idle := settings.Sessions.IdleMinutes
+			if idle <= 0 {
+				continue
+			}
+			sessionMu.Lock()
+			expired := currentSession != nil && !currentSession.Locked &&
+				time.Since(currentSession.LastActivity) >= time.Duration(idle)*time.Minute
+			sessionMu.Unlock()
+			if !expired {
+				continue
+			}
+			if settings.Sessions.IdleAction == idleActionLogout {
+				endSession(sessionEndIdle)
+			} else {
+				lockSession()
+			}
+		}
+	}()
+}
+
+// activityWatcher wraps the main layout and counts mouse movement over it
+// as activity. Typing and data changes are counted separately.
+type activityWatcher struct {
+	widget.BaseWidget
+	content fyne.CanvasObject
+}
+
+var _ desktop.Hoverable = (*activityWatcher)(nil)
+
+func newActivityWatcher(content fyne.CanvasObject) *activityWatcher {
+	a := &activityWatcher{content: content}
+	a.ExtendBaseWidget(a)
+	return a
+}
+
+func (a *activityWatcher) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(a.content)
+}
+
+func (a *activityWatcher) MouseIn(*desktop.MouseEvent)    { touchSession() }
+func (a *activityWatcher) MouseMoved(*desktop.MouseEvent) { touchSession() }
+func (a *activityWatcher) MouseOut()                      {}
+
+// showSessions lists open and recent sessions for admins.
+func showSessions(w fyne.Window) {
+	sessionMu.Lock()
+	records := append([]Session(nil), sessions...)
+	sessionMu.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].Started.After(records[j].Started) })
+
+	formatTime := func(t time.Time) string {
+		return t.In(siteLocation()).Format("Jan 2 " + timeLayout12Hour)
+	}
+	list := container.NewVBox()
+	if len(records) == 0 {
+		list.Add(widget.NewLabel("No sessions recorded yet."))
+	}
+	for _, s := range records {
+		status := "Active"
+		switch {
+		case !s.Ended.IsZero():
+			status = fmt.Sprintf("Ended %s (%s)", formatTime(s.Ended), s.EndReason)
+		case s.Locked:
+			status = "Locked"
+		}
+		list.Add(widget.NewLabel(fmt.Sprintf("%s on %s\nSigned in %s, last active %s\n%s",
+			s.Username, s.Host, formatTime(s.Started), formatTime(s.LastActivity), status)))
+		list.Add(widget.NewSeparator())
+	}
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(500, 400))
+	dialog.ShowCustom("Sessions", "Close", scroll, w)
+}
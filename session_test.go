@@ -0,0 +1,97 @@
+// session_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/test"
+)
+
+// useTestShell sets up the main window in a test app with no sessions on
+// record, restoring the previous state afterwards.
+func useTestShell(t *testing.T) {
+	t.Helper()
+	useTestDataDir(t)
+	test.NewApp()
+	savedShell, savedSessions, savedCurrent, savedView := shell, sessions, currentSession, currentView
+	sessions, currentSession = nil, nil
+	shell.window = test.NewWindow(nil)
+	shell.content = container.NewMax()
+	shell.sidebar = container.NewMax()
+	shell.main = container.NewBorder(nil, nil, shell.sidebar, nil, shell.content)
+	t.Cleanup(func() {
+		shell.window.Close()
+		shell, sessions, currentSession, currentView = savedShell, savedSessions, savedCurrent, savedView
+	})
+}
+
+func TestSessionLockAndEnd(t *testing.T) {
+	useTestShell(t)
+	users = []User{{Username: "alice", Role: "User"}}
+	beginSession(&users[0])
+	if !sessionActive() || currentUser == nil || len(sessions) != 1 || sessions[0].Host != hostName() {
+		t.Fatalf("after signing in: active %v, sessions %+v", sessionActive(), sessions)
+	}
+	if err := executeCommand(&AddRoomCommand{room: &Room{Name: "Lab A"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	lockSession()
+	if sessionActive() || !sessions[0].Locked {
+		t.Error("the session is not locked")
+	}
+	if shell.window.Content() == shell.main {
+		t.Error("the app is still shown while locked")
+	}
+	// Activity on the lock screen does not keep the session alive
+	before := sessions[0].LastActivity
+	touchSession()
+	if sessions[0].LastActivity != before {
+		t.Error("activity while locked was recorded")
+	}
+
+	unlockSession()
+	if !sessionActive() || shell.window.Content() != shell.main {
+		t.Error("the session did not unlock")
+	}
+
+	endSession(sessionEndLogout)
+	if sessionActive() || currentUser != nil {
+		t.Error("the user is still signed in")
+	}
+	if sessions[0].Ended.IsZero() || sessions[0].EndReason != sessionEndLogout {
+		t.Errorf("ended session is %+v", sessions[0])
+	}
+	if len(undoStack) != 0 {
+		t.Error("the next user could undo the previous user's changes")
+	}
+}
+
+func TestLoadSessionsClosesThisMachinesSessions(t *testing.T) {
+	useTestShell(t)
+	lastSeen := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	sessions = []Session{
+		{ID: "here", Username: "alice", Host: hostName(), LastActivity: lastSeen, Locked: true},
+		{ID: "there", Username: "bob", Host: "other-" + hostName(), LastActivity: lastSeen},
+		{ID: "done", Username: "carol", Host: hostName(), LastActivity: lastSeen, Ended: lastSeen.Add(time.Minute), EndReason: sessionEndLogout},
+	}
+	saveSessions()
+	sessions = nil
+
+	loadSessions()
+	if len(sessions) != 3 {
+		t.Fatalf("loaded %d sessions, want 3", len(sessions))
+	}
+	if s := sessions[0]; !s.Ended.Equal(lastSeen) || s.EndReason != sessionEndClosed || s.Locked {
+		t.Errorf("session left open here is %+v, want closed at its last activity", s)
+	}
+	if s := sessions[1]; !s.Ended.IsZero() {
+		t.Errorf("another machine's session was closed: %+v", s)
+	}
+	if s := sessions[2]; s.EndReason != sessionEndLogout {
+		t.Errorf("an ended session was changed: %+v", s)
+	}
+}
@@ -0,0 +1,289 @@
+// settings.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Bundle zone data so the site zone resolves on every platform
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Settings holds site-wide configuration
+type Settings struct {
+	SiteName      string // Shown in the window title
+	TimeZone      string // IANA name, e.g. "America/Chicago"
+	OpenTime      string // Start of the bookable day as "15:04"; empty is 8 AM
+	CloseTime     string // End of the bookable day; "24:00" or empty is midnight
+	Notifications NotificationSettings
+	LDAP          LDAPSettings
+	OIDC          OIDCSettings
+	Sessions      SessionSettings
+	Login         LoginSettings
+	Passwords     PasswordPolicy
+	Registration  RegistrationSettings
+}
+
+var settings = Settings{}
+
+const (
+	defaultOpenTime  = "08:00"
+	defaultCloseTime = "24:00"
+)
+
+// parseTimeOfDay parses a time such as "8:00 AM" or "17:30" into a time
+// since midnight. "24:00" means the midnight that ends the day.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := parseClockTime(value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseOpeningHours checks a pair of opening and closing times. A closing
+// time of midnight ends the day.
+func parseOpeningHours(openText, closeText string) (open, closing time.Duration, err error) {
+	if open, err = parseTimeOfDay(openText); err != nil {
+		return 0, 0, fmt.Errorf("invalid opening time %q", openText)
+	}
+	if closing, err = parseTimeOfDay(closeText); err != nil {
+		return 0, 0, fmt.Errorf("invalid closing time %q", closeText)
+	}
+	if closing == 0 {
+		closing = 24 * time.Hour
+	}
+	if closing <= open {
+		return 0, 0, fmt.Errorf("the closing time must be after the opening time")
+	}
+	return open, closing, nil
+}
+
+// formatTimeOfDay stores a time since midnight as "15:04".
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// openingHours returns the configured bookable day as times since midnight.
+func openingHours() (open, closing time.Duration) {
+	openText, closeText := settings.OpenTime, settings.CloseTime
+	if openText == "" {
+		openText = defaultOpenTime
+	}
+	if closeText == "" {
+		closeText = defaultCloseTime
+	}
+	open, closing, err := parseOpeningHours(openText, closeText)
+	if err != nil {
+		log.Printf("Error reading opening hours: %v\n", err)
+		open, closing, _ = parseOpeningHours(defaultOpenTime, defaultCloseTime)
+	}
+	return open, closing
+}
+
+// windowTitle names the main window after the site.
+func windowTitle() string {
+	if settings.SiteName == "" {
+		return "Room Booking"
+	}
+	return settings.SiteName + " - Room Booking"
+}
+
+// siteZone is the site time zone, loaded when the settings are loaded or
+// saved rather than on every use
+var siteZone = time.Local
+
+// siteLocation returns the configured site time zone.
+func siteLocation() *time.Location {
+	return siteZone
+}
+
+// loadSiteLocation resolves the configured time zone, falling back to the
+// machine's local zone if none is set or it cannot be loaded.
+func loadSiteLocation() {
+	siteZone = time.Local
+	if settings.TimeZone == "" {
+		return
+	}
+	loc, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		log.Printf("Error loading time zone %q: %v\n", settings.TimeZone, err)
+		return
+	}
+	siteZone = loc
+}
+
+// Load and save settings
+func loadSettings() {
+	err := loadJSONFile("settings.json", &settings)
+	if os.IsNotExist(err) {
+		log.Println("settings.json file not found, using defaults.")
+	} else if err != nil {
+		log.Printf("Error loading settings: %v\n", err)
+	}
+	loadSiteLocation()
+}
+
+func saveSettings() {
+	loadSiteLocation()
+	scheduleReminders()
+	if err := saveJSONFile("settings.json", &settings); err != nil {
+		log.Printf("Error saving settings: %v\n", err)
+	}
+}
+
+func showSettings(w fyne.Window) {
+	siteNameEntry := widget.NewEntry()
+	siteNameEntry.SetText(settings.SiteName)
+	timeZoneEntry := widget.NewEntry()
+	timeZoneEntry.SetText(settings.TimeZone)
+	open, closing := openingHours()
+	openEntry := widget.NewEntry()
+	openEntry.SetText(formatTimeOfDay(open))
+	closeEntry := widget.NewEntry()
+	closeEntry.SetText(formatTimeOfDay(closing))
+
+	// Notification delivery
+	notifications := settings.Notifications
+	senderSelect := widget.NewSelect([]string{senderOff, senderSMTP, senderFile}, nil)
+	senderSelect.SetSelected(senderOff)
+	if notifications.Sender != "" {
+		senderSelect.SetSelected(notifications.Sender)
+	}
+	hostEntry := widget.NewEntry()
+	hostEntry.SetText(notifications.SMTPHost)
+	portEntry := widget.NewEntry()
+	portEntry.SetPlaceHolder("587")
+	if notifications.SMTPPort > 0 {
+		portEntry.SetText(strconv.Itoa(notifications.SMTPPort))
+	}
+	smtpUserEntry := widget.NewEntry()
+	smtpUserEntry.SetText(notifications.SMTPUsername)
+	smtpPasswordEntry := widget.NewPasswordEntry()
+	smtpPasswordEntry.SetText(notifications.SMTPPassword)
+	fromEntry := widget.NewEntry()
+	fromEntry.SetText(notifications.From)
+	outputEntry := widget.NewEntry()
+	outputEntry.SetText(notifications.OutputFile)
+	outputEntry.SetPlaceHolder("Empty prints to stdout")
+	reminderEntry := widget.NewEntry()
+	reminderEntry.SetPlaceHolder(strconv.Itoa(defaultReminderMinutes))
+	if notifications.ReminderMinutes > 0 {
+		reminderEntry.SetText(strconv.Itoa(notifications.ReminderMinutes))
+	}
+
+	// Idle sessions
+	idleEntry := widget.NewEntry()
+	idleEntry.SetPlaceHolder("Never")
+	if settings.Sessions.IdleMinutes > 0 {
+		idleEntry.SetText(strconv.Itoa(settings.Sessions.IdleMinutes))
+	}
+	idleActionSelect := widget.NewSelect([]string{idleActionLock, idleActionLogout}, nil)
+	idleActionSelect.SetSelected(idleActionLock)
+	if settings.Sessions.IdleAction != "" {
+		idleActionSelect.SetSelected(settings.Sessions.IdleAction)
+	}
+
+	form := dialog.NewForm("Settings", "Save", "Cancel", []*widget.FormItem{
+		{Text: "Site Name", Widget: siteNameEntry},
+		{Text: "Site Time Zone", Widget: timeZoneEntry, HintText: "IANA name, e.g. Europe/London; empty uses this machine's zone"},
+		{Text: "Opens", Widget: openEntry, HintText: "Start of the bookable day, e.g. 8:00 AM"},
+		{Text: "Closes", Widget: closeEntry, HintText: "End of the bookable day; 24:00 is midnight"},
+		{Text: "Idle Timeout (minutes)", Widget: idleEntry, HintText: "Empty never times out"},
+		{Text: "When Idle", Widget: idleActionSelect, HintText: "Lock keeps the user signed in behind a password screen"},
+		{Text: "Notifications", Widget: senderSelect, HintText: "SMTP sends email; File writes messages to a file for testing"},
+		{Text: "SMTP Host", Widget: hostEntry},
+		{Text: "SMTP Port", Widget: portEntry},
+		{Text: "SMTP Username", Widget: smtpUserEntry},
+		{Text: "SMTP Password", Widget: smtpPasswordEntry},
+		{Text: "From Address", Widget: fromEntry},
+		{Text: "Output File", Widget: outputEntry},
+		{Text: "Reminder (minutes)", Widget: reminderEntry, HintText: "Default time before a booking that reminders are sent"},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		if timeZoneEntry.Text != "" {
+			if _, err := time.LoadLocation(timeZoneEntry.Text); err != nil {
+				dialog.ShowError(fmt.Errorf("unknown time zone %q", timeZoneEntry.Text), w)
+				return
+			}
+		}
+		newOpen, newClosing, err := parseOpeningHours(openEntry.Text, closeEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		updated := NotificationSettings{
+			Sender:       senderSelect.Selected,
+			SMTPHost:     strings.TrimSpace(hostEntry.Text),
+			SMTPPort:     587,
+			SMTPUsername: smtpUserEntry.Text,
+			SMTPPassword: smtpPasswordEntry.Text,
+			From:         strings.TrimSpace(fromEntry.Text),
+			OutputFile:   strings.TrimSpace(outputEntry.Text),
+		}
+		if text := strings.TrimSpace(portEntry.Text); text != "" {
+			port, err := strconv.Atoi(text)
+			if err != nil || port <= 0 || port > 65535 {
+				dialog.ShowError(fmt.Errorf("invalid SMTP port %q", text), w)
+				return
+			}
+			updated.SMTPPort = port
+		}
+		if text := strings.TrimSpace(reminderEntry.Text); text != "" {
+			minutes, err := strconv.Atoi(text)
+			if err != nil || minutes <= 0 {
+				dialog.ShowError(fmt.Errorf("reminder must be a positive number of minutes"), w)
+				return
+			}
+			updated.ReminderMinutes = minutes
+		}
+		if (updated.Sender == "" || updated.Sender == senderOff) && settings.Registration.mode() == registrationDomain {
+			dialog.ShowError(fmt.Errorf("registration is limited by email domain, which needs notifications; change it in Admin Panel > Registration first"), w)
+			return
+		}
+		if updated.Sender == senderSMTP {
+			if updated.SMTPHost == "" {
+				dialog.ShowError(fmt.Errorf("enter the SMTP host to send email"), w)
+				return
+			}
+			if err := validateEmail(updated.From); err != nil || updated.From == "" {
+				dialog.ShowError(fmt.Errorf("enter a valid from address to send email"), w)
+				return
+			}
+		}
+
+		sessionSettings := SessionSettings{IdleAction: idleActionSelect.Selected}
+		if text := strings.TrimSpace(idleEntry.Text); text != "" {
+			minutes, err := strconv.Atoi(text)
+			if err != nil || minutes <= 0 {
+				dialog.ShowError(fmt.Errorf("idle timeout must be a positive number of minutes"), w)
+				return
+			}
+			sessionSettings.IdleMinutes = minutes
+		}
+
+		settings.SiteName = strings.TrimSpace(siteNameEntry.Text)
+		settings.TimeZone = timeZoneEntry.Text
+		settings.OpenTime = formatTimeOfDay(newOpen)
+		settings.CloseTime = formatTimeOfDay(newClosing)
+		settings.Notifications = updated
+		settings.Sessions = sessionSettings
+		saveSettings()
+		dialog.ShowInformation("Settings", "Settings saved.", w)
+	}, w)
+	form.Resize(fyne.NewSize(500, 680))
+	form.Show()
+}
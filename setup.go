@@ -0,0 +1,582 @@
+// setup.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// usersUnreadable is set when users.json exists but could not be read, so
+// setup never replaces accounts it failed to load.
+var usersUnreadable bool
+
+// SetupConfig is everything the first-run setup asks for. Only the admin
+// account is needed when users exist but none is an admin.
+type SetupConfig struct {
+	AdminUsername string
+	AdminPassword string
+	AdminEmail    string
+	SiteName      string
+	TimeZone      string
+	OpenTime      string
+	CloseTime     string
+	Rooms         []string
+	FloorPlanName string // File name of the floor plan, for its type
+	FloorPlan     []byte
+}
+
+// setupNeeded reports whether the app has no admin account yet.
+func setupNeeded() bool {
+	return countAdmins() == 0
+}
+
+// freshInstall reports whether setup should also configure the site, rather
+// than only create an admin for existing accounts.
+func freshInstall() bool {
+	return len(users) == 0
+}
+
+// parseRoomList reads room names from the first column of CSV or plain text,
+// one room per line. A header row of "Name" or "Room" is skipped.
+func parseRoomList(data []byte) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the room list: %v", err)
+	}
+	var names []string
+	seen := map[string]bool{}
+	for i, record := range records {
+		if len(record) == 0 {
+			continue
+		}
+		name := normalizeRoomName(record[0])
+		if i == 0 {
+			switch strings.ToLower(name) {
+			case "name", "room", "room name":
+				continue
+			}
+		}
+		if name == "" {
+			continue
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fieldErrorf(fieldRoomName, "room '%s' is listed twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// validateAdminAccount checks the admin part of a setup.
+func validateAdminAccount(config SetupConfig) error {
+	if err := validateNewUsername(config.AdminUsername); err != nil {
+		return err
+	}
+	if err := validatePassword(config.AdminUsername, config.AdminPassword, nil); err != nil {
+		return asFieldError(fieldPassword, err)
+	}
+	return validateEmail(config.AdminEmail)
+}
+
+func validateTimeZone(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	return nil
+}
+
+func validateNewRooms(names []string) error {
+	for _, name := range names {
+		if err := validateRoomName(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSiteSetup checks the site part of a setup.
+func validateSiteSetup(config SetupConfig) error {
+	if err := validateTimeZone(config.TimeZone); err != nil {
+		return err
+	}
+	if _, _, err := parseOpeningHours(config.OpenTime, config.CloseTime); err != nil {
+		return err
+	}
+	if err := validateNewRooms(config.Rooms); err != nil {
+		return err
+	}
+	if len(config.FloorPlan) > 0 {
+		if _, err := decodePlanImage(config.FloorPlanName, config.FloorPlan); err != nil {
+			return fmt.Errorf("could not read the floor plan: %v", err)
+		}
+	}
+	return nil
+}
+
+// applySetup checks the whole configuration before changing anything, then
+// creates the admin and, on a fresh install, configures the site.
+func applySetup(config SetupConfig) error {
+	if usersUnreadable {
+		return errors.New("users.json could not be read; fix or move it before running setup")
+	}
+	if !setupNeeded() {
+		return errors.New("setup has already been completed")
+	}
+	fresh := freshInstall()
+	if err := validateAdminAccount(config); err != nil {
+		return err
+	}
+	if fresh {
+		if err := validateSiteSetup(config); err != nil {
+			return err
+		}
+	}
+
+	if err := createUser(config.AdminUsername, config.AdminPassword, "Admin", config.AdminEmail); err != nil {
+		return err
+	}
+	if !fresh {
+		return nil
+	}
+
+	open, closing, _ := parseOpeningHours(config.OpenTime, config.CloseTime)
+	settings.SiteName = strings.TrimSpace(config.SiteName)
+	settings.TimeZone = config.TimeZone
+	settings.OpenTime = formatTimeOfDay(open)
+	settings.CloseTime = formatTimeOfDay(closing)
+	saveSettings()
+
+	floors := allFloors()
+	if len(floors) == 0 {
+		createDefaultLocation()
+		floors = allFloors()
+	}
+	if settings.SiteName != "" && len(sites) == 1 {
+		sites[0].Name = settings.SiteName
+		saveLocations()
+	}
+	floorID := floors[0].Floor.ID
+	for _, name := range config.Rooms {
+		if err := (&AddRoomCommand{room: &Room{Name: name, FloorID: floorID}}).Execute(); err != nil {
+			return err
+		}
+	}
+	if len(config.FloorPlan) > 0 {
+		if _, err := storeFloorPlan(floorID, config.FloorPlanName, config.FloorPlan); err != nil {
+			return err
+		}
+		selectedFloorID = floorID
+	}
+	// Setup is not something the admin should be able to undo
+	undoStack = nil
+	redoStack = nil
+	return nil
+}
+
+// runSetupCommand is "roomy setup": first-run setup without a display, for
+// scripted installs. It returns the process exit code.
+func runSetupCommand(args []string) int {
+	flags := flag.NewFlagSet("setup", flag.ContinueOnError)
+	admin := flags.String("admin", "", "username of the admin account (required)")
+	email := flags.String("email", "", "email address of the admin account")
+	passwordFile := flags.String("password-file", "", "file holding the admin password; otherwise $ROOMY_ADMIN_PASSWORD or standard input")
+	siteName := flags.String("site", "", "site name shown in the window title")
+	timeZone := flags.String("timezone", "", "site time zone, e.g. Europe/London; empty uses this machine's zone")
+	opens := flags.String("opens", defaultOpenTime, "start of the bookable day")
+	closes := flags.String("closes", defaultCloseTime, "end of the bookable day; 24:00 is midnight")
+	roomsFile := flags.String("rooms", "", "CSV or text file with one room name per line")
+	floorPlanFile := flags.String("floor-plan", "", "PNG, JPEG or SVG floor plan for the first floor")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: roomy setup -admin NAME [options]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *admin == "" {
+		flags.Usage()
+		return 2
+	}
+
+	// Problems with one input name the flag it came from
+	fieldFlags := map[string]string{
+		fieldUsername: "-admin",
+		fieldEmail:    "-email",
+		fieldPassword: "password",
+		fieldRoomName: "-rooms",
+	}
+	if *passwordFile != "" {
+		fieldFlags[fieldPassword] = "-password-file"
+	}
+	fail := func(err error) int {
+		var fieldErr *FieldError
+		if errors.As(err, &fieldErr) && fieldFlags[fieldErr.Field] != "" {
+			fmt.Fprintf(os.Stderr, "roomy setup: %s: %v\n", fieldFlags[fieldErr.Field], err)
+		} else {
+			fmt.Fprintf(os.Stderr, "roomy setup: %v\n", err)
+		}
+		return 1
+	}
+
+	loadKeyring()
+	if err := unlockFromEnvironment(); err != nil {
+		return fail(err)
+	}
+	loadSettings()
+	loadReservations()
+	loadLocations()
+	loadUsers()
+	if usersUnreadable {
+		return fail(errors.New("users.json could not be read; fix or move it before running setup"))
+	}
+	if !setupNeeded() {
+		return fail(errors.New("setup has already been completed"))
+	}
+
+	password, err := readSetupPassword(*passwordFile)
+	if err != nil {
+		return fail(err)
+	}
+	config := SetupConfig{
+		AdminUsername: strings.TrimSpace(*admin),
+		AdminPassword: password,
+		AdminEmail:    strings.TrimSpace(*email),
+		SiteName:      *siteName,
+		TimeZone:      *timeZone,
+		OpenTime:      *opens,
+		CloseTime:     *closes,
+	}
+	if *roomsFile != "" {
+		data, err := os.ReadFile(*roomsFile)
+		if err != nil {
+			return fail(err)
+		}
+		if config.Rooms, err = parseRoomList(data); err != nil {
+			return fail(err)
+		}
+	}
+	if *floorPlanFile != "" {
+		if config.FloorPlan, err = os.ReadFile(*floorPlanFile); err != nil {
+			return fail(err)
+		}
+		config.FloorPlanName = filepath.Base(*floorPlanFile)
+	}
+
+	if err := applySetup(config); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Created admin account %s", config.AdminUsername)
+	if len(config.Rooms) > 0 {
+		fmt.Printf(" and %d rooms", len(config.Rooms))
+	}
+	fmt.Println(".")
+	return 0
+}
+
+// readSetupPassword reads the admin password from a file, the environment
+// or the first line of standard input, so it never appears in the process
+// list.
+func readSetupPassword(path string) (string, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if password := os.Getenv("ROOMY_ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Admin password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setupStep is one page of the setup wizard
+type setupStep struct {
+	title    string
+	content  fyne.CanvasObject
+	onShow   func()       // Optional; refreshes the page before it is shown
+	validate func() error // Optional; checked before moving on
+}
+
+// createSetupWizard fills the window until setup is finished, so the app
+// cannot be used without an admin account. Nothing is saved until Finish.
+func createSetupWizard(w fyne.Window, onDone func()) fyne.CanvasObject {
+	if usersUnreadable {
+		message := wrappedLabel("users.json could not be read, so roomy cannot check for an admin account. Fix or move the file and start roomy again; see the log for details.")
+		return container.NewCenter(container.NewGridWrap(fyne.NewSize(500, 120), message))
+	}
+	fresh := freshInstall()
+	var config SetupConfig
+
+	// Admin account
+	usernameEntry := widget.NewEntry()
+	emailEntry := widget.NewEntry()
+	emailEntry.SetPlaceHolder("Optional, for notifications")
+	passwordEntry := widget.NewPasswordEntry()
+	confirmPasswordEntry := widget.NewPasswordEntry()
+	usernameEntry.Validator = entryValidator(validateNewUsername)
+	emailEntry.Validator = entryValidator(validateEmail)
+	passwordEntry.Validator = func(password string) error {
+		return validatePassword(usernameEntry.Text, password, nil)
+	}
+	confirmPasswordEntry.Validator = func(password string) error {
+		if password != passwordEntry.Text {
+			return errors.New("passwords do not match")
+		}
+		return nil
+	}
+	adminIntro := "Create the first admin account. Admins manage rooms, users and settings."
+	if !fresh {
+		adminIntro = "There are no admin accounts. Create one to manage rooms, users and settings."
+	}
+	steps := []setupStep{{
+		title: "Admin Account",
+		content: container.NewVBox(wrappedLabel(adminIntro), widget.NewForm(
+			widget.NewFormItem("Username", usernameEntry),
+			widget.NewFormItem("Email", emailEntry),
+			&widget.FormItem{Text: "Password", Widget: passwordEntry, HintText: passwordPolicyHint()},
+			widget.NewFormItem("Confirm Password", confirmPasswordEntry),
+		)),
+		validate: func() error {
+			if passwordEntry.Text != confirmPasswordEntry.Text {
+				return errors.New("passwords do not match")
+			}
+			config.AdminUsername = strings.TrimSpace(usernameEntry.Text)
+			config.AdminPassword = passwordEntry.Text
+			config.AdminEmail = strings.TrimSpace(emailEntry.Text)
+			return validateAdminAccount(config)
+		},
+	}}
+
+	if fresh {
+		// Site
+		siteNameEntry := widget.NewEntry()
+		siteNameEntry.SetPlaceHolder("e.g. City Library")
+		timeZoneEntry := widget.NewEntry()
+		timeZoneEntry.SetPlaceHolder("e.g. Europe/London")
+		openEntry := widget.NewEntry()
+		openEntry.SetText("8:00 AM")
+		closeEntry := widget.NewEntry()
+		closeEntry.SetText(defaultCloseTime)
+		steps = append(steps, setupStep{
+			title: "Site",
+			content: widget.NewForm(
+				widget.NewFormItem("Site Name", siteNameEntry),
+				&widget.FormItem{Text: "Time Zone", Widget: timeZoneEntry, HintText: "IANA name; empty uses this machine's zone"},
+				&widget.FormItem{Text: "Opens", Widget: openEntry, HintText: "Start of the bookable day"},
+				&widget.FormItem{Text: "Closes", Widget: closeEntry, HintText: "End of the bookable day; 24:00 is midnight"},
+			),
+			validate: func() error {
+				config.SiteName = strings.TrimSpace(siteNameEntry.Text)
+				config.TimeZone = strings.TrimSpace(timeZoneEntry.Text)
+				config.OpenTime = openEntry.Text
+				config.CloseTime = closeEntry.Text
+				if err := validateTimeZone(config.TimeZone); err != nil {
+					return err
+				}
+				_, _, err := parseOpeningHours(config.OpenTime, config.CloseTime)
+				return err
+			},
+		})
+
+		// Rooms
+		roomsEntry := widget.NewMultiLineEntry()
+		roomsEntry.SetPlaceHolder("Study Room 1\nStudy Room 2")
+		roomsEntry.SetMinRowsVisible(10)
+		roomsEntry.Validator = func(text string) error {
+			names, err := parseRoomList([]byte(text))
+			if err != nil {
+				return err
+			}
+			return validateNewRooms(names)
+		}
+		importButton := widget.NewButtonWithIcon("Import CSV...", theme.FolderOpenIcon(), func() {
+			fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+				if err != nil || reader == nil {
+					return
+				}
+				defer reader.Close()
+				data, err := io.ReadAll(reader)
+				if err == nil {
+					var names []string
+					if names, err = parseRoomList(data); err == nil {
+						roomsEntry.SetText(strings.Join(names, "\n"))
+					}
+				}
+				if err != nil {
+					dialog.ShowError(err, w)
+				}
+			}, w)
+			fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv", ".txt"}))
+			fileDialog.Show()
+		})
+		steps = append(steps, setupStep{
+			title: "Rooms",
+			content: container.NewBorder(
+				wrappedLabel("List the rooms that can be booked, one per line, or import them from a CSV file with the room name in the first column. Rooms can also be added later."),
+				container.NewHBox(importButton), nil, nil, roomsEntry),
+			validate: func() error {
+				names, err := parseRoomList([]byte(roomsEntry.Text))
+				if err != nil {
+					return err
+				}
+				config.Rooms = names
+				return validateNewRooms(names)
+			},
+		})
+
+		// Floor plan
+		planLabel := widget.NewLabel("No floor plan chosen.")
+		var removeButton *widget.Button
+		chooseButton := widget.NewButtonWithIcon("Choose Image...", theme.FolderOpenIcon(), func() {
+			fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+				if err != nil || reader == nil {
+					return
+				}
+				defer reader.Close()
+				data, err := io.ReadAll(reader)
+				if err == nil {
+					_, err = decodePlanImage(reader.URI().Name(), data)
+				}
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("could not read the floor plan: %v", err), w)
+					return
+				}
+				config.FloorPlan = data
+				config.FloorPlanName = reader.URI().Name()
+				planLabel.SetText("Floor plan: " + config.FloorPlanName)
+				removeButton.Enable()
+			}, w)
+			fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png", ".jpg", ".jpeg", ".svg"}))
+			fileDialog.Show()
+		})
+		removeButton = widget.NewButtonWithIcon("Remove", theme.DeleteIcon(), func() {
+			config.FloorPlan = nil
+			config.FloorPlanName = ""
+			planLabel.SetText("No floor plan chosen.")
+			removeButton.Disable()
+		})
+		removeButton.Disable()
+		steps = append(steps, setupStep{
+			title: "Floor Plan",
+			content: container.NewVBox(
+				wrappedLabel("Optionally upload a PNG, JPEG or SVG floor plan. Rooms can be placed on it afterwards, and more sites, buildings and floors added under Manage Locations."),
+				planLabel, container.NewHBox(chooseButton, removeButton)),
+		})
+	}
+
+	// Review
+	summary := widget.NewLabel("")
+	steps = append(steps, setupStep{
+		title:   "Review",
+		content: container.NewVBox(wrappedLabel("Check the details below, then choose Finish."), summary),
+		onShow: func() {
+			lines := []string{"Admin account: " + config.AdminUsername}
+			if fresh {
+				siteName := config.SiteName
+				if siteName == "" {
+					siteName = "(none)"
+				}
+				timeZone := config.TimeZone
+				if timeZone == "" {
+					timeZone = "This machine's zone"
+				}
+				lines = append(lines,
+					"Site name: "+siteName,
+					"Time zone: "+timeZone,
+					fmt.Sprintf("Hours: %s to %s", config.OpenTime, config.CloseTime),
+					fmt.Sprintf("Rooms: %d", len(config.Rooms)))
+				if config.FloorPlanName != "" {
+					lines = append(lines, "Floor plan: "+config.FloorPlanName)
+				}
+			}
+			summary.SetText(strings.Join(lines, "\n"))
+		},
+	})
+
+	title := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	page := container.NewMax()
+	current := 0
+	var backButton, nextButton *widget.Button
+	show := func(i int) {
+		current = i
+		step := steps[i]
+		if step.onShow != nil {
+			step.onShow()
+		}
+		title.SetText(fmt.Sprintf("Set Up Room Booking: %s (step %d of %d)", step.title, i+1, len(steps)))
+		page.Objects = []fyne.CanvasObject{step.content}
+		page.Refresh()
+		if i == 0 {
+			backButton.Disable()
+		} else {
+			backButton.Enable()
+		}
+		if i == len(steps)-1 {
+			nextButton.SetText("Finish")
+		} else {
+			nextButton.SetText("Next")
+		}
+	}
+	backButton = widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		show(current - 1)
+	})
+	nextButton = widget.NewButtonWithIcon("Next", theme.NavigateNextIcon(), func() {
+		if validate := steps[current].validate; validate != nil {
+			if err := validate(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
+		if current < len(steps)-1 {
+			show(current + 1)
+			return
+		}
+		if err := applySetup(config); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		onDone()
+	})
+	nextButton.Importance = widget.HighImportance
+	show(0)
+
+	buttons := container.NewBorder(nil, nil, backButton, nextButton)
+	body := container.NewBorder(container.NewVBox(title, widget.NewSeparator()), buttons, nil, nil, container.NewVScroll(page))
+	return container.NewCenter(container.NewGridWrap(fyne.NewSize(600, 520), body))
+}
+
+func wrappedLabel(text string) *widget.Label {
+	label := widget.NewLabel(text)
+	label.Wrapping = fyne.TextWrapWord
+	return label
+}
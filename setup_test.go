@@ -0,0 +1,295 @@
+// setup_test.go
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+)
+
+// useTestSetup starts the test as a fresh install with no data files.
+func useTestSetup(t *testing.T) {
+	t.Helper()
+	useTestEncryption(t)
+	savedUnreadable, savedFloor := usersUnreadable, selectedFloorID
+	usersUnreadable, selectedFloorID = false, ""
+	t.Cleanup(func() { usersUnreadable, selectedFloorID = savedUnreadable, savedFloor })
+}
+
+func TestParseRoomList(t *testing.T) {
+	names, err := parseRoomList([]byte("Room,Capacity\nStudy Room 1, 4\n\n  Lab A ,12\nStudy  Room 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, "|"); got != "Study Room 1|Lab A|Study Room 2" {
+		t.Errorf("rooms = %q", got)
+	}
+	names, err = parseRoomList([]byte("Lab A\nLab B"))
+	if err != nil || len(names) != 2 {
+		t.Errorf("plain text list gave %q, %v", names, err)
+	}
+	if _, err := parseRoomList([]byte("Lab A\nlab a\n")); err == nil || !strings.Contains(err.Error(), "listed twice") {
+		t.Errorf("a repeated room gave %v", err)
+	}
+}
+
+func TestApplySetupFreshInstall(t *testing.T) {
+	useTestSetup(t)
+	config := SetupConfig{
+		AdminUsername: "admin",
+		AdminPassword: "quiet meadow",
+		SiteName:      "City Library",
+		TimeZone:      "Europe/London",
+		OpenTime:      "9:00 AM",
+		CloseTime:     "17:30",
+		Rooms:         []string{"Study Room 1", "Lab A"},
+		FloorPlanName: "plan.svg",
+		FloorPlan:     []byte(testSVGPlan),
+	}
+	if err := applySetup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if admin := findUser("admin"); admin == nil || admin.Role != "Admin" {
+		t.Fatalf("admin account = %+v", admin)
+	}
+	if settings.SiteName != "City Library" || settings.OpenTime != "09:00" || settings.CloseTime != "17:30" {
+		t.Errorf("settings = %+v", settings)
+	}
+	if siteLocation().String() != "Europe/London" {
+		t.Errorf("site zone = %v", siteLocation())
+	}
+	floors := allFloors()
+	if len(floors) != 1 || floors[0].Site != "City Library" || floors[0].Floor.PlanPath == "" {
+		t.Fatalf("floors = %+v", floors)
+	}
+	if len(rooms) != 2 || rooms[0].Name != "Study Room 1" || rooms[1].FloorID != floors[0].Floor.ID {
+		t.Errorf("rooms = %+v", rooms)
+	}
+	if selectedFloorID != floors[0].Floor.ID {
+		t.Error("the floor plan view does not open on the new floor")
+	}
+	if len(undoStack) != 0 {
+		t.Errorf("setup left %d changes to undo", len(undoStack))
+	}
+	for _, file := range []string{"users.json", "settings.json", "reservations.json", floors[0].Floor.PlanPath} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("%s was not saved: %v", file, err)
+		}
+	}
+
+	config.AdminUsername = "admin2"
+	if err := applySetup(config); err == nil || err.Error() != "setup has already been completed" {
+		t.Errorf("running setup again gave %v", err)
+	}
+}
+
+func TestApplySetupChecksEverythingFirst(t *testing.T) {
+	useTestSetup(t)
+	valid := SetupConfig{AdminUsername: "admin", AdminPassword: "quiet meadow", OpenTime: "8:00 AM", CloseTime: "24:00"}
+	tests := []struct {
+		name   string
+		change func(*SetupConfig)
+	}{
+		{"short password", func(c *SetupConfig) { c.AdminPassword = "short" }},
+		{"bad email", func(c *SetupConfig) { c.AdminEmail = "not an email" }},
+		{"unknown time zone", func(c *SetupConfig) { c.TimeZone = "Mars/Olympus" }},
+		{"closing before opening", func(c *SetupConfig) { c.OpenTime, c.CloseTime = "17:00", "9:00" }},
+		{"bad room name", func(c *SetupConfig) { c.Rooms = []string{"Lab A", ""} }},
+		{"unreadable floor plan", func(c *SetupConfig) { c.FloorPlanName, c.FloorPlan = "plan.png", []byte("not a png") }},
+	}
+	for _, tt := range tests {
+		config := valid
+		tt.change(&config)
+		if err := applySetup(config); err == nil {
+			t.Errorf("%s: setup succeeded", tt.name)
+		}
+		if len(users) != 0 || len(rooms) != 0 || settings.TimeZone != "" {
+			t.Fatalf("%s: setup changed data before failing", tt.name)
+		}
+	}
+
+	usersUnreadable = true
+	if err := applySetup(valid); err == nil || !strings.Contains(err.Error(), "could not be read") {
+		t.Errorf("setup with unreadable accounts gave %v", err)
+	}
+}
+
+func TestApplySetupOnlyAddsAdminToExistingAccounts(t *testing.T) {
+	useTestSetup(t)
+	users = []User{{Username: "bob", Role: "User"}}
+	settings.SiteName = "Old Name"
+	// The site is already set up, so its part of the config is ignored
+	config := SetupConfig{AdminUsername: "admin", AdminPassword: "quiet meadow", SiteName: "New Name", TimeZone: "Mars/Olympus", Rooms: []string{"Lab A"}}
+	if err := applySetup(config); err != nil {
+		t.Fatal(err)
+	}
+	if countAdmins() != 1 || len(users) != 2 {
+		t.Errorf("users = %+v", users)
+	}
+	if settings.SiteName != "Old Name" || len(rooms) != 0 || len(sites) != 0 {
+		t.Errorf("setup changed the site: settings %+v, %d rooms, %d sites", settings, len(rooms), len(sites))
+	}
+}
+
+func TestRunSetupCommand(t *testing.T) {
+	useTestSetup(t)
+	if code := runSetupCommand(nil); code != 2 {
+		t.Errorf("setup without -admin exited with %d, want 2", code)
+	}
+	if err := os.WriteFile("admin-password", []byte("quiet meadow\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("rooms.csv", []byte("Name\nStudy Room 1\nLab A\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code := runSetupCommand([]string{"-admin", "admin", "-password-file", "admin-password", "-timezone", "Europe/London", "-rooms", "rooms.csv"})
+	if code != 0 {
+		t.Fatalf("setup exited with %d", code)
+	}
+	if _, err := authenticateUser("admin", "quiet meadow"); err != nil {
+		t.Errorf("signing in as the new admin: %v", err)
+	}
+	if len(rooms) != 2 || settings.TimeZone != "Europe/London" {
+		t.Errorf("rooms %+v, time zone %q", rooms, settings.TimeZone)
+	}
+
+	// A second run reads the saved accounts and refuses
+	users, rooms = nil, nil
+	t.Setenv("ROOMY_ADMIN_PASSWORD", "quiet meadow")
+	if code := runSetupCommand([]string{"-admin", "admin2"}); code != 1 {
+		t.Errorf("setup after an admin exists exited with %d, want 1", code)
+	}
+	if findUser("admin2") != nil {
+		t.Error("a second admin was created")
+	}
+}
+
+func TestReadSetupPassword(t *testing.T) {
+	useTestSetup(t)
+	if err := os.WriteFile("admin-password", []byte("from file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ROOMY_ADMIN_PASSWORD", "from environment")
+	if got, err := readSetupPassword("admin-password"); err != nil || got != "from file" {
+		t.Errorf("password file gave %q, %v", got, err)
+	}
+	if got, err := readSetupPassword(""); err != nil || got != "from environment" {
+		t.Errorf("environment gave %q, %v", got, err)
+	}
+	if _, err := readSetupPassword("missing"); err == nil {
+		t.Error("a missing password file was not reported")
+	}
+}
+
+// setupPage finds the title, entries and buttons on the wizard's current
+// page.
+func setupPage(obj fyne.CanvasObject) (title string, entries []*widget.Entry, buttons map[string]*widget.Button) {
+	buttons = map[string]*widget.Button{}
+	var walk func(fyne.CanvasObject)
+	walk = func(obj fyne.CanvasObject) {
+		switch o := obj.(type) {
+		case *fyne.Container:
+			for _, child := range o.Objects {
+				walk(child)
+			}
+		case *container.Scroll:
+			walk(o.Content)
+		case *widget.Form:
+			for _, item := range o.Items {
+				walk(item.Widget)
+			}
+		case *widget.Entry:
+			entries = append(entries, o)
+		case *widget.Button:
+			buttons[o.Text] = o
+		case *widget.Label:
+			if strings.HasPrefix(o.Text, "Set Up Room Booking: ") {
+				title = o.Text
+			}
+		}
+	}
+	walk(obj)
+	return title, entries, buttons
+}
+
+func TestSetupWizard(t *testing.T) {
+	useTestSetup(t)
+	test.NewApp()
+	w := test.NewWindow(nil)
+	defer w.Close()
+	done := false
+	wizard := createSetupWizard(w, func() { done = true })
+	w.SetContent(wizard)
+
+	next := func() string {
+		_, _, buttons := setupPage(wizard)
+		if buttons["Next"] != nil {
+			test.Tap(buttons["Next"])
+		} else {
+			test.Tap(buttons["Finish"])
+		}
+		title, _, _ := setupPage(wizard)
+		return title
+	}
+	title, entries, _ := setupPage(wizard)
+	if title != "Set Up Room Booking: Admin Account (step 1 of 5)" || len(entries) != 4 {
+		t.Fatalf("first page %q has %d entries", title, len(entries))
+	}
+	entries[0].SetText("admin")
+	entries[2].SetText("quiet meadow")
+	entries[3].SetText("quiet meadows")
+	if title := next(); !strings.Contains(title, "Admin Account") {
+		t.Errorf("mismatched passwords moved on to %q", title)
+	}
+	entries[3].SetText("quiet meadow")
+	if title := next(); !strings.Contains(title, "Site (step 2") {
+		t.Fatalf("the admin page moved on to %q", title)
+	}
+
+	_, entries, _ = setupPage(wizard)
+	entries[1].SetText("Mars/Olympus")
+	if title := next(); !strings.Contains(title, "Site") {
+		t.Errorf("an unknown time zone moved on to %q", title)
+	}
+	entries[1].SetText("UTC")
+	if title := next(); !strings.Contains(title, "Rooms") {
+		t.Fatalf("the site page moved on to %q", title)
+	}
+	_, entries, _ = setupPage(wizard)
+	entries[0].SetText("Lab A\nLab B")
+	next()
+	if title := next(); !strings.Contains(title, "Review") {
+		t.Fatalf("reached %q instead of the review", title)
+	}
+	if len(users) != 0 || len(rooms) != 0 {
+		t.Fatal("the wizard saved before Finish")
+	}
+
+	next()
+	if !done {
+		t.Fatal("finishing the wizard did not continue")
+	}
+	if countAdmins() != 1 || len(rooms) != 2 || settings.TimeZone != "UTC" {
+		t.Errorf("after finishing: users %+v, %d rooms, time zone %q", users, len(rooms), settings.TimeZone)
+	}
+}
+
+func TestSetupWizardForExistingAccounts(t *testing.T) {
+	useTestSetup(t)
+	test.NewApp()
+	w := test.NewWindow(nil)
+	defer w.Close()
+	users = []User{{Username: "bob", Role: "User"}}
+	title, _, _ := setupPage(createSetupWizard(w, func() {}))
+	if title != "Set Up Room Booking: Admin Account (step 1 of 2)" {
+		t.Errorf("title = %q", title)
+	}
+}
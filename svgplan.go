@@ -0,0 +1,430 @@
+// svgplan.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// maxSVGRasterSize is the longest side, in pixels, SVG plans are drawn at
+const maxSVGRasterSize = 2048
+
+const notARoomLabel = "Not a room"
+
+// svgShape is an element of an SVG floor plan that may outline a room
+type svgShape struct {
+	ID     string
+	Points []fyne.Position // Plan coordinates
+}
+
+// svgPlan is what the app needs from an SVG floor plan
+type svgPlan struct {
+	ViewBox struct{ X, Y, W, H float64 }
+	Shapes  []svgShape
+}
+
+// svgMatrix is an affine transform: x' = a*x + c*y + e, y' = b*x + d*y + f
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+func (m svgMatrix) multiply(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m svgMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+var (
+	svgNumberPattern    = regexp.MustCompile(`[-+]?(?:\d*\.\d+|\d+\.?)(?:[eE][-+]?\d+)?`)
+	svgTransformPattern = regexp.MustCompile(`(\w+)\s*\(([^)]*)\)`)
+	svgPathPattern      = regexp.MustCompile(`[MmLlHhVvCcSsQqTtAaZz]|[-+]?(?:\d*\.\d+|\d+\.?)(?:[eE][-+]?\d+)?`)
+)
+
+func svgNumbers(value string) []float64 {
+	numbers := []float64{}
+	for _, field := range svgNumberPattern.FindAllString(value, -1) {
+		if n, err := strconv.ParseFloat(field, 64); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// svgLength reads a plain or pixel length such as "120" or "120px".
+func svgLength(value string) float64 {
+	n, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	return n
+}
+
+// parseSVGTransform reads a transform attribute such as
+// "translate(10 20) rotate(45)".
+func parseSVGTransform(value string) svgMatrix {
+	m := svgIdentity
+	for _, match := range svgTransformPattern.FindAllStringSubmatch(value, -1) {
+		args := svgNumbers(match[2])
+		arg := func(i int, fallback float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return fallback
+		}
+		var t svgMatrix
+		switch match[1] {
+		case "matrix":
+			if len(args) != 6 {
+				continue
+			}
+			copy(t[:], args)
+		case "translate":
+			t = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			sx := arg(0, 1)
+			t = svgMatrix{sx, 0, 0, arg(1, sx), 0, 0}
+		case "rotate":
+			angle := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = svgMatrix{1, 0, 0, 1, cx, cy}.
+				multiply(svgMatrix{math.Cos(angle), math.Sin(angle), -math.Sin(angle), math.Cos(angle), 0, 0}).
+				multiply(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			t = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			continue
+		}
+		m = m.multiply(t)
+	}
+	return m
+}
+
+// svgPathVertices returns the end points of each segment of the first
+// subpath in d. Curves are reduced to their end points, which is enough to
+// outline a room.
+func svgPathVertices(d string) [][2]float64 {
+	argCounts := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7, 'Z': 0}
+	tokens := svgPathPattern.FindAllString(d, -1)
+	points := [][2]float64{}
+	var x, y float64
+	var command byte
+	for i := 0; i < len(tokens); {
+		if c := tokens[i][0]; unicode.IsLetter(rune(c)) {
+			command = c
+			i++
+			if command == 'Z' || command == 'z' {
+				return points
+			}
+			if (command == 'M' || command == 'm') && len(points) > 0 {
+				return points // Only the outline, not holes or later subpaths
+			}
+		}
+		upper := byte(unicode.ToUpper(rune(command)))
+		count, ok := argCounts[upper]
+		if !ok || count == 0 || i+count > len(tokens) {
+			break
+		}
+		args := make([]float64, count)
+		for j := range args {
+			n, err := strconv.ParseFloat(tokens[i+j], 64)
+			if err != nil {
+				return points
+			}
+			args[j] = n
+		}
+		i += count
+		relative := command != upper
+		switch upper {
+		case 'H':
+			if relative {
+				x += args[0]
+			} else {
+				x = args[0]
+			}
+		case 'V':
+			if relative {
+				y += args[0]
+			} else {
+				y = args[0]
+			}
+		default:
+			if relative {
+				x, y = x+args[count-2], y+args[count-1]
+			} else {
+				x, y = args[count-2], args[count-1]
+			}
+		}
+		points = append(points, [2]float64{x, y})
+		// Further coordinate pairs after a move are line segments
+		if command == 'M' {
+			command = 'L'
+		} else if command == 'm' {
+			command = 'l'
+		}
+	}
+	return points
+}
+
+// svgElementVertices outlines a shape element in its own coordinates.
+func svgElementVertices(name string, attr func(string) string) [][2]float64 {
+	number := func(key string) float64 {
+		return svgLength(attr(key))
+	}
+	switch name {
+	case "rect":
+		x, y, w, h := number("x"), number("y"), number("width"), number("height")
+		if w <= 0 || h <= 0 {
+			return nil
+		}
+		return [][2]float64{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+	case "polygon", "polyline":
+		numbers := svgNumbers(attr("points"))
+		points := [][2]float64{}
+		for i := 0; i+1 < len(numbers); i += 2 {
+			points = append(points, [2]float64{numbers[i], numbers[i+1]})
+		}
+		return points
+	case "path":
+		return svgPathVertices(attr("d"))
+	case "circle", "ellipse":
+		cx, cy := number("cx"), number("cy")
+		rx, ry := number("rx"), number("ry")
+		if name == "circle" {
+			rx, ry = number("r"), number("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil
+		}
+		points := [][2]float64{}
+		for i := 0; i < 16; i++ {
+			angle := float64(i) * 2 * math.Pi / 16
+			points = append(points, [2]float64{cx + rx*math.Cos(angle), cy + ry*math.Sin(angle)})
+		}
+		return points
+	}
+	return nil
+}
+
+// parseSVGPlan reads an SVG floor plan and collects every shape element with
+// an id as a candidate room outline.
+func parseSVGPlan(data []byte) (*svgPlan, error) {
+	plan := &svgPlan{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	transforms := []svgMatrix{svgIdentity}
+	defsDepth := 0
+	seenRoot := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range element.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			attr := func(key string) string { return attrs[key] }
+			current := transforms[len(transforms)-1].multiply(parseSVGTransform(attrs["transform"]))
+			transforms = append(transforms, current)
+
+			name := element.Name.Local
+			if !seenRoot {
+				if name != "svg" {
+					return nil, errors.New("not an SVG file")
+				}
+				seenRoot = true
+				if box := svgNumbers(attrs["viewBox"]); len(box) == 4 {
+					plan.ViewBox.X, plan.ViewBox.Y, plan.ViewBox.W, plan.ViewBox.H = box[0], box[1], box[2], box[3]
+				} else {
+					plan.ViewBox.W, plan.ViewBox.H = svgLength(attrs["width"]), svgLength(attrs["height"])
+				}
+				continue
+			}
+			if name == "defs" || name == "symbol" || name == "clipPath" || name == "mask" || defsDepth > 0 {
+				defsDepth++
+				continue
+			}
+			id := strings.TrimSpace(attrs["id"])
+			if id == "" {
+				continue
+			}
+			vertices := svgElementVertices(name, attr)
+			if len(vertices) < 3 {
+				continue
+			}
+			shape := svgShape{ID: id}
+			for _, v := range vertices {
+				x, y := current.apply(v[0], v[1])
+				shape.Points = append(shape.Points, fyne.NewPos(float32((x-plan.ViewBox.X)/plan.ViewBox.W), float32((y-plan.ViewBox.Y)/plan.ViewBox.H)))
+			}
+			plan.Shapes = append(plan.Shapes, shape)
+		case xml.EndElement:
+			if len(transforms) > 1 {
+				transforms = transforms[:len(transforms)-1]
+			}
+			if defsDepth > 0 {
+				defsDepth--
+			}
+		}
+	}
+	if !seenRoot {
+		return nil, errors.New("not an SVG file")
+	}
+	if plan.ViewBox.W <= 0 || plan.ViewBox.H <= 0 {
+		return nil, errors.New("the SVG has no size; add a viewBox or width and height")
+	}
+	return plan, nil
+}
+
+// rasterizeSVG draws an SVG floor plan into an image for display.
+func rasterizeSVG(data []byte) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New("the SVG has no size; add a viewBox or width and height")
+	}
+	scale := maxSVGRasterSize / math.Max(icon.ViewBox.W, icon.ViewBox.H)
+	w, h := int(icon.ViewBox.W*scale), int(icon.ViewBox.H*scale)
+	icon.SetTarget(0, 0, float64(w), float64(h))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img, nil
+}
+
+// normalizeRoomKey reduces a room name or element id to lowercase letters
+// and digits, so "Study Room 1" matches "study-room-1".
+func normalizeRoomKey(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// polygonCentre returns the centroid of shape, used to place the room's marker.
+func polygonCentre(shape []fyne.Position) fyne.Position {
+	var area, cx, cy float64
+	for i := range shape {
+		a, b := shape[i], shape[(i+1)%len(shape)]
+		cross := float64(a.X*b.Y - b.X*a.Y)
+		area += cross
+		cx += float64(a.X+b.X) * cross
+		cy += float64(a.Y+b.Y) * cross
+	}
+	if math.Abs(area) < 1e-9 {
+		minX, minY, maxX, maxY := shapeBounds(shape)
+		return fyne.NewPos((minX+maxX)/2, (minY+maxY)/2)
+	}
+	return fyne.NewPos(float32(cx/(3*area)), float32(cy/(3*area)))
+}
+
+// showSVGBinding lets admins bind the shapes found in a floor's SVG plan to
+// rooms. Bound rooms move to the floor and take the element's outline, with
+// the marker at its centre, as one undoable change.
+func showSVGBinding(floor floorRef, plan *svgPlan, onDone func(), w fyne.Window) {
+	if len(plan.Shapes) == 0 {
+		dialog.ShowInformation("Bind Rooms", "No shapes with an id were found in the drawing.", w)
+		return
+	}
+
+	options := append([]string{notARoomLabel}, roomNames()...)
+	byKey := map[string]*Room{}
+	for _, room := range rooms {
+		byKey[normalizeRoomKey(room.Name)] = room
+	}
+
+	list := container.NewVBox()
+	selects := make([]*widget.Select, len(plan.Shapes))
+	for i, shape := range plan.Shapes {
+		roomSelect := widget.NewSelect(options, nil)
+		roomSelect.SetSelected(notARoomLabel)
+		// Keep existing bindings, otherwise suggest a room with a matching name
+		suggested := byKey[normalizeRoomKey(shape.ID)]
+		for _, room := range rooms {
+			if room.PlanElementID == shape.ID && room.FloorID == floor.Floor.ID {
+				suggested = room
+			}
+		}
+		if suggested != nil {
+			roomSelect.SetSelected(suggested.Name)
+		}
+		selects[i] = roomSelect
+		list.Add(container.NewBorder(nil, nil, widget.NewLabel(shape.ID), nil, roomSelect))
+	}
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(450, 320))
+
+	help := widget.NewLabel(fmt.Sprintf("Choose the room each element of the drawing for %s outlines.", floor.Label()))
+	help.Wrapping = fyne.TextWrapWord
+	d := dialog.NewCustomConfirm("Bind Rooms", "Apply", "Cancel", container.NewBorder(help, nil, nil, nil, scroll), func(apply bool) {
+		if !apply {
+			return
+		}
+		composite := &CompositeCommand{}
+		bound := map[*Room]bool{}
+		for i, shape := range plan.Shapes {
+			room := findRoom(selects[i].Selected)
+			if room == nil {
+				continue
+			}
+			if bound[room] {
+				dialog.ShowError(fmt.Errorf("room '%s' is chosen for more than one element", room.Name), w)
+				return
+			}
+			bound[room] = true
+			after := roomPlacement{FloorID: floor.Floor.ID, ElementID: shape.ID, Position: polygonCentre(shape.Points), Shape: shape.Points}
+			composite.Commands = append(composite.Commands, &SetRoomPlacementCommand{room: room, before: placementOf(room), after: after})
+		}
+		// Rooms on this floor no longer bound keep their outline but lose the link
+		for _, room := range roomsOnFloor(floor.Floor.ID) {
+			if !bound[room] && room.PlanElementID != "" {
+				after := placementOf(room)
+				after.ElementID = ""
+				composite.Commands = append(composite.Commands, &SetRoomPlacementCommand{room: room, before: placementOf(room), after: after})
+			}
+		}
+		if len(composite.Commands) > 0 {
+			if err := executeCommand(composite); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
+		if onDone != nil {
+			onDone()
+		}
+	}, w)
+	d.Resize(fyne.NewSize(520, 480))
+	d.Show()
+}
@@ -0,0 +1,120 @@
+// svgplan_test.go
+
+package main
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+const testSVGPlan = `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 200 100">
+  <defs><rect id="template" x="0" y="0" width="10" height="10"/></defs>
+  <rect id="study-room-1" x="0" y="0" width="100" height="50"/>
+  <rect x="0" y="50" width="10" height="10"/>
+  <g transform="translate(100 50)">
+    <polygon id="lab" points="0,0 50,0 50,50"/>
+  </g>
+  <path id="hall" d="m 0 50 h 100 v 50 h -100 z"/>
+  <circle id="pod" cx="150" cy="25" r="10"/>
+</svg>`
+
+func TestParseSVGPlan(t *testing.T) {
+	plan, err := parseSVGPlan([]byte(testSVGPlan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]fyne.Position{
+		"study-room-1": {{X: 0, Y: 0}, {X: 0.5, Y: 0}, {X: 0.5, Y: 0.5}, {X: 0, Y: 0.5}},
+		"lab":          {{X: 0.5, Y: 0.5}, {X: 0.75, Y: 0.5}, {X: 0.75, Y: 1}},
+		"hall":         {{X: 0, Y: 0.5}, {X: 0.5, Y: 0.5}, {X: 0.5, Y: 1}, {X: 0, Y: 1}},
+	}
+	ids := []string{}
+	for _, shape := range plan.Shapes {
+		ids = append(ids, shape.ID)
+		if shape.ID == "pod" {
+			if len(shape.Points) != 16 || !closeTo(polygonCentre(shape.Points), fyne.NewPos(0.75, 0.25)) {
+				t.Errorf("circle outlined by %d points around %v", len(shape.Points), polygonCentre(shape.Points))
+			}
+			continue
+		}
+		expected, ok := want[shape.ID]
+		if !ok || len(shape.Points) != len(expected) {
+			t.Errorf("shape %s has points %v, want %v", shape.ID, shape.Points, expected)
+			continue
+		}
+		for i := range expected {
+			if !closeTo(shape.Points[i], expected[i]) {
+				t.Errorf("shape %s point %d is %v, want %v", shape.ID, i, shape.Points[i], expected[i])
+			}
+		}
+	}
+	if len(ids) != 4 {
+		t.Errorf("found shapes %v, want study-room-1, lab, hall and pod", ids)
+	}
+
+	for name, data := range map[string]string{
+		"not SVG": `<html><body/></html>`,
+		"no size": `<svg xmlns="http://www.w3.org/2000/svg"><rect id="a" width="1" height="1"/></svg>`,
+	} {
+		if _, err := parseSVGPlan([]byte(data)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestParseSVGTransform(t *testing.T) {
+	tests := []struct {
+		transform string
+		x, y      float64
+		wantX     float64
+		wantY     float64
+	}{
+		{"", 3, 4, 3, 4},
+		{"translate(10 20)", 1, 1, 11, 21},
+		{"translate(10, 20) scale(2)", 1, 1, 12, 22},
+		{"rotate(90)", 1, 0, 0, 1},
+		{"matrix(1 0 0 1 5 6)", 0, 0, 5, 6},
+	}
+	for _, tt := range tests {
+		x, y := parseSVGTransform(tt.transform).apply(tt.x, tt.y)
+		if !closeTo(fyne.NewPos(float32(x), float32(y)), fyne.NewPos(float32(tt.wantX), float32(tt.wantY))) {
+			t.Errorf("%q moves (%v, %v) to (%v, %v), want (%v, %v)", tt.transform, tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestRasterizeSVG(t *testing.T) {
+	img, err := rasterizeSVG([]byte(testSVGPlan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != maxSVGRasterSize || size.Y != maxSVGRasterSize/2 {
+		t.Errorf("drawn at %v, want %dx%d", size, maxSVGRasterSize, maxSVGRasterSize/2)
+	}
+}
+
+func TestBindRoomToSVGElementUndo(t *testing.T) {
+	room := useTestRoom(t, "Study Room 1")
+	if normalizeRoomKey(room.Name) != normalizeRoomKey("study-room-1") {
+		t.Errorf("%q does not match the element id study-room-1", room.Name)
+	}
+	room.FloorID = "old-floor"
+	room.Position = fyne.NewPos(0.9, 0.9)
+	shape := []fyne.Position{{X: 0, Y: 0}, {X: 0.5, Y: 0}, {X: 0.5, Y: 0.5}, {X: 0, Y: 0.5}}
+	after := roomPlacement{FloorID: "new-floor", ElementID: "study-room-1", Position: polygonCentre(shape), Shape: shape}
+
+	if err := executeCommand(&SetRoomPlacementCommand{room: room, before: placementOf(room), after: after}); err != nil {
+		t.Fatal(err)
+	}
+	if room.FloorID != "new-floor" || room.PlanElementID != "study-room-1" || !closeTo(room.Position, fyne.NewPos(0.25, 0.25)) {
+		t.Errorf("bound room is on %s, element %q, at %v", room.FloorID, room.PlanElementID, room.Position)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if room.FloorID != "old-floor" || room.PlanElementID != "" || room.Position != fyne.NewPos(0.9, 0.9) || room.Shape != nil {
+		t.Errorf("after undo the room is on %s, element %q, at %v with shape %v", room.FloorID, room.PlanElementID, room.Position, room.Shape)
+	}
+}
@@ -0,0 +1,365 @@
+// totp.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Time-based one-time passwords (RFC 6238) as used by authenticator apps
+const (
+	totpDigits        = 6
+	totpPeriod        = 30 // Seconds per code
+	totpSkew          = 1  // Codes either side of the current one that are accepted
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+	totpIssuer        = "roomy"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var errInvalidCode = errors.New("incorrect code")
+
+// totpCode returns the code for a secret at a time step (RFC 4226).
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// matchTOTP returns the time step whose code matches, or 0 if none does.
+// Steps at or before lastStep were already used and are refused.
+func matchTOTP(secret string, code string, lastStep int64) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0
+	}
+	now := totpStep(time.Now())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+func newTOTPSecret() string {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(secret)
+}
+
+// totpURI is the otpauth:// link authenticator apps read from the QR code.
+func totpURI(username, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return fmt.Sprintf("otpauth://totp/%s?%s", url.PathEscape(totpIssuer+":"+username), query.Encode())
+}
+
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns codes to show the user once and the hashes kept
+// in users.json.
+func newRecoveryCodes() (codes, hashes []string) {
+	alphabet := "abcdefghjkmnpqrstuvwxyz23456789"
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			panic(err)
+		}
+		code := make([]byte, 0, 11)
+		for j, b := range raw {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, alphabet[int(b)%len(alphabet)])
+		}
+		codes = append(codes, string(code))
+		hashes = append(hashes, hashRecoveryCode(string(code)))
+	}
+	return codes, hashes
+}
+
+// needsTwoFactor reports whether user must enter a code after their password.
+// SSO accounts are left to the identity provider.
+func needsTwoFactor(user *User) bool {
+	return user.TOTPEnabled && user.Source != authSourceOIDC
+}
+
+// mustEnrolTwoFactor reports whether an admin has to set up two-factor
+// authentication before continuing.
+func mustEnrolTwoFactor(user *User) bool {
+	return settings.Login.RequireAdminTwoFactor && user.Role == "Admin" &&
+		!user.TOTPEnabled && user.Source != authSourceOIDC
+}
+
+// verifySecondFactor checks an authenticator code or an unused recovery
+// code, with the same rate limiting as passwords.
+func verifySecondFactor(user *User, code, source string) error {
+	if err := checkLoginAllowed(user.Username, source); err != nil {
+		return err
+	}
+	code = normalizeCode(code)
+	if step := matchTOTP(user.TOTPSecret, code, user.TOTPLastStep); step != 0 {
+		user.TOTPLastStep = step // A code cannot be replayed
+		saveUsers()
+		resetLoginThrottles(user.Username)
+		return nil
+	}
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			saveUsers()
+			resetLoginThrottles(user.Username)
+			return nil
+		}
+	}
+	recordLoginFailure(user.Username, source)
+	return errInvalidCode
+}
+
+// enableTwoFactor turns on two-factor authentication once the user has
+// entered a code from the new secret, and returns fresh recovery codes.
+func enableTwoFactor(user *User, secret, code string) ([]string, error) {
+	step := matchTOTP(secret, normalizeCode(code), 0)
+	if step == 0 {
+		return nil, errors.New("that code does not match; check the time on your phone and try again")
+	}
+	codes, hashes := newRecoveryCodes()
+	user.TOTPSecret = secret
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	saveUsers()
+	return codes, nil
+}
+
+// resetTwoFactor lets an admin turn off two-factor authentication for a
+// user who has lost their phone and recovery codes.
+func resetTwoFactor(username string) error {
+	user := findUser(username)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	disableTwoFactor(user)
+	return nil
+}
+
+func disableTwoFactor(user *User) {
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	saveUsers()
+}
+
+// showTwoFactorPrompt asks for the second step of sign-in.
+func showTwoFactorPrompt(user *User, w fyne.Window, onDone func()) {
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("123456")
+	form := dialog.NewForm("Two-Factor Authentication", "Verify", "Cancel", []*widget.FormItem{
+		{Text: "Code", Widget: codeEntry, HintText: "From your authenticator app, or a recovery code"},
+	}, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		if err := verifySecondFactor(user, codeEntry.Text, localLoginSource()); err != nil {
+			d := dialog.NewError(err, w)
+			if err == errInvalidCode {
+				d.SetOnClosed(func() { showTwoFactorPrompt(user, w, onDone) })
+			}
+			d.Show()
+			return
+		}
+		if len(user.RecoveryCodes) <= 2 {
+			dialog.ShowInformation("Recovery Codes", fmt.Sprintf("You have %d recovery codes left. Create new ones under Two-Factor Auth.", len(user.RecoveryCodes)), w)
+		}
+		onDone()
+	}, w)
+	form.Resize(fyne.NewSize(400, 180))
+	form.Show()
+}
+
+// showTwoFactorSetup shows a new secret as a QR code and enables two-factor
+// authentication once the user confirms a code. When forced is set the user
+// cannot continue without it.
+func showTwoFactorSetup(user *User, forced bool, w fyne.Window, onDone func()) {
+	showTwoFactorEnrol(user, newTOTPSecret(), forced, w, onDone)
+}
+
+// showTwoFactorEnrol is reshown with the same secret after a mistyped code,
+// since the user has already added it to their app.
+func showTwoFactorEnrol(user *User, secret string, forced bool, w fyne.Window, onDone func()) {
+	qr, err := encodeQR([]byte(totpURI(user.Username, secret)))
+	if err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	image := canvas.NewImageFromImage(qr.Image(6))
+	image.FillMode = canvas.ImageFillContain
+	image.ScaleMode = canvas.ImageScalePixels
+	image.SetMinSize(fyne.NewSize(220, 220))
+
+	secretLabel := widget.NewLabelWithStyle(regroup(secret, 4), fyne.TextAlignCenter, fyne.TextStyle{Monospace: true})
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("Code from the app")
+
+	intro := "Scan this code with an authenticator app, or enter the key below, then type the 6-digit code it shows."
+	if forced {
+		intro = "Admin accounts must use two-factor authentication. " + intro
+	}
+	introLabel := widget.NewLabel(intro)
+	introLabel.Wrapping = fyne.TextWrapWord
+
+	dismiss := "Cancel"
+	if forced {
+		dismiss = "Log Out"
+	}
+	content := container.NewVBox(introLabel, image, secretLabel, codeEntry)
+	d := dialog.NewCustomConfirm("Set Up Two-Factor Authentication", "Enable", dismiss, content, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		codes, err := enableTwoFactor(user, secret, codeEntry.Text)
+		if err != nil {
+			d := dialog.NewError(err, w)
+			d.SetOnClosed(func() { showTwoFactorEnrol(user, secret, forced, w, onDone) })
+			d.Show()
+			return
+		}
+		showRecoveryCodes(codes, w, onDone)
+	}, w)
+	d.Resize(fyne.NewSize(420, 480))
+	d.Show()
+}
+
+// regroup inserts a space every n characters to make keys easier to type.
+func regroup(s string, n int) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && i%n == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// showRecoveryCodes shows new recovery codes once.
+func showRecoveryCodes(codes []string, w fyne.Window, onClosed func()) {
+	text := strings.Join(codes, "\n")
+	list := widget.NewLabelWithStyle(text, fyne.TextAlignCenter, fyne.TextStyle{Monospace: true})
+	help := widget.NewLabel("Keep these codes somewhere safe. Each can be used once instead of a code from your app. They will not be shown again.")
+	help.Wrapping = fyne.TextWrapWord
+	copyButton := widget.NewButton("Copy", func() {
+		w.Clipboard().SetContent(text)
+	})
+	d := dialog.NewCustom("Recovery Codes", "Done", container.NewVBox(help, list, copyButton), w)
+	d.SetOnClosed(func() {
+		if onClosed != nil {
+			onClosed()
+		}
+	})
+	d.Resize(fyne.NewSize(380, 420))
+	d.Show()
+}
+
+// showTwoFactorSettings lets the signed-in user set up, renew or turn off
+// two-factor authentication.
+func showTwoFactorSettings(w fyne.Window) {
+	user := currentUser
+	if user == nil {
+		return
+	}
+	if !user.TOTPEnabled {
+		showTwoFactorSetup(user, false, w, nil)
+		return
+	}
+
+	// Both actions need a current code so a walk-up user cannot change them
+	withCode := func(title string, action func()) {
+		codeEntry := widget.NewEntry()
+		dialog.ShowForm(title, "Continue", "Cancel", []*widget.FormItem{
+			{Text: "Code", Widget: codeEntry, HintText: "From your authenticator app"},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			if err := verifySecondFactor(user, codeEntry.Text, localLoginSource()); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			action()
+		}, w)
+	}
+
+	status := widget.NewLabel(fmt.Sprintf("Two-factor authentication is on. %d recovery codes left.", len(user.RecoveryCodes)))
+	var d dialog.Dialog
+	renewButton := widget.NewButton("New Recovery Codes", func() {
+		d.Hide()
+		withCode("New Recovery Codes", func() {
+			codes, hashes := newRecoveryCodes()
+			user.RecoveryCodes = hashes
+			saveUsers()
+			showRecoveryCodes(codes, w, nil)
+		})
+	})
+	disableButton := widget.NewButton("Turn Off", func() {
+		d.Hide()
+		if settings.Login.RequireAdminTwoFactor && user.Role == "Admin" {
+			dialog.ShowError(errors.New("two-factor authentication is required for admin accounts"), w)
+			return
+		}
+		withCode("Turn Off Two-Factor Authentication", func() {
+			disableTwoFactor(user)
+			dialog.ShowInformation("Two-Factor Authentication", "Two-factor authentication is off.", w)
+		})
+	})
+	d = dialog.NewCustom("Two-Factor Authentication", "Close", container.NewVBox(status, renewButton, disableButton), w)
+	d.Show()
+}
@@ -0,0 +1,59 @@
+// totp_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := totpCode(rfcSecret, int64(counter)); got != code {
+			t.Errorf("counter %d: code %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// The RFC 6238 vectors are eight digits; roomy's six-digit codes are their
+// last six digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		step := totpStep(time.Unix(test.unix, 0))
+		if got := totpCode(rfcSecret, step); got != test.code[2:] {
+			t.Errorf("T=%d: code %s, want %s", test.unix, got, test.code[2:])
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+	now := totpStep(time.Now())
+	for _, offset := range []int64{-totpSkew, 0, totpSkew} {
+		code := totpCode(rfcSecret, now+offset)
+		if step := matchTOTP(secret, code, 0); step != now+offset {
+			t.Errorf("offset %d: matched step %d, want %d", offset, step, now+offset)
+		}
+	}
+	if step := matchTOTP(secret, totpCode(rfcSecret, now+totpSkew+1), 0); step != 0 {
+		t.Errorf("a code beyond the allowed skew matched step %d", step)
+	}
+	if step := matchTOTP(secret, totpCode(rfcSecret, now), now); step != 0 {
+		t.Errorf("a code from an already used step matched step %d", step)
+	}
+}
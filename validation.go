@@ -0,0 +1,169 @@
+// validation.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fyne.io/fyne/v2"
+)
+
+// Fields named in FieldError
+const (
+	fieldUsername = "Username"
+	fieldPassword = "Password"
+	fieldEmail    = "Email"
+	fieldRoomName = "Room Name"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 64
+	maxRoomNameLength = 64
+	usernameSymbols   = "._-@"          // Allowed in usernames besides letters and digits
+	roomNameSymbols   = " -_.,'&#()/+:" // Allowed in room names besides letters and digits
+)
+
+// FieldError is a problem with one input. Forms show it next to the field
+// and the setup command next to the matching flag.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// fieldErrorf returns a FieldError for field with a formatted message.
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// asFieldError attributes err to field unless it already names one.
+func asFieldError(field string, err error) error {
+	var fieldErr *FieldError
+	if err == nil || errors.As(err, &fieldErr) {
+		return err
+	}
+	return &FieldError{Field: field, Message: err.Error()}
+}
+
+// entryValidator adapts a check of trimmed text for widget.Entry.Validator,
+// so forms show the problem under the field as it is typed.
+func entryValidator(check func(string) error) fyne.StringValidator {
+	return func(text string) error {
+		return check(strings.TrimSpace(text))
+	}
+}
+
+// checkCharacters returns the first rune of text that is neither a letter,
+// a digit nor one of symbols.
+func checkCharacters(text, symbols string) (rune, bool) {
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(symbols, r) {
+			return r, false
+		}
+	}
+	return 0, true
+}
+
+// normalizeUsername trims a username as typed. Usernames keep their case
+// for display but are compared without it, see usernameKey.
+func normalizeUsername(username string) string {
+	return strings.TrimSpace(username)
+}
+
+// usernameKey is the form of a username used to compare and look it up.
+func usernameKey(username string) string {
+	return strings.ToLower(normalizeUsername(username))
+}
+
+// validateUsername checks the length and characters of a new username.
+func validateUsername(username string) error {
+	username = normalizeUsername(username)
+	length := utf8.RuneCountInString(username)
+	if length == 0 {
+		return fieldErrorf(fieldUsername, "enter a username")
+	}
+	if length < minUsernameLength || length > maxUsernameLength {
+		return fieldErrorf(fieldUsername, "usernames must be %d to %d characters long", minUsernameLength, maxUsernameLength)
+	}
+	if r, ok := checkCharacters(username, usernameSymbols); !ok {
+		return fieldErrorf(fieldUsername, "usernames can only contain letters, digits and %s, not %q", usernameSymbols, r)
+	}
+	return nil
+}
+
+// validateNewUsername also checks that no account has the same name,
+// ignoring case.
+func validateNewUsername(username string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	if findUser(username) != nil {
+		return fieldErrorf(fieldUsername, "username already exists")
+	}
+	return nil
+}
+
+// directoryUsername maps a name from an OpenID provider to a valid
+// username, replacing characters that are not allowed with "-" and
+// shortening or padding it to the allowed length.
+func directoryUsername(name string) string {
+	var b strings.Builder
+	for _, r := range normalizeUsername(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(usernameSymbols, r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	mapped := []rune(b.String())
+	if len(mapped) > maxUsernameLength {
+		mapped = mapped[:maxUsernameLength]
+	}
+	for len(mapped) < minUsernameLength {
+		mapped = append(mapped, '-')
+	}
+	return string(mapped)
+}
+
+// normalizeRoomName trims a room name and collapses runs of spaces.
+func normalizeRoomName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// roomNamed returns the room called name, ignoring case and spacing.
+func roomNamed(name string) *Room {
+	name = normalizeRoomName(name)
+	for _, room := range rooms {
+		if strings.EqualFold(normalizeRoomName(room.Name), name) {
+			return room
+		}
+	}
+	return nil
+}
+
+// validateRoomName checks the length and characters of a room name and that
+// no room other than except has it, ignoring case.
+func validateRoomName(name string, except *Room) error {
+	name = normalizeRoomName(name)
+	if name == "" {
+		return fieldErrorf(fieldRoomName, "room name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxRoomNameLength {
+		return fieldErrorf(fieldRoomName, "room name %q is longer than %d characters", name, maxRoomNameLength)
+	}
+	if r, ok := checkCharacters(name, roomNameSymbols); !ok {
+		return fieldErrorf(fieldRoomName, "room name %q cannot contain %q", name, r)
+	}
+	if existing := roomNamed(name); existing != nil && existing != except {
+		return fieldErrorf(fieldRoomName, "room '%s' already exists", existing.Name)
+	}
+	return nil
+}
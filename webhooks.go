@@ -0,0 +1,428 @@
+// webhooks.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Webhook events
+const (
+	webhookReservationCreated   = "reservation.created"
+	webhookReservationCancelled = "reservation.cancelled"
+	webhookReservationUpdated   = "reservation.updated"
+	webhookRoomAdded            = "room.added"
+	webhookUserCreated          = "user.created"
+)
+
+var webhookEvents = []string{
+	webhookReservationCreated,
+	webhookReservationCancelled,
+	webhookReservationUpdated,
+	webhookRoomAdded,
+	webhookUserCreated,
+}
+
+const (
+	webhookMaxAttempts   = 5
+	webhookTimeout       = 10 * time.Second
+	webhookLogLimit      = 1000 // Deliveries kept in the log
+	webhookSignatureName = "X-Roomy-Signature"
+)
+
+// Webhook is a URL notified of the selected events
+type Webhook struct {
+	ID     string
+	URL    string
+	Secret string   // Key for the HMAC-SHA256 signature of each payload
+	Events []string // Events delivered to this URL
+	Active bool
+}
+
+// WebhookDelivery records one attempt to deliver an event
+type WebhookDelivery struct {
+	DeliveryID string
+	WebhookID  string
+	URL        string
+	Event      string
+	Attempt    int
+	Time       time.Time
+	StatusCode int
+	Error      string
+	Success    bool
+}
+
+// webhookPayload is the JSON body posted to webhooks
+type webhookPayload struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+var (
+	webhooks      = []Webhook{}
+	webhookMu     sync.Mutex
+	webhookLog    = []WebhookDelivery{}
+	webhookLogMu  sync.Mutex
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+
+	webhookFirstBackoff = 2 * time.Second // Doubled after each failed attempt
+)
+
+// heldWebhooks collects the events of a group of changes while it is being
+// applied, so they are only sent once the whole group has been. Groups may
+// nest; events are sent when the outermost one finishes.
+var heldWebhooks struct {
+	sync.Mutex
+	depth  int
+	events []heldWebhook
+}
+
+type heldWebhook struct {
+	event string
+	data  interface{}
+}
+
+// holdWebhooks starts holding back events.
+func holdWebhooks() {
+	heldWebhooks.Lock()
+	heldWebhooks.depth++
+	heldWebhooks.Unlock()
+}
+
+// releaseWebhooks stops holding back events, sending the held ones if send
+// is true and dropping them if the changes were rolled back.
+func releaseWebhooks(send bool) {
+	heldWebhooks.Lock()
+	heldWebhooks.depth--
+	if heldWebhooks.depth > 0 {
+		heldWebhooks.Unlock()
+		return
+	}
+	events := heldWebhooks.events
+	heldWebhooks.events = nil
+	heldWebhooks.Unlock()
+	if send {
+		for _, held := range events {
+			emitWebhook(held.event, held.data)
+		}
+	}
+}
+
+// Load and save webhooks
+func loadWebhooks() {
+	err := loadJSONFile("webhooks.json", &webhooks)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Error loading webhooks: %v\n", err)
+	}
+
+	err = loadJSONFile("webhook_deliveries.json", &webhookLog)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error loading webhook deliveries: %v\n", err)
+	}
+}
+
+func saveWebhooks() {
+	if err := saveJSONFile("webhooks.json", &webhooks); err != nil {
+		log.Printf("Error saving webhooks: %v\n", err)
+	}
+}
+
+// recordDelivery appends to the delivery log and saves it. Callers must hold webhookLogMu.
+func recordDelivery(delivery WebhookDelivery) {
+	webhookLog = append(webhookLog, delivery)
+	if len(webhookLog) > webhookLogLimit {
+		webhookLog = webhookLog[len(webhookLog)-webhookLogLimit:]
+	}
+	if err := saveJSONFile("webhook_deliveries.json", &webhookLog); err != nil {
+		log.Printf("Error saving webhook deliveries: %v\n", err)
+	}
+}
+
+// signPayload returns the signature header value for body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhook delivers event to every active webhook subscribed to it. It
+// returns immediately; deliveries and their retries run in the background
+// and are not saved, so those still waiting when roomy exits are lost.
+func emitWebhook(event string, data interface{}) {
+	heldWebhooks.Lock()
+	if heldWebhooks.depth > 0 {
+		heldWebhooks.events = append(heldWebhooks.events, heldWebhook{event, data})
+		heldWebhooks.Unlock()
+		return
+	}
+	heldWebhooks.Unlock()
+
+	webhookMu.Lock()
+	targets := []Webhook{}
+	for _, hook := range webhooks {
+		if hook.Active && subscribesTo(hook, event) {
+			targets = append(targets, hook)
+		}
+	}
+	webhookMu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	payload := webhookPayload{ID: newLocationID(), Event: event, Time: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s webhook: %v\n", event, err)
+		return
+	}
+	for _, hook := range targets {
+		go deliverWebhook(hook, payload, body)
+	}
+}
+
+func subscribesTo(hook Webhook, event string) bool {
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWebhook posts body to the hook, retrying with exponential backoff
+// on network errors, rate limiting and server errors.
+func deliverWebhook(hook Webhook, payload webhookPayload, body []byte) {
+	wait := webhookFirstBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		delivery := WebhookDelivery{
+			DeliveryID: payload.ID,
+			WebhookID:  hook.ID,
+			URL:        hook.URL,
+			Event:      payload.Event,
+			Attempt:    attempt,
+			Time:       time.Now(),
+		}
+		retry := true
+		status, err := postWebhook(hook, payload, body)
+		delivery.StatusCode = status
+		switch {
+		case err != nil:
+			delivery.Error = err.Error()
+		case status >= 200 && status < 300:
+			delivery.Success = true
+			retry = false
+		case status == http.StatusTooManyRequests || status >= 500:
+			delivery.Error = http.StatusText(status)
+		default:
+			delivery.Error = http.StatusText(status)
+			retry = false // The receiver rejected the request; resending won't help
+		}
+
+		webhookLogMu.Lock()
+		recordDelivery(delivery)
+		webhookLogMu.Unlock()
+
+		if !retry || attempt == webhookMaxAttempts {
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func postWebhook(hook Webhook, payload webhookPayload, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "roomy-webhooks")
+	request.Header.Set("X-Roomy-Event", payload.Event)
+	request.Header.Set("X-Roomy-Delivery", payload.ID)
+	if hook.Secret != "" {
+		request.Header.Set(webhookSignatureName, signPayload(hook.Secret, body))
+	}
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+// Payloads for room and user events. Reservations are sent as stored;
+// user payloads never include the password hash.
+type roomPayload struct {
+	ID      string
+	Name    string
+	FloorID string
+}
+
+type userPayload struct {
+	Username string
+	Role     string
+}
+
+// reservationUpdate is the payload of reservation.updated
+type reservationUpdate struct {
+	Before Reservation
+	After  Reservation
+}
+
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhook URL must start with http:// or https://")
+	}
+	return nil
+}
+
+// manageWebhooks lets admins register webhook URLs and review deliveries.
+func manageWebhooks(w fyne.Window) {
+	list := container.NewVBox()
+
+	var rebuild func()
+	rebuild = func() {
+		list.Objects = nil
+		webhookMu.Lock()
+		hooks := append([]Webhook{}, webhooks...)
+		webhookMu.Unlock()
+		for _, hook := range hooks {
+			id := hook.ID
+			activeCheck := widget.NewCheck("Active", func(active bool) {
+				webhookMu.Lock()
+				for i := range webhooks {
+					if webhooks[i].ID == id {
+						webhooks[i].Active = active
+					}
+				}
+				saveWebhooks()
+				webhookMu.Unlock()
+			})
+			activeCheck.Checked = hook.Active
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Webhook", "Stop sending events to this URL?", func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+					webhookMu.Lock()
+					for i := range webhooks {
+						if webhooks[i].ID == id {
+							webhooks = append(webhooks[:i], webhooks[i+1:]...)
+							break
+						}
+					}
+					saveWebhooks()
+					webhookMu.Unlock()
+					rebuild()
+				}, w)
+			})
+			label := widget.NewLabel(fmt.Sprintf("%s\n%s", hook.URL, strings.Join(hook.Events, ", ")))
+			list.Add(container.NewBorder(nil, nil, nil, container.NewHBox(activeCheck, deleteButton), label))
+		}
+		if len(hooks) == 0 {
+			list.Add(widget.NewLabel("No webhooks registered."))
+		}
+		list.Refresh()
+	}
+	rebuild()
+
+	addButton := widget.NewButtonWithIcon("Add Webhook", theme.ContentAddIcon(), func() {
+		urlEntry := widget.NewEntry()
+		urlEntry.SetPlaceHolder("https://example.com/hooks/roomy")
+		secretEntry := widget.NewEntry()
+		secretEntry.SetText(newLocationID() + newLocationID())
+		eventsGroup := widget.NewCheckGroup(webhookEvents, nil)
+		eventsGroup.SetSelected(webhookEvents)
+		form := dialog.NewForm("Add Webhook", "Add", "Cancel", []*widget.FormItem{
+			{Text: "URL", Widget: urlEntry},
+			{Text: "Signing Secret", Widget: secretEntry, HintText: "Payloads are signed with HMAC-SHA256 in the " + webhookSignatureName + " header"},
+			{Text: "Events", Widget: eventsGroup},
+		}, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			hookURL := strings.TrimSpace(urlEntry.Text)
+			if err := validateWebhookURL(hookURL); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if len(eventsGroup.Selected) == 0 {
+				dialog.ShowError(errors.New("select at least one event"), w)
+				return
+			}
+			webhookMu.Lock()
+			webhooks = append(webhooks, Webhook{
+				ID:     newLocationID(),
+				URL:    hookURL,
+				Secret: secretEntry.Text,
+				Events: append([]string{}, eventsGroup.Selected...),
+				Active: true,
+			})
+			saveWebhooks()
+			webhookMu.Unlock()
+			rebuild()
+		}, w)
+		form.Resize(fyne.NewSize(500, 450))
+		form.Show()
+	})
+
+	logButton := widget.NewButtonWithIcon("Delivery Log", theme.ListIcon(), func() {
+		showWebhookLog(w)
+	})
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(550, 300))
+	dialog.ShowCustom("Webhooks", "Close", container.NewBorder(nil, container.NewHBox(addButton, logButton), nil, nil, scroll), w)
+}
+
+// showWebhookLog lists the most recent delivery attempts, newest first.
+func showWebhookLog(w fyne.Window) {
+	webhookLogMu.Lock()
+	entries := append([]WebhookDelivery{}, webhookLog...)
+	webhookLogMu.Unlock()
+
+	list := container.NewVBox()
+	for i := len(entries) - 1; i >= 0 && i >= len(entries)-200; i-- {
+		entry := entries[i]
+		result := "OK"
+		if !entry.Success {
+			result = "Failed: " + entry.Error
+		}
+		status := ""
+		if entry.StatusCode > 0 {
+			status = fmt.Sprintf(" (HTTP %d)", entry.StatusCode)
+		}
+		list.Add(widget.NewLabel(fmt.Sprintf("%s  %s  attempt %d\n%s  %s%s",
+			entry.Time.In(siteLocation()).Format("2006-01-02 "+timeLayout12Hour), entry.Event, entry.Attempt, entry.URL, result, status)))
+	}
+	if len(entries) == 0 {
+		list.Add(widget.NewLabel("No deliveries yet."))
+	}
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(600, 400))
+	dialog.ShowCustom("Webhook Deliveries", "Close", scroll, w)
+}
@@ -0,0 +1,222 @@
+// webhooks_test.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookTestServer answers each delivery with the next status in statuses,
+// repeating the last one, and records what it received.
+type webhookTestServer struct {
+	server   *httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	times    []time.Time
+	events   []string
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newWebhookTestServer(t *testing.T, statuses ...int) *webhookTestServer {
+	s := &webhookTestServer{statuses: statuses, received: make(chan struct{}, 100)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		status := s.statuses[len(s.statuses)-1]
+		if len(s.times) < len(s.statuses) {
+			status = s.statuses[len(s.times)]
+		}
+		s.times = append(s.times, time.Now())
+		s.events = append(s.events, r.Header.Get("X-Roomy-Event"))
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		w.WriteHeader(status)
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// wait waits for n deliveries to arrive and be logged, so no delivery is
+// still running when the test ends.
+func (s *webhookTestServer) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d deliveries", i, n)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		webhookLogMu.Lock()
+		logged := len(webhookLog)
+		webhookLogMu.Unlock()
+		if logged >= n {
+			return
+		}
+	}
+	t.Fatalf("%d deliveries were not logged", n)
+}
+
+// useTestWebhooks runs the test with only the given webhooks, an empty
+// delivery log and a short retry backoff.
+func useTestWebhooks(t *testing.T, hooks ...Webhook) {
+	t.Helper()
+	useTestDataDir(t)
+	webhookMu.Lock()
+	savedHooks := webhooks
+	webhooks = hooks
+	webhookMu.Unlock()
+	webhookLogMu.Lock()
+	savedLog := webhookLog
+	webhookLog = nil
+	webhookLogMu.Unlock()
+	savedBackoff := webhookFirstBackoff
+	webhookFirstBackoff = 20 * time.Millisecond
+	t.Cleanup(func() {
+		webhookMu.Lock()
+		webhooks = savedHooks
+		webhookMu.Unlock()
+		webhookLogMu.Lock()
+		webhookLog = savedLog
+		webhookLogMu.Unlock()
+		webhookFirstBackoff = savedBackoff
+	})
+}
+
+func TestSignPayload(t *testing.T) {
+	// The widely published HMAC-SHA256 example
+	got := signPayload("key", []byte("The quick brown fox jumps over the lazy dog"))
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+}
+
+func TestDeliverWebhookRetriesWithBackoff(t *testing.T) {
+	server := newWebhookTestServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	hook := Webhook{ID: "hook-1", URL: server.server.URL, Secret: "s3cret", Events: []string{webhookRoomAdded}, Active: true}
+	useTestWebhooks(t, hook)
+
+	payload := webhookPayload{ID: "delivery-1", Event: webhookRoomAdded, Time: time.Now().UTC(), Data: roomPayload{ID: "r1", Name: "Lab A"}}
+	body, _ := json.Marshal(payload)
+	deliverWebhook(hook, payload, body)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.times) != 3 {
+		t.Fatalf("%d attempts, want 3", len(server.times))
+	}
+	first, second := server.times[1].Sub(server.times[0]), server.times[2].Sub(server.times[1])
+	if first < webhookFirstBackoff || second < 2*webhookFirstBackoff {
+		t.Errorf("waited %v then %v, want at least %v then %v", first, second, webhookFirstBackoff, 2*webhookFirstBackoff)
+	}
+	for i, request := range server.requests {
+		if request.Header.Get("X-Roomy-Delivery") != "delivery-1" || request.Header.Get("X-Roomy-Event") != webhookRoomAdded {
+			t.Errorf("attempt %d has headers %v", i+1, request.Header)
+		}
+		if !hmac.Equal([]byte(request.Header.Get(webhookSignatureName)), []byte(signPayload("s3cret", server.bodies[i]))) {
+			t.Errorf("attempt %d has a bad signature", i+1)
+		}
+	}
+
+	webhookLogMu.Lock()
+	defer webhookLogMu.Unlock()
+	if len(webhookLog) != 3 {
+		t.Fatalf("%d deliveries logged, want 3", len(webhookLog))
+	}
+	for i, delivery := range webhookLog {
+		if delivery.Attempt != i+1 || delivery.Success != (i == 2) {
+			t.Errorf("log entry %d is %+v", i, delivery)
+		}
+	}
+	if webhookLog[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("first attempt logged status %d", webhookLog[0].StatusCode)
+	}
+}
+
+func TestDeliverWebhookStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"rejected", http.StatusBadRequest, 1},
+		{"server keeps failing", http.StatusInternalServerError, webhookMaxAttempts},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newWebhookTestServer(t, test.status)
+			hook := Webhook{ID: "hook-1", URL: server.server.URL, Events: []string{webhookRoomAdded}, Active: true}
+			useTestWebhooks(t, hook)
+			deliverWebhook(hook, webhookPayload{ID: "d", Event: webhookRoomAdded}, []byte(`{}`))
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if len(server.times) != test.attempts {
+				t.Errorf("%d attempts, want %d", len(server.times), test.attempts)
+			}
+			if server.requests[0].Header.Get(webhookSignatureName) != "" {
+				t.Error("a webhook without a secret was signed")
+			}
+		})
+	}
+}
+
+func TestBatchWebhooksWaitForCommit(t *testing.T) {
+	server := newWebhookTestServer(t, http.StatusOK)
+	room := useTestRoom(t, "Lab A")
+	useTestWebhooks(t, Webhook{ID: "hook-1", URL: server.server.URL, Events: webhookEvents, Active: true})
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	log := []string{}
+	failing := &CompositeCommand{Commands: []Command{
+		&ReservationCommand{room: room, reservation: testReservation(start, "Rolled back")},
+		&testStep{name: "fails", log: &log, failDo: errors.New("no")},
+	}}
+	if err := failing.Execute(); err == nil {
+		t.Fatal("the failing batch was applied")
+	}
+
+	batch := &CompositeCommand{Commands: []Command{
+		&ReservationCommand{room: room, reservation: testReservation(start, "One")},
+		&ReservationCommand{room: room, reservation: testReservation(start.Add(time.Hour), "Two")},
+	}}
+	if err := batch.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	server.wait(t, 2)
+	time.Sleep(50 * time.Millisecond) // Anything from the rolled-back batch would have arrived too
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.events) != 2 {
+		t.Fatalf("received %v, want only the two bookings of the applied batch", server.events)
+	}
+	for i, event := range server.events {
+		if event != webhookReservationCreated {
+			t.Errorf("event %d is %s", i, event)
+		}
+		var payload struct {
+			Data Reservation `json:"data"`
+		}
+		if err := json.Unmarshal(server.bodies[i], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Data.Purpose == "Rolled back" {
+			t.Error("the rolled-back booking was announced")
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// xlsx.go
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxDate is a cell value shown as a date without a time of day. Plain
+// time.Time values are shown as a date and time.
+type xlsxDate time.Time
+
+// Cell styles defined in xlsxStyles
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStyleDateTime
+	xlsxStyleDate
+)
+
+// excelEpoch is day zero of Excel's date serial numbers.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// xlsxColumn returns the letters naming a zero-based column, e.g. 27 is "AB".
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// excelSerial converts the wall-clock time of t to an Excel date serial
+// number, which has no time zone.
+func excelSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	return wall.Sub(excelEpoch).Hours() / 24
+}
+
+// xlsxEscape escapes text for an XML element or attribute.
+func xlsxEscape(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// writeXLSXCell writes one cell. Strings are written inline so no shared
+// string table is needed, and are never treated as formulas.
+func writeXLSXCell(b *strings.Builder, ref string, value interface{}, style int) {
+	switch v := value.(type) {
+	case int:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+	case float64:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'f', -1, 64))
+	case time.Time:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDateTime, strconv.FormatFloat(excelSerial(v), 'f', -1, 64))
+	case xlsxDate:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDate, strconv.FormatFloat(excelSerial(time.Time(v)), 'f', -1, 64))
+	default:
+		fmt.Fprintf(b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xlsxEscape(fmt.Sprint(v)))
+	}
+}
+
+// writeXLSX writes a workbook with a single sheet holding a bold, frozen
+// header row with filter buttons, followed by rows. widths gives each
+// column's width in characters.
+func writeXLSX(w io.Writer, sheetName string, header []string, widths []float64, rows [][]interface{}) error {
+	lastCell := fmt.Sprintf("%s%d", xlsxColumn(len(header)-1), len(rows)+1)
+
+	var sheet strings.Builder
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprintf(&sheet, `<dimension ref="A1:%s"/>`, lastCell)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	sheet.WriteString(`<cols>`)
+	for i, width := range widths {
+		fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%s" customWidth="1"/>`, i+1, i+1, strconv.FormatFloat(width, 'f', -1, 64))
+	}
+	sheet.WriteString(`</cols><sheetData><row r="1">`)
+	for i, title := range header {
+		writeXLSXCell(&sheet, xlsxColumn(i)+"1", title, xlsxStyleHeader)
+	}
+	sheet.WriteString(`</row>`)
+	for r, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r+2)
+		for i, value := range row {
+			writeXLSXCell(&sheet, fmt.Sprintf("%s%d", xlsxColumn(i), r+2), value, xlsxStyleDefault)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	fmt.Fprintf(&sheet, `</sheetData><autoFilter ref="A1:%s"/></worksheet>`, lastCell)
+
+	quotedName := "'" + strings.ReplaceAll(sheetName, "'", "''") + "'"
+	workbook := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + xlsxEscape(sheetName) + `" sheetId="1" r:id="rId1"/></sheets>
+<definedNames><definedName name="_xlnm._FilterDatabase" localSheetId="0" hidden="1">` +
+		xlsxEscape(quotedName+"!$A$1:$"+xlsxColumn(len(header)-1)+"$"+strconv.Itoa(len(rows)+1)) + `</definedName></definedNames>
+</workbook>`
+
+	archive := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", workbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+	for _, part := range parts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}